@@ -1,6 +1,8 @@
 package main_test
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,7 +11,9 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 var binary string
@@ -50,6 +54,25 @@ func run(t *testing.T, env []string, args ...string) (string, string, int) {
 	return stdout.String(), stderr.String(), code
 }
 
+// runStdin is run with stdin, for the interactive session.
+func runStdin(t *testing.T, env []string, stdin string, args ...string) (string, string, int) {
+	t.Helper()
+	cmd := exec.Command(binary, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr strings.Builder
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	code := 0
+	if exit, ok := err.(*exec.ExitError); ok {
+		code = exit.ExitCode()
+	} else if err != nil {
+		t.Fatalf("run pingu: %v", err)
+	}
+	return stdout.String(), stderr.String(), code
+}
+
 // fakeOpenAI serves a streaming text completion.
 func fakeOpenAI(t *testing.T, reply string) *httptest.Server {
 	t.Helper()
@@ -196,3 +219,445 @@ func TestBadModelRef(t *testing.T) {
 		t.Errorf("stderr = %q", stderr)
 	}
 }
+
+func TestRunAnthropicEndToEnd(t *testing.T) {
+	var gotModel string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		gotModel = body.Model
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n")
+		fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hello from claude\"}}\n\n")
+		fmt.Fprint(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	agentDir := filepath.Join(dir, "agent")
+	run(t, nil, "init", agentDir)
+	env := []string{"ANTHROPIC_API_KEY=test", "ANTHROPIC_BASE_URL=" + srv.URL}
+	stdout, stderr, code := run(t, env, "run", agentDir, "-m", "hi", "--model", "anthropic/claude-test")
+	if code != 0 {
+		t.Fatalf("exit = %d, stderr = %q", code, stderr)
+	}
+	if !strings.Contains(stdout, "Hello from claude") {
+		t.Errorf("stdout = %q", stdout)
+	}
+	if gotModel != "claude-test" {
+		t.Errorf("wire model = %q, want the model id without the provider prefix", gotModel)
+	}
+}
+
+func TestUnknownProvider(t *testing.T) {
+	dir := t.TempDir()
+	agentDir := filepath.Join(dir, "agent")
+	run(t, nil, "init", agentDir)
+	_, stderr, code := run(t, testEnv("http://unused"), "run", agentDir, "-m", "hi", "--model", "bogus/model")
+	if code != 2 {
+		t.Errorf("exit = %d, want 2", code)
+	}
+	for _, want := range []string{"bogus", "anthropic", "openai"} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr should mention %q: %q", want, stderr)
+		}
+	}
+}
+
+func TestRunGenerationFlags(t *testing.T) {
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"ok\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	agentDir := filepath.Join(dir, "agent")
+	run(t, nil, "init", agentDir)
+	os.WriteFile(filepath.Join(agentDir, "agent.toml"), []byte("[generation]\ntemperature = 0.7\nmax_output_tokens = 100\n"), 0o644)
+	_, stderr, code := run(t, testEnv(srv.URL), "run", agentDir, "-m", "hi", "--temperature", "0", "--stop", "a,b")
+	if code != 0 {
+		t.Fatalf("exit = %d, stderr = %q", code, stderr)
+	}
+	if body["temperature"] != float64(0) || body["max_tokens"] != float64(100) {
+		t.Errorf("body = %v", body)
+	}
+	if stop, _ := body["stop"].([]any); len(stop) != 1 || stop[0] != "a,b" {
+		t.Errorf("stop = %v", body["stop"])
+	}
+
+	env := []string{"ANTHROPIC_API_KEY=test", "ANTHROPIC_BASE_URL=http://unused"}
+	_, stderr, code = run(t, env, "run", agentDir, "-m", "hi", "--model", "anthropic/claude-test", "--seed", "1")
+	if code != 2 || !strings.Contains(stderr, "seed: not supported by provider anthropic") {
+		t.Errorf("exit = %d, stderr = %q", code, stderr)
+	}
+	_, stderr, code = run(t, testEnv(srv.URL), "run", agentDir, "-m", "hi", "--top-p", "1.5")
+	if code != 2 || !strings.Contains(stderr, "--top-p") {
+		t.Errorf("exit = %d, stderr = %q", code, stderr)
+	}
+}
+
+func TestRunOutputSchema(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		formats []any // response_format of each request
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		formats = append(formats, body["response_format"])
+		n := len(formats)
+		mu.Unlock()
+		reply := `{\"answer\":\"four\"}`
+		if n > 1 {
+			reply = `{\"answer\":4}`
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"%s\"}}]}\n\n", reply)
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	agentDir := filepath.Join(dir, "agent")
+	run(t, nil, "init", agentDir)
+	schema := filepath.Join(dir, "schema.json")
+	os.WriteFile(schema, []byte(`{"type":"object","properties":{"answer":{"type":"integer"}},"required":["answer"]}`), 0o644)
+
+	stdout, stderr, code := run(t, testEnv(srv.URL), "run", agentDir, "-m", "2+2?", "--output-schema", schema)
+	if code != 0 {
+		t.Fatalf("exit = %d, stderr = %q", code, stderr)
+	}
+	if stdout != "{\"answer\":4}\n" {
+		t.Errorf("stdout = %q, want only the validated JSON", stdout)
+	}
+	if !strings.Contains(stderr, "does not match the schema") {
+		t.Errorf("stderr = %q, want a retry warning", stderr)
+	}
+	mu.Lock()
+	first := formats[0]
+	formats = nil
+	mu.Unlock()
+	if rf, _ := first.(map[string]any); rf["type"] != "json_schema" {
+		t.Errorf("response_format = %v", first)
+	}
+
+	stdout, _, code = run(t, testEnv(srv.URL), "run", agentDir, "-m", "2+2?", "--output-schema", schema, "--schema-retries", "0")
+	if code != 1 || stdout != "" {
+		t.Errorf("exit = %d, stdout = %q; want 1 and no output", code, stdout)
+	}
+}
+
+func TestRunSessionResume(t *testing.T) {
+	var counts []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []json.RawMessage `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		counts = append(counts, len(body.Messages))
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"ok\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	agentDir := filepath.Join(dir, "agent")
+	run(t, nil, "init", agentDir)
+	env := testEnv(srv.URL)
+	for _, args := range [][]string{
+		{"-m", "first", "--session", "work"},
+		{"-m", "second", "--session", "work"},
+		{"-m", "third", "--session", "work", "--new-session"},
+		{"-m", "fourth"},
+	} {
+		if _, stderr, code := run(t, env, append([]string{"run", agentDir}, args...)...); code != 0 {
+			t.Fatalf("%v: exit = %d, stderr = %q", args, code, stderr)
+		}
+	}
+	// system + history + user: the second run resumes one exchange; the
+	// fresh and unnamed sessions start empty.
+	want := []int{2, 4, 2, 2}
+	if fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Errorf("messages per request = %v, want %v", counts, want)
+	}
+	if _, err := os.Stat(filepath.Join(agentDir, ".pingu", "sessions.db")); err != nil {
+		t.Errorf("session db: %v", err)
+	}
+}
+
+func TestSessionsCommands(t *testing.T) {
+	srv := fakeOpenAI(t, "stored reply")
+	defer srv.Close()
+	dir := t.TempDir()
+	agentDir := filepath.Join(dir, "agent")
+	run(t, nil, "init", agentDir)
+	if _, stderr, code := run(t, testEnv(srv.URL), "run", agentDir, "-m", "remember this", "--session", "notes"); code != 0 {
+		t.Fatalf("run: exit = %d, stderr = %q", code, stderr)
+	}
+
+	stdout, _, code := run(t, nil, "sessions", "list", agentDir)
+	if code != 0 || !strings.Contains(stdout, "notes") || !strings.Contains(stdout, "remember this") {
+		t.Errorf("list: exit = %d, stdout = %q", code, stdout)
+	}
+	stdout, _, code = run(t, nil, "sessions", "show", agentDir, "notes")
+	if code != 0 || !strings.Contains(stdout, "stored reply") {
+		t.Errorf("show: exit = %d, stdout = %q", code, stdout)
+	}
+	stdout, _, code = run(t, nil, "sessions", "export", agentDir, "notes", "--format", "markdown")
+	if code != 0 || !strings.Contains(stdout, "## Assistant") {
+		t.Errorf("export: exit = %d, stdout = %q", code, stdout)
+	}
+	if _, _, code = run(t, nil, "sessions", "export", agentDir, "notes", "--format", "xml"); code != 2 {
+		t.Errorf("export bad format: exit = %d, want 2", code)
+	}
+	if _, _, code = run(t, nil, "sessions", "delete", agentDir, "notes"); code != 0 {
+		t.Errorf("delete: exit = %d", code)
+	}
+	if _, _, code = run(t, nil, "sessions", "show", agentDir, "notes"); code != 2 {
+		t.Errorf("show deleted: exit = %d, want 2", code)
+	}
+	stdout, _, code = run(t, nil, "sessions", "prune", agentDir, "--older-than", "30d")
+	if code != 0 || !strings.Contains(stdout, "pruned 0") {
+		t.Errorf("prune: exit = %d, stdout = %q", code, stdout)
+	}
+}
+
+func TestServeStreamsRun(t *testing.T) {
+	srv := fakeOpenAI(t, "served reply")
+	defer srv.Close()
+	dir := t.TempDir()
+	agentDir := filepath.Join(dir, "agent")
+	run(t, nil, "init", agentDir)
+
+	cmd := exec.Command(binary, "serve", agentDir, "--addr", "127.0.0.1:0")
+	cmd.Env = append(os.Environ(), testEnv(srv.URL)...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	// The "serving" log line carries the bound address.
+	var addr string
+	logs := bufio.NewScanner(stderr)
+	for addr == "" && logs.Scan() {
+		var entry struct{ Msg, Addr string }
+		if json.Unmarshal(logs.Bytes(), &entry) == nil && entry.Msg == "serving" {
+			addr = entry.Addr
+		}
+	}
+	if addr == "" {
+		t.Fatal("server did not report its address")
+	}
+	go io.Copy(io.Discard, stderr)
+
+	resp, err := http.Post("http://"+addr+"/v1/runs", "application/json", strings.NewReader(`{"input":"hi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{"event: run_started", `"text":"served reply"`, "event: run_finished"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("stream missing %q:\n%s", want, body)
+		}
+	}
+
+	status, err := http.Get("http://" + addr + resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result struct{ Status, Output string }
+	json.NewDecoder(status.Body).Decode(&result)
+	status.Body.Close()
+	if result.Status != "succeeded" || result.Output != "served reply" {
+		t.Errorf("result = %+v", result)
+	}
+
+	cmd.Process.Signal(os.Interrupt)
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("serve exited with %v after SIGINT", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("serve did not stop after SIGINT")
+	}
+}
+
+func TestServeNeedsTokenOffLoopback(t *testing.T) {
+	dir := t.TempDir()
+	agentDir := filepath.Join(dir, "agent")
+	run(t, nil, "init", agentDir)
+	for _, addr := range []string{":0", "0.0.0.0:0", "[::]:0"} {
+		_, stderr, code := run(t, []string{"PINGU_SERVER_TOKEN="}, "serve", agentDir, "--addr", addr)
+		if code != 2 || !strings.Contains(stderr, "PINGU_SERVER_TOKEN") {
+			t.Errorf("--addr %s: exit = %d, stderr = %q", addr, code, stderr)
+		}
+	}
+}
+
+func TestTelegramConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	agentDir := filepath.Join(dir, "agent")
+	run(t, nil, "init", agentDir)
+	tests := []struct {
+		name string
+		env  []string
+		args []string
+		want string
+	}{
+		{"missing token", []string{"TELEGRAM_BOT_TOKEN="}, nil, "TELEGRAM_BOT_TOKEN"},
+		{"missing allowlist", []string{"TELEGRAM_BOT_TOKEN=1:x", "TELEGRAM_ALLOWED_USERS="}, nil, "allowlist"},
+		{"bad allowlist", []string{"TELEGRAM_BOT_TOKEN=1:x", "TELEGRAM_ALLOWED_USERS=12,abc"}, nil, "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"telegram", agentDir}, tt.args...)
+			_, stderr, code := run(t, tt.env, args...)
+			if code != 2 || !strings.Contains(stderr, tt.want) {
+				t.Errorf("exit = %d, stderr = %q", code, stderr)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	stdout, _, code := run(t, nil, "validate", "../../examples/hello-agent")
+	if code != 0 || !strings.HasSuffix(stdout, ": ok\n") {
+		t.Errorf("valid agent: exit = %d, stdout = %q", code, stdout)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "instructions.md"), []byte("hi"), 0o644)
+	os.WriteFile(filepath.Join(dir, "agent.toml"), []byte("model = \"nope/x\"\n"), 0o644)
+	os.Mkdir(filepath.Join(dir, "skills"), 0o755)
+	os.WriteFile(filepath.Join(dir, "skills", "a.md"), []byte("no front-matter"), 0o644)
+	os.WriteFile(filepath.Join(dir, "skills", "b.md"), []byte("---\nname: B\ndescription: d\n---\nbody"), 0o644)
+	env := []string{"PINGU_MAX_TOOL_CALLS=0", "OPENAI_API_KEY="}
+
+	stdout, stderr, code := run(t, env, "validate", dir)
+	if code != 2 || !strings.Contains(stderr, "4 problems") {
+		t.Errorf("exit = %d, stderr = %q", code, stderr)
+	}
+	for _, want := range []string{"skills/a.md:", "skills/b.md:", `unknown provider "nope"`, "PINGU_MAX_TOOL_CALLS"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("report missing %q:\n%s", want, stdout)
+		}
+	}
+
+	stdout, _, code = run(t, env, "validate", "--json", dir)
+	var report struct {
+		Valid    bool
+		Problems []struct{ File, Field, Message string }
+	}
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("--json output %q: %v", stdout, err)
+	}
+	if code != 2 || report.Valid || len(report.Problems) != 4 || report.Problems[0].File != "skills/a.md" {
+		t.Errorf("exit = %d, report = %+v", code, report)
+	}
+}
+
+func TestRunShowUsageAndCostLimit(t *testing.T) {
+	srv := fakeOpenAI(t, "hello")
+	defer srv.Close()
+
+	dir := t.TempDir()
+	agentDir := filepath.Join(dir, "agent")
+	run(t, nil, "init", agentDir)
+	// $1 per token, so the reply's 3 input and 2 output tokens cost $5.
+	os.WriteFile(filepath.Join(agentDir, "agent.toml"), []byte("[pricing.\"openai/gpt-4o-mini\"]\ninput = 1000000\noutput = 1000000\n"), 0o644)
+	_, stderr, code := run(t, testEnv(srv.URL), "run", agentDir, "-m", "hi", "--show-usage")
+	if code != 0 {
+		t.Fatalf("exit = %d, stderr = %q", code, stderr)
+	}
+	if !strings.Contains(stderr, "usage: 3 input + 2 output tokens, $5.0000") {
+		t.Errorf("stderr = %q", stderr)
+	}
+
+	// A final answer is kept whatever it cost; the budget stops the run
+	// before the tools of a turn that asked for some.
+	_, stderr, code = run(t, append(testEnv(srv.URL), "PINGU_MAX_COST_USD=4"), "run", agentDir, "-m", "hi")
+	if code != 0 {
+		t.Errorf("final answer over budget: exit = %d, stderr = %q", code, stderr)
+	}
+	tools := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"c1\",\"function\":{\"name\":\"read_file\",\"arguments\":\"{}\"}}]}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"tool_calls\"}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":2}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer tools.Close()
+	_, stderr, code = run(t, append(testEnv(tools.URL), "PINGU_MAX_COST_USD=4"), "run", agentDir, "-m", "hi")
+	if code != 1 || !strings.Contains(stderr, "max cost") {
+		t.Errorf("exit = %d, stderr = %q", code, stderr)
+	}
+}
+
+func TestREPLCommands(t *testing.T) {
+	var requests []string // message count and last message of each request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, fmt.Sprintf("%d:%s", len(body.Messages), body.Messages[len(body.Messages)-1].Content))
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"ok\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	agentDir := filepath.Join(dir, "agent")
+	run(t, nil, "init", agentDir)
+	script := strings.Join([]string{
+		"first", "/retry", "/edit changed", // main: changed
+		"/branch alt", "second", // alt: changed, second
+		"/switch main", `"""`, "third", "line", `"""`, // main: changed, third line
+		"/undo", "/undo", "/undo", "/bogus",
+		"/etc/hosts looks wrong", "//help is a word", // messages, not commands
+	}, "\n") + "\n"
+	stdout, stderr, code := runStdin(t, testEnv(srv.URL), script, "run", agentDir, "--session", "main")
+	if code != 0 {
+		t.Fatalf("exit = %d, stderr = %q", code, stderr)
+	}
+	want := []string{"2:first", "2:first", "2:changed", "4:second", "4:third\nline", "2:/etc/hosts looks wrong", "4:/help is a word"}
+	if fmt.Sprint(requests) != fmt.Sprint(want) {
+		t.Errorf("requests = %q, want %q", requests, want)
+	}
+	for _, s := range []string{"on branch alt, forked from main", "switched to main (2 messages)", "dropped: third"} {
+		if !strings.Contains(stdout, s) {
+			t.Errorf("stdout missing %q:\n%s", s, stdout)
+		}
+	}
+	for _, s := range []string{"/undo: no exchange yet", "/bogus: unknown command"} {
+		if !strings.Contains(stderr, s) {
+			t.Errorf("stderr missing %q:\n%s", s, stderr)
+		}
+	}
+
+	// The branch keeps its history; main was emptied by /undo.
+	stdout, _, _ = run(t, nil, "sessions", "show", agentDir, "alt")
+	if !strings.Contains(stdout, "changed") || !strings.Contains(stdout, "second") {
+		t.Errorf("alt = %q", stdout)
+	}
+}
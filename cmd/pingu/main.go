@@ -9,6 +9,11 @@ import (
 	"github.com/chtushar/pingu/internal/config"
 	"github.com/chtushar/pingu/internal/logging"
 
+	// Provider adapters register themselves with internal/provider; add an
+	// import here to make another adapter available to model references.
+	_ "github.com/chtushar/pingu/internal/provider/anthropic"
+	_ "github.com/chtushar/pingu/internal/provider/openai"
+
 	"github.com/spf13/cobra"
 )
 
@@ -30,6 +35,10 @@ func main() {
 	root := newRootCmd()
 	root.AddCommand(newInitCmd())
 	root.AddCommand(newRunCmd())
+	root.AddCommand(newSessionsCmd())
+	root.AddCommand(newServeCmd())
+	root.AddCommand(newTelegramCmd())
+	root.AddCommand(newValidateCmd())
 	if err := root.Execute(); err != nil {
 		var cfgErr *config.ConfigError
 		switch {
@@ -1,13 +1,15 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -15,19 +17,25 @@ import (
 	"github.com/chtushar/pingu/internal/agent"
 	"github.com/chtushar/pingu/internal/config"
 	"github.com/chtushar/pingu/internal/llm"
-	"github.com/chtushar/pingu/internal/provider/openai"
+	"github.com/chtushar/pingu/internal/markdown"
+	"github.com/chtushar/pingu/internal/provider"
+	"github.com/chtushar/pingu/internal/repl"
 	"github.com/chtushar/pingu/internal/runner"
+	"github.com/chtushar/pingu/internal/session"
 	"github.com/chtushar/pingu/internal/tools"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 func newRunCmd() *cobra.Command {
 	var (
-		message  string
-		model    string
-		maxTurns int
-		timeout  time.Duration
+		message    string
+		rtFlags    runtimeFlags
+		sessionRef string
+		newSession bool
+		showUsage  bool
+		plain      bool
 	)
 	cmd := &cobra.Command{
 		Use:   "run PATH",
@@ -37,106 +45,381 @@ func newRunCmd() *cobra.Command {
 With --message, run a single exchange and exit — useful for scripts and
 tests. Without it, start an interactive terminal session: type a message and
 press Enter; /exit or Ctrl-D quits. Ctrl-C interrupts the current run; a
-second Ctrl-C exits immediately.`,
+second Ctrl-C exits immediately.
+
+In the interactive session, /help lists the slash commands: among them
+/undo, /retry, /edit, /branch, and /switch rework the conversation, /model
+switches models, and /usage shows the tokens spent so far. End a line with
+\ or wrap lines in """ to send a multi-line message, or write one in
+$EDITOR with /compose.
+
+On a terminal, answers are rendered as they stream in: headings, lists,
+bold, tables, and syntax-highlighted code blocks. --plain, NO_COLOR, or
+output to a pipe prints the markdown as it is.
+
+Every exchange is saved to a session in the agent's state directory
+(.pingu/, or PINGU_STATE_DIR). Without --session each invocation starts a
+new session; --session NAME resumes the session with that ID or name,
+creating it if needed, and --new-session starts NAME over with an empty
+history.`,
 		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			a, err := agent.Load(args[0])
-			if err != nil {
-				return err
-			}
-			cfg := a.Config
-			if err := cfg.ApplyModelFlag(model); err != nil {
-				return err
-			}
-			limits, err := config.DefaultLimits.ApplyEnv()
+			rt, err := loadRuntime(args[0], rtFlags)
 			if err != nil {
 				return err
 			}
-			if maxTurns > 0 {
-				limits.MaxModelTurns = maxTurns
-			}
-			if timeout > 0 {
-				limits.RunTimeout = timeout
-			}
 
-			p, err := openai.FromEnv(nil)
+			store, err := session.Open(context.Background(), session.StateDir(rt.agent.Root))
 			if err != nil {
-				return &config.ConfigError{Field: "OPENAI_API_KEY", Err: err}
+				return err
 			}
-
-			slog.Debug("agent loaded", "root", a.Root, "model", cfg.Model.String())
-
-			// Phase 1 ships no built-in tools; executable tools from the
-			// agent directory land in Phase 2.
-			registry, err := tools.NewRegistry()
+			defer store.Close()
+			conv, err := session.OpenConversation(context.Background(), store, sessionRef, newSession)
 			if err != nil {
 				return err
 			}
+			conv.Model = rt.model.String()
+			slog.Debug("session opened", "session", conv.Session.ID, "messages", len(conv.Messages()))
 
-			r := &runner.Runner{Provider: p, Limits: limits}
+			r := rt.newRunner()
+			out := newOutput(plain)
 			if message != "" {
-				return oneShot(r, registry, a, cfg.Model.String(), message)
+				return oneShot(r, out, rt.tools, rt.agent, conv, rt.model.Model, message, rt.runner.Generation.ResponseFormat == llm.ResponseJSONSchema, showUsage)
 			}
-			return repl(r, registry, a, cfg.Model.String())
+			return interactive(rt, r, out, conv, showUsage)
 		},
 	}
 	cmd.Flags().StringVarP(&message, "message", "m", "", "send one message and exit")
-	cmd.Flags().StringVar(&model, "model", "", "model reference provider/model-id (overrides agent.toml and PINGU_MODEL)")
-	cmd.Flags().IntVar(&maxTurns, "max-turns", 0, "maximum model turns per run (overrides PINGU_MAX_MODEL_TURNS)")
-	cmd.Flags().DurationVar(&timeout, "timeout", 0, "total run timeout (overrides PINGU_RUN_TIMEOUT)")
+	rtFlags.register(cmd)
+	cmd.Flags().StringVar(&sessionRef, "session", "", "resume the session with this ID or name (created if missing)")
+	cmd.Flags().BoolVar(&newSession, "new-session", false, "start a fresh session, even if --session names an existing one")
+	cmd.Flags().BoolVar(&showUsage, "show-usage", false, "print token usage and cost to stderr after each answer")
+	cmd.Flags().BoolVar(&plain, "plain", false, "print answers as they come, without rendering markdown")
 	return cmd
 }
 
-func oneShot(r *runner.Runner, registry *tools.Registry, a *agent.Agent, model, message string) error {
+// runtimeFlags are the flags shared by every command that runs the agent.
+type runtimeFlags struct {
+	model    string
+	maxTurns int
+	timeout  time.Duration
+
+	temperature     float64
+	topP            float64
+	maxOutputTokens int
+	stop            []string
+	seed            int64
+	responseFormat  string
+	outputSchema    string
+	schemaRetries   int
+	cmd             *cobra.Command // to tell set flags from defaults
+}
+
+func (f *runtimeFlags) register(cmd *cobra.Command) {
+	f.cmd = cmd
+	cmd.Flags().StringVar(&f.model, "model", "", "model reference provider/model-id (overrides agent.toml and PINGU_MODEL)")
+	cmd.Flags().IntVar(&f.maxTurns, "max-turns", 0, "maximum model turns per run (overrides agent.toml and PINGU_MAX_MODEL_TURNS)")
+	cmd.Flags().DurationVar(&f.timeout, "timeout", 0, "total run timeout (overrides agent.toml and PINGU_RUN_TIMEOUT)")
+	cmd.Flags().Float64Var(&f.temperature, "temperature", 0, "sampling temperature, 0 to 2 (overrides agent.toml)")
+	cmd.Flags().Float64Var(&f.topP, "top-p", 0, "nucleus sampling probability mass, above 0 up to 1 (overrides agent.toml)")
+	cmd.Flags().IntVar(&f.maxOutputTokens, "max-output-tokens", 0, "output token cap per model call (overrides agent.toml)")
+	cmd.Flags().StringArrayVar(&f.stop, "stop", nil, "stop sequence (repeatable; overrides agent.toml)")
+	cmd.Flags().Int64Var(&f.seed, "seed", 0, "sampling seed, where the provider supports one (overrides agent.toml)")
+	cmd.Flags().StringVar(&f.responseFormat, "response-format", "", `"text", "json", or "json_schema" (overrides agent.toml)`)
+	cmd.Flags().StringVar(&f.outputSchema, "output-schema", "", "JSON Schema file the final answer must match; run -m prints only the validated JSON")
+	cmd.Flags().IntVar(&f.schemaRetries, "schema-retries", defaultSchemaRetries, "times to ask the model to fix an answer that does not match the output schema")
+}
+
+// defaultSchemaRetries is the --schema-retries default.
+const defaultSchemaRetries = 2
+
+// generation returns the generation parameters set by flags.
+func (f *runtimeFlags) generation() (llm.Generation, error) {
+	var g llm.Generation
+	if f.cmd == nil {
+		return g, nil
+	}
+	set := f.cmd.Flags().Changed
+	if set("temperature") {
+		g.Temperature = &f.temperature
+	}
+	if set("top-p") {
+		g.TopP = &f.topP
+	}
+	if set("max-output-tokens") {
+		if f.maxOutputTokens <= 0 {
+			return g, &config.ConfigError{Field: "--max-output-tokens", Err: errors.New("must be positive")}
+		}
+		g.MaxOutputTokens = f.maxOutputTokens
+	}
+	if set("stop") {
+		g.Stop = f.stop
+	}
+	if set("seed") {
+		g.Seed = &f.seed
+	}
+	g.ResponseFormat = llm.ResponseFormat(f.responseFormat)
+	if f.outputSchema != "" {
+		if g.ResponseFormat != "" && g.ResponseFormat != llm.ResponseJSONSchema {
+			return g, &config.ConfigError{Field: "--output-schema", Err: fmt.Errorf("conflicts with --response-format %s", g.ResponseFormat)}
+		}
+		schema, err := config.LoadSchema(f.outputSchema)
+		if err != nil {
+			return g, &config.ConfigError{Field: "--output-schema", Err: err}
+		}
+		g.ResponseFormat, g.Schema = llm.ResponseJSONSchema, schema
+	}
+	if f.schemaRetries < 0 {
+		return g, &config.ConfigError{Field: "--schema-retries", Err: errors.New("must not be negative")}
+	}
+	return g, nil
+}
+
+// agentRuntime is everything a run needs besides its input: the loaded
+// agent, the resolved model and limits, a provider, and the tool registry.
+type agentRuntime struct {
+	agent   *agent.Agent
+	cfg     config.Config
+	flagGen llm.Generation // generation parameters set by flags
+	model   config.ModelRef
+	// runner is the template newRunner copies: provider, limits,
+	// generation, pricing, and context management for the current model.
+	runner runner.Runner
+	tools  *tools.Registry
+}
+
+// loadRuntime loads the agent at path and applies flags over agent.toml,
+// environment, and defaults.
+func loadRuntime(path string, flags runtimeFlags) (*agentRuntime, error) {
+	a, err := agent.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := a.Config
+	if err := cfg.ApplyModelFlag(flags.model); err != nil {
+		return nil, err
+	}
+	limits, err := cfg.ResolveLimits()
+	if err != nil {
+		return nil, err
+	}
+	if flags.maxTurns > 0 {
+		limits.MaxModelTurns = flags.maxTurns
+	}
+	if flags.timeout > 0 {
+		limits.RunTimeout = flags.timeout
+	}
+
+	flagGen, err := flags.generation()
+	if err != nil {
+		return nil, err
+	}
+	rt := &agentRuntime{
+		agent:   a,
+		cfg:     cfg,
+		flagGen: flagGen,
+		runner: runner.Runner{
+			Limits:        limits,
+			SchemaRetries: flags.schemaRetries,
+			Pricing:       cfg.PriceOf,
+		},
+	}
+	if err := rt.useModel(cfg.Model); err != nil {
+		return nil, err
+	}
+
+	slog.Debug("agent loaded", "root", a.Root, "model", cfg.Model.String())
+
+	registry, err := a.Registry(limits)
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("tools registered", "count", len(registry.List()))
+	rt.tools = registry
+	return rt, nil
+}
+
+// useModel makes ref the runtime's primary model, ahead of the configured
+// fallback models, and rebuilds the provider chain for it.
+func (rt *agentRuntime) useModel(ref config.ModelRef) error {
+	cfg := rt.cfg
+	cfg.Model = ref
+	cfg.FallbackModels = slices.DeleteFunc(slices.Clone(cfg.FallbackModels), func(m config.ModelRef) bool { return m == ref })
+	var routes []provider.Route
+	for _, m := range cfg.Models() {
+		route, err := newRoute(cfg, m, rt.flagGen, rt.runner.Limits)
+		if err != nil {
+			if m != ref {
+				return fmt.Errorf("fallback model %s: %w", m, err)
+			}
+			return err
+		}
+		routes = append(routes, route)
+	}
+	rt.model = ref
+	rt.runner.Generation = routes[0].Generation
+	rt.runner.Provider = provider.WithFallback(routes, cfg.FallbackOn)
+	rt.runner.Context = contextManager(cfg)
+	return nil
+}
+
+// newRoute builds the provider for ref, retrying transient errors, with the
+// generation parameters for its provider and flagGen applied on top.
+func newRoute(cfg config.Config, ref config.ModelRef, flagGen llm.Generation, limits config.Limits) (provider.Route, error) {
+	generation, err := config.ApplyGenerationFlags(cfg.GenerationFor(ref), flagGen)
+	if err != nil {
+		return provider.Route{}, err
+	}
+	if err := provider.CheckGeneration(ref, generation); err != nil {
+		return provider.Route{}, err
+	}
+	p, err := provider.New(ref, provider.OptionsFrom(cfg, ref))
+	if err != nil {
+		return provider.Route{}, err
+	}
+	p = provider.WithRetry(p, provider.RetryPolicy{MaxAttempts: limits.MaxModelAttempts})
+	return provider.Route{Model: ref, Provider: p, Generation: generation}, nil
+}
+
+// contextManager returns the runner's context manager for cfg, or nil when
+// compaction is off or no model's context window is known.
+func contextManager(cfg config.Config) *runner.ContextManager {
+	window, ok := cfg.ContextWindow()
+	if !ok || cfg.Context.Strategy == config.ContextOff {
+		if !ok {
+			slog.Debug("no context window known; history is not compacted", "model", cfg.Model.String())
+		}
+		return nil
+	}
+	return &runner.ContextManager{
+		Window:    window,
+		Reserve:   cfg.Context.ReserveTokens,
+		Summarize: cfg.Context.Strategy == config.ContextSummarize,
+	}
+}
+
+// newRunner returns a Runner for this runtime. Runners are for sequential
+// use, so concurrent callers each take their own.
+func (rt *agentRuntime) newRunner() *runner.Runner {
+	r := rt.runner
+	return &r
+}
+
+// oneShot runs one exchange. With structured set, the answer is only
+// printed once it has matched the output schema, so stdout carries nothing
+// but the validated JSON.
+func oneShot(r *runner.Runner, out *output, registry *tools.Registry, a *agent.Agent, conv *session.Conversation, model, message string, structured, showUsage bool) error {
 	ctx, cancel, stop := withSignalCancel()
 	defer func() {
 		cancel()
 		stop()
 	}()
-	_, err := r.Run(ctx, runner.RunRequest{
+	render := out.event
+	if structured {
+		render = func(ev runner.Event) {
+			if ev.Kind != runner.EventTextDelta {
+				out.event(ev)
+			}
+		}
+	}
+	result, err := conv.Exchange(ctx, r, runner.RunRequest{
 		RunID:        newRunID(),
-		Instructions: a.Instructions,
+		Instructions: a.SystemPrompt(),
 		Model:        model,
 		Input:        message,
 		Tools:        registry,
-	}, renderEvent)
+	}, render)
+	out.flush()
+	if structured && err == nil {
+		if n := len(result.Messages); n > 0 {
+			fmt.Fprint(os.Stdout, strings.TrimSpace(result.Messages[n-1].Content))
+		}
+	}
 	if err == nil || errors.Is(err, context.Canceled) {
 		fmt.Fprintln(os.Stdout)
 	}
+	if showUsage && err == nil {
+		printUsage(r, result)
+	}
 	return err
 }
 
-func repl(r *runner.Runner, registry *tools.Registry, a *agent.Agent, model string) error {
-	conv := &conversation{}
-	reader := bufio.NewScanner(os.Stdin)
-	reader.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+func interactive(rt *agentRuntime, r *runner.Runner, out *output, conv *session.Conversation, showUsage bool) error {
+	commands := repl.Default()
+	state := &repl.State{
+		Out:          os.Stdout,
+		Conv:         conv,
+		Runner:       r,
+		Tools:        rt.tools,
+		Instructions: rt.agent.SystemPrompt(),
+		Model:        rt.model,
+		SetModel: func(ref config.ModelRef) error {
+			if err := rt.useModel(ref); err != nil {
+				return err
+			}
+			*r = *rt.newRunner()
+			return nil
+		},
+		Models: knownModels(rt.cfg),
+	}
+	lines := repl.NewScanner(os.Stdin, os.Stdout)
+	if repl.IsTerminal(os.Stdin) && repl.IsTerminal(os.Stdout) {
+		history, err := repl.LoadHistory(filepath.Join(session.StateDir(rt.agent.Root), repl.HistoryFile), repl.DefaultHistorySize)
+		if err != nil {
+			slog.Warn("input history is not kept", "error", err)
+		}
+		lines = repl.NewTerminal(os.Stdin, os.Stdout, history, func(line string) []string {
+			return commands.Complete(context.Background(), state, line)
+		})
+		state.Editor = repl.EditText
+	}
 
-	fmt.Fprintln(os.Stdout, "pingu — type a message, /exit or Ctrl-D to quit")
+	if n := len(conv.Messages()); n > 0 {
+		fmt.Fprintf(os.Stdout, "pingu — resumed session %s (%d messages), /help for commands, /exit or Ctrl-D to quit\n", conv.Label(), n)
+	} else {
+		fmt.Fprintln(os.Stdout, "pingu — type a message, /help for commands, /exit or Ctrl-D to quit")
+	}
 	for {
-		fmt.Fprint(os.Stdout, "> ")
-		if !reader.Scan() {
+		input, err := repl.ReadInput(lines)
+		if errors.Is(err, io.EOF) {
 			fmt.Fprintln(os.Stdout)
-			return nil // EOF
+			return nil
 		}
-		line := strings.TrimSpace(reader.Text())
-		if line == "" {
+		if err != nil {
+			return err
+		}
+		input = strings.TrimSpace(input)
+		if input == "" {
 			continue
 		}
-		if line == "/exit" {
-			return nil
+		if repl.IsCommand(input) {
+			var err error
+			input, err = commands.Dispatch(context.Background(), state, input)
+			if errors.Is(err, repl.ErrExit) {
+				return nil
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				continue
+			}
+			if input == "" {
+				continue
+			}
+		} else {
+			input = repl.Message(input)
 		}
 
 		ctx, cancel, stop := withSignalCancel()
-		result, err := r.Run(ctx, runner.RunRequest{
+		result, err := state.Conv.Exchange(ctx, r, runner.RunRequest{
 			RunID:        newRunID(),
-			Instructions: a.Instructions,
-			Model:        model,
-			Input:        line,
-			History:      conv.messages(),
-			Tools:        registry,
-		}, renderEvent)
+			Instructions: state.Instructions,
+			Model:        state.Model.Model,
+			Input:        input,
+			Tools:        state.Tools,
+		}, out.event)
 		cancel()
 		stop()
+		out.flush()
 
 		if err != nil {
 			if errors.Is(err, context.Canceled) {
@@ -145,17 +428,82 @@ func repl(r *runner.Runner, registry *tools.Registry, a *agent.Agent, model stri
 			}
 			return err
 		}
-		conv.append(result.Messages)
+		state.Record(result)
 		fmt.Fprintln(os.Stdout)
+		if showUsage {
+			printUsage(r, result)
+		}
 	}
 }
 
-// renderEvent prints run events: assistant text to stdout, diagnostics to
-// stderr.
-func renderEvent(ev runner.Event) {
+// knownModels lists the model references offered by /model completion: the
+// configured ones and those with built-in prices or context windows.
+func knownModels(cfg config.Config) []string {
+	var out []string
+	for _, ref := range cfg.Models() {
+		out = append(out, ref.String())
+	}
+	for ref := range config.DefaultPricing {
+		out = append(out, ref.String())
+	}
+	for ref := range config.DefaultContextWindows {
+		out = append(out, ref.String())
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// printUsage writes a run's token usage to stderr, with its cost when every
+// model that served it has a price.
+func printUsage(r *runner.Runner, result runner.RunResult) {
+	line := fmt.Sprintf("usage: %d input + %d output tokens", result.Usage.InputTokens, result.Usage.OutputTokens)
+	priced := r.Pricing != nil
+	for _, m := range result.Models {
+		if priced {
+			_, priced = r.Pricing(m)
+		}
+	}
+	if priced {
+		line += fmt.Sprintf(", $%.4f", result.CostUSD)
+	}
+	fmt.Fprintln(os.Stderr, line)
+}
+
+// output prints run events: assistant text to stdout, rendered as markdown
+// on a terminal, and diagnostics to stderr.
+type output struct {
+	md *markdown.Renderer // nil prints text as it comes
+}
+
+// newOutput renders markdown when stdout is a terminal, unless plain is set
+// or NO_COLOR is.
+func newOutput(plain bool) *output {
+	fd := int(os.Stdout.Fd())
+	if plain || os.Getenv("NO_COLOR") != "" || !term.IsTerminal(fd) {
+		return &output{}
+	}
+	width := 80
+	if w, _, err := term.GetSize(fd); err == nil && w > 0 {
+		width = w
+	}
+	return &output{md: markdown.NewRenderer(os.Stdout, width)}
+}
+
+// event prints ev. Text the renderer holds back is flushed before anything
+// else is printed, so output stays in order.
+func (o *output) event(ev runner.Event) {
+	if ev.Kind == runner.EventTextDelta {
+		if o.md != nil {
+			o.md.Write([]byte(ev.Text))
+		} else {
+			fmt.Fprint(os.Stdout, ev.Text)
+		}
+		return
+	}
+	if ev.Kind != runner.EventRunStarted {
+		o.flush()
+	}
 	switch ev.Kind {
-	case runner.EventTextDelta:
-		fmt.Fprint(os.Stdout, ev.Text)
 	case runner.EventToolStarted:
 		fmt.Fprintf(os.Stderr, "→ %s\n", ev.ToolName)
 	case runner.EventToolFinished:
@@ -167,15 +515,13 @@ func renderEvent(ev runner.Event) {
 	}
 }
 
-// conversation accumulates messages across runs within one process.
-type conversation struct {
-	msgs []llm.Message
+// flush ends the assistant text printed so far.
+func (o *output) flush() {
+	if o.md != nil {
+		o.md.Flush()
+	}
 }
 
-func (c *conversation) messages() []llm.Message { return c.msgs }
-
-func (c *conversation) append(msgs []llm.Message) { c.msgs = append(c.msgs, msgs...) }
-
 // withSignalCancel returns a context that is cancelled on the first SIGINT.
 // A second SIGINT while the first is being handled exits immediately with
 // code 130. stop releases the signal handler.
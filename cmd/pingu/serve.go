@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/chtushar/pingu/internal/config"
+	"github.com/chtushar/pingu/internal/server"
+
+	"github.com/spf13/cobra"
+)
+
+// shutdownTimeout bounds how long serve waits for open connections after
+// its runs were cancelled.
+const shutdownTimeout = 10 * time.Second
+
+// defaultServeAddr keeps the server on the local machine unless --addr says
+// otherwise.
+const defaultServeAddr = "127.0.0.1:8080"
+
+func newServeCmd() *cobra.Command {
+	var (
+		addr          string
+		rtFlags       runtimeFlags
+		maxConcurrent int
+	)
+	cmd := &cobra.Command{
+		Use:   "serve PATH",
+		Short: "Serve the agent at PATH over HTTP",
+		Long: `Serve the agent defined at PATH over HTTP.
+
+POST /v1/runs with {"input": "..."} starts a run and streams its events as
+Server-Sent Events. GET /v1/runs/{id} returns the run's status and result;
+DELETE /v1/runs/{id} cancels it. Each request is a fresh conversation.
+
+POST /v1/chat/completions accepts OpenAI Chat Completions requests, so
+existing OpenAI clients can talk to the agent unchanged. Tools run on the
+server and only assistant text is returned.
+
+The server listens on 127.0.0.1 by default. With PINGU_SERVER_TOKEN set,
+every request must send "Authorization: Bearer <token>"; listening on any
+other address requires it.
+
+SIGINT or SIGTERM cancels running runs and stops the server.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			token := os.Getenv("PINGU_SERVER_TOKEN")
+			if token == "" && !isLoopback(addr) {
+				return &config.ConfigError{Field: "--addr", Err: fmt.Errorf("%s is reachable from other hosts: set PINGU_SERVER_TOKEN to require a bearer token", addr)}
+			}
+			rt, err := loadRuntime(args[0], rtFlags)
+			if err != nil {
+				return err
+			}
+			srv := server.New(server.Config{
+				Instructions:      rt.agent.SystemPrompt(),
+				Model:             rt.model.Model,
+				Tools:             rt.tools,
+				NewRunner:         rt.newRunner,
+				MaxConcurrentRuns: maxConcurrent,
+				Token:             token,
+			})
+
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				return err
+			}
+			httpSrv := &http.Server{Handler: srv.Handler(), ReadHeaderTimeout: 10 * time.Second}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			errc := make(chan error, 1)
+			go func() { errc <- httpSrv.Serve(ln) }()
+			slog.Info("serving", "addr", ln.Addr().String(), "model", rt.model.String())
+
+			select {
+			case err := <-errc:
+				srv.Close()
+				return err
+			case <-ctx.Done():
+			}
+			slog.Info("shutting down")
+			srv.Close()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := httpSrv.Shutdown(shutdownCtx); err != nil {
+				return err
+			}
+			if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&addr, "addr", defaultServeAddr, "listen address; other than loopback needs PINGU_SERVER_TOKEN")
+	cmd.Flags().IntVar(&maxConcurrent, "max-concurrent-runs", server.DefaultMaxConcurrentRuns, "runs in flight before requests are refused with 429")
+	rtFlags.register(cmd)
+	return cmd
+}
+
+// isLoopback reports whether addr, a listen address, only accepts
+// connections from the local machine. An empty host listens on every
+// interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
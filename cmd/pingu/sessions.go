@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chtushar/pingu/internal/agent"
+	"github.com/chtushar/pingu/internal/config"
+	"github.com/chtushar/pingu/internal/session"
+
+	"github.com/spf13/cobra"
+)
+
+func newSessionsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sessions",
+		Short: "List, show, export, and delete stored sessions",
+		Long: `Manage the sessions saved by pingu run for the agent at PATH.
+
+Sessions live in the agent's state directory (.pingu/, or PINGU_STATE_DIR).
+A session is addressed by its ID or its name.`,
+	}
+	cmd.AddCommand(newSessionsListCmd())
+	cmd.AddCommand(newSessionsShowCmd())
+	cmd.AddCommand(newSessionsExportCmd())
+	cmd.AddCommand(newSessionsDeleteCmd())
+	cmd.AddCommand(newSessionsPruneCmd())
+	return cmd
+}
+
+func newSessionsListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list PATH",
+		Short: "List sessions, most recently active first",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withStore(args[0], func(ctx context.Context, store *session.Store) error {
+				sums, err := store.List(ctx)
+				if err != nil {
+					return err
+				}
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(tw, "ID\tNAME\tTITLE\tLAST ACTIVITY\tTURNS\tTOKENS (IN/OUT)")
+				for _, s := range sums {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d/%d\n",
+						s.ID, orDash(s.Name), orDash(s.Title), s.UpdatedAt.Local().Format("2006-01-02 15:04"),
+						s.Turns, s.Usage.InputTokens, s.Usage.OutputTokens)
+				}
+				return tw.Flush()
+			})
+		},
+	}
+}
+
+func newSessionsShowCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show PATH ID",
+		Short: "Print a session transcript with tool calls and results",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withStore(args[0], func(ctx context.Context, store *session.Store) error {
+				sess, entries, err := loadTranscript(ctx, store, args[1])
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stdout, "session %s", sess.ID)
+				if sess.Name != "" {
+					fmt.Fprintf(os.Stdout, " (%s)", sess.Name)
+				}
+				fmt.Fprintf(os.Stdout, " — last activity %s\n\n", sess.UpdatedAt.Local().Format("2006-01-02 15:04"))
+				return session.WriteTranscript(os.Stdout, entries)
+			})
+		},
+	}
+}
+
+func newSessionsExportCmd() *cobra.Command {
+	var format string
+	cmd := &cobra.Command{
+		Use:   "export PATH ID",
+		Short: "Export a session as JSON Lines or Markdown",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != session.FormatJSONL && format != session.FormatMarkdown {
+				return &config.ConfigError{Field: "--format", Err: fmt.Errorf("invalid value %q (want jsonl or markdown)", format)}
+			}
+			return withStore(args[0], func(ctx context.Context, store *session.Store) error {
+				sess, entries, err := loadTranscript(ctx, store, args[1])
+				if err != nil {
+					return err
+				}
+				return session.Export(os.Stdout, sess, entries, format)
+			})
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", session.FormatJSONL, "export format: jsonl or markdown")
+	return cmd
+}
+
+func newSessionsDeleteCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete PATH ID",
+		Short: "Delete a session and its history",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withStore(args[0], func(ctx context.Context, store *session.Store) error {
+				sess, err := findSession(ctx, store, args[1])
+				if err != nil {
+					return err
+				}
+				if err := store.Delete(ctx, sess.ID); err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stdout, "deleted session %s\n", sess.ID)
+				return nil
+			})
+		},
+	}
+}
+
+func newSessionsPruneCmd() *cobra.Command {
+	var olderThan string
+	cmd := &cobra.Command{
+		Use:   "prune PATH",
+		Short: "Delete sessions with no activity for a while",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			age, err := parseAge(olderThan)
+			if err != nil {
+				return &config.ConfigError{Field: "--older-than", Err: err}
+			}
+			return withStore(args[0], func(ctx context.Context, store *session.Store) error {
+				n, err := store.Prune(ctx, time.Now().Add(-age))
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stdout, "pruned %d session(s)\n", n)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().StringVar(&olderThan, "older-than", "30d", "minimum inactivity, e.g. 30d, 2w, or 12h")
+	return cmd
+}
+
+// withStore loads the agent at path and opens its session store.
+func withStore(path string, fn func(ctx context.Context, store *session.Store) error) error {
+	a, err := agent.Load(path)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	store, err := session.Open(ctx, session.StateDir(a.Root))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return fn(ctx, store)
+}
+
+// findSession resolves an ID or name; an unknown session is a usage error.
+func findSession(ctx context.Context, store *session.Store, ref string) (*session.Session, error) {
+	sess, err := store.Get(ctx, ref)
+	if errors.Is(err, session.ErrNotFound) {
+		return nil, &config.ConfigError{Field: "session", Err: err}
+	}
+	return sess, err
+}
+
+func loadTranscript(ctx context.Context, store *session.Store, ref string) (*session.Session, []session.Entry, error) {
+	sess, err := findSession(ctx, store, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err := store.Transcript(ctx, sess.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sess, entries, nil
+}
+
+// parseAge accepts Go durations plus whole days ("30d") and weeks ("2w").
+func parseAge(s string) (time.Duration, error) {
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit != 0 {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * unit, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return d, nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
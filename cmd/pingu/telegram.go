@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/chtushar/pingu/internal/config"
+	"github.com/chtushar/pingu/internal/session"
+	"github.com/chtushar/pingu/internal/telegram"
+
+	"github.com/spf13/cobra"
+)
+
+func newTelegramCmd() *cobra.Command {
+	var (
+		rtFlags    runtimeFlags
+		allowUsers []int64
+	)
+	cmd := &cobra.Command{
+		Use:   "telegram PATH",
+		Short: "Chat with the agent at PATH through a Telegram bot",
+		Long: `Run the agent defined at PATH as a Telegram bot.
+
+The bot long-polls the Bot API with the token in TELEGRAM_BOT_TOKEN and
+answers only the user IDs given with --allow-user or TELEGRAM_ALLOWED_USERS
+(comma-separated); an allowlist is required. Each chat keeps one
+conversation, saved as the session "telegram:<chat ID>"; /new in a chat
+starts it over. Replies are edited in place as text streams in, with tool
+activity shown until the run finishes.
+
+TELEGRAM_API_URL overrides the Bot API base URL. SIGINT or SIGTERM stops the
+bot.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			token := os.Getenv("TELEGRAM_BOT_TOKEN")
+			if token == "" {
+				return &config.ConfigError{Field: "TELEGRAM_BOT_TOKEN", Err: errors.New("not set")}
+			}
+			if len(allowUsers) == 0 {
+				ids, err := parseUserIDs(os.Getenv("TELEGRAM_ALLOWED_USERS"))
+				if err != nil {
+					return &config.ConfigError{Field: "TELEGRAM_ALLOWED_USERS", Err: err}
+				}
+				allowUsers = ids
+			}
+			if len(allowUsers) == 0 {
+				return &config.ConfigError{Field: "--allow-user", Err: errors.New("an allowlist of Telegram user IDs is required (or set TELEGRAM_ALLOWED_USERS)")}
+			}
+
+			rt, err := loadRuntime(args[0], rtFlags)
+			if err != nil {
+				return err
+			}
+			store, err := session.Open(context.Background(), session.StateDir(rt.agent.Root))
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			bot, err := telegram.New(telegram.Config{
+				Client:       telegram.NewClient(os.Getenv("TELEGRAM_API_URL"), token, nil),
+				AllowedUsers: allowUsers,
+				Store:        store,
+				Instructions: rt.agent.SystemPrompt(),
+				Model:        rt.model.Model,
+				ModelRef:     rt.model.String(),
+				Tools:        rt.tools,
+				NewRunner:    rt.newRunner,
+			})
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			slog.Info("telegram bot started", "model", rt.model.String(), "allowed_users", len(allowUsers))
+			return bot.Run(ctx)
+		},
+	}
+	cmd.Flags().Int64SliceVar(&allowUsers, "allow-user", nil, "Telegram user ID allowed to talk to the bot (repeatable; overrides TELEGRAM_ALLOWED_USERS)")
+	rtFlags.register(cmd)
+	return cmd
+}
+
+// parseUserIDs parses a comma-separated list of Telegram user IDs.
+func parseUserIDs(s string) ([]int64, error) {
+	var ids []int64
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user ID %q", f)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/chtushar/pingu/internal/agent"
+	"github.com/chtushar/pingu/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+func newValidateCmd() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "validate PATH",
+		Short: "Check an agent directory without running it",
+		Long: `Check the agent defined at PATH and report every problem found:
+instructions.md, agent.toml, tool manifests and their parameter schemas,
+skills front-matter, the model and fallback model references and their
+generation parameters against the registered providers, and limits from
+agent.toml and PINGU_* variables.
+Provider credentials are not required.
+
+Problems are printed one per line, or as a JSON object with --json. The exit
+code is 0 when the agent is valid and 2 when there are problems, so the
+command works as a pre-commit or CI check.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root, problems := agent.Validate(args[0])
+			if asJSON {
+				if problems == nil {
+					problems = []agent.Problem{}
+				}
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(map[string]any{"path": root, "valid": len(problems) == 0, "problems": problems}); err != nil {
+					return err
+				}
+			} else {
+				for _, p := range problems {
+					fmt.Fprintln(os.Stdout, p)
+				}
+				if len(problems) == 0 {
+					fmt.Fprintf(os.Stdout, "%s: ok\n", root)
+				}
+			}
+			switch n := len(problems); n {
+			case 0:
+				return nil
+			case 1:
+				return &config.ConfigError{Err: fmt.Errorf("%s: 1 problem", root)}
+			default:
+				return &config.ConfigError{Err: fmt.Errorf("%s: %d problems", root, n)}
+			}
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the report as JSON")
+	return cmd
+}
@@ -5,6 +5,7 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -12,6 +13,8 @@ import (
 	"strings"
 
 	"github.com/chtushar/pingu/internal/config"
+	"github.com/chtushar/pingu/internal/skills"
+	"github.com/chtushar/pingu/internal/tools"
 )
 
 // MaxInstructionsBytes bounds the instructions file.
@@ -20,51 +23,224 @@ const MaxInstructionsBytes = 256 * 1024
 // InstructionsFile is the only required file in an agent directory.
 const InstructionsFile = "instructions.md"
 
+// ToolsDir holds executable tool plugins, relative to the agent root.
+const ToolsDir = "tools"
+
 // Agent is a loaded agent directory.
 type Agent struct {
 	Root         string // absolute, symlink-free path to the agent root
 	Instructions string
 	Config       config.Config
+	Tools        []*tools.Executable // discovered from ToolsDir, sorted by name
+	Skills       []skills.Skill      // loaded from skills/, sorted by name
 }
 
-// Load validates the directory at path and resolves its configuration.
+// Load validates the directory at path and resolves its configuration. It
+// returns the first problem found; Validate reports all of them.
 func Load(path string) (*Agent, error) {
+	a, _, errs := load(path)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return a, nil
+}
+
+// load runs every check Load performs, continuing past a failure when later
+// checks do not depend on it, and returns all problems as ConfigErrors. The
+// agent is nil only when the root cannot be resolved; configOK reports
+// whether agent.toml resolved, which later checks need.
+func load(path string) (a *Agent, configOK bool, errs []error) {
 	root, err := filepath.Abs(path)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return nil, false, []error{&config.ConfigError{File: path, Err: fmt.Errorf("resolve path: %w", err)}}
+	}
+	a = &Agent{Root: root}
+
+	instructions, err := readInstructions(root)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	a.Instructions = instructions
+
+	cfg, err := config.Load(root)
+	if err != nil {
+		errs = append(errs, unjoin(err)...)
+	} else {
+		configOK = true
+		a.Config = cfg
+		if cfg.Tools.Shell.Enabled {
+			if err := checkDir("tools.shell.workdir", cfg.Tools.Shell.Workdir); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if cfg.Tools.Files.Enabled {
+			if err := checkDir("tools.files.workspace", cfg.Tools.Files.Workspace); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	execs, err := tools.Discover(context.Background(), filepath.Join(root, ToolsDir))
 	if err != nil {
-		return nil, &config.ConfigError{File: path, Err: fmt.Errorf("resolve path: %w", err)}
+		errs = append(errs, &config.ConfigError{File: ToolsDir, Err: err})
 	}
+	a.Tools = execs
 
+	sks, err := skills.Load(filepath.Join(root, skills.Dir))
+	for _, err := range unjoin(err) {
+		var skillErr *skills.Error
+		if errors.As(err, &skillErr) {
+			errs = append(errs, &config.ConfigError{File: skillErr.File, Err: skillErr.Err})
+		} else {
+			errs = append(errs, &config.ConfigError{File: skills.Dir, Err: err})
+		}
+	}
+	a.Skills = sks
+	return a, configOK, errs
+}
+
+// readInstructions reads and checks the instructions file.
+func readInstructions(root string) (string, error) {
 	instrPath := filepath.Join(root, InstructionsFile)
 	info, err := os.Stat(instrPath)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return nil, &config.ConfigError{File: InstructionsFile, Err: fmt.Errorf("not found in %s", root)}
+			return "", &config.ConfigError{File: InstructionsFile, Err: fmt.Errorf("not found in %s", root)}
 		}
-		return nil, &config.ConfigError{File: InstructionsFile, Err: err}
+		return "", &config.ConfigError{File: InstructionsFile, Err: err}
 	}
 	if !info.Mode().IsRegular() {
-		return nil, &config.ConfigError{File: InstructionsFile, Err: errors.New("not a regular file")}
+		return "", &config.ConfigError{File: InstructionsFile, Err: errors.New("not a regular file")}
 	}
 	if info.Size() > MaxInstructionsBytes {
-		return nil, &config.ConfigError{File: InstructionsFile, Err: fmt.Errorf("size %d exceeds limit %d", info.Size(), MaxInstructionsBytes)}
+		return "", &config.ConfigError{File: InstructionsFile, Err: fmt.Errorf("size %d exceeds limit %d", info.Size(), MaxInstructionsBytes)}
 	}
 
 	data, err := os.ReadFile(instrPath)
 	if err != nil {
-		return nil, &config.ConfigError{File: InstructionsFile, Err: err}
+		return "", &config.ConfigError{File: InstructionsFile, Err: err}
 	}
 	if len(bytes.TrimSpace(data)) == 0 {
-		return nil, &config.ConfigError{File: InstructionsFile, Err: errors.New("file is empty")}
+		return "", &config.ConfigError{File: InstructionsFile, Err: errors.New("file is empty")}
 	}
+	return strings.TrimRight(string(data), "\n"), nil
+}
 
-	cfg, err := config.Load(root)
+// unjoin returns the errors joined in err, flattening nested joins, or err
+// itself; nil yields none.
+func unjoin(err error) []error {
+	if err == nil {
+		return nil
+	}
+	j, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var errs []error
+	for _, e := range j.Unwrap() {
+		errs = append(errs, unjoin(e)...)
+	}
+	return errs
+}
+
+// SystemPrompt returns the instructions followed by the skill index, if the
+// agent has skills.
+func (a *Agent) SystemPrompt() string {
+	if index := skills.Index(a.Skills); index != "" {
+		return a.Instructions + "\n\n" + index
+	}
+	return a.Instructions
+}
+
+// checkDir reports a ConfigError for field unless dir is a directory.
+func checkDir(field, dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return &config.ConfigError{File: "agent.toml", Field: field, Err: err}
+	}
+	if !info.IsDir() {
+		return &config.ConfigError{File: "agent.toml", Field: field, Err: errors.New("not a directory")}
+	}
+	return nil
+}
+
+// Registry builds the tool registry for one run: the built-in tools enabled
+// in agent.toml, load_skill when the agent has skills, and the executable
+// tools, less those named in tools.disabled. Tools capture at most
+// limits.MaxToolOutputBytes of output per call; the per-call timeout is
+// applied by the runner through the call context. Errors are ConfigErrors.
+func (a *Agent) Registry(limits config.Limits) (*tools.Registry, error) {
+	limits = limits.WithDefaults()
+	ts := make([]tools.Tool, 0, len(a.Tools)+8)
+	if sh := a.Config.Tools.Shell; sh.Enabled {
+		ts = append(ts, tools.NewShell(tools.ShellConfig{
+			Dir:       sh.Workdir,
+			Env:       sh.Env,
+			MaxOutput: limits.MaxToolOutputBytes,
+		}))
+	}
+	if fc := a.Config.Tools.Files; fc.Enabled {
+		ws, err := tools.NewWorkspace(fc.Workspace)
+		if err != nil {
+			return nil, &config.ConfigError{File: "agent.toml", Field: "tools.files.workspace", Err: err}
+		}
+		ts = append(ts, tools.FileTools(ws, tools.FilesConfig{
+			ReadOnly:  fc.ReadOnly,
+			MaxOutput: limits.MaxToolOutputBytes,
+		})...)
+	}
+	if wf := a.Config.Tools.WebFetch; wf.Enabled {
+		ts = append(ts, tools.NewWebFetch(tools.WebFetchConfig{
+			AllowDomains: wf.AllowDomains,
+			DenyDomains:  wf.DenyDomains,
+			Timeout:      wf.Timeout,
+			MaxBytes:     wf.MaxResponseBytes,
+			MaxOutput:    limits.MaxToolOutputBytes,
+			AllowPrivate: wf.AllowPrivate,
+		}))
+	}
+	if len(a.Skills) > 0 {
+		ts = append(ts, skills.NewTool(a.Skills))
+	}
+	for _, t := range a.Tools {
+		ts = append(ts, t.WithOutputLimit(limits.MaxToolOutputBytes))
+	}
+	ts, err := disable(ts, a.Config.Tools.Disabled)
 	if err != nil {
 		return nil, err
 	}
+	reg, err := tools.NewRegistry(ts...)
+	if err != nil {
+		return nil, &config.ConfigError{File: ToolsDir, Err: err}
+	}
+	return reg, nil
+}
 
-	return &Agent{
-		Root:         root,
-		Instructions: strings.TrimRight(string(data), "\n"),
-		Config:       cfg,
-	}, nil
+// disable drops the tools named in names. A name that matches no tool is a
+// ConfigError, since it is most likely a typo.
+func disable(ts []tools.Tool, names []string) ([]tools.Tool, error) {
+	if len(names) == 0 {
+		return ts, nil
+	}
+	drop := make(map[string]bool, len(names))
+	for _, n := range names {
+		drop[n] = true
+	}
+	kept := ts[:0]
+	for _, t := range ts {
+		if drop[t.Name()] {
+			delete(drop, t.Name())
+			continue
+		}
+		kept = append(kept, t)
+	}
+	for _, n := range names {
+		if drop[n] {
+			return nil, &config.ConfigError{File: "agent.toml", Field: "tools.disabled", Err: fmt.Errorf("unknown tool %q", n)}
+		}
+	}
+	return kept, nil
 }
@@ -4,11 +4,16 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"testing"
 
 	"github.com/chtushar/pingu/internal/agent"
 	"github.com/chtushar/pingu/internal/config"
+
+	// Register a provider for Validate's model check.
+	_ "github.com/chtushar/pingu/internal/provider/openai"
 )
 
 func TestLoad_Minimal(t *testing.T) {
@@ -83,4 +88,251 @@ func TestLoad_ExampleAgent(t *testing.T) {
 	if !strings.Contains(a.Instructions, "hello-agent") {
 		t.Errorf("unexpected instructions: %q", a.Instructions)
 	}
+	if len(a.Skills) != 1 || a.Skills[0].Name != "haiku" {
+		t.Errorf("skills = %+v", a.Skills)
+	}
+}
+
+func TestLoad_DiscoversTools(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell-script tools need a Unix shell")
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "instructions.md"), []byte("hi"), 0o644)
+	os.Mkdir(filepath.Join(dir, agent.ToolsDir), 0o755)
+	script := "#!/bin/sh\n[ \"$1\" = \"--manifest\" ] && exec echo '{\"name\":\"greet\",\"description\":\"says hi\"}'\necho hi\n"
+	os.WriteFile(filepath.Join(dir, agent.ToolsDir, "greet"), []byte(script), 0o755)
+
+	a, err := agent.Load(dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	reg, err := a.Registry(config.DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reg.Get("greet"); !ok {
+		t.Errorf("greet not registered: %v", reg.List())
+	}
+}
+
+func TestLoad_BadToolManifest(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell-script tools need a Unix shell")
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "instructions.md"), []byte("hi"), 0o644)
+	os.Mkdir(filepath.Join(dir, agent.ToolsDir), 0o755)
+	os.WriteFile(filepath.Join(dir, agent.ToolsDir, "broken"), []byte("#!/bin/sh\nexit 1\n"), 0o755)
+
+	_, err := agent.Load(dir)
+	var cfgErr *config.ConfigError
+	if !errors.As(err, &cfgErr) || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("expected ConfigError naming the tool, got %v", err)
+	}
+}
+
+func TestRegistry_ShellTool(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "instructions.md"), []byte("hi"), 0o644)
+
+	a, err := agent.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, _ := a.Registry(config.DefaultLimits)
+	if _, ok := reg.Get("shell"); ok {
+		t.Error("shell registered without opting in")
+	}
+
+	os.WriteFile(filepath.Join(dir, "agent.toml"), []byte("[tools.shell]\nenabled = true\n"), 0o644)
+	a, err = agent.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, _ = a.Registry(config.DefaultLimits)
+	if _, ok := reg.Get("shell"); !ok {
+		t.Error("shell not registered")
+	}
+
+	os.WriteFile(filepath.Join(dir, "agent.toml"), []byte("[tools.shell]\nenabled = true\nworkdir = \"missing\"\n"), 0o644)
+	_, err = agent.Load(dir)
+	var cfgErr *config.ConfigError
+	if !errors.As(err, &cfgErr) || !strings.Contains(err.Error(), "tools.shell.workdir") {
+		t.Errorf("expected workdir ConfigError, got %v", err)
+	}
+}
+
+func TestRegistry_FileAndWebTools(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "instructions.md"), []byte("hi"), 0o644)
+	os.WriteFile(filepath.Join(dir, "agent.toml"), []byte("[tools.files]\nenabled = true\nread_only = true\n[tools.web_fetch]\nenabled = true\n"), 0o644)
+
+	a, err := agent.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := a.Registry(config.DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"web_fetch": true, "read_file": true, "list_dir": true, "search_files": true, "write_file": false, "apply_patch": false} {
+		if _, ok := reg.Get(name); ok != want {
+			t.Errorf("%s registered = %v, want %v", name, ok, want)
+		}
+	}
+
+	os.WriteFile(filepath.Join(dir, "agent.toml"), []byte("[tools.files]\nenabled = true\nworkspace = \"missing\"\n"), 0o644)
+	_, err = agent.Load(dir)
+	var cfgErr *config.ConfigError
+	if !errors.As(err, &cfgErr) || !strings.Contains(err.Error(), "tools.files.workspace") {
+		t.Errorf("expected workspace ConfigError, got %v", err)
+	}
+}
+
+func TestRegistry_DisabledTools(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "instructions.md"), []byte("hi"), 0o644)
+	os.WriteFile(filepath.Join(dir, "agent.toml"), []byte("[tools]\ndisabled = [\"write_file\", \"apply_patch\"]\n[tools.files]\nenabled = true\n"), 0o644)
+
+	a, err := agent.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := a.Registry(config.DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"read_file": true, "write_file": false, "apply_patch": false} {
+		if _, ok := reg.Get(name); ok != want {
+			t.Errorf("%s registered = %v, want %v", name, ok, want)
+		}
+	}
+
+	os.WriteFile(filepath.Join(dir, "agent.toml"), []byte("[tools]\ndisabled = [\"wirte_file\"]\n"), 0o644)
+	if a, err = agent.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.Registry(config.DefaultLimits)
+	var cfgErr *config.ConfigError
+	if !errors.As(err, &cfgErr) || cfgErr.Field != "tools.disabled" {
+		t.Errorf("expected tools.disabled ConfigError, got %v", err)
+	}
+}
+
+func TestLoad_ResolvesSymlinkedRoot(t *testing.T) {
+	dir := t.TempDir()
+	real := filepath.Join(dir, "real")
+	os.Mkdir(real, 0o755)
+	os.WriteFile(filepath.Join(real, "instructions.md"), []byte("hi"), 0o644)
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(real, link); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+	a, err := agent.Load(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := filepath.EvalSymlinks(real)
+	if a.Root != want {
+		t.Errorf("root = %q, want %q", a.Root, want)
+	}
+}
+
+func TestLoad_Skills(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "instructions.md"), []byte("Be brief."), 0o644)
+
+	a, err := agent.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, _ := a.Registry(config.DefaultLimits)
+	if _, ok := reg.Get("load_skill"); ok || a.SystemPrompt() != "Be brief." {
+		t.Errorf("agent without skills: load_skill registered = %v, prompt = %q", ok, a.SystemPrompt())
+	}
+
+	os.Mkdir(filepath.Join(dir, "skills"), 0o755)
+	os.WriteFile(filepath.Join(dir, "skills", "deploy.md"), []byte("---\ndescription: Ship it\n---\nSteps.\n"), 0o644)
+	a, err = agent.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, _ = a.Registry(config.DefaultLimits)
+	if _, ok := reg.Get("load_skill"); !ok {
+		t.Error("load_skill not registered")
+	}
+	if prompt := a.SystemPrompt(); !strings.HasPrefix(prompt, "Be brief.\n\n## Skills") || !strings.Contains(prompt, "- deploy: Ship it") {
+		t.Errorf("prompt = %q", prompt)
+	}
+
+	os.WriteFile(filepath.Join(dir, "skills", "broken.md"), []byte("no front-matter"), 0o644)
+	_, err = agent.Load(dir)
+	var cfgErr *config.ConfigError
+	if !errors.As(err, &cfgErr) || cfgErr.File != "skills/broken.md" {
+		t.Errorf("expected ConfigError for skills/broken.md, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if root, problems := agent.Validate("../../examples/hello-agent"); len(problems) != 0 || !filepath.IsAbs(root) {
+		t.Errorf("example agent: root %q, problems %v", root, problems)
+	}
+	if _, problems := agent.Validate(filepath.Join(t.TempDir(), "missing")); len(problems) != 1 {
+		t.Errorf("missing dir problems = %v", problems)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "instructions.md"), nil, 0o644)
+	os.WriteFile(filepath.Join(dir, "agent.toml"), []byte("model = \"nope/x\"\nfallback_models = [\"openai/gpt-4o\", \"gone/y\"]\n[provider.other]\ntemperature = 1.0\n"), 0o644)
+	os.Mkdir(filepath.Join(dir, "skills"), 0o755)
+	os.WriteFile(filepath.Join(dir, "skills", "a.md"), []byte("no front-matter"), 0o644)
+	os.WriteFile(filepath.Join(dir, "skills", "b.md"), []byte("also none"), 0o644)
+	t.Setenv("PINGU_RUN_TIMEOUT", "soon")
+	t.Setenv("PINGU_MAX_TOOL_CALLS", "-1")
+
+	_, problems := agent.Validate(dir)
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want := []string{
+		"instructions.md: file is empty",
+		"skills/a.md: missing front-matter",
+		"skills/b.md: missing front-matter",
+		"PINGU_MAX_TOOL_CALLS: invalid value",
+		"PINGU_RUN_TIMEOUT: invalid duration",
+		`model: unknown provider "nope"`,
+		`fallback_models[1] in agent.toml: unknown provider "gone"`,
+		`provider.other in agent.toml: unknown provider "other"`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("problems = %q", got)
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("problem %d = %q, want prefix %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestValidate_EveryAgentTomlProblem(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "instructions.md"), []byte("hi"), 0o644)
+	os.WriteFile(filepath.Join(dir, "agent.toml"), []byte("bogus = 1\n[limits]\nmax_model_turns = -1\nmax_cost_usd = -2.0\n[context]\nreserve_tokens = -5\n"), 0o644)
+
+	_, problems := agent.Validate(dir)
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want := []string{
+		"bogus in agent.toml: unknown field",
+		"limits.max_cost_usd in agent.toml: invalid value -2",
+		"limits.max_model_turns in agent.toml: must be positive",
+		"context.reserve_tokens in agent.toml: must not be negative",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("problems =\n%q\nwant\n%q", got, want)
+	}
 }
@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/chtushar/pingu/internal/config"
+	"github.com/chtushar/pingu/internal/jsonschema"
+	"github.com/chtushar/pingu/internal/provider"
+	"github.com/chtushar/pingu/internal/tools"
+)
+
+// Problem is one finding of Validate.
+type Problem struct {
+	File    string `json:"file,omitempty"`  // relative to the agent root, if the problem is in a file
+	Field   string `json:"field,omitempty"` // offending field or variable, if known
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	return (&config.ConfigError{File: p.File, Field: p.Field, Err: errors.New(p.Message)}).Error()
+}
+
+// Validate checks the agent directory at path without running it and
+// returns every problem found. Beyond what Load checks, it resolves the
+// model, the fallback models, and [provider.<name>] tables against the
+// provider registry (credentials are not required), resolves limits from
+// agent.toml and the environment, and checks every tool's parameters as a
+// JSON Schema. It returns the resolved root (path itself if it cannot be
+// resolved) and no problems for a valid agent.
+func Validate(path string) (root string, problems []Problem) {
+	a, configOK, errs := load(path)
+	if a == nil {
+		return path, problemsFrom(errs)
+	}
+
+	limits, err := a.Config.ResolveLimits()
+	errs = append(errs, unjoin(err)...)
+	if err == nil {
+		if err := limits.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if configOK {
+		for i, ref := range a.Config.Models() {
+			var refErrs []error
+			if err := provider.Check(ref); err != nil {
+				refErrs = append(refErrs, err)
+			}
+			refErrs = append(refErrs, unjoin(provider.CheckGeneration(ref, a.Config.GenerationFor(ref)))...)
+			for _, err := range refErrs {
+				if i > 0 {
+					err = fallbackError(i-1, err)
+				}
+				errs = append(errs, err)
+			}
+		}
+		errs = append(errs, unjoin(provider.CheckConfig(a.Config))...)
+		registry, err := a.Registry(limits)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			for _, t := range registry.List() {
+				errs = append(errs, a.checkParameters(t)...)
+			}
+		}
+	}
+	return a.Root, problemsFrom(errs)
+}
+
+// checkParameters checks a tool's parameters schema, which providers need
+// to be an object schema.
+func (a *Agent) checkParameters(t tools.Tool) []error {
+	file := "agent.toml"
+	if exe, ok := t.(*tools.Executable); ok {
+		if rel, err := filepath.Rel(a.Root, exe.Path()); err == nil {
+			file = filepath.ToSlash(rel)
+		}
+	}
+	field := fmt.Sprintf("tool %q parameters", t.Name())
+	params := t.Parameters()
+	if len(params) == 0 {
+		return nil
+	}
+	var errs []error
+	for _, err := range unjoin(jsonschema.Check(params)) {
+		errs = append(errs, &config.ConfigError{File: file, Field: field, Err: err})
+	}
+	var root struct {
+		Type any `json:"type"`
+	}
+	if json.Unmarshal(params, &root) == nil && root.Type != "object" {
+		errs = append(errs, &config.ConfigError{File: file, Field: field, Err: errors.New(`top-level "type" must be "object"`)})
+	}
+	return errs
+}
+
+// fallbackError reports a problem with fallback model i under its
+// agent.toml field.
+func fallbackError(i int, err error) error {
+	var ce *config.ConfigError
+	if errors.As(err, &ce) {
+		err = ce.Err
+		if ce.Field != "" && ce.Field != "model" {
+			err = fmt.Errorf("%s: %w", ce.Field, ce.Err)
+		}
+	}
+	return &config.ConfigError{File: "agent.toml", Field: fmt.Sprintf("fallback_models[%d]", i), Err: err}
+}
+
+// problemsFrom flattens errors into problems, splitting joined errors.
+func problemsFrom(errs []error) []Problem {
+	var out []Problem
+	for _, err := range errs {
+		var cfgErr *config.ConfigError
+		if !errors.As(err, &cfgErr) {
+			out = append(out, Problem{Message: err.Error()})
+			continue
+		}
+		for _, inner := range unjoin(cfgErr.Err) {
+			out = append(out, Problem{File: cfgErr.File, Field: cfgErr.Field, Message: inner.Error()})
+		}
+	}
+	return out
+}
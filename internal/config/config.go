@@ -3,11 +3,20 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
+
+	"github.com/chtushar/pingu/internal/jsonschema"
+	"github.com/chtushar/pingu/internal/llm"
 
 	"github.com/BurntSushi/toml"
 )
@@ -16,9 +25,70 @@ import (
 // reference. Override with agent.toml, PINGU_MODEL, or --model.
 const DefaultModel = "openai/gpt-4o-mini"
 
+// DefaultFallbackOn lists the ProviderError codes that move a run to the
+// next fallback model when agent.toml sets no fallback_on.
+var DefaultFallbackOn = []string{"http_429", "http_500", "http_502", "http_503", "http_504", "http_529", "overloaded_error"}
+
 // Config is the resolved agent configuration.
 type Config struct {
-	Model ModelRef
+	Model          ModelRef
+	FallbackModels []ModelRef     // tried in order when Model fails; see FallbackOn
+	FallbackOn     []string       // ProviderError codes that trigger a fallback
+	Limits         Limits         // [limits]; zero fields are unset, see ResolveLimits
+	Generation     llm.Generation // [generation]; see GenerationFor
+	Tools          ToolsConfig
+	Providers      map[string]ProviderConfig // [provider.<name>], keyed by provider prefix
+	Prices         map[ModelRef]llm.Price    // [pricing."<model>"]; see PriceFor
+	Context        ContextConfig             // [context]
+}
+
+// ToolsConfig configures the built-in tools. Every built-in tool is off
+// until agent.toml enables it.
+type ToolsConfig struct {
+	Disabled []string       // tool names left out of the registry
+	Shell    ShellConfig    // [tools.shell]
+	Files    FilesConfig    // [tools.files]
+	WebFetch WebFetchConfig // [tools.web_fetch]
+}
+
+// ProviderConfig carries per-provider settings from agent.toml. Zero
+// fields mean the adapter's defaults.
+type ProviderConfig struct {
+	BaseURL     string            // overrides the adapter's base URL variable
+	Headers     map[string]string // extra request headers
+	Temperature *float64          // overrides [generation] for this provider
+	MaxTokens   int               // overrides [generation] max_output_tokens
+}
+
+// ShellConfig configures the built-in shell tool.
+type ShellConfig struct {
+	Enabled bool
+	Workdir string   // absolute; defaults to the agent root
+	Env     []string // variable names passed through to commands
+}
+
+// FilesConfig configures the built-in file tools.
+type FilesConfig struct {
+	Enabled   bool
+	Workspace string // absolute; defaults to the agent root
+	ReadOnly  bool   // only read_file, list_dir, and search_files
+}
+
+// WebFetchConfig configures the built-in web_fetch tool. Zero Timeout and
+// MaxResponseBytes mean the tool's defaults.
+type WebFetchConfig struct {
+	Enabled          bool
+	AllowDomains     []string // when set, the only hosts (and subdomains) allowed
+	DenyDomains      []string // always refused, even if allowed
+	Timeout          time.Duration
+	MaxResponseBytes int64
+	AllowPrivate     bool // reach loopback, private, and link-local addresses
+}
+
+// Models returns Model followed by FallbackModels, in the order a run tries
+// them.
+func (cfg Config) Models() []ModelRef {
+	return append([]ModelRef{cfg.Model}, cfg.FallbackModels...)
 }
 
 // ModelRef is a provider/model-id reference split on the first slash.
@@ -68,17 +138,94 @@ func (e *ConfigError) Error() string {
 
 func (e *ConfigError) Unwrap() error { return e.Err }
 
-// agentFile mirrors the agent.toml fields recognized in Phase 1. Unknown
-// fields are rejected so typos fail early.
+// agentFile mirrors the recognized agent.toml fields. Unknown fields are
+// rejected so typos fail early.
 type agentFile struct {
-	Model string `toml:"model"`
+	Model          string                  `toml:"model"`
+	FallbackModels []string                `toml:"fallback_models"`
+	FallbackOn     []string                `toml:"fallback_on"`
+	Limits         limitsFile              `toml:"limits"`
+	Generation     generationFile          `toml:"generation"`
+	Tools          toolsFile               `toml:"tools"`
+	Provider       map[string]providerFile `toml:"provider"`
+	Pricing        map[string]priceFile    `toml:"pricing"`
+	Context        contextFile             `toml:"context"`
+}
+
+type priceFile struct {
+	Input  float64 `toml:"input"`  // US dollars per million input tokens
+	Output float64 `toml:"output"` // US dollars per million output tokens
+}
+
+type generationFile struct {
+	Temperature     *float64 `toml:"temperature"`
+	TopP            *float64 `toml:"top_p"`
+	MaxOutputTokens int      `toml:"max_output_tokens"`
+	Stop            []string `toml:"stop"`
+	Seed            *int64   `toml:"seed"`
+	ResponseFormat  string   `toml:"response_format"`
+	OutputSchema    string   `toml:"output_schema"`
+}
+
+// limitsFile holds [limits]. The counts are pointers so that an explicit 0
+// is rejected rather than mistaken for an unset field.
+type limitsFile struct {
+	MaxModelTurns      *int    `toml:"max_model_turns"`
+	MaxToolCalls       *int    `toml:"max_tool_calls"`
+	RunTimeout         string  `toml:"run_timeout"`
+	ToolTimeout        string  `toml:"tool_timeout"`
+	MaxToolOutputBytes *int64  `toml:"max_tool_output_bytes"`
+	MaxParallelTools   *int    `toml:"max_parallel_tools"`
+	MaxModelAttempts   *int    `toml:"max_model_attempts"`
+	MaxCostUSD         float64 `toml:"max_cost_usd"`
+}
+
+type providerFile struct {
+	BaseURL     string            `toml:"base_url"`
+	Headers     map[string]string `toml:"headers"`
+	Temperature *float64          `toml:"temperature"`
+	MaxTokens   int               `toml:"max_tokens"`
+}
+
+type toolsFile struct {
+	Disabled []string     `toml:"disabled"`
+	Shell    shellFile    `toml:"shell"`
+	Files    filesFile    `toml:"files"`
+	WebFetch webFetchFile `toml:"web_fetch"`
+}
+
+type shellFile struct {
+	Enabled bool     `toml:"enabled"`
+	Workdir string   `toml:"workdir"`
+	Env     []string `toml:"env"`
+}
+
+type filesFile struct {
+	Enabled   bool   `toml:"enabled"`
+	Workspace string `toml:"workspace"`
+	ReadOnly  bool   `toml:"read_only"`
+}
+
+type webFetchFile struct {
+	Enabled          bool     `toml:"enabled"`
+	AllowDomains     []string `toml:"allow_domains"`
+	DenyDomains      []string `toml:"deny_domains"`
+	Timeout          string   `toml:"timeout"`
+	MaxResponseBytes int64    `toml:"max_response_bytes"`
+	AllowPrivate     bool     `toml:"allow_private"`
 }
 
 // Load resolves configuration for the agent rooted at root: agent.toml (if
 // present), then PINGU_MODEL, then DefaultModel. Flag overrides are applied
-// by the caller with ApplyModelFlag.
+// by the caller with ApplyModelFlag. The provider prefix is checked against
+// the provider registry when the provider is built, not here.
+//
+// Every problem in agent.toml is reported: the error joins one ConfigError
+// per unknown key or invalid value. Only an unreadable or syntactically
+// invalid file stops Load at the first error.
 func Load(root string) (Config, error) {
 	var cfg Config
+	var errs []error
 	model := DefaultModel
 
 	path := filepath.Join(root, "agent.toml")
@@ -97,12 +244,88 @@ func Load(root string) (Config, error) {
 		if err != nil {
 			return cfg, &ConfigError{File: "agent.toml", Err: err}
 		}
-		if keys := md.Undecoded(); len(keys) > 0 {
-			return cfg, &ConfigError{File: "agent.toml", Field: keys[0].String(), Err: errors.New("unknown field")}
+		for _, key := range md.Undecoded() {
+			errs = append(errs, &ConfigError{File: "agent.toml", Field: key.String(), Err: errors.New("unknown field")})
 		}
 		if doc.Model != "" {
 			model = doc.Model
 		}
+		for i, s := range doc.FallbackModels {
+			ref, err := ParseModelRef(s)
+			if err != nil {
+				errs = append(errs, &ConfigError{File: "agent.toml", Field: fmt.Sprintf("fallback_models[%d]", i), Err: err})
+				continue
+			}
+			cfg.FallbackModels = append(cfg.FallbackModels, ref)
+		}
+		if slices.Contains(doc.FallbackOn, "") {
+			errs = append(errs, &ConfigError{File: "agent.toml", Field: "fallback_on", Err: errors.New("empty error code")})
+		}
+		cfg.FallbackOn = doc.FallbackOn
+		limits, err := resolveLimits(doc.Limits)
+		errs = append(errs, err)
+		cfg.Limits = limits
+		gen := llm.Generation{
+			Temperature:     doc.Generation.Temperature,
+			TopP:            doc.Generation.TopP,
+			MaxOutputTokens: doc.Generation.MaxOutputTokens,
+			Stop:            doc.Generation.Stop,
+			Seed:            doc.Generation.Seed,
+			ResponseFormat:  llm.ResponseFormat(doc.Generation.ResponseFormat),
+		}
+		check := gen
+		if p := doc.Generation.OutputSchema; p != "" {
+			if gen.ResponseFormat == "" {
+				gen.ResponseFormat = llm.ResponseJSONSchema
+			}
+			schema, err := LoadSchema(resolvePath(root, p))
+			switch {
+			case gen.ResponseFormat != llm.ResponseJSONSchema:
+				errs = append(errs, &ConfigError{File: "agent.toml", Field: "generation.output_schema", Err: fmt.Errorf("needs response_format %q", llm.ResponseJSONSchema)})
+			case err != nil:
+				errs = append(errs, &ConfigError{File: "agent.toml", Field: "generation.output_schema", Err: err})
+			default:
+				gen.Schema = schema
+				check = gen
+			}
+		}
+		// A bad output_schema is reported once, not again as a json_schema
+		// response format without a schema.
+		errs = append(errs, checkGeneration(check, func(name string) string { return "generation." + name }))
+		cfg.Generation = gen
+		if slices.Contains(doc.Tools.Disabled, "") {
+			errs = append(errs, &ConfigError{File: "agent.toml", Field: "tools.disabled", Err: errors.New("empty tool name")})
+		}
+		cfg.Tools.Disabled = doc.Tools.Disabled
+		providers, err := resolveProviders(doc.Provider)
+		errs = append(errs, err)
+		cfg.Providers = providers
+		prices, err := resolvePricing(doc.Pricing)
+		errs = append(errs, err)
+		cfg.Prices = prices
+		contextCfg, err := resolveContext(doc.Context)
+		errs = append(errs, err)
+		cfg.Context = contextCfg
+		shell, err := resolveShell(root, doc.Tools.Shell)
+		errs = append(errs, err)
+		cfg.Tools.Shell = shell
+		cfg.Tools.Files = FilesConfig{
+			Enabled:   doc.Tools.Files.Enabled,
+			Workspace: resolvePath(root, doc.Tools.Files.Workspace),
+			ReadOnly:  doc.Tools.Files.ReadOnly,
+		}
+		webFetch, err := resolveWebFetch(doc.Tools.WebFetch)
+		errs = append(errs, err)
+		cfg.Tools.WebFetch = webFetch
+	}
+	if cfg.Tools.Shell.Workdir == "" {
+		cfg.Tools.Shell.Workdir = root
+	}
+	if cfg.Tools.Files.Workspace == "" {
+		cfg.Tools.Files.Workspace = root
+	}
+	if cfg.Context.Strategy == "" {
+		cfg.Context.Strategy = ContextSummarize
 	}
 
 	if v := os.Getenv("PINGU_MODEL"); v != "" {
@@ -111,13 +334,281 @@ func Load(root string) (Config, error) {
 
 	ref, err := ParseModelRef(model)
 	if err != nil {
-		return cfg, &ConfigError{Field: "model", Err: err}
-	}
-	if ref.Provider != "openai" {
-		return cfg, &ConfigError{Field: "model", Err: fmt.Errorf("unsupported provider %q (supported: openai)", ref.Provider)}
+		errs = append(errs, &ConfigError{Field: "model", Err: err})
 	}
 	cfg.Model = ref
-	return cfg, nil
+	if cfg.FallbackOn == nil {
+		cfg.FallbackOn = DefaultFallbackOn
+	}
+	return cfg, errors.Join(errs...)
+}
+
+// resolveLimits validates [limits]. Unset fields stay zero so that
+// ResolveLimits can tell them apart from values set in agent.toml. Every
+// invalid field is reported, as joined ConfigErrors.
+func resolveLimits(f limitsFile) (Limits, error) {
+	l := Limits{MaxCostUSD: f.MaxCostUSD}
+	var errs []error
+	if f.MaxCostUSD < 0 || math.IsInf(f.MaxCostUSD, 0) || math.IsNaN(f.MaxCostUSD) {
+		errs = append(errs, &ConfigError{File: "agent.toml", Field: "limits.max_cost_usd", Err: fmt.Errorf("invalid value %v", f.MaxCostUSD)})
+	}
+	for _, n := range []struct {
+		field string
+		value *int
+		set   *int
+	}{
+		{"max_model_turns", f.MaxModelTurns, &l.MaxModelTurns},
+		{"max_tool_calls", f.MaxToolCalls, &l.MaxToolCalls},
+		{"max_parallel_tools", f.MaxParallelTools, &l.MaxParallelTools},
+		{"max_model_attempts", f.MaxModelAttempts, &l.MaxModelAttempts},
+	} {
+		if n.value == nil {
+			continue
+		}
+		if *n.value <= 0 {
+			errs = append(errs, &ConfigError{File: "agent.toml", Field: "limits." + n.field, Err: errors.New("must be positive")})
+			continue
+		}
+		*n.set = *n.value
+	}
+	if v := f.MaxToolOutputBytes; v != nil {
+		if *v <= 0 {
+			errs = append(errs, &ConfigError{File: "agent.toml", Field: "limits.max_tool_output_bytes", Err: errors.New("must be positive")})
+		} else {
+			l.MaxToolOutputBytes = *v
+		}
+	}
+	for _, d := range []struct {
+		field string
+		value string
+		set   *time.Duration
+	}{
+		{"run_timeout", f.RunTimeout, &l.RunTimeout},
+		{"tool_timeout", f.ToolTimeout, &l.ToolTimeout},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil || v <= 0 {
+			errs = append(errs, &ConfigError{File: "agent.toml", Field: "limits." + d.field, Err: fmt.Errorf("invalid duration %q", d.value)})
+			continue
+		}
+		*d.set = v
+	}
+	return l, errors.Join(errs...)
+}
+
+// resolveProviders validates the [provider.<name>] tables. Whether name is
+// a registered provider is checked by the provider package.
+func resolveProviders(files map[string]providerFile) (map[string]ProviderConfig, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]ProviderConfig, len(files))
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		f := files[name]
+		field := "provider." + name
+		if f.BaseURL != "" {
+			u, err := url.Parse(f.BaseURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, &ConfigError{File: "agent.toml", Field: field + ".base_url", Err: fmt.Errorf("invalid URL %q: want an http or https URL", f.BaseURL)})
+			}
+		}
+		for _, key := range slices.Sorted(maps.Keys(f.Headers)) {
+			if key == "" || strings.ContainsAny(key, ": \t\r\n") {
+				errs = append(errs, &ConfigError{File: "agent.toml", Field: field + ".headers", Err: fmt.Errorf("invalid header name %q", key)})
+			}
+		}
+		names := map[string]string{llm.GenTemperature: "temperature", llm.GenMaxOutputTokens: "max_tokens"}
+		gen := llm.Generation{Temperature: f.Temperature, MaxOutputTokens: f.MaxTokens}
+		if err := checkGeneration(gen, func(name string) string { return field + "." + names[name] }); err != nil {
+			errs = append(errs, err)
+		}
+		out[name] = ProviderConfig{
+			BaseURL:     strings.TrimRight(f.BaseURL, "/"),
+			Headers:     f.Headers,
+			Temperature: f.Temperature,
+			MaxTokens:   f.MaxTokens,
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return out, nil
+}
+
+// checkGeneration validates the parameters set in g. field maps a
+// parameter name to the name reported in the ConfigError. Every invalid
+// parameter is reported, as joined ConfigErrors.
+func checkGeneration(g llm.Generation, field func(name string) string) error {
+	var errs []error
+	fail := func(name string, err error) {
+		e := &ConfigError{Field: field(name), Err: err}
+		if !strings.HasPrefix(e.Field, "--") {
+			e.File = "agent.toml"
+		}
+		errs = append(errs, e)
+	}
+	if t := g.Temperature; t != nil && (*t < 0 || *t > 2) {
+		fail(llm.GenTemperature, fmt.Errorf("%g is out of range [0, 2]", *t))
+	}
+	if p := g.TopP; p != nil && (*p <= 0 || *p > 1) {
+		fail(llm.GenTopP, fmt.Errorf("%g is out of range (0, 1]", *p))
+	}
+	if g.MaxOutputTokens < 0 {
+		fail(llm.GenMaxOutputTokens, errors.New("must be positive"))
+	}
+	if slices.Contains(g.Stop, "") {
+		fail(llm.GenStop, errors.New("empty stop sequence"))
+	}
+	switch g.ResponseFormat {
+	case "", llm.ResponseText, llm.ResponseJSON:
+	case llm.ResponseJSONSchema:
+		if g.Schema == nil {
+			fail(llm.GenResponseFormat, errors.New("json_schema needs an output schema"))
+		}
+	default:
+		fail(llm.GenResponseFormat, fmt.Errorf("unknown format %q: want %q, %q, or %q", g.ResponseFormat, llm.ResponseText, llm.ResponseJSON, llm.ResponseJSONSchema))
+	}
+	return errors.Join(errs...)
+}
+
+// MaxSchemaBytes bounds an output schema file.
+const MaxSchemaBytes = 1 << 20
+
+// LoadSchema reads an output schema file: a well-formed JSON Schema whose
+// top level describes an object, as providers require for structured
+// output.
+func LoadSchema(path string) (json.RawMessage, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > MaxSchemaBytes {
+		return nil, fmt.Errorf("%s: size %d exceeds limit %d", path, info.Size(), MaxSchemaBytes)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := jsonschema.Check(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var root struct {
+		Type any `json:"type"`
+	}
+	if json.Unmarshal(data, &root) != nil || root.Type != "object" {
+		return nil, fmt.Errorf(`%s: top-level "type" must be "object"`, path)
+	}
+	return json.RawMessage(data), nil
+}
+
+// GenerationFor returns the generation parameters for models of ref's
+// provider: [generation], overridden by the temperature and max_tokens of
+// its [provider.<name>] table. Flags are applied by the caller with
+// ApplyGenerationFlags.
+func (cfg Config) GenerationFor(ref ModelRef) llm.Generation {
+	pc := cfg.Providers[ref.Provider]
+	return cfg.Generation.Merge(llm.Generation{Temperature: pc.Temperature, MaxOutputTokens: pc.MaxTokens})
+}
+
+// ApplyGenerationFlags returns g with the parameters set by flags, which
+// win over every other source, after validating them.
+func ApplyGenerationFlags(g, flags llm.Generation) (llm.Generation, error) {
+	if err := checkGeneration(flags, func(name string) string { return "--" + strings.ReplaceAll(name, "_", "-") }); err != nil {
+		return g, err
+	}
+	return g.Merge(flags), nil
+}
+
+// ResolveLimits returns the run limits: DefaultLimits, overridden by the
+// PINGU_* variables, overridden by [limits] in agent.toml. Flags are
+// applied by the caller. Environment errors are joined ConfigErrors.
+func (cfg Config) ResolveLimits() (Limits, error) {
+	l, err := DefaultLimits.ApplyEnv()
+	f := cfg.Limits
+	if f.MaxModelTurns > 0 {
+		l.MaxModelTurns = f.MaxModelTurns
+	}
+	if f.MaxToolCalls > 0 {
+		l.MaxToolCalls = f.MaxToolCalls
+	}
+	if f.RunTimeout > 0 {
+		l.RunTimeout = f.RunTimeout
+	}
+	if f.ToolTimeout > 0 {
+		l.ToolTimeout = f.ToolTimeout
+	}
+	if f.MaxToolOutputBytes > 0 {
+		l.MaxToolOutputBytes = f.MaxToolOutputBytes
+	}
+	if f.MaxParallelTools > 0 {
+		l.MaxParallelTools = f.MaxParallelTools
+	}
+	if f.MaxModelAttempts > 0 {
+		l.MaxModelAttempts = f.MaxModelAttempts
+	}
+	if f.MaxCostUSD > 0 {
+		l.MaxCostUSD = f.MaxCostUSD
+	}
+	return l, err
+}
+
+// resolveShell validates [tools.shell]. A relative workdir is relative to
+// the agent root.
+func resolveShell(root string, f shellFile) (ShellConfig, error) {
+	sc := ShellConfig{Enabled: f.Enabled, Workdir: resolvePath(root, f.Workdir), Env: f.Env}
+	var errs []error
+	for _, name := range sc.Env {
+		if name == "" || strings.ContainsAny(name, "= ") {
+			errs = append(errs, &ConfigError{File: "agent.toml", Field: "tools.shell.env", Err: fmt.Errorf("invalid variable name %q", name)})
+		}
+	}
+	return sc, errors.Join(errs...)
+}
+
+// resolveWebFetch validates [tools.web_fetch]. Domains are bare host names
+// such as "example.com"; subdomains match too.
+func resolveWebFetch(f webFetchFile) (WebFetchConfig, error) {
+	wc := WebFetchConfig{
+		Enabled:          f.Enabled,
+		AllowDomains:     f.AllowDomains,
+		DenyDomains:      f.DenyDomains,
+		MaxResponseBytes: f.MaxResponseBytes,
+		AllowPrivate:     f.AllowPrivate,
+	}
+	var errs []error
+	for _, list := range []struct {
+		field   string
+		domains []string
+	}{{"allow_domains", f.AllowDomains}, {"deny_domains", f.DenyDomains}} {
+		for _, d := range list.domains {
+			if d == "" || strings.ContainsAny(d, "/: ") {
+				errs = append(errs, &ConfigError{File: "agent.toml", Field: "tools.web_fetch." + list.field, Err: fmt.Errorf("invalid domain %q: want a host name such as example.com", d)})
+			}
+		}
+	}
+	if f.Timeout != "" {
+		d, err := time.ParseDuration(f.Timeout)
+		if err != nil || d <= 0 {
+			errs = append(errs, &ConfigError{File: "agent.toml", Field: "tools.web_fetch.timeout", Err: fmt.Errorf("invalid duration %q", f.Timeout)})
+		}
+		wc.Timeout = d
+	}
+	if wc.MaxResponseBytes < 0 {
+		errs = append(errs, &ConfigError{File: "agent.toml", Field: "tools.web_fetch.max_response_bytes", Err: errors.New("must be positive")})
+	}
+	return wc, errors.Join(errs...)
+}
+
+// resolvePath makes a non-empty relative path relative to the agent root.
+func resolvePath(root, p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(root, p)
 }
 
 // ApplyModelFlag applies a --model flag value, which wins over every other
@@ -130,9 +621,6 @@ func (cfg *Config) ApplyModelFlag(v string) error {
 	if err != nil {
 		return &ConfigError{Field: "--model", Err: err}
 	}
-	if ref.Provider != "openai" {
-		return &ConfigError{Field: "--model", Err: fmt.Errorf("unsupported provider %q (supported: openai)", ref.Provider)}
-	}
 	cfg.Model = ref
 	return nil
 }
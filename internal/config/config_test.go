@@ -4,9 +4,13 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/chtushar/pingu/internal/config"
+	"github.com/chtushar/pingu/internal/llm"
 )
 
 func writeAgentToml(t *testing.T, dir, content string) {
@@ -55,6 +59,50 @@ func TestLoad_UnknownField(t *testing.T) {
 	}
 }
 
+func TestLoad_ReportsEveryProblem(t *testing.T) {
+	dir := t.TempDir()
+	writeAgentToml(t, dir, `modle = "typo"
+fallback_on = [""]
+
+[limits]
+max_tool_calls = -1
+run_timeout = "soon"
+colour = "blue"
+
+[generation]
+temperature = 3.0
+
+[provider.openai]
+base_url = "ftp://example.com"
+
+[context]
+strategy = "fold"
+
+[tools.web_fetch]
+timeout = "later"
+`)
+	_, err := config.Load(dir)
+	var cfgErr *config.ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("expected ConfigError, got %v", err)
+	}
+	for _, field := range []string{
+		"modle in agent.toml: unknown field",
+		"limits.colour in agent.toml: unknown field",
+		"fallback_on in agent.toml",
+		"limits.max_tool_calls in agent.toml",
+		"limits.run_timeout in agent.toml",
+		"generation.temperature in agent.toml",
+		"provider.openai.base_url in agent.toml",
+		"context.strategy in agent.toml",
+		"tools.web_fetch.timeout in agent.toml",
+	} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error misses %q:\n%v", field, err)
+		}
+	}
+}
+
 func TestLoad_EnvOverridesToml(t *testing.T) {
 	dir := t.TempDir()
 	writeAgentToml(t, dir, "model = \"openai/from-toml\"\n")
@@ -84,13 +132,15 @@ func TestLoad_FlagOverridesAll(t *testing.T) {
 	}
 }
 
-func TestLoad_UnsupportedProvider(t *testing.T) {
+func TestLoad_AnthropicProvider(t *testing.T) {
 	dir := t.TempDir()
-	writeAgentToml(t, dir, "model = \"anthropic/claude\"\n")
-	_, err := config.Load(dir)
-	var cfgErr *config.ConfigError
-	if !errors.As(err, &cfgErr) {
-		t.Fatalf("expected ConfigError, got %v", err)
+	writeAgentToml(t, dir, "model = \"anthropic/claude-sonnet-4-5\"\n")
+	cfg, err := config.Load(dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Model.Provider != "anthropic" || cfg.Model.Model != "claude-sonnet-4-5" {
+		t.Errorf("model = %+v", cfg.Model)
 	}
 }
 
@@ -130,11 +180,13 @@ func TestLimitsEnv(t *testing.T) {
 	t.Setenv("PINGU_MAX_MODEL_TURNS", "7")
 	t.Setenv("PINGU_RUN_TIMEOUT", "3m")
 	t.Setenv("PINGU_MAX_TOOL_OUTPUT_BYTES", "2048")
+	t.Setenv("PINGU_MAX_PARALLEL_TOOLS", "3")
+	t.Setenv("PINGU_MAX_MODEL_ATTEMPTS", "5")
 	l, err := config.DefaultLimits.ApplyEnv()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if l.MaxModelTurns != 7 || l.RunTimeout.String() != "3m0s" || l.MaxToolOutputBytes != 2048 {
+	if l.MaxModelTurns != 7 || l.RunTimeout.String() != "3m0s" || l.MaxToolOutputBytes != 2048 || l.MaxParallelTools != 3 || l.MaxModelAttempts != 5 {
 		t.Errorf("limits = %+v", l)
 	}
 }
@@ -145,3 +197,356 @@ func TestLimitsEnvInvalid(t *testing.T) {
 		t.Fatal("expected error")
 	}
 }
+
+func TestLoad_ShellTool(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := config.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Tools.Shell.Enabled || cfg.Tools.Shell.Workdir != dir {
+		t.Errorf("default shell = %+v", cfg.Tools.Shell)
+	}
+
+	writeAgentToml(t, dir, "[tools.shell]\nenabled = true\nworkdir = \"work\"\nenv = [\"GITHUB_TOKEN\"]\n")
+	cfg, err = config.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh := cfg.Tools.Shell
+	if !sh.Enabled || sh.Workdir != filepath.Join(dir, "work") || len(sh.Env) != 1 || sh.Env[0] != "GITHUB_TOKEN" {
+		t.Errorf("shell = %+v", sh)
+	}
+
+	for _, doc := range []string{
+		"[tools.shell]\nenv = [\"A=B\"]\n",
+		"[tools.shell]\nenabled = true\ntimeout = \"5s\"\n",
+	} {
+		writeAgentToml(t, dir, doc)
+		var cfgErr *config.ConfigError
+		if _, err := config.Load(dir); !errors.As(err, &cfgErr) {
+			t.Errorf("%q: expected ConfigError, got %v", doc, err)
+		}
+	}
+}
+
+func TestLoad_FilesTools(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := config.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Tools.Files.Enabled || cfg.Tools.Files.Workspace != dir {
+		t.Errorf("default files = %+v", cfg.Tools.Files)
+	}
+
+	writeAgentToml(t, dir, "[tools.files]\nenabled = true\nworkspace = \"repo\"\nread_only = true\n")
+	cfg, err = config.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := cfg.Tools.Files; !f.Enabled || !f.ReadOnly || f.Workspace != filepath.Join(dir, "repo") {
+		t.Errorf("files = %+v", f)
+	}
+}
+
+func TestLoad_WebFetchTool(t *testing.T) {
+	dir := t.TempDir()
+	writeAgentToml(t, dir, "[tools.web_fetch]\nenabled = true\nallow_domains = [\"go.dev\"]\ndeny_domains = [\"internal.go.dev\"]\ntimeout = \"10s\"\nmax_response_bytes = 1024\nallow_private = true\n")
+	cfg, err := config.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf := cfg.Tools.WebFetch
+	if !wf.Enabled || len(wf.AllowDomains) != 1 || len(wf.DenyDomains) != 1 || wf.Timeout != 10*time.Second || wf.MaxResponseBytes != 1024 || !wf.AllowPrivate {
+		t.Errorf("web_fetch = %+v", wf)
+	}
+
+	for _, doc := range []string{
+		"[tools.web_fetch]\nallow_domains = [\"https://go.dev\"]\n",
+		"[tools.web_fetch]\ntimeout = \"soon\"\n",
+		"[tools.web_fetch]\nmax_response_bytes = -1\n",
+	} {
+		writeAgentToml(t, dir, doc)
+		var cfgErr *config.ConfigError
+		if _, err := config.Load(dir); !errors.As(err, &cfgErr) {
+			t.Errorf("%q: expected ConfigError, got %v", doc, err)
+		}
+	}
+}
+
+func TestLoad_Limits(t *testing.T) {
+	dir := t.TempDir()
+	writeAgentToml(t, dir, "[limits]\nmax_model_turns = 5\nrun_timeout = \"2m\"\nmax_parallel_tools = 4\n")
+	t.Setenv("PINGU_MAX_MODEL_TURNS", "9")
+	t.Setenv("PINGU_MAX_TOOL_CALLS", "7")
+	cfg, err := config.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := cfg.ResolveLimits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// agent.toml wins over the environment, which wins over defaults.
+	if l.MaxModelTurns != 5 || l.RunTimeout != 2*time.Minute || l.MaxParallelTools != 4 {
+		t.Errorf("toml limits not applied: %+v", l)
+	}
+	if l.MaxToolCalls != 7 {
+		t.Errorf("max tool calls = %d, want 7 from env", l.MaxToolCalls)
+	}
+	if l.ToolTimeout != config.DefaultLimits.ToolTimeout {
+		t.Errorf("tool timeout = %v, want default", l.ToolTimeout)
+	}
+
+	for _, doc := range []string{
+		"[limits]\nmax_tool_calls = -1\n",
+		"[limits]\nmax_tool_calls = 0\n", // not "use the default"
+		"[limits]\nmax_tool_output_bytes = 0\n",
+		"[limits]\ntool_timeout = \"0s\"\n",
+		"[limits]\nmax_parallel_tools = -2\n",
+		"[limits]\nmax_model_attempts = -1\n",
+		"[limits]\nmax_turns = 3\n",
+	} {
+		writeAgentToml(t, dir, doc)
+		var cfgErr *config.ConfigError
+		if _, err := config.Load(dir); !errors.As(err, &cfgErr) {
+			t.Errorf("%q: expected ConfigError, got %v", doc, err)
+		}
+	}
+}
+
+func TestLoad_Generation(t *testing.T) {
+	dir := t.TempDir()
+	writeAgentToml(t, dir, `[generation]
+temperature = 0.7
+top_p = 0.9
+max_output_tokens = 256
+stop = ["END"]
+seed = 42
+response_format = "json"
+
+[provider.anthropic]
+temperature = 0.1
+`)
+	cfg, err := config.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := cfg.GenerationFor(config.ModelRef{Provider: "openai", Model: "m"})
+	if *g.Temperature != 0.7 || *g.TopP != 0.9 || g.MaxOutputTokens != 256 || len(g.Stop) != 1 || *g.Seed != 42 || g.ResponseFormat != llm.ResponseJSON {
+		t.Errorf("generation = %+v", g)
+	}
+	// The provider table wins for its own models.
+	if g := cfg.GenerationFor(config.ModelRef{Provider: "anthropic", Model: "m"}); *g.Temperature != 0.1 || g.MaxOutputTokens != 256 {
+		t.Errorf("anthropic generation = %+v", g)
+	}
+
+	// Flags win over agent.toml.
+	temp := 0.0
+	g, err = config.ApplyGenerationFlags(g, llm.Generation{Temperature: &temp, ResponseFormat: llm.ResponseText})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *g.Temperature != 0 || g.ResponseFormat != llm.ResponseText || g.MaxOutputTokens != 256 {
+		t.Errorf("after flags = %+v", g)
+	}
+	bad := 1.5
+	var cfgErr *config.ConfigError
+	if _, err := config.ApplyGenerationFlags(g, llm.Generation{TopP: &bad}); !errors.As(err, &cfgErr) || cfgErr.Field != "--top-p" {
+		t.Errorf("expected --top-p ConfigError, got %v", err)
+	}
+
+	for _, doc := range []string{
+		"[generation]\ntemperature = 2.5\n",
+		"[generation]\ntop_p = 0.0\n",
+		"[generation]\nmax_output_tokens = -5\n",
+		"[generation]\nstop = [\"\"]\n",
+		"[generation]\nresponse_format = \"yaml\"\n",
+		"[generation]\nfrequency_penalty = 1.0\n",
+	} {
+		writeAgentToml(t, dir, doc)
+		if _, err := config.Load(dir); !errors.As(err, &cfgErr) {
+			t.Errorf("%q: expected ConfigError, got %v", doc, err)
+		}
+	}
+}
+
+func TestLoad_OutputSchema(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "answer.json"), []byte(`{"type":"object","properties":{"answer":{"type":"string"}}}`), 0o644)
+	os.WriteFile(filepath.Join(dir, "list.json"), []byte(`{"type":"array"}`), 0o644)
+	writeAgentToml(t, dir, "[generation]\noutput_schema = \"answer.json\"\n")
+	cfg, err := config.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := cfg.Generation; g.ResponseFormat != llm.ResponseJSONSchema || len(g.Schema) == 0 {
+		t.Errorf("generation = %+v", g)
+	}
+
+	for _, doc := range []string{
+		"[generation]\noutput_schema = \"missing.json\"\n",
+		"[generation]\noutput_schema = \"list.json\"\n",
+		"[generation]\nresponse_format = \"json\"\noutput_schema = \"answer.json\"\n",
+		"[generation]\nresponse_format = \"json_schema\"\n",
+	} {
+		writeAgentToml(t, dir, doc)
+		var cfgErr *config.ConfigError
+		if _, err := config.Load(dir); !errors.As(err, &cfgErr) {
+			t.Errorf("%q: expected ConfigError, got %v", doc, err)
+		}
+	}
+}
+
+func TestLoad_Pricing(t *testing.T) {
+	dir := t.TempDir()
+	writeAgentToml(t, dir, "[limits]\nmax_cost_usd = 0.5\n\n[pricing.\"openai/my-finetune\"]\ninput = 3.0\noutput = 12.0\n")
+	cfg, err := config.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := cfg.PriceOf("openai/my-finetune"); !ok || p != (llm.Price{InputPerMTok: 3, OutputPerMTok: 12}) {
+		t.Errorf("price = %+v, %v", p, ok)
+	}
+	if _, ok := cfg.PriceFor(config.ModelRef{Provider: "openai", Model: "gpt-4o-mini"}); !ok {
+		t.Error("default price missing")
+	}
+	if _, ok := cfg.PriceOf("openai/unknown"); ok {
+		t.Error("unknown model should have no price")
+	}
+	if l, err := cfg.ResolveLimits(); err != nil || l.MaxCostUSD != 0.5 {
+		t.Errorf("max cost = %v, %v", l.MaxCostUSD, err)
+	}
+
+	for _, doc := range []string{
+		"[pricing.gpt-4o]\ninput = 1.0\n",
+		"[pricing.\"openai/gpt-4o\"]\noutput = -1.0\n",
+		"[limits]\nmax_cost_usd = -1.0\n",
+	} {
+		writeAgentToml(t, dir, doc)
+		var cfgErr *config.ConfigError
+		if _, err := config.Load(dir); !errors.As(err, &cfgErr) {
+			t.Errorf("%q: expected ConfigError, got %v", doc, err)
+		}
+	}
+
+	t.Setenv("PINGU_MAX_COST_USD", "free")
+	if _, err := config.DefaultLimits.ApplyEnv(); err == nil {
+		t.Error("expected an error for PINGU_MAX_COST_USD")
+	}
+}
+
+func TestLoad_Context(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := config.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Context.Strategy != config.ContextSummarize {
+		t.Errorf("default strategy = %q", cfg.Context.Strategy)
+	}
+
+	writeAgentToml(t, dir, "model = \"openai/gpt-4.1\"\nfallback_models = [\"openai/my-finetune\", \"openai/unknown\"]\n\n[context]\nstrategy = \"drop\"\nreserve_tokens = 2000\n\n[context.windows]\n\"openai/my-finetune\" = 32000\n")
+	cfg, err = config.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Context.Strategy != config.ContextDrop || cfg.Context.ReserveTokens != 2000 {
+		t.Errorf("context = %+v", cfg.Context)
+	}
+	// The smallest known window of the chain; unknown models are skipped.
+	if n, ok := cfg.ContextWindow(); !ok || n != 32000 {
+		t.Errorf("window = %d, %v", n, ok)
+	}
+	if _, ok := cfg.WindowFor(config.ModelRef{Provider: "openai", Model: "unknown"}); ok {
+		t.Error("unknown model should have no window")
+	}
+
+	for _, doc := range []string{
+		"[context]\nstrategy = \"forget\"\n",
+		"[context]\nreserve_tokens = -1\n",
+		"[context.windows]\n\"openai/gpt-4o\" = 0\n",
+		"[context.windows]\ngpt-4o = 1000\n",
+	} {
+		writeAgentToml(t, dir, doc)
+		var cfgErr *config.ConfigError
+		if _, err := config.Load(dir); !errors.As(err, &cfgErr) {
+			t.Errorf("%q: expected ConfigError, got %v", doc, err)
+		}
+	}
+}
+
+func TestLoad_FallbackModels(t *testing.T) {
+	dir := t.TempDir()
+	writeAgentToml(t, dir, "model = \"openai/gpt-4o\"\nfallback_models = [\"anthropic/claude-sonnet-4-5\", \"openai/gpt-4o-mini\"]\n")
+	cfg, err := config.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ref := range cfg.Models() {
+		got = append(got, ref.String())
+	}
+	if strings.Join(got, ",") != "openai/gpt-4o,anthropic/claude-sonnet-4-5,openai/gpt-4o-mini" {
+		t.Errorf("models = %v", got)
+	}
+	if !slices.Equal(cfg.FallbackOn, config.DefaultFallbackOn) {
+		t.Errorf("fallback_on = %v, want the default", cfg.FallbackOn)
+	}
+
+	writeAgentToml(t, dir, "fallback_models = [\"anthropic/claude-sonnet-4-5\"]\nfallback_on = [\"http_404\"]\n")
+	if cfg, err = config.Load(dir); err != nil || !slices.Equal(cfg.FallbackOn, []string{"http_404"}) {
+		t.Errorf("fallback_on = %v, %v", cfg.FallbackOn, err)
+	}
+
+	for _, doc := range []string{
+		"fallback_models = [\"claude\"]\n",
+		"fallback_on = [\"\"]\n",
+	} {
+		writeAgentToml(t, dir, doc)
+		var cfgErr *config.ConfigError
+		if _, err := config.Load(dir); !errors.As(err, &cfgErr) {
+			t.Errorf("%q: expected ConfigError, got %v", doc, err)
+		}
+	}
+}
+
+func TestLoad_ProvidersAndDisabledTools(t *testing.T) {
+	dir := t.TempDir()
+	writeAgentToml(t, dir, `[tools]
+disabled = ["deploy"]
+
+[provider.openai]
+base_url = "http://localhost:11434/v1/"
+headers = { "X-Team" = "docs" }
+temperature = 0.2
+max_tokens = 512
+`)
+	cfg, err := config.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Tools.Disabled) != 1 || cfg.Tools.Disabled[0] != "deploy" {
+		t.Errorf("disabled = %v", cfg.Tools.Disabled)
+	}
+	pc := cfg.Providers["openai"]
+	if pc.BaseURL != "http://localhost:11434/v1" || pc.Headers["X-Team"] != "docs" || pc.Temperature == nil || *pc.Temperature != 0.2 || pc.MaxTokens != 512 {
+		t.Errorf("provider.openai = %+v", pc)
+	}
+
+	for _, doc := range []string{
+		"[provider.openai]\nbase_url = \"localhost:8080\"\n",
+		"[provider.openai]\ntemperature = 3.0\n",
+		"[provider.openai]\nmax_tokens = -1\n",
+		"[provider.openai]\nheaders = { \"Bad Name\" = \"x\" }\n",
+		"[provider.openai]\nbaseurl = \"http://x\"\n",
+		"[tools]\ndisabled = [\"\"]\n",
+	} {
+		writeAgentToml(t, dir, doc)
+		var cfgErr *config.ConfigError
+		if _, err := config.Load(dir); !errors.As(err, &cfgErr) {
+			t.Errorf("%q: expected ConfigError, got %v", doc, err)
+		}
+	}
+}
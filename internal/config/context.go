@@ -0,0 +1,102 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Context strategies for [context] strategy.
+const (
+	ContextSummarize = "summarize" // replace old exchanges with a model-written summary
+	ContextDrop      = "drop"      // drop old exchanges
+	ContextOff       = "off"       // send the history as it is
+)
+
+// DefaultContextWindows holds the context windows, in tokens, of common
+// models. [context.windows] in agent.toml overrides or extends this table.
+var DefaultContextWindows = map[ModelRef]int{
+	{Provider: "openai", Model: "gpt-4o"}:                     128_000,
+	{Provider: "openai", Model: "gpt-4o-mini"}:                128_000,
+	{Provider: "openai", Model: "gpt-4.1"}:                    1_047_576,
+	{Provider: "openai", Model: "gpt-4.1-mini"}:               1_047_576,
+	{Provider: "openai", Model: "gpt-4.1-nano"}:               1_047_576,
+	{Provider: "openai", Model: "o4-mini"}:                    200_000,
+	{Provider: "anthropic", Model: "claude-opus-4-1"}:         200_000,
+	{Provider: "anthropic", Model: "claude-sonnet-4-5"}:       200_000,
+	{Provider: "anthropic", Model: "claude-haiku-4-5"}:        200_000,
+	{Provider: "anthropic", Model: "claude-3-5-haiku-latest"}: 200_000,
+}
+
+// ContextConfig controls how the history is kept within the model's
+// context window.
+type ContextConfig struct {
+	Strategy      string           // ContextSummarize (default), ContextDrop, or ContextOff
+	ReserveTokens int              // tokens kept free for the answer; zero means the runner's default
+	Windows       map[ModelRef]int // [context.windows]; see ContextWindow
+}
+
+type contextFile struct {
+	Strategy      string         `toml:"strategy"`
+	ReserveTokens int            `toml:"reserve_tokens"`
+	Windows       map[string]int `toml:"windows"`
+}
+
+// WindowFor returns the context window of ref: its [context.windows] entry
+// in agent.toml, else its DefaultContextWindows entry. The boolean is false
+// for a model with neither.
+func (cfg Config) WindowFor(ref ModelRef) (int, bool) {
+	if n, ok := cfg.Context.Windows[ref]; ok {
+		return n, true
+	}
+	n, ok := DefaultContextWindows[ref]
+	return n, ok
+}
+
+// ContextWindow returns the smallest known context window among Models, so
+// a history that fits also fits every fallback. The boolean is false when
+// no model's window is known.
+func (cfg Config) ContextWindow() (int, bool) {
+	window, found := 0, false
+	for _, ref := range cfg.Models() {
+		if n, ok := cfg.WindowFor(ref); ok && (!found || n < window) {
+			window, found = n, true
+		}
+	}
+	return window, found
+}
+
+// resolveContext validates the [context] table.
+func resolveContext(f contextFile) (ContextConfig, error) {
+	out := ContextConfig{Strategy: f.Strategy, ReserveTokens: f.ReserveTokens}
+	var errs []error
+	switch f.Strategy {
+	case "":
+		out.Strategy = ContextSummarize
+	case ContextSummarize, ContextDrop, ContextOff:
+	default:
+		errs = append(errs, &ConfigError{File: "agent.toml", Field: "context.strategy", Err: fmt.Errorf("unknown strategy %q (want %s, %s, or %s)", f.Strategy, ContextSummarize, ContextDrop, ContextOff)})
+	}
+	if f.ReserveTokens < 0 {
+		errs = append(errs, &ConfigError{File: "agent.toml", Field: "context.reserve_tokens", Err: errors.New("must not be negative")})
+	}
+	if len(f.Windows) > 0 {
+		out.Windows = make(map[ModelRef]int, len(f.Windows))
+	}
+	for _, key := range slices.Sorted(maps.Keys(f.Windows)) {
+		n := f.Windows[key]
+		field := fmt.Sprintf("context.windows.%q", key)
+		ref, err := ParseModelRef(key)
+		if err != nil {
+			errs = append(errs, &ConfigError{File: "agent.toml", Field: field, Err: err})
+			continue
+		}
+		if n <= 0 {
+			errs = append(errs, &ConfigError{File: "agent.toml", Field: field, Err: errors.New("must be positive")})
+			continue
+		}
+		out.Windows[ref] = n
+	}
+	return out, errors.Join(errs...)
+}
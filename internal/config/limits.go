@@ -3,18 +3,23 @@ package config
 import (
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"strconv"
 	"time"
 )
 
-// Limits bound every run. Zero fields fall back to DefaultLimits at run time.
+// Limits bound every run. Zero fields fall back to DefaultLimits at run time;
+// a zero MaxCostUSD means no cost limit.
 type Limits struct {
 	MaxModelTurns      int           // model calls per run
 	MaxToolCalls       int           // tool invocations per run
 	RunTimeout         time.Duration // wall-clock budget for one run
 	ToolTimeout        time.Duration // wall-clock budget for one tool call
 	MaxToolOutputBytes int64         // captured tool output per call
+	MaxParallelTools   int           // tool calls of one turn run at once
+	MaxModelAttempts   int           // tries per model call on transient errors
+	MaxCostUSD         float64       // model spend per run, in US dollars
 }
 
 // DefaultLimits are the documented runtime defaults.
@@ -24,9 +29,11 @@ var DefaultLimits = Limits{
 	RunTimeout:         10 * time.Minute,
 	ToolTimeout:        60 * time.Second,
 	MaxToolOutputBytes: 64 * 1024,
+	MaxParallelTools:   1,
+	MaxModelAttempts:   3,
 }
 
-// Validate rejects non-positive limits.
+// Validate rejects non-positive limits and a negative MaxCostUSD.
 func (l Limits) Validate() error {
 	if l.MaxModelTurns <= 0 {
 		return &ConfigError{Field: "max model turns", Err: errors.New("must be positive")}
@@ -43,6 +50,15 @@ func (l Limits) Validate() error {
 	if l.MaxToolOutputBytes <= 0 {
 		return &ConfigError{Field: "max tool output bytes", Err: errors.New("must be positive")}
 	}
+	if l.MaxParallelTools <= 0 {
+		return &ConfigError{Field: "max parallel tools", Err: errors.New("must be positive")}
+	}
+	if l.MaxModelAttempts <= 0 {
+		return &ConfigError{Field: "max model attempts", Err: errors.New("must be positive")}
+	}
+	if l.MaxCostUSD < 0 {
+		return &ConfigError{Field: "max cost", Err: errors.New("must not be negative")}
+	}
 	return nil
 }
 
@@ -64,48 +80,61 @@ func (l Limits) WithDefaults() Limits {
 	if l.MaxToolOutputBytes == 0 {
 		l.MaxToolOutputBytes = d.MaxToolOutputBytes
 	}
+	if l.MaxParallelTools == 0 {
+		l.MaxParallelTools = d.MaxParallelTools
+	}
+	if l.MaxModelAttempts == 0 {
+		l.MaxModelAttempts = d.MaxModelAttempts
+	}
 	return l
 }
 
 // ApplyEnv returns limits overridden by PINGU_MAX_MODEL_TURNS,
-// PINGU_MAX_TOOL_CALLS, PINGU_RUN_TIMEOUT, PINGU_TOOL_TIMEOUT, and
-// PINGU_MAX_TOOL_OUTPUT_BYTES. Invalid values are ConfigErrors.
+// PINGU_MAX_TOOL_CALLS, PINGU_RUN_TIMEOUT, PINGU_TOOL_TIMEOUT,
+// PINGU_MAX_TOOL_OUTPUT_BYTES, PINGU_MAX_PARALLEL_TOOLS,
+// PINGU_MAX_MODEL_ATTEMPTS, and PINGU_MAX_COST_USD. Invalid values are
+// ConfigErrors, joined so every bad variable is reported.
 func (l Limits) ApplyEnv() (Limits, error) {
 	out := l
-	if v := os.Getenv("PINGU_MAX_MODEL_TURNS"); v != "" {
-		n, err := strconv.Atoi(v)
-		if err != nil || n <= 0 {
-			return out, &ConfigError{Field: "PINGU_MAX_MODEL_TURNS", Err: fmt.Errorf("invalid value %q", v)}
+	var errs []error
+	positiveInt := func(name string, set func(int64)) {
+		v := os.Getenv(name)
+		if v == "" {
+			return
 		}
-		out.MaxModelTurns = n
-	}
-	if v := os.Getenv("PINGU_MAX_TOOL_CALLS"); v != "" {
-		n, err := strconv.Atoi(v)
+		n, err := strconv.ParseInt(v, 10, 64)
 		if err != nil || n <= 0 {
-			return out, &ConfigError{Field: "PINGU_MAX_TOOL_CALLS", Err: fmt.Errorf("invalid value %q", v)}
+			errs = append(errs, &ConfigError{Field: name, Err: fmt.Errorf("invalid value %q", v)})
+			return
 		}
-		out.MaxToolCalls = n
+		set(n)
 	}
-	if v := os.Getenv("PINGU_RUN_TIMEOUT"); v != "" {
-		d, err := time.ParseDuration(v)
-		if err != nil || d <= 0 {
-			return out, &ConfigError{Field: "PINGU_RUN_TIMEOUT", Err: fmt.Errorf("invalid duration %q", v)}
+	positiveDuration := func(name string, set func(time.Duration)) {
+		v := os.Getenv(name)
+		if v == "" {
+			return
 		}
-		out.RunTimeout = d
-	}
-	if v := os.Getenv("PINGU_TOOL_TIMEOUT"); v != "" {
 		d, err := time.ParseDuration(v)
 		if err != nil || d <= 0 {
-			return out, &ConfigError{Field: "PINGU_TOOL_TIMEOUT", Err: fmt.Errorf("invalid duration %q", v)}
+			errs = append(errs, &ConfigError{Field: name, Err: fmt.Errorf("invalid duration %q", v)})
+			return
 		}
-		out.ToolTimeout = d
+		set(d)
 	}
-	if v := os.Getenv("PINGU_MAX_TOOL_OUTPUT_BYTES"); v != "" {
-		n, err := strconv.ParseInt(v, 10, 64)
-		if err != nil || n <= 0 {
-			return out, &ConfigError{Field: "PINGU_MAX_TOOL_OUTPUT_BYTES", Err: fmt.Errorf("invalid value %q", v)}
+	positiveInt("PINGU_MAX_MODEL_TURNS", func(n int64) { out.MaxModelTurns = int(n) })
+	positiveInt("PINGU_MAX_TOOL_CALLS", func(n int64) { out.MaxToolCalls = int(n) })
+	positiveDuration("PINGU_RUN_TIMEOUT", func(d time.Duration) { out.RunTimeout = d })
+	positiveDuration("PINGU_TOOL_TIMEOUT", func(d time.Duration) { out.ToolTimeout = d })
+	positiveInt("PINGU_MAX_TOOL_OUTPUT_BYTES", func(n int64) { out.MaxToolOutputBytes = n })
+	positiveInt("PINGU_MAX_PARALLEL_TOOLS", func(n int64) { out.MaxParallelTools = int(n) })
+	positiveInt("PINGU_MAX_MODEL_ATTEMPTS", func(n int64) { out.MaxModelAttempts = int(n) })
+	if v := os.Getenv("PINGU_MAX_COST_USD"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(f > 0) || math.IsInf(f, 0) {
+			errs = append(errs, &ConfigError{Field: "PINGU_MAX_COST_USD", Err: fmt.Errorf("invalid value %q", v)})
+		} else {
+			out.MaxCostUSD = f
 		}
-		out.MaxToolOutputBytes = n
 	}
-	return out, nil
+	return out, errors.Join(errs...)
 }
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+
+	"github.com/chtushar/pingu/internal/llm"
+)
+
+// DefaultPricing holds list prices, in US dollars per million tokens, for
+// common models. Prices change; [pricing] in agent.toml overrides or extends
+// this table.
+var DefaultPricing = map[ModelRef]llm.Price{
+	{Provider: "openai", Model: "gpt-4o"}:                     {InputPerMTok: 2.50, OutputPerMTok: 10.00},
+	{Provider: "openai", Model: "gpt-4o-mini"}:                {InputPerMTok: 0.15, OutputPerMTok: 0.60},
+	{Provider: "openai", Model: "gpt-4.1"}:                    {InputPerMTok: 2.00, OutputPerMTok: 8.00},
+	{Provider: "openai", Model: "gpt-4.1-mini"}:               {InputPerMTok: 0.40, OutputPerMTok: 1.60},
+	{Provider: "openai", Model: "gpt-4.1-nano"}:               {InputPerMTok: 0.10, OutputPerMTok: 0.40},
+	{Provider: "openai", Model: "o4-mini"}:                    {InputPerMTok: 1.10, OutputPerMTok: 4.40},
+	{Provider: "anthropic", Model: "claude-opus-4-1"}:         {InputPerMTok: 15.00, OutputPerMTok: 75.00},
+	{Provider: "anthropic", Model: "claude-sonnet-4-5"}:       {InputPerMTok: 3.00, OutputPerMTok: 15.00},
+	{Provider: "anthropic", Model: "claude-haiku-4-5"}:        {InputPerMTok: 1.00, OutputPerMTok: 5.00},
+	{Provider: "anthropic", Model: "claude-3-5-haiku-latest"}: {InputPerMTok: 0.80, OutputPerMTok: 4.00},
+}
+
+// PriceFor returns the price of ref: its [pricing] entry in agent.toml, else
+// its DefaultPricing entry. The boolean is false for a model with neither.
+func (cfg Config) PriceFor(ref ModelRef) (llm.Price, bool) {
+	if p, ok := cfg.Prices[ref]; ok {
+		return p, true
+	}
+	p, ok := DefaultPricing[ref]
+	return p, ok
+}
+
+// PriceOf is PriceFor for a model reference string, as reported by
+// llm.ServedModel.
+func (cfg Config) PriceOf(model string) (llm.Price, bool) {
+	ref, err := ParseModelRef(model)
+	if err != nil {
+		return llm.Price{}, false
+	}
+	return cfg.PriceFor(ref)
+}
+
+// resolvePricing validates the [pricing."<provider>/<model>"] tables.
+func resolvePricing(files map[string]priceFile) (map[ModelRef]llm.Price, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	out := make(map[ModelRef]llm.Price, len(files))
+	var errs []error
+	for _, key := range slices.Sorted(maps.Keys(files)) {
+		f := files[key]
+		field := fmt.Sprintf("pricing.%q", key)
+		ref, err := ParseModelRef(key)
+		if err != nil {
+			errs = append(errs, &ConfigError{File: "agent.toml", Field: field, Err: err})
+			continue
+		}
+		for _, v := range []float64{f.Input, f.Output} {
+			if v < 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+				errs = append(errs, &ConfigError{File: "agent.toml", Field: field, Err: fmt.Errorf("invalid price %v", v)})
+				break
+			}
+		}
+		out[ref] = llm.Price{InputPerMTok: f.Input, OutputPerMTok: f.Output}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return out, nil
+}
@@ -0,0 +1,207 @@
+// Package jsonschema checks JSON Schema documents of the kind tools and
+// providers exchange, and validates JSON values against them: the common
+// draft 2020-12 keywords, with unknown keywords allowed as the
+// specification does.
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Types are the JSON Schema primitive type names.
+var Types = []string{"array", "boolean", "integer", "null", "number", "object", "string"}
+
+// Error is one problem at a location in a schema.
+type Error struct {
+	Path string // dotted keyword path, e.g. "properties.path.type"; empty for the root
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return e.Msg
+	}
+	return e.Path + ": " + e.Msg
+}
+
+// Check reports every problem in schema, joined. It checks that the
+// document is an object and that recognized keywords have well-formed
+// values; it does not resolve $ref.
+func Check(schema json.RawMessage) error {
+	var v any
+	if err := json.Unmarshal(schema, &v); err != nil {
+		return &Error{Msg: fmt.Sprintf("not valid JSON: %v", err)}
+	}
+	var c checker
+	c.schema("", v)
+	return errors.Join(c.errs...)
+}
+
+type checker struct {
+	errs []error
+}
+
+func (c *checker) fail(path, format string, args ...any) {
+	c.errs = append(c.errs, &Error{Path: path, Msg: fmt.Sprintf(format, args...)})
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func (c *checker) schema(path string, v any) {
+	if _, ok := v.(bool); ok {
+		return // true and false are valid schemas
+	}
+	obj, ok := v.(map[string]any)
+	if !ok {
+		c.fail(path, "schema must be an object")
+		return
+	}
+	for _, key := range sortedKeys(obj) {
+		val, p := obj[key], join(path, key)
+		switch key {
+		case "type":
+			c.typeKeyword(p, val)
+		case "properties", "patternProperties", "$defs", "definitions":
+			m, ok := val.(map[string]any)
+			if !ok {
+				c.fail(p, "must be an object")
+				continue
+			}
+			for _, name := range sortedKeys(m) {
+				if key == "patternProperties" {
+					if _, err := regexp.Compile(name); err != nil {
+						c.fail(p, "invalid pattern %q: %v", name, err)
+					}
+				}
+				c.schema(join(p, name), m[name])
+			}
+		case "required":
+			c.required(p, val, obj["properties"])
+		case "items", "additionalProperties", "additionalItems", "not", "contains",
+			"propertyNames", "if", "then", "else", "unevaluatedProperties", "unevaluatedItems":
+			if arr, ok := val.([]any); ok && key == "items" {
+				c.schemaList(p, arr) // draft-07 tuple form
+				continue
+			}
+			c.schema(p, val)
+		case "allOf", "anyOf", "oneOf", "prefixItems":
+			arr, ok := val.([]any)
+			if !ok || len(arr) == 0 {
+				c.fail(p, "must be a non-empty array of schemas")
+				continue
+			}
+			c.schemaList(p, arr)
+		case "enum":
+			if arr, ok := val.([]any); !ok || len(arr) == 0 {
+				c.fail(p, "must be a non-empty array")
+			}
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf":
+			n, ok := val.(float64)
+			if !ok {
+				c.fail(p, "must be a number")
+			} else if key == "multipleOf" && n <= 0 {
+				c.fail(p, "must be greater than 0")
+			}
+		case "minLength", "maxLength", "minItems", "maxItems", "minProperties", "maxProperties":
+			if n, ok := val.(float64); !ok || n < 0 || n != float64(int64(n)) {
+				c.fail(p, "must be a non-negative integer")
+			}
+		case "uniqueItems":
+			if _, ok := val.(bool); !ok {
+				c.fail(p, "must be a boolean")
+			}
+		case "pattern":
+			s, ok := val.(string)
+			if !ok {
+				c.fail(p, "must be a string")
+			} else if _, err := regexp.Compile(s); err != nil {
+				c.fail(p, "invalid pattern: %v", err)
+			}
+		case "title", "description", "format", "$schema", "$id", "$ref", "$comment":
+			if _, ok := val.(string); !ok {
+				c.fail(p, "must be a string")
+			}
+		}
+	}
+}
+
+func (c *checker) schemaList(path string, arr []any) {
+	for i, s := range arr {
+		c.schema(fmt.Sprintf("%s[%d]", path, i), s)
+	}
+}
+
+func (c *checker) typeKeyword(path string, v any) {
+	var names []any
+	switch t := v.(type) {
+	case string:
+		names = []any{t}
+	case []any:
+		if len(t) == 0 {
+			c.fail(path, "must not be empty")
+		}
+		names = t
+	default:
+		c.fail(path, "must be a type name or an array of type names")
+		return
+	}
+	for _, n := range names {
+		s, _ := n.(string)
+		if !isType(s) {
+			c.fail(path, "unknown type %v (want one of %v)", jsonString(n), Types)
+		}
+	}
+}
+
+func (c *checker) required(path string, v, properties any) {
+	arr, ok := v.([]any)
+	if !ok {
+		c.fail(path, "must be an array of property names")
+		return
+	}
+	props, _ := properties.(map[string]any)
+	for _, n := range arr {
+		name, ok := n.(string)
+		if !ok {
+			c.fail(path, "must be an array of property names")
+			return
+		}
+		if props != nil {
+			if _, ok := props[name]; !ok {
+				c.fail(path, "%q is not defined in properties", name)
+			}
+		}
+	}
+}
+
+func isType(s string) bool {
+	for _, t := range Types {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonString(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,110 @@
+package jsonschema_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/chtushar/pingu/internal/jsonschema"
+)
+
+func TestCheck(t *testing.T) {
+	valid := []string{
+		`{}`,
+		`true`,
+		`{"type":"object","properties":{"path":{"type":"string","description":"p"},"n":{"type":["integer","null"],"minimum":1}},"required":["path"],"additionalProperties":false}`,
+		`{"type":"array","items":{"enum":["a","b"]},"minItems":1}`,
+		`{"anyOf":[{"type":"string","pattern":"^a+$"},{"type":"number"}],"x-custom":{"anything":1}}`,
+	}
+	for _, s := range valid {
+		if err := jsonschema.Check(json.RawMessage(s)); err != nil {
+			t.Errorf("Check(%s) = %v", s, err)
+		}
+	}
+
+	tests := []struct {
+		schema string
+		want   []string // every problem must be reported
+	}{
+		{`[1]`, []string{"schema must be an object"}},
+		{`{"type":"str"}`, []string{`type: unknown type "str"`}},
+		{
+			`{"type":"object","properties":{"a":{"type":"strin"},"b":{"minLength":-1}},"required":["a","c"]}`,
+			[]string{`properties.a.type: unknown type "strin"`, "properties.b.minLength: must be a non-negative integer", `required: "c" is not defined`},
+		},
+		{`{"anyOf":[],"enum":[],"pattern":"("}`, []string{"anyOf: must be a non-empty array", "enum: must be a non-empty array", "pattern: invalid pattern"}},
+		{`{"items":{"type":1}}`, []string{"items.type: must be a type name"}},
+		{`{"type":`, []string{"not valid JSON"}},
+	}
+	for _, tt := range tests {
+		err := jsonschema.Check(json.RawMessage(tt.schema))
+		if err == nil {
+			t.Errorf("Check(%s): expected errors", tt.schema)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Check(%s) = %v, missing %q", tt.schema, err, want)
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema := json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"title": {"type": "string", "minLength": 1},
+			"count": {"type": "integer", "minimum": 0},
+			"tags": {"type": "array", "items": {"$ref": "#/$defs/tag"}, "uniqueItems": true},
+			"kind": {"enum": ["bug", "feature"]},
+			"score": {"type": ["number", "null"], "exclusiveMaximum": 10}
+		},
+		"required": ["title", "count"],
+		"additionalProperties": false,
+		"$defs": {"tag": {"type": "string", "pattern": "^[a-z]+$"}}
+	}`)
+
+	valid := []string{
+		`{"title":"x","count":0}`,
+		`{"title":"x","count":3.0,"tags":["a","b"],"kind":"bug","score":null}`,
+		`{"title":"x","count":1,"score":9.5}`,
+	}
+	for _, s := range valid {
+		if err := jsonschema.Validate(schema, json.RawMessage(s)); err != nil {
+			t.Errorf("Validate(%s) = %v", s, err)
+		}
+	}
+
+	tests := []struct {
+		instance string
+		want     []string // every problem must be reported
+	}{
+		{`[]`, []string{"got array, want object"}},
+		{`{"count":1.5}`, []string{`missing required property "title"`, "count: got number, want integer"}},
+		{`{"title":"","count":-1,"extra":true}`, []string{"title: length 0 is less than 1", "count: -1 is less than 0", `property "extra" is not allowed`}},
+		{`{"title":"x","count":1,"tags":["a","B","a"]}`, []string{`tags[1]: "B" does not match pattern`, "tags: items 0 and 2 are equal"}},
+		{`{"title":"x","count":1,"kind":"chore","score":10}`, []string{`kind: value "chore" is not one of`, "score: 10 is not less than 10"}},
+		{`{"title":"x"} trailing`, []string{"not valid JSON"}},
+		{`Here you go: {"title":"x"}`, []string{"not valid JSON"}},
+	}
+	for _, tt := range tests {
+		err := jsonschema.Validate(schema, json.RawMessage(tt.instance))
+		if err == nil {
+			t.Errorf("Validate(%s): expected errors", tt.instance)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Validate(%s) = %v, missing %q", tt.instance, err, want)
+			}
+		}
+	}
+
+	combinators := json.RawMessage(`{"oneOf":[{"type":"string"},{"type":"integer"}],"not":{"const":"no"}}`)
+	for instance, ok := range map[string]bool{`"yes"`: true, `3`: true, `"no"`: false, `true`: false} {
+		if err := jsonschema.Validate(combinators, json.RawMessage(instance)); (err == nil) != ok {
+			t.Errorf("Validate(%s) = %v, want ok = %v", instance, err, ok)
+		}
+	}
+}
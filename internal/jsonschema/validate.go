@@ -0,0 +1,386 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// maxRefDepth bounds $ref chains so a schema that refers to itself without
+// consuming input cannot loop forever.
+const maxRefDepth = 64
+
+// Validate reports every way instance fails to match schema, joined. Paths
+// in the errors locate the offending value in the instance, e.g.
+// "items[2].name"; the root value has an empty path. It understands the
+// keywords Check does, resolves local $ref pointers ("#/$defs/name"), and
+// ignores format and keywords it does not know.
+func Validate(schema, instance json.RawMessage) error {
+	var s, v any
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return &Error{Msg: fmt.Sprintf("schema is not valid JSON: %v", err)}
+	}
+	dec := json.NewDecoder(strings.NewReader(string(instance)))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return &Error{Msg: fmt.Sprintf("not valid JSON: %v", err)}
+	}
+	if dec.More() {
+		return &Error{Msg: "not valid JSON: trailing data after the value"}
+	}
+	c := validator{root: s}
+	c.value("", s, v, 0)
+	return errors.Join(c.errs...)
+}
+
+type validator struct {
+	root any
+	errs []error
+}
+
+func (c *validator) fail(path, format string, args ...any) {
+	c.errs = append(c.errs, &Error{Path: path, Msg: fmt.Sprintf(format, args...)})
+}
+
+// matches reports whether v matches schema without recording errors.
+func (c *validator) matches(schema, v any, depth int) bool {
+	sub := validator{root: c.root}
+	sub.value("", schema, v, depth)
+	return len(sub.errs) == 0
+}
+
+func (c *validator) value(path string, schema, v any, depth int) {
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			c.fail(path, "no value is allowed here")
+		}
+		return
+	case map[string]any:
+		c.object(path, s, v, depth)
+	}
+}
+
+func (c *validator) object(path string, s map[string]any, v any, depth int) {
+	if ref, ok := s["$ref"].(string); ok {
+		if depth >= maxRefDepth {
+			c.fail(path, "$ref nesting exceeds %d", maxRefDepth)
+			return
+		}
+		target, err := c.resolve(ref)
+		if err != nil {
+			c.fail(path, "%v", err)
+			return
+		}
+		c.value(path, target, v, depth+1)
+	}
+
+	if t, ok := s["type"]; ok && !typeMatches(t, v) {
+		c.fail(path, "got %s, want %s", typeName(v), describeType(t))
+		return // the remaining keywords assume the right type
+	}
+	if enum, ok := s["enum"].([]any); ok && !containsValue(enum, v) {
+		c.fail(path, "value %s is not one of %s", jsonString(v), jsonString(enum))
+	}
+	if want, ok := s["const"]; ok && !equal(want, v) {
+		c.fail(path, "value %s is not %s", jsonString(v), jsonString(want))
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		c.properties(path, s, v, depth)
+	case []any:
+		c.items(path, s, v, depth)
+	case string:
+		c.stringKeywords(path, s, v)
+	case json.Number:
+		c.numberKeywords(path, s, v)
+	}
+
+	if all, ok := s["allOf"].([]any); ok {
+		for _, sub := range all {
+			c.value(path, sub, v, depth)
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]any); ok && !slices.ContainsFunc(anyOf, func(sub any) bool { return c.matches(sub, v, depth) }) {
+		c.fail(path, "value matches none of the anyOf schemas")
+	}
+	if oneOf, ok := s["oneOf"].([]any); ok {
+		n := 0
+		for _, sub := range oneOf {
+			if c.matches(sub, v, depth) {
+				n++
+			}
+		}
+		if n != 1 {
+			c.fail(path, "value matches %d of the oneOf schemas, want exactly 1", n)
+		}
+	}
+	if not, ok := s["not"]; ok && c.matches(not, v, depth) {
+		c.fail(path, "value matches the not schema")
+	}
+}
+
+func (c *validator) properties(path string, s map[string]any, obj map[string]any, depth int) {
+	if req, ok := s["required"].([]any); ok {
+		for _, r := range req {
+			if name, ok := r.(string); ok {
+				if _, present := obj[name]; !present {
+					c.fail(path, "missing required property %q", name)
+				}
+			}
+		}
+	}
+	props, _ := s["properties"].(map[string]any)
+	patterns, _ := s["patternProperties"].(map[string]any)
+	for _, name := range sortedKeys(obj) {
+		val, p := obj[name], join(path, name)
+		matched := false
+		if sub, ok := props[name]; ok {
+			matched = true
+			c.value(p, sub, val, depth)
+		}
+		for _, pat := range sortedKeys(patterns) {
+			if re, err := regexp.Compile(pat); err == nil && re.MatchString(name) {
+				matched = true
+				c.value(p, patterns[pat], val, depth)
+			}
+		}
+		if matched {
+			continue
+		}
+		switch extra := s["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				c.fail(path, "property %q is not allowed", name)
+			}
+		case map[string]any:
+			c.value(p, extra, val, depth)
+		}
+	}
+	if n, ok := count(s, "minProperties"); ok && len(obj) < n {
+		c.fail(path, "has %d properties, want at least %d", len(obj), n)
+	}
+	if n, ok := count(s, "maxProperties"); ok && len(obj) > n {
+		c.fail(path, "has %d properties, want at most %d", len(obj), n)
+	}
+}
+
+func (c *validator) items(path string, s map[string]any, arr []any, depth int) {
+	prefix, _ := s["prefixItems"].([]any)
+	if tuple, ok := s["items"].([]any); ok {
+		prefix = tuple // draft-07 tuple form
+	}
+	for i, val := range arr {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case i < len(prefix):
+			c.value(p, prefix[i], val, depth)
+		case s["items"] != nil && !isArray(s["items"]):
+			c.value(p, s["items"], val, depth)
+		}
+	}
+	if n, ok := count(s, "minItems"); ok && len(arr) < n {
+		c.fail(path, "has %d items, want at least %d", len(arr), n)
+	}
+	if n, ok := count(s, "maxItems"); ok && len(arr) > n {
+		c.fail(path, "has %d items, want at most %d", len(arr), n)
+	}
+	if unique, _ := s["uniqueItems"].(bool); unique {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if equal(arr[i], arr[j]) {
+					c.fail(path, "items %d and %d are equal", i, j)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *validator) stringKeywords(path string, s map[string]any, str string) {
+	n := len([]rune(str))
+	if lo, ok := count(s, "minLength"); ok && n < lo {
+		c.fail(path, "length %d is less than %d", n, lo)
+	}
+	if hi, ok := count(s, "maxLength"); ok && n > hi {
+		c.fail(path, "length %d is more than %d", n, hi)
+	}
+	if pat, ok := s["pattern"].(string); ok {
+		if re, err := regexp.Compile(pat); err == nil && !re.MatchString(str) {
+			c.fail(path, "%q does not match pattern %q", str, pat)
+		}
+	}
+}
+
+func (c *validator) numberKeywords(path string, s map[string]any, num json.Number) {
+	x, err := num.Float64()
+	if err != nil {
+		return
+	}
+	bound := func(key string, fails func(b float64) bool, rel string) {
+		if b, ok := s[key].(float64); ok && fails(b) {
+			c.fail(path, "%s is %s %v", num, rel, b)
+		}
+	}
+	bound("minimum", func(b float64) bool { return x < b }, "less than")
+	bound("maximum", func(b float64) bool { return x > b }, "more than")
+	bound("exclusiveMinimum", func(b float64) bool { return x <= b }, "not more than")
+	bound("exclusiveMaximum", func(b float64) bool { return x >= b }, "not less than")
+	if m, ok := s["multipleOf"].(float64); ok && m > 0 {
+		if q := x / m; math.Abs(q-math.Round(q)) > 1e-9 {
+			c.fail(path, "%s is not a multiple of %v", num, m)
+		}
+	}
+}
+
+// resolve follows a local JSON pointer such as "#/$defs/address".
+func (c *validator) resolve(ref string) (any, error) {
+	pointer, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, fmt.Errorf("$ref %q: only local references are supported", ref)
+	}
+	cur := c.root
+	if pointer == "" {
+		return cur, nil
+	}
+	for _, tok := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		tok = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("$ref %q does not resolve", ref)
+		}
+		if cur, ok = m[tok]; !ok {
+			return nil, fmt.Errorf("$ref %q does not resolve", ref)
+		}
+	}
+	return cur, nil
+}
+
+func typeMatches(t, v any) bool {
+	switch t := t.(type) {
+	case string:
+		return isOfType(t, v)
+	case []any:
+		for _, name := range t {
+			if s, ok := name.(string); ok && isOfType(s, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isOfType(name string, v any) bool {
+	switch name {
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		if _, err := n.Int64(); err == nil {
+			return true
+		}
+		f, err := n.Float64()
+		return err == nil && f == math.Trunc(f)
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	default:
+		return typeName(v) == name
+	}
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func describeType(t any) string {
+	if s, ok := t.(string); ok {
+		return s
+	}
+	return jsonString(t)
+}
+
+func count(s map[string]any, key string) (int, bool) {
+	n, ok := s[key].(float64)
+	return int(n), ok
+}
+
+func isArray(v any) bool {
+	_, ok := v.([]any)
+	return ok
+}
+
+func containsValue(list []any, v any) bool {
+	for _, x := range list {
+		if equal(x, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// equal compares JSON values, treating numbers by value: schema constants
+// decode as float64 while instance numbers stay json.Number.
+func equal(a, b any) bool {
+	if fa, ok := number(a); ok {
+		fb, ok := number(b)
+		return ok && fa == fb
+	}
+	switch a := a.(type) {
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, va := range a {
+			vb, ok := b[k]
+			if !ok || !equal(va, vb) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"time"
 )
 
 // Role enumerates conversation roles.
@@ -48,12 +49,104 @@ type Usage struct {
 	OutputTokens int64
 }
 
+// Price is what a model charges, in US dollars per million tokens.
+type Price struct {
+	InputPerMTok  float64
+	OutputPerMTok float64
+}
+
+// Cost returns the price of u in US dollars.
+func (p Price) Cost(u Usage) float64 {
+	return (float64(u.InputTokens)*p.InputPerMTok + float64(u.OutputTokens)*p.OutputPerMTok) / 1e6
+}
+
 // Request is a provider-neutral completion request.
 type Request struct {
-	Model    string
-	System   string
-	Messages []Message
-	Tools    []ToolDef
+	Model      string
+	System     string
+	Messages   []Message
+	Tools      []ToolDef
+	Generation Generation
+}
+
+// ResponseFormat constrains the model's text output.
+type ResponseFormat string
+
+const (
+	ResponseText       ResponseFormat = "text"        // free text; the default
+	ResponseJSON       ResponseFormat = "json"        // a single JSON object
+	ResponseJSONSchema ResponseFormat = "json_schema" // JSON matching Generation.Schema
+)
+
+// Generation names, as used in agent.toml and in adapter support lists.
+const (
+	GenTemperature     = "temperature"
+	GenTopP            = "top_p"
+	GenMaxOutputTokens = "max_output_tokens"
+	GenStop            = "stop"
+	GenSeed            = "seed"
+	GenResponseFormat  = "response_format"
+)
+
+// Generation holds sampling parameters. Zero values mean the provider's
+// defaults; an empty ResponseFormat means ResponseText.
+type Generation struct {
+	Temperature     *float64
+	TopP            *float64
+	MaxOutputTokens int
+	Stop            []string // stop sequences
+	Seed            *int64
+	ResponseFormat  ResponseFormat
+	Schema          json.RawMessage // JSON Schema for ResponseJSONSchema
+}
+
+// Fields returns the names of the parameters g sets, in declaration order.
+// A text response format is the default and is not reported.
+func (g Generation) Fields() []string {
+	var out []string
+	if g.Temperature != nil {
+		out = append(out, GenTemperature)
+	}
+	if g.TopP != nil {
+		out = append(out, GenTopP)
+	}
+	if g.MaxOutputTokens != 0 {
+		out = append(out, GenMaxOutputTokens)
+	}
+	if len(g.Stop) > 0 {
+		out = append(out, GenStop)
+	}
+	if g.Seed != nil {
+		out = append(out, GenSeed)
+	}
+	if g.ResponseFormat != "" && g.ResponseFormat != ResponseText {
+		out = append(out, GenResponseFormat)
+	}
+	return out
+}
+
+// Merge returns g with every parameter set in over replacing g's.
+func (g Generation) Merge(over Generation) Generation {
+	if over.Temperature != nil {
+		g.Temperature = over.Temperature
+	}
+	if over.TopP != nil {
+		g.TopP = over.TopP
+	}
+	if over.MaxOutputTokens != 0 {
+		g.MaxOutputTokens = over.MaxOutputTokens
+	}
+	if over.Stop != nil {
+		g.Stop = over.Stop
+	}
+	if over.Seed != nil {
+		g.Seed = over.Seed
+	}
+	if over.ResponseFormat != "" {
+		g.ResponseFormat = over.ResponseFormat
+		g.Schema = over.Schema
+	}
+	return g
 }
 
 // EventType enumerates provider stream events.
@@ -96,11 +189,19 @@ type Stream interface {
 	Close() error
 }
 
+// ServedModel is implemented by streams that report which model serves
+// them, such as those of a fallback chain. Model returns a model reference
+// ("provider/model-id").
+type ServedModel interface {
+	Model() string
+}
+
 // ProviderError is a provider-side failure with a stable code.
 type ProviderError struct {
-	Provider string
-	Code     string // e.g. "http_500", "malformed_stream", "request_failed"
-	Err      error
+	Provider   string
+	Code       string // e.g. "http_500", "malformed_stream", "request_failed"
+	Err        error
+	RetryAfter time.Duration // server-requested wait before retrying; zero if none
 }
 
 func (e *ProviderError) Error() string {
@@ -114,6 +215,22 @@ func NewProviderError(provider, code string, err error) *ProviderError {
 	return &ProviderError{Provider: provider, Code: code, Err: err}
 }
 
+type warningsKey struct{}
+
+// WithWarnings returns a context carrying fn, through which provider
+// wrappers report recoverable problems, such as a retried call, to the run.
+// The runner installs one that emits warning events.
+func WithWarnings(ctx context.Context, fn func(msg string)) context.Context {
+	return context.WithValue(ctx, warningsKey{}, fn)
+}
+
+// Warn reports msg through the function installed by WithWarnings, if any.
+func Warn(ctx context.Context, msg string) {
+	if fn, ok := ctx.Value(warningsKey{}).(func(string)); ok {
+		fn(msg)
+	}
+}
+
 // sliceStream adapts a fixed event slice to the Stream interface. It exists
 // for tests and providers that produce whole responses.
 type SliceStream struct {
@@ -0,0 +1,248 @@
+package markdown
+
+import "strings"
+
+// syntax is what the highlighter knows of a language: enough to color
+// keywords, strings, numbers, and comments a line at a time.
+type syntax struct {
+	keywords map[string]bool
+	comments []string  // line comment openers
+	block    [2]string // block comment delimiters, if any
+	quotes   string    // string delimiters
+	fold     bool      // keywords are case-insensitive
+}
+
+func words(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var (
+	cLike = syntax{
+		keywords: words(`auto break case char class const continue default do double
+			else enum extern false final float for goto if implements import
+			int long namespace new null nullptr private protected public return
+			short signed sizeof static struct switch template this throw throws
+			true try catch typedef typename union unsigned using var virtual
+			void volatile while bool package interface extends super`),
+		comments: []string{"//"},
+		block:    [2]string{"/*", "*/"},
+		quotes:   `"'`,
+	}
+	shell = syntax{
+		keywords: words(`if then else elif fi case esac for while until do done in
+			function return local export set unset echo exit break continue
+			source alias cd`),
+		comments: []string{"#"},
+		quotes:   `"'`,
+	}
+)
+
+var syntaxes = map[string]*syntax{
+	"go": {
+		keywords: words(`break case chan const continue default defer else
+			fallthrough for func go goto if import interface map package range
+			return select struct switch type var nil true false iota any error
+			string int int64 uint8 byte rune bool float64 append len make new
+			panic recover`),
+		comments: []string{"//"},
+		block:    [2]string{"/*", "*/"},
+		quotes:   "\"'`",
+	},
+	"python": {
+		keywords: words(`and as assert async await break class continue def del
+			elif else except False finally for from global if import in is
+			lambda None nonlocal not or pass raise return True try while with
+			yield self print`),
+		comments: []string{"#"},
+		quotes:   `"'`,
+	},
+	"javascript": {
+		keywords: words(`async await break case catch class const continue
+			debugger default delete do else export extends false finally for
+			from function if import in instanceof let new null of return static
+			super switch this throw true try typeof undefined var void while
+			yield interface type enum implements readonly`),
+		comments: []string{"//"},
+		block:    [2]string{"/*", "*/"},
+		quotes:   "\"'`",
+	},
+	"rust": {
+		keywords: words(`as async await break const continue crate dyn else enum
+			extern false fn for if impl in let loop match mod move mut pub ref
+			return self Self static struct super trait true type unsafe use
+			where while Some None Ok Err`),
+		comments: []string{"//"},
+		block:    [2]string{"/*", "*/"},
+		quotes:   `"`,
+	},
+	"ruby": {
+		keywords: words(`alias and begin break case class def do else
+			elsif end ensure false for if in module next nil not or redo rescue
+			retry return self super then true undef unless until when while
+			yield require puts`),
+		comments: []string{"#"},
+		quotes:   `"'`,
+	},
+	"sql": {
+		keywords: words(`select from where and or not insert into values update
+			set delete create table drop alter index primary key foreign
+			references join left right inner outer on group by order having
+			limit offset as distinct union all null is in like between case
+			when then else end exists default unique`),
+		comments: []string{"--"},
+		block:    [2]string{"/*", "*/"},
+		quotes:   `'"`,
+		fold:     true,
+	},
+	"json": {
+		keywords: words(`true false null`),
+		quotes:   `"`,
+	},
+	"yaml": {
+		keywords: words(`true false null yes no`),
+		comments: []string{"#"},
+		quotes:   `"'`,
+	},
+	"toml": {
+		keywords: words(`true false`),
+		comments: []string{"#"},
+		quotes:   `"'`,
+	},
+	"c":     &cLike,
+	"sh":    &shell,
+	"shell": &shell,
+}
+
+// aliases maps fence languages to the names in syntaxes.
+var aliases = map[string]string{
+	"golang": "go", "py": "python", "js": "javascript", "jsx": "javascript",
+	"ts": "javascript", "tsx": "javascript", "typescript": "javascript",
+	"rs": "rust", "rb": "ruby", "yml": "yaml", "bash": "sh", "zsh": "sh",
+	"console": "sh", "cpp": "c", "c++": "c", "h": "c", "java": "c",
+	"cs": "c", "csharp": "c", "kotlin": "c", "swift": "c", "jsonc": "json",
+}
+
+// highlight writes one line of fenced code in lang, colored where the
+// language is known. comment tells whether the line starts inside a block
+// comment; highlight returns whether the next one does.
+func highlight(b *strings.Builder, lang, line string, comment bool) bool {
+	if lang == "diff" {
+		switch {
+		case strings.HasPrefix(line, "+"):
+			b.WriteString(green + line + reset)
+		case strings.HasPrefix(line, "-"):
+			b.WriteString(red + line + reset)
+		case strings.HasPrefix(line, "@@"):
+			b.WriteString(cyan + line + reset)
+		default:
+			b.WriteString(line)
+		}
+		return false
+	}
+	if name, ok := aliases[lang]; ok {
+		lang = name
+	}
+	syn := syntaxes[lang]
+	if syn == nil {
+		b.WriteString(line)
+		return false
+	}
+
+	i := 0
+	if comment {
+		end := strings.Index(line, syn.block[1])
+		if end < 0 {
+			b.WriteString(dim + line + reset)
+			return true
+		}
+		i = end + len(syn.block[1])
+		b.WriteString(dim + line[:i] + reset)
+	}
+	for i < len(line) {
+		rest := line[i:]
+		if hasAnyPrefix(rest, syn.comments) {
+			b.WriteString(dim + rest + reset)
+			return false
+		}
+		if open := syn.block[0]; open != "" && strings.HasPrefix(rest, open) {
+			end := strings.Index(rest[len(open):], syn.block[1])
+			if end < 0 {
+				b.WriteString(dim + rest + reset)
+				return true
+			}
+			n := len(open) + end + len(syn.block[1])
+			b.WriteString(dim + rest[:n] + reset)
+			i += n
+			continue
+		}
+		c := line[i]
+		switch {
+		case strings.IndexByte(syn.quotes, c) >= 0:
+			n := quoted(rest)
+			b.WriteString(green + rest[:n] + reset)
+			i += n
+		case isDigit(c) && (i == 0 || !isWord(line[i-1])):
+			n := 1
+			for n < len(rest) && (isWord(rest[n]) || rest[n] == '.') {
+				n++
+			}
+			b.WriteString(yellow + rest[:n] + reset)
+			i += n
+		case isWord(c):
+			n := 1
+			for n < len(rest) && isWord(rest[n]) {
+				n++
+			}
+			word := rest[:n]
+			if syn.fold {
+				word = strings.ToLower(word)
+			}
+			if syn.keywords[word] {
+				b.WriteString(magenta + rest[:n] + reset)
+			} else {
+				b.WriteString(rest[:n])
+			}
+			i += n
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return false
+}
+
+// quoted returns the length of the string literal s starts with, up to
+// the end of the line if it is not closed there.
+func quoted(s string) int {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if q != '`' {
+				i++
+			}
+		case q:
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isWord(c byte) bool {
+	return c == '_' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,412 @@
+// Package markdown renders streamed markdown for a terminal with ANSI
+// styles. Text arrives in chunks of any size; a line is styled as soon as
+// its start shows what kind of line it is, so paragraphs still stream
+// while headings, lists, fenced code, and tables come out formatted.
+package markdown
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// ANSI styles.
+const (
+	reset     = "\x1b[0m"
+	bold      = "\x1b[1m"
+	dim       = "\x1b[2m"
+	underline = "\x1b[4m"
+	red       = "\x1b[31m"
+	green     = "\x1b[32m"
+	yellow    = "\x1b[33m"
+	magenta   = "\x1b[35m"
+	cyan      = "\x1b[36m"
+)
+
+// maxRule bounds the width of a horizontal rule.
+const maxRule = 80
+
+// kind is how a line renders.
+type kind int
+
+const (
+	kindUnknown  kind = iota // the line's start is not seen yet
+	kindText                 // paragraph text, or a blank line
+	kindHeading              // # Title
+	kindBullet               // - item
+	kindNumbered             // 1. item
+	kindQuote                // > text
+	kindRule                 // ---
+	kindFence                // ```lang
+	kindTable                // | a | b |
+)
+
+// Renderer writes markdown to a terminal as it streams in. It is not safe
+// for concurrent use.
+type Renderer struct {
+	w     io.Writer
+	width int
+
+	out   strings.Builder // rendered output of the current Write
+	line  string          // current line: text not yet rendered
+	kind  kind            // of the current line
+	span  span            // inline styles of the current line
+	table []string        // rows of the table being read
+
+	fence   string // marker of the open code fence, "" outside code
+	lang    string // language of the open code fence
+	comment bool   // inside a block comment in fenced code
+}
+
+// NewRenderer returns a Renderer writing to w, a terminal width columns
+// wide.
+func NewRenderer(w io.Writer, width int) *Renderer {
+	return &Renderer{w: w, width: width}
+}
+
+// Write renders p, holding back what cannot be styled yet: the start of a
+// line too short to classify, a possible half of a ** marker, and whole
+// lines of fenced code and tables.
+func (r *Renderer) Write(p []byte) (int, error) {
+	r.line += string(p)
+	for {
+		i := strings.IndexByte(r.line, '\n')
+		if i < 0 {
+			break
+		}
+		rest := r.line[i+1:]
+		r.line = r.line[:i]
+		r.endLine(true)
+		r.line = rest
+	}
+	if r.fence == "" && (r.kind != kindUnknown || r.begin(false)) {
+		r.line = r.span.render(&r.out, r.line, false)
+	}
+	return len(p), r.emit()
+}
+
+// Flush renders everything held back and ends the text: the next Write
+// starts a new document, outside any code fence or table. A last line
+// without a newline is rendered without one.
+func (r *Renderer) Flush() error {
+	if r.line != "" || r.kind != kindUnknown {
+		r.endLine(false)
+	}
+	r.endTable(false)
+	r.fence, r.lang, r.comment = "", "", false
+	return r.emit()
+}
+
+func (r *Renderer) emit() error {
+	if r.out.Len() == 0 {
+		return nil
+	}
+	_, err := io.WriteString(r.w, r.out.String())
+	r.out.Reset()
+	return err
+}
+
+// begin classifies the current line and renders its block prefix. It
+// reports false while the line is too short to tell; complete lines always
+// classify. Fences, rules, and table rows classify only once complete.
+func (r *Renderer) begin(complete bool) bool {
+	k, n, level, ok := classify(r.line, complete)
+	if !ok {
+		return false
+	}
+	if k != kindTable {
+		r.endTable(true)
+	}
+	r.kind = k
+	indent := r.line[:len(r.line)-len(strings.TrimLeft(r.line, " \t"))]
+	switch k {
+	case kindHeading:
+		r.span.base = bold
+		if level == 1 {
+			r.span.base += underline
+		}
+		r.span.start(&r.out)
+	case kindBullet:
+		r.out.WriteString(indent + "• ")
+	case kindNumbered:
+		r.out.WriteString(r.line[:n])
+	case kindQuote:
+		r.out.WriteString(indent + dim + "│ " + reset)
+	case kindFence, kindRule, kindTable:
+		return true // rendered whole by endLine
+	}
+	r.line = r.line[n:]
+	return true
+}
+
+// endLine renders the rest of the current line, then a newline if nl is
+// set.
+func (r *Renderer) endLine(nl bool) {
+	r.line = strings.TrimSuffix(r.line, "\r")
+	if r.fence != "" {
+		r.codeLine()
+	} else {
+		if r.kind == kindUnknown {
+			r.begin(true)
+		}
+		switch r.kind {
+		case kindFence:
+			r.openFence()
+		case kindRule:
+			r.out.WriteString(dim + strings.Repeat("─", min(max(r.width, 3), maxRule)) + reset)
+		case kindTable:
+			r.table = append(r.table, r.line)
+			nl = false // endTable ends the row
+		default:
+			r.span.render(&r.out, r.line, true)
+			r.span.end(&r.out)
+		}
+	}
+	if nl {
+		r.out.WriteByte('\n')
+	}
+	r.line, r.kind = "", kindUnknown
+}
+
+// classify tells the kind of a line from its start. n is the length of the
+// block marker the rendered line replaces, and level the heading level.
+// ok is false while line is too short to tell.
+func classify(line string, complete bool) (k kind, n, level int, ok bool) {
+	body := strings.TrimLeft(line, " \t")
+	indent := len(line) - len(body)
+	if body == "" {
+		return kindText, 0, 0, complete
+	}
+	c := body[0]
+	run := len(body) - len(strings.TrimLeft(body, string(c)))
+	switch {
+	case c == '`' || c == '~':
+		if run >= 3 {
+			return kindFence, 0, 0, complete
+		}
+		if run == len(body) && !complete {
+			return kindUnknown, 0, 0, false
+		}
+	case c == '#':
+		switch {
+		case run == len(body) && !complete:
+			return kindUnknown, 0, 0, false
+		case run <= 6 && (run == len(body) || body[run] == ' '):
+			n = indent + run
+			n += len(line[n:]) - len(strings.TrimLeft(line[n:], " "))
+			return kindHeading, n, run, true
+		}
+	case c == '-' || c == '*' || c == '+' || c == '_':
+		if c != '_' && len(body) > 1 && body[1] == ' ' {
+			return kindBullet, indent + 2, 0, true
+		}
+		if c != '+' && isRule(body, c) {
+			if !complete {
+				return kindUnknown, 0, 0, false
+			}
+			if len(strings.ReplaceAll(body, " ", "")) >= 3 {
+				return kindRule, 0, 0, true
+			}
+		}
+	case c >= '0' && c <= '9':
+		digits := len(body) - len(strings.TrimLeft(body, "0123456789"))
+		switch {
+		case digits+1 >= len(body) && !complete:
+			return kindUnknown, 0, 0, false
+		case digits < len(body)-1 && (body[digits] == '.' || body[digits] == ')') && body[digits+1] == ' ':
+			return kindNumbered, indent + digits + 2, 0, true
+		}
+	case c == '>':
+		n = indent + 1
+		if len(body) > 1 && body[1] == ' ' {
+			n++
+		}
+		return kindQuote, n, 0, len(body) > 1 || complete
+	case c == '|':
+		return kindTable, 0, 0, complete
+	}
+	return kindText, 0, 0, true
+}
+
+// isRule reports whether body so far is made of c, possibly spaced.
+func isRule(body string, c byte) bool {
+	for i := 0; i < len(body); i++ {
+		if body[i] != c && body[i] != ' ' {
+			return false
+		}
+	}
+	return true
+}
+
+// span renders the inline styles of a line: **bold** and `code`.
+type span struct {
+	base       string // style of the whole line
+	bold, code bool
+}
+
+func (s *span) start(b *strings.Builder) { b.WriteString(s.base) }
+
+// restyle sets the terminal to the span's current style.
+func (s *span) restyle(b *strings.Builder) {
+	b.WriteString(reset + s.base)
+	if s.bold {
+		b.WriteString(bold)
+	}
+	if s.code {
+		b.WriteString(cyan)
+	}
+}
+
+// render writes text and returns what it held back: a trailing * that may
+// be half of a bold marker, unless the text is final.
+func (s *span) render(b *strings.Builder, text string, final bool) string {
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case c == '`':
+			s.code = !s.code
+			s.restyle(b)
+			i++
+		case c == '*' && !s.code:
+			if i+1 == len(text) && !final {
+				return text[i:]
+			}
+			if i+1 < len(text) && text[i+1] == '*' {
+				s.bold = !s.bold
+				s.restyle(b)
+				i += 2
+				continue
+			}
+			b.WriteByte('*')
+			i++
+		default:
+			j := i + 1
+			for j < len(text) && text[j] != '`' && text[j] != '*' {
+				j++
+			}
+			b.WriteString(text[i:j])
+			i = j
+		}
+	}
+	return ""
+}
+
+// end closes the line's styles.
+func (s *span) end(b *strings.Builder) {
+	if s.base != "" || s.bold || s.code {
+		b.WriteString(reset)
+	}
+	*s = span{}
+}
+
+// openFence starts a code block at the current line, a fence.
+func (r *Renderer) openFence() {
+	body := strings.TrimLeft(r.line, " \t")
+	run := len(body) - len(strings.TrimLeft(body, body[:1]))
+	r.fence = body[:run]
+	r.lang = ""
+	if info := strings.Fields(body[run:]); len(info) > 0 {
+		r.lang = strings.ToLower(info[0])
+	}
+	r.comment = false
+	r.out.WriteString(dim + r.line + reset)
+}
+
+// codeLine renders the current line inside a code fence, or the fence that
+// closes it.
+func (r *Renderer) codeLine() {
+	body := strings.TrimSpace(r.line)
+	if len(body) >= len(r.fence) && strings.Trim(body, r.fence[:1]) == "" {
+		r.out.WriteString(dim + r.line + reset)
+		r.fence, r.lang, r.comment = "", "", false
+		return
+	}
+	r.comment = highlight(&r.out, r.lang, r.line, r.comment)
+}
+
+// endTable renders the buffered table with its columns aligned, ending the
+// last row with a newline if nl is set.
+func (r *Renderer) endTable(nl bool) {
+	if len(r.table) == 0 {
+		return
+	}
+	rows := make([][]string, len(r.table))
+	var widths []int
+	for i, line := range r.table {
+		cells := strings.Split(strings.Trim(strings.TrimSpace(line), "|"), "|")
+		for j, cell := range cells {
+			var b strings.Builder
+			var s span
+			s.render(&b, strings.TrimSpace(cell), true)
+			s.end(&b)
+			cells[j] = b.String()
+			if j == len(widths) {
+				widths = append(widths, 0)
+			}
+			if !isDelimiter(cell) {
+				widths[j] = max(widths[j], visibleWidth(cells[j]))
+			}
+		}
+		rows[i] = cells
+	}
+	for i, cells := range rows {
+		if i > 0 {
+			r.out.WriteByte('\n')
+		}
+		if isDelimiterRow(r.table[i]) {
+			parts := make([]string, len(widths))
+			for j, w := range widths {
+				parts[j] = strings.Repeat("─", w)
+			}
+			r.out.WriteString(dim + strings.Join(parts, "─┼─") + reset)
+			continue
+		}
+		for j, cell := range cells {
+			if j > 0 {
+				r.out.WriteString(dim + " │ " + reset)
+			}
+			r.out.WriteString(cell)
+			if j < len(cells)-1 {
+				r.out.WriteString(strings.Repeat(" ", widths[j]-visibleWidth(cell)))
+			}
+		}
+	}
+	if nl {
+		r.out.WriteByte('\n')
+	}
+	r.table = r.table[:0]
+}
+
+// isDelimiterRow reports whether line is a table's header delimiter row,
+// such as |---|:--:|.
+func isDelimiterRow(line string) bool {
+	for _, cell := range strings.Split(strings.Trim(strings.TrimSpace(line), "|"), "|") {
+		if !isDelimiter(cell) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDelimiter(cell string) bool {
+	cell = strings.Trim(strings.TrimSpace(cell), ":")
+	return cell != "" && strings.Trim(cell, "-") == ""
+}
+
+// visibleWidth counts the runes of s outside ANSI escape sequences.
+func visibleWidth(s string) int {
+	n := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\x1b' {
+			j := strings.IndexByte(s[i:], 'm')
+			if j < 0 {
+				break
+			}
+			i += j + 1
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		n++
+	}
+	return n
+}
@@ -0,0 +1,130 @@
+package markdown_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/chtushar/pingu/internal/markdown"
+)
+
+const doc = "# Title\n" +
+	"Some **bold** text and `code`, 2 * 3.\n" +
+	"\n" +
+	"## Steps\n" +
+	"- first\n" +
+	"  - nested **item**\n" +
+	"1. one\n" +
+	"10) ten\n" +
+	"> quoted\n" +
+	"---\n" +
+	"```go\n" +
+	"func main() { // entry\n" +
+	"\treturn \"x\" /* a\n" +
+	"b */ + 42\n" +
+	"```\n" +
+	"| Name | Size |\n" +
+	"|------|-----:|\n" +
+	"| **a** | 1 |\n" +
+	"| bb | 22 |\n" +
+	"\n" +
+	"#hashtag and -dash"
+
+// render writes text to a Renderer in chunks of size n, or in one piece
+// for n = 0.
+func render(text string, n int) string {
+	var b strings.Builder
+	r := markdown.NewRenderer(&b, 20)
+	if n == 0 {
+		n = len(text)
+	}
+	for i := 0; i < len(text); i += n {
+		r.Write([]byte(text[i:min(i+n, len(text))]))
+	}
+	r.Flush()
+	return b.String()
+}
+
+var ansi = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func TestRenderer(t *testing.T) {
+	styled := render(doc, 0)
+	plain := ansi.ReplaceAllString(styled, "")
+	want := "Title\n" +
+		"Some bold text and code, 2 * 3.\n" +
+		"\n" +
+		"Steps\n" +
+		"• first\n" +
+		"  • nested item\n" +
+		"1. one\n" +
+		"10) ten\n" +
+		"│ quoted\n" +
+		strings.Repeat("─", 20) + "\n" +
+		"```go\n" +
+		"func main() { // entry\n" +
+		"\treturn \"x\" /* a\n" +
+		"b */ + 42\n" +
+		"```\n" +
+		"Name │ Size\n" +
+		"─────┼─────\n" +
+		"a    │ 1\n" +
+		"bb   │ 22\n" +
+		"\n" +
+		"#hashtag and -dash"
+	if plain != want {
+		t.Errorf("text =\n%s\nwant\n%s", plain, want)
+	}
+
+	for _, s := range []string{
+		"\x1b[1m\x1b[4mTitle\x1b[0m",             // h1
+		"\x1b[0m\x1b[1mbold\x1b[0m",              // bold
+		"\x1b[0m\x1b[36mcode\x1b[0m",             // inline code
+		"\x1b[35mfunc\x1b[0m main",               // keyword
+		"\x1b[2m// entry\x1b[0m",                 // comment
+		"\x1b[32m\"x\"\x1b[0m",                   // string
+		"\x1b[2m/* a\x1b[0m\n\x1b[2mb */\x1b[0m", // block comment across lines
+		"\x1b[33m42\x1b[0m",                      // number
+	} {
+		if !strings.Contains(styled, s) {
+			t.Errorf("output misses %q:\n%q", s, styled)
+		}
+	}
+}
+
+func TestRendererChunking(t *testing.T) {
+	whole := render(doc, 0)
+	for n := 1; n < 12; n++ {
+		if got := render(doc, n); got != whole {
+			t.Errorf("chunks of %d:\n%q\nwant\n%q", n, got, whole)
+		}
+	}
+}
+
+func TestRendererStreams(t *testing.T) {
+	var b strings.Builder
+	r := markdown.NewRenderer(&b, 80)
+	r.Write([]byte("Hello wor"))
+	if b.String() != "Hello wor" {
+		t.Errorf("paragraph text held back: %q", b.String())
+	}
+	r.Write([]byte("ld **bo"))
+	r.Write([]byte("ld*"))
+	if got := ansi.ReplaceAllString(b.String(), ""); got != "Hello world bold" {
+		t.Errorf("after a partial marker: %q", got)
+	}
+	r.Write([]byte("*\n```sh\necho hi"))
+	if strings.Contains(b.String(), "echo") {
+		t.Errorf("partial code line written: %q", b.String())
+	}
+	r.Flush()
+	if got := ansi.ReplaceAllString(b.String(), ""); got != "Hello world bold\n```sh\necho hi" {
+		t.Errorf("flushed: %q", got)
+	}
+
+	// Flush ends the open fence: the next text is markdown again.
+	b.Reset()
+	r.Write([]byte("# Next\n"))
+	if !strings.HasPrefix(b.String(), "\x1b[1m") {
+		t.Errorf("after Flush: %q", b.String())
+	}
+}
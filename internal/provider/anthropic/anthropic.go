@@ -0,0 +1,410 @@
+// Package anthropic implements llm.Provider against the Anthropic Messages
+// API with response streaming. It speaks raw HTTP with the standard library;
+// no provider SDK types cross this boundary.
+package anthropic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/provider"
+)
+
+const (
+	// DefaultBaseURL is the public Anthropic API root.
+	DefaultBaseURL = "https://api.anthropic.com/v1"
+	// APIVersion is the anthropic-version header value the adapter speaks.
+	APIVersion = "2023-06-01"
+	// DefaultMaxTokens is sent when the caller sets no output cap; the
+	// Messages API requires one.
+	DefaultMaxTokens = 4096
+	providerName     = "anthropic"
+	maxErrorBody     = 4 * 1024
+	maxScanLine      = 1024 * 1024
+)
+
+// Options configures the adapter.
+type Options struct {
+	APIKey     string
+	BaseURL    string
+	Headers    map[string]string // extra request headers, set last
+	MaxTokens  int               // output cap when a request sets none; DefaultMaxTokens when zero
+	HTTPClient *http.Client
+}
+
+// Provider streams completions from the Anthropic Messages API.
+type Provider struct {
+	opts   Options
+	client *http.Client
+}
+
+// New builds a provider; a missing API key is an error.
+func New(opts Options) (*Provider, error) {
+	if opts.APIKey == "" {
+		return nil, errors.New("ANTHROPIC_API_KEY is not set")
+	}
+	if opts.BaseURL == "" {
+		opts.BaseURL = DefaultBaseURL
+	}
+	if opts.MaxTokens == 0 {
+		opts.MaxTokens = DefaultMaxTokens
+	}
+	client := opts.HTTPClient
+	if client == nil {
+		client = &http.Client{}
+	}
+	return &Provider{opts: opts, client: client}, nil
+}
+
+// FromEnv builds a provider from ANTHROPIC_API_KEY and ANTHROPIC_BASE_URL.
+func FromEnv(client *http.Client) (*Provider, error) {
+	return New(Options{
+		APIKey:     os.Getenv("ANTHROPIC_API_KEY"),
+		BaseURL:    os.Getenv("ANTHROPIC_BASE_URL"),
+		HTTPClient: client,
+	})
+}
+
+// Stream starts a streaming completion. The context governs the whole HTTP
+// exchange: cancelling it aborts the request and unblocks Next.
+func (p *Provider) Stream(ctx context.Context, req llm.Request) (llm.Stream, error) {
+	body, err := p.buildBody(req)
+	if err != nil {
+		return nil, llm.NewProviderError(providerName, "request_body", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.opts.BaseURL+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, llm.NewProviderError(providerName, "request_failed", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	httpReq.Header.Set("X-Api-Key", p.opts.APIKey)
+	httpReq.Header.Set("Anthropic-Version", APIVersion)
+	for k, v := range p.opts.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, llm.NewProviderError(providerName, "request_failed", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg := readBounded(resp.Body)
+		if msg == "" {
+			msg = http.StatusText(resp.StatusCode)
+		}
+		perr := llm.NewProviderError(providerName, "http_"+strconv.Itoa(resp.StatusCode), errors.New(msg))
+		perr.RetryAfter = provider.RetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return nil, perr
+	}
+
+	s := &stream{resp: resp, blocks: map[int]string{}}
+	s.scanner = bufio.NewScanner(resp.Body)
+	s.scanner.Buffer(make([]byte, 0, 64*1024), maxScanLine)
+	return s, nil
+}
+
+func readBounded(r io.Reader) string {
+	b, _ := io.ReadAll(io.LimitReader(r, maxErrorBody))
+	return string(bytes.TrimSpace(b))
+}
+
+// --- wire format ---
+
+type wireBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type wireMessage struct {
+	Role    string      `json:"role"`
+	Content []wireBlock `json:"content"`
+}
+
+type wireToolDef struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type wireRequest struct {
+	Model         string        `json:"model"`
+	MaxTokens     int           `json:"max_tokens"`
+	Temperature   *float64      `json:"temperature,omitempty"`
+	TopP          *float64      `json:"top_p,omitempty"`
+	StopSequences []string      `json:"stop_sequences,omitempty"`
+	Stream        bool          `json:"stream"`
+	System        string        `json:"system,omitempty"`
+	Messages      []wireMessage `json:"messages"`
+	Tools         []wireToolDef `json:"tools,omitempty"`
+}
+
+// emptySchema is sent for tools without parameters; input_schema is
+// required by the API.
+var emptySchema = json.RawMessage(`{"type":"object","properties":{}}`)
+
+func (p *Provider) buildBody(req llm.Request) ([]byte, error) {
+	w := wireRequest{
+		Model:         req.Model,
+		MaxTokens:     p.opts.MaxTokens,
+		Temperature:   req.Generation.Temperature,
+		TopP:          req.Generation.TopP,
+		StopSequences: req.Generation.Stop,
+		Stream:        true,
+		System:        req.System,
+		Messages:      make([]wireMessage, 0, len(req.Messages)),
+	}
+	if req.Generation.MaxOutputTokens > 0 {
+		w.MaxTokens = req.Generation.MaxOutputTokens
+	}
+	for _, m := range req.Messages {
+		var role string
+		var blocks []wireBlock
+		switch m.Role {
+		case llm.RoleSystem:
+			// The Messages API has no system role; fold stray system
+			// messages into the top-level prompt.
+			if w.System != "" {
+				w.System += "\n\n"
+			}
+			w.System += m.Content
+			continue
+		case llm.RoleUser:
+			role = "user"
+			blocks = []wireBlock{{Type: "text", Text: m.Content}}
+		case llm.RoleAssistant:
+			role = "assistant"
+			if m.Content != "" {
+				blocks = append(blocks, wireBlock{Type: "text", Text: m.Content})
+			}
+			for _, c := range m.ToolCalls {
+				input := c.Arguments
+				if len(bytes.TrimSpace(input)) == 0 {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, wireBlock{Type: "tool_use", ID: c.ID, Name: c.Name, Input: input})
+			}
+		case llm.RoleTool:
+			// Tool results travel as user messages.
+			role = "user"
+			blocks = []wireBlock{{Type: "tool_result", ToolUseID: m.ToolCallID, Content: m.Content}}
+		default:
+			return nil, fmt.Errorf("unsupported role %q", m.Role)
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		// Consecutive same-role messages (e.g. several tool results) merge
+		// into one message; the API requires alternating roles.
+		if n := len(w.Messages); n > 0 && w.Messages[n-1].Role == role {
+			w.Messages[n-1].Content = append(w.Messages[n-1].Content, blocks...)
+			continue
+		}
+		w.Messages = append(w.Messages, wireMessage{Role: role, Content: blocks})
+	}
+	for _, t := range req.Tools {
+		schema := t.Parameters
+		if len(schema) == 0 {
+			schema = emptySchema
+		}
+		w.Tools = append(w.Tools, wireToolDef{Name: t.Name, Description: t.Description, InputSchema: schema})
+	}
+	return json.Marshal(w)
+}
+
+// --- stream decoding ---
+
+type wireEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message struct {
+		Usage wireUsage `json:"usage"`
+	} `json:"message"`
+	ContentBlock struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Text string `json:"text"`
+	} `json:"content_block"`
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *wireUsage `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type wireUsage struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+}
+
+type stream struct {
+	resp    *http.Response
+	scanner *bufio.Scanner
+	// blocks maps content block indexes to their type so stop events can
+	// be mapped to tool call ends.
+	blocks map[int]string
+	// inputTokens is reported by message_start and folded into the single
+	// usage event emitted at message_delta.
+	inputTokens int64
+	closed      bool
+}
+
+func (s *stream) Next(ctx context.Context) (llm.Event, error) {
+	if s.closed {
+		return llm.Event{}, io.EOF
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return llm.Event{}, err
+		}
+		if !s.scanner.Scan() {
+			if err := s.scanner.Err(); err != nil {
+				return llm.Event{}, s.streamErr(err)
+			}
+			return llm.Event{}, io.EOF
+		}
+		line := bytes.TrimSpace(s.scanner.Bytes())
+		if len(line) == 0 || line[0] == ':' {
+			continue // keep-alive comment or blank separator
+		}
+		if bytes.HasPrefix(line, []byte("event:")) {
+			continue // the data payload repeats the event type
+		}
+		data, ok := bytes.CutPrefix(line, []byte("data:"))
+		if !ok {
+			return llm.Event{}, llm.NewProviderError(providerName, "malformed_stream", fmt.Errorf("unexpected line %q", string(line)))
+		}
+		var ev wireEvent
+		if err := json.Unmarshal(bytes.TrimSpace(data), &ev); err != nil {
+			return llm.Event{}, llm.NewProviderError(providerName, "malformed_stream", err)
+		}
+		out, ok, err := s.decode(&ev)
+		if err != nil {
+			return llm.Event{}, err
+		}
+		if ok {
+			return out, nil
+		}
+		if ev.Type == "message_stop" {
+			return llm.Event{}, io.EOF
+		}
+	}
+}
+
+func (s *stream) streamErr(err error) error {
+	if cerr := s.resp.Request.Context().Err(); cerr != nil {
+		return cerr
+	}
+	return llm.NewProviderError(providerName, "stream_failed", err)
+}
+
+func (s *stream) decode(ev *wireEvent) (llm.Event, bool, error) {
+	switch ev.Type {
+	case "message_start":
+		s.inputTokens = ev.Message.Usage.InputTokens
+	case "content_block_start":
+		s.blocks[ev.Index] = ev.ContentBlock.Type
+		switch ev.ContentBlock.Type {
+		case "tool_use":
+			return llm.Event{
+				Type:       llm.EventToolCallStart,
+				ToolIndex:  ev.Index,
+				ToolCallID: ev.ContentBlock.ID,
+				ToolName:   ev.ContentBlock.Name,
+			}, true, nil
+		case "text":
+			if ev.ContentBlock.Text != "" {
+				return llm.Event{Type: llm.EventTextDelta, Text: ev.ContentBlock.Text}, true, nil
+			}
+		}
+	case "content_block_delta":
+		switch ev.Delta.Type {
+		case "text_delta":
+			if ev.Delta.Text != "" {
+				return llm.Event{Type: llm.EventTextDelta, Text: ev.Delta.Text}, true, nil
+			}
+		case "input_json_delta":
+			if ev.Delta.PartialJSON != "" {
+				return llm.Event{Type: llm.EventToolCallDelta, ToolIndex: ev.Index, ArgumentsDelta: ev.Delta.PartialJSON}, true, nil
+			}
+		}
+	case "content_block_stop":
+		if s.blocks[ev.Index] == "tool_use" {
+			return llm.Event{Type: llm.EventToolCallEnd, ToolIndex: ev.Index}, true, nil
+		}
+	case "message_delta":
+		if ev.Usage != nil {
+			// Counts are cumulative; newer API versions repeat the input
+			// count here, older ones report it only in message_start.
+			input := ev.Usage.InputTokens
+			if input == 0 {
+				input = s.inputTokens
+			}
+			return llm.Event{Type: llm.EventUsage, Usage: llm.Usage{
+				InputTokens:  input,
+				OutputTokens: ev.Usage.OutputTokens,
+			}}, true, nil
+		}
+	case "error":
+		code := ev.Error.Type
+		if code == "" {
+			code = "stream_error"
+		}
+		return llm.Event{}, false, llm.NewProviderError(providerName, code, errors.New(ev.Error.Message))
+	}
+	// ping, message_stop, and thinking or other unknown blocks carry no
+	// emittable event.
+	return llm.Event{}, false, nil
+}
+
+func (s *stream) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.resp.Body.Close()
+}
+
+func init() {
+	provider.Register(provider.Adapter{
+		Name: providerName,
+		Env:  []string{"ANTHROPIC_API_KEY"},
+		New: func(opts provider.Options) (llm.Provider, error) {
+			baseURL := opts.BaseURL
+			if baseURL == "" {
+				baseURL = os.Getenv("ANTHROPIC_BASE_URL")
+			}
+			return New(Options{
+				APIKey:     os.Getenv("ANTHROPIC_API_KEY"),
+				BaseURL:    baseURL,
+				Headers:    opts.Headers,
+				HTTPClient: opts.HTTPClient,
+			})
+		},
+		// The Messages API has no seed and no JSON mode.
+		Generation: []string{llm.GenTemperature, llm.GenTopP, llm.GenMaxOutputTokens, llm.GenStop},
+	})
+}
@@ -0,0 +1,306 @@
+package anthropic_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/provider/anthropic"
+)
+
+func newProvider(t *testing.T, handler http.HandlerFunc) *anthropic.Provider {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	p, err := anthropic.New(anthropic.Options{
+		APIKey:  "test-key",
+		BaseURL: srv.URL,
+	})
+	if err != nil {
+		t.Fatalf("provider: %v", err)
+	}
+	return p
+}
+
+// sse writes named server-sent events; each entry is {event, data}.
+func sse(w http.ResponseWriter, events [][2]string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher := w.(http.Flusher)
+	for _, e := range events {
+		io.WriteString(w, "event: "+e[0]+"\ndata: "+e[1]+"\n\n")
+		flusher.Flush()
+	}
+}
+
+func collect(t *testing.T, s llm.Stream) []llm.Event {
+	t.Helper()
+	var events []llm.Event
+	for {
+		ev, err := s.Next(context.Background())
+		if errors.Is(err, io.EOF) {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+		events = append(events, ev)
+	}
+}
+
+func TestStream_TextDeltas(t *testing.T) {
+	p := newProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		sse(w, [][2]string{
+			{"message_start", `{"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":12,"output_tokens":1}}}`},
+			{"content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`},
+			{"ping", `{"type":"ping"}`},
+			{"content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`},
+			{"content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" world"}}`},
+			{"content_block_stop", `{"type":"content_block_stop","index":0}`},
+			{"message_delta", `{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":4}}`},
+			{"message_stop", `{"type":"message_stop"}`},
+		})
+	})
+	s, err := p.Stream(context.Background(), llm.Request{Model: "claude-test", Messages: []llm.Message{{Role: llm.RoleUser, Content: "hi"}}})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	defer s.Close()
+	events := collect(t, s)
+
+	var text strings.Builder
+	var usage *llm.Usage
+	for _, ev := range events {
+		switch ev.Type {
+		case llm.EventTextDelta:
+			text.WriteString(ev.Text)
+		case llm.EventUsage:
+			u := ev.Usage
+			usage = &u
+		case llm.EventToolCallEnd:
+			t.Errorf("text block stop must not end a tool call: %+v", ev)
+		}
+	}
+	if text.String() != "Hello world" {
+		t.Errorf("text = %q", text.String())
+	}
+	if usage == nil || usage.InputTokens != 12 || usage.OutputTokens != 4 {
+		t.Errorf("usage = %+v", usage)
+	}
+}
+
+func TestStream_ToolCallAssembly(t *testing.T) {
+	p := newProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		sse(w, [][2]string{
+			{"message_start", `{"type":"message_start","message":{"usage":{"input_tokens":3}}}`},
+			{"content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`},
+			{"content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Let me check."}}`},
+			{"content_block_stop", `{"type":"content_block_stop","index":0}`},
+			{"content_block_start", `{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"echo","input":{}}}`},
+			{"content_block_delta", `{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"v"}}`},
+			{"content_block_delta", `{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"alue\":1}"}}`},
+			{"content_block_stop", `{"type":"content_block_stop","index":1}`},
+			{"message_delta", `{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":9}}`},
+			{"message_stop", `{"type":"message_stop"}`},
+		})
+	})
+	s, err := p.Stream(context.Background(), llm.Request{Model: "claude-test"})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	defer s.Close()
+	events := collect(t, s)
+
+	var got []llm.EventType
+	var args strings.Builder
+	for _, ev := range events {
+		got = append(got, ev.Type)
+		switch ev.Type {
+		case llm.EventToolCallStart:
+			if ev.ToolCallID != "toolu_1" || ev.ToolName != "echo" || ev.ToolIndex != 1 {
+				t.Errorf("start = %+v", ev)
+			}
+		case llm.EventToolCallDelta:
+			args.WriteString(ev.ArgumentsDelta)
+		}
+	}
+	want := []llm.EventType{
+		llm.EventTextDelta, llm.EventToolCallStart, llm.EventToolCallDelta,
+		llm.EventToolCallDelta, llm.EventToolCallEnd, llm.EventUsage,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("events[%d] = %s, want %s", i, got[i], want[i])
+		}
+	}
+	if args.String() != `{"value":1}` {
+		t.Errorf("arguments = %q", args.String())
+	}
+}
+
+func TestStream_HTTPError(t *testing.T) {
+	p := newProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(529)
+		io.WriteString(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+	})
+	_, err := p.Stream(context.Background(), llm.Request{Model: "claude-test"})
+	var perr *llm.ProviderError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected ProviderError, got %T", err)
+	}
+	if perr.Code != "http_529" || !strings.Contains(err.Error(), "Overloaded") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestStream_ErrorEvent(t *testing.T) {
+	p := newProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		sse(w, [][2]string{
+			{"error", `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`},
+		})
+	})
+	s, err := p.Stream(context.Background(), llm.Request{Model: "claude-test"})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	defer s.Close()
+	_, err = s.Next(context.Background())
+	var perr *llm.ProviderError
+	if !errors.As(err, &perr) || perr.Code != "overloaded_error" {
+		t.Fatalf("expected overloaded_error, got %v", err)
+	}
+}
+
+func TestStream_MalformedLine(t *testing.T) {
+	p := newProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "this is not sse\n\n")
+	})
+	s, err := p.Stream(context.Background(), llm.Request{Model: "claude-test"})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	defer s.Close()
+	_, err = s.Next(context.Background())
+	var perr *llm.ProviderError
+	if !errors.As(err, &perr) || perr.Code != "malformed_stream" {
+		t.Errorf("expected malformed_stream, got %v", err)
+	}
+}
+
+func TestStream_RequestShape(t *testing.T) {
+	p := newProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/messages" {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		if r.Header.Get("X-Api-Key") != "test-key" || r.Header.Get("Anthropic-Version") == "" {
+			t.Errorf("headers = %v", r.Header)
+		}
+		var body struct {
+			Model     string `json:"model"`
+			MaxTokens int    `json:"max_tokens"`
+			Stream    bool   `json:"stream"`
+			System    string `json:"system"`
+			Messages  []struct {
+				Role    string           `json:"role"`
+				Content []map[string]any `json:"content"`
+			} `json:"messages"`
+			Tools []map[string]any `json:"tools"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		if body.Model != "claude-test" || !body.Stream || body.MaxTokens != anthropic.DefaultMaxTokens {
+			t.Errorf("body = %+v", body)
+		}
+		if body.System != "sys" {
+			t.Errorf("system = %q", body.System)
+		}
+		// user, assistant(tool_use x2), user(tool_result x2)
+		if len(body.Messages) != 3 {
+			t.Fatalf("messages = %+v", body.Messages)
+		}
+		if body.Messages[1].Content[0]["type"] != "tool_use" || len(body.Messages[1].Content) != 2 {
+			t.Errorf("assistant = %+v", body.Messages[1])
+		}
+		if body.Messages[2].Role != "user" || len(body.Messages[2].Content) != 2 || body.Messages[2].Content[1]["tool_use_id"] != "t2" {
+			t.Errorf("tool results = %+v", body.Messages[2])
+		}
+		if len(body.Tools) != 1 || body.Tools[0]["input_schema"] == nil {
+			t.Errorf("tools = %+v", body.Tools)
+		}
+		sse(w, [][2]string{{"message_stop", `{"type":"message_stop"}`}})
+	})
+	s, err := p.Stream(context.Background(), llm.Request{
+		Model:  "claude-test",
+		System: "sys",
+		Messages: []llm.Message{
+			{Role: llm.RoleUser, Content: "hi"},
+			{Role: llm.RoleAssistant, ToolCalls: []llm.ToolCall{
+				{ID: "t1", Name: "echo", Arguments: json.RawMessage(`{"value":1}`)},
+				{ID: "t2", Name: "echo"},
+			}},
+			{Role: llm.RoleTool, ToolCallID: "t1", Content: "1"},
+			{Role: llm.RoleTool, ToolCallID: "t2", Content: "2"},
+		},
+		Tools: []llm.ToolDef{{Name: "echo", Description: "echoes"}},
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	defer s.Close()
+	collect(t, s)
+}
+
+func TestStream_Generation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Team") != "docs" {
+			t.Errorf("X-Team = %q", r.Header.Get("X-Team"))
+		}
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		if body["temperature"] != 0.5 || body["max_tokens"] != float64(64) {
+			t.Errorf("body = %v", body)
+		}
+		if stop, _ := body["stop_sequences"].([]any); len(stop) != 1 || stop[0] != "END" {
+			t.Errorf("stop_sequences = %v", body["stop_sequences"])
+		}
+		sse(w, [][2]string{{"message_stop", `{"type":"message_stop"}`}})
+	}))
+	t.Cleanup(srv.Close)
+	p, err := anthropic.New(anthropic.Options{
+		APIKey:  "test-key",
+		BaseURL: srv.URL,
+		Headers: map[string]string{"X-Team": "docs"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	temp := 0.5
+	s, err := p.Stream(context.Background(), llm.Request{
+		Model:      "claude-test",
+		Messages:   []llm.Message{{Role: llm.RoleUser, Content: "hi"}},
+		Generation: llm.Generation{Temperature: &temp, MaxOutputTokens: 64, Stop: []string{"END"}},
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	defer s.Close()
+	collect(t, s)
+}
+
+func TestNew_MissingAPIKey(t *testing.T) {
+	if _, err := anthropic.New(anthropic.Options{}); err == nil {
+		t.Fatal("expected error for missing API key")
+	}
+}
@@ -0,0 +1,83 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/chtushar/pingu/internal/config"
+	"github.com/chtushar/pingu/internal/llm"
+)
+
+// Route is one model of a fallback chain: the provider built for it and the
+// generation parameters resolved for its provider.
+type Route struct {
+	Model      config.ModelRef
+	Provider   llm.Provider
+	Generation llm.Generation
+}
+
+// WithFallback returns a provider that streams from the first route and
+// moves to the next one when a call fails with a ProviderError whose code is
+// in codes. As with WithRetry, Stream reads the first event itself, so a
+// fallback only happens before anything has streamed. Each route replaces
+// the request's Model and Generation with its own, except that the response
+// format and schema stay the request's. The returned streams
+// implement llm.ServedModel, and every fallback is reported with llm.Warn.
+// It panics without routes.
+func WithFallback(routes []Route, codes []string) llm.Provider {
+	if len(routes) == 0 {
+		panic("provider: WithFallback without routes")
+	}
+	return &fallback{routes: routes, codes: codes}
+}
+
+type fallback struct {
+	routes []Route
+	codes  []string
+}
+
+func (f *fallback) Stream(ctx context.Context, req llm.Request) (llm.Stream, error) {
+	var err error
+	for i, rt := range f.routes {
+		if i > 0 {
+			llm.Warn(ctx, fmt.Sprintf("model %s failed, falling back to %s: %v", f.routes[i-1].Model, rt.Model, err))
+		}
+		last := i == len(f.routes)-1
+		r := req
+		r.Model, r.Generation = rt.Model.Model, rt.Generation
+		r.Generation.ResponseFormat, r.Generation.Schema = req.Generation.ResponseFormat, req.Generation.Schema
+		var s llm.Stream
+		s, err = rt.Provider.Stream(ctx, r)
+		if err != nil {
+			if last || !f.fallsBack(ctx, err) {
+				return nil, err
+			}
+			continue
+		}
+		ps := &peekedStream{Stream: s, peeked: true}
+		ps.ev, ps.err = s.Next(ctx)
+		if ps.err == nil || errors.Is(ps.err, io.EOF) || last || !f.fallsBack(ctx, ps.err) {
+			return &servedStream{Stream: ps, model: rt.Model.String()}, nil
+		}
+		s.Close()
+		err = ps.err
+	}
+	return nil, err // not reached: the last route always returns
+}
+
+// fallsBack reports whether err is one of the configured codes.
+func (f *fallback) fallsBack(ctx context.Context, err error) bool {
+	var pe *llm.ProviderError
+	return ctx.Err() == nil && errors.As(err, &pe) && slices.Contains(f.codes, pe.Code)
+}
+
+type servedStream struct {
+	llm.Stream
+	model string
+}
+
+// Model implements llm.ServedModel.
+func (s *servedStream) Model() string { return s.model }
@@ -0,0 +1,96 @@
+package provider_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/chtushar/pingu/internal/config"
+	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/provider"
+)
+
+// recordingProvider streams events and records the last request.
+type recordingProvider struct {
+	err    error
+	events []llm.Event
+	req    llm.Request
+	calls  int
+}
+
+func (p *recordingProvider) Stream(_ context.Context, req llm.Request) (llm.Stream, error) {
+	p.calls++
+	p.req = req
+	if p.err != nil {
+		return nil, p.err
+	}
+	return llm.NewSliceStream(p.events), nil
+}
+
+func TestWithFallback(t *testing.T) {
+	primary := &recordingProvider{err: overloaded()}
+	backup := &recordingProvider{events: []llm.Event{{Type: llm.EventTextDelta, Text: "hi"}}}
+	temp := 0.3
+	p := provider.WithFallback([]provider.Route{
+		{Model: config.ModelRef{Provider: "openai", Model: "gpt-4o"}, Provider: primary},
+		{Model: config.ModelRef{Provider: "anthropic", Model: "claude"}, Provider: backup, Generation: llm.Generation{Temperature: &temp, ResponseFormat: llm.ResponseJSON}},
+	}, []string{"http_503"})
+
+	var warnings []string
+	ctx := llm.WithWarnings(context.Background(), func(msg string) { warnings = append(warnings, msg) })
+	s, err := p.Stream(ctx, llm.Request{Model: "gpt-4o"})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if ev, err := s.Next(ctx); err != nil || ev.Text != "hi" {
+		t.Errorf("first event = %+v, %v", ev, err)
+	}
+	if served, ok := s.(llm.ServedModel); !ok || served.Model() != "anthropic/claude" {
+		t.Errorf("served model = %v", s)
+	}
+	// The route's sampling parameters apply; the response format is the
+	// request's.
+	if backup.req.Model != "claude" || backup.req.Generation.Temperature != &temp || backup.req.Generation.ResponseFormat != "" {
+		t.Errorf("backup request = %+v", backup.req)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "falling back to anthropic/claude") {
+		t.Errorf("warnings = %q", warnings)
+	}
+}
+
+func TestWithFallback_Stops(t *testing.T) {
+	routes := func(first llm.Provider, second *recordingProvider) []provider.Route {
+		return []provider.Route{
+			{Model: config.ModelRef{Provider: "a", Model: "1"}, Provider: first},
+			{Model: config.ModelRef{Provider: "b", Model: "2"}, Provider: second},
+		}
+	}
+
+	// A code that is not configured is returned as it is.
+	backup := &recordingProvider{}
+	bad := &recordingProvider{err: llm.NewProviderError("test", "http_400", errors.New("bad request"))}
+	if _, err := provider.WithFallback(routes(bad, backup), []string{"http_503"}).Stream(context.Background(), llm.Request{}); err == nil || backup.calls != 0 {
+		t.Errorf("unconfigured code: err = %v, backup calls = %d", err, backup.calls)
+	}
+
+	// Once an event has streamed, a failure stays with the first model.
+	partial := providerFunc(func() (llm.Stream, error) {
+		return &failingStream{events: []llm.Event{{Type: llm.EventTextDelta, Text: "par"}}, err: overloaded()}, nil
+	})
+	s, err := provider.WithFallback(routes(partial, backup), []string{"http_503"}).Stream(context.Background(), llm.Request{})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	s.Next(context.Background())
+	if _, err := s.Next(context.Background()); err == nil || backup.calls != 0 {
+		t.Errorf("after first event: err = %v, backup calls = %d", err, backup.calls)
+	}
+
+	// The last model's error is returned.
+	backup.err = llm.NewProviderError("test", "http_503", errors.New("also down"))
+	_, err = provider.WithFallback(routes(&recordingProvider{err: overloaded()}, backup), []string{"http_503"}).Stream(context.Background(), llm.Request{})
+	if err == nil || !strings.Contains(err.Error(), "also down") {
+		t.Errorf("err = %v", err)
+	}
+}
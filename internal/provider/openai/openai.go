@@ -14,8 +14,10 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/provider"
 )
 
 const (
@@ -30,6 +32,7 @@ const (
 type Options struct {
 	APIKey     string
 	BaseURL    string
+	Headers    map[string]string // extra request headers, set last
 	HTTPClient *http.Client
 }
 
@@ -77,6 +80,9 @@ func (p *Provider) Stream(ctx context.Context, req llm.Request) (llm.Stream, err
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("Accept", "text/event-stream")
 	httpReq.Header.Set("Authorization", "Bearer "+p.opts.APIKey)
+	for k, v := range p.opts.Headers {
+		httpReq.Header.Set(k, v)
+	}
 
 	resp, err := p.client.Do(httpReq)
 	if err != nil {
@@ -88,7 +94,9 @@ func (p *Provider) Stream(ctx context.Context, req llm.Request) (llm.Stream, err
 		if msg == "" {
 			msg = http.StatusText(resp.StatusCode)
 		}
-		return nil, llm.NewProviderError(providerName, "http_"+strconv.Itoa(resp.StatusCode), errors.New(msg))
+		perr := llm.NewProviderError(providerName, "http_"+strconv.Itoa(resp.StatusCode), errors.New(msg))
+		perr.RetryAfter = provider.RetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return nil, perr
 	}
 
 	s := &stream{resp: resp}
@@ -134,11 +142,27 @@ type wireToolDefFunc struct {
 }
 
 type wireRequest struct {
-	Model         string        `json:"model"`
-	Stream        bool          `json:"stream"`
-	StreamOptions *wireStreamOp `json:"stream_options,omitempty"`
-	Messages      []wireMessage `json:"messages"`
-	Tools         []wireToolDef `json:"tools,omitempty"`
+	Model          string              `json:"model"`
+	Temperature    *float64            `json:"temperature,omitempty"`
+	TopP           *float64            `json:"top_p,omitempty"`
+	MaxTokens      int                 `json:"max_tokens,omitempty"`
+	Stop           []string            `json:"stop,omitempty"`
+	Seed           *int64              `json:"seed,omitempty"`
+	ResponseFormat *wireResponseFormat `json:"response_format,omitempty"`
+	Stream         bool                `json:"stream"`
+	StreamOptions  *wireStreamOp       `json:"stream_options,omitempty"`
+	Messages       []wireMessage       `json:"messages"`
+	Tools          []wireToolDef       `json:"tools,omitempty"`
+}
+
+type wireResponseFormat struct {
+	Type       string          `json:"type"`
+	JSONSchema *wireJSONSchema `json:"json_schema,omitempty"`
+}
+
+type wireJSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
 }
 
 type wireStreamOp struct {
@@ -148,10 +172,23 @@ type wireStreamOp struct {
 func (p *Provider) buildBody(req llm.Request) ([]byte, error) {
 	w := wireRequest{
 		Model:         req.Model,
+		Temperature:   req.Generation.Temperature,
+		TopP:          req.Generation.TopP,
+		MaxTokens:     req.Generation.MaxOutputTokens,
+		Stop:          req.Generation.Stop,
+		Seed:          req.Generation.Seed,
 		Stream:        true,
 		StreamOptions: &wireStreamOp{IncludeUsage: true},
 		Messages:      make([]wireMessage, 0, len(req.Messages)+1),
 	}
+	switch req.Generation.ResponseFormat {
+	case llm.ResponseJSON:
+		w.ResponseFormat = &wireResponseFormat{Type: "json_object"}
+	case llm.ResponseJSONSchema:
+		// Not strict: strict mode restricts which schemas are accepted, and
+		// the runner validates the answer either way.
+		w.ResponseFormat = &wireResponseFormat{Type: "json_schema", JSONSchema: &wireJSONSchema{Name: "output", Schema: req.Generation.Schema}}
+	}
 	if req.System != "" {
 		w.Messages = append(w.Messages, wireMessage{Role: "system", Content: req.System})
 	}
@@ -329,3 +366,26 @@ func (s *stream) Close() error {
 	s.closed = true
 	return s.resp.Body.Close()
 }
+
+func init() {
+	provider.Register(provider.Adapter{
+		Name: providerName,
+		Env:  []string{"OPENAI_API_KEY"},
+		New: func(opts provider.Options) (llm.Provider, error) {
+			baseURL := opts.BaseURL
+			if baseURL == "" {
+				baseURL = os.Getenv("OPENAI_BASE_URL")
+			}
+			return New(Options{
+				APIKey:     os.Getenv("OPENAI_API_KEY"),
+				BaseURL:    baseURL,
+				Headers:    opts.Headers,
+				HTTPClient: opts.HTTPClient,
+			})
+		},
+		Generation: []string{
+			llm.GenTemperature, llm.GenTopP, llm.GenMaxOutputTokens,
+			llm.GenStop, llm.GenSeed, llm.GenResponseFormat,
+		},
+	})
+}
@@ -9,8 +9,11 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/chtushar/pingu/internal/config"
 	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/provider"
 	"github.com/chtushar/pingu/internal/provider/openai"
 )
 
@@ -148,6 +151,21 @@ func TestStream_HTTPError(t *testing.T) {
 	}
 }
 
+func TestStream_RetryAfter(t *testing.T) {
+	p := newProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	_, err := p.Stream(context.Background(), llm.Request{Model: "gpt-test"})
+	var perr *llm.ProviderError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected ProviderError, got %v", err)
+	}
+	if perr.Code != "http_429" || perr.RetryAfter != 7*time.Second {
+		t.Errorf("code = %s, retry after = %v", perr.Code, perr.RetryAfter)
+	}
+}
+
 func TestStream_MalformedLine(t *testing.T) {
 	p := newProvider(t, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/event-stream")
@@ -218,6 +236,57 @@ func TestStream_RequestShape(t *testing.T) {
 	collect(t, s)
 }
 
+func TestRegistry_OptionsAndGeneration(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Team") != "docs" {
+			t.Errorf("X-Team = %q", r.Header.Get("X-Team"))
+		}
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		if body["temperature"] != 0.5 || body["top_p"] != 0.9 || body["max_tokens"] != float64(64) || body["seed"] != float64(7) {
+			t.Errorf("body = %v", body)
+		}
+		if stop, _ := body["stop"].([]any); len(stop) != 1 || stop[0] != "END" {
+			t.Errorf("stop = %v", body["stop"])
+		}
+		if rf, _ := body["response_format"].(map[string]any); rf["type"] != "json_object" {
+			t.Errorf("response_format = %v", body["response_format"])
+		}
+		sse(w, []string{`{"choices":[{"index":0,"delta":{"content":"ok"}}]}`})
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_BASE_URL", "http://127.0.0.1:1") // agent.toml wins
+
+	p, err := provider.New(config.ModelRef{Provider: "openai", Model: "gpt-test"}, provider.Options{
+		BaseURL: srv.URL,
+		Headers: map[string]string{"X-Team": "docs"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	temp, topP, seed := 0.5, 0.9, int64(7)
+	s, err := p.Stream(context.Background(), llm.Request{
+		Model:    "gpt-test",
+		Messages: []llm.Message{{Role: llm.RoleUser, Content: "hi"}},
+		Generation: llm.Generation{
+			Temperature:     &temp,
+			TopP:            &topP,
+			MaxOutputTokens: 64,
+			Stop:            []string{"END"},
+			Seed:            &seed,
+			ResponseFormat:  llm.ResponseJSON,
+		},
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	defer s.Close()
+	collect(t, s)
+}
+
 func TestNew_MissingAPIKey(t *testing.T) {
 	if _, err := openai.New(openai.Options{}); err == nil {
 		t.Fatal("expected error for missing API key")
@@ -0,0 +1,150 @@
+// Package provider is the registry of model provider adapters. Each adapter
+// registers itself from init under the provider prefix of a model reference
+// (the "openai" in "openai/gpt-4o-mini"); the CLI builds providers only
+// through this registry, so adding an adapter needs no CLI changes beyond
+// importing its package.
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chtushar/pingu/internal/config"
+	"github.com/chtushar/pingu/internal/llm"
+)
+
+// Options carries caller-controlled settings shared by every adapter. Zero
+// fields mean the adapter's defaults.
+type Options struct {
+	HTTPClient *http.Client      // nil means a default client
+	BaseURL    string            // wins over the adapter's base URL variable
+	Headers    map[string]string // extra headers on every request
+}
+
+// OptionsFrom returns the options agent.toml sets for the provider of ref.
+// Its temperature and max_tokens travel on each request instead; see
+// config.Config.GenerationFor.
+func OptionsFrom(cfg config.Config, ref config.ModelRef) Options {
+	pc := cfg.Providers[ref.Provider]
+	return Options{BaseURL: pc.BaseURL, Headers: pc.Headers}
+}
+
+// CheckConfig reports every [provider.<name>] table in cfg that names no
+// registered provider.
+func CheckConfig(cfg config.Config) error {
+	names := make([]string, 0, len(cfg.Providers))
+	for name := range cfg.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		if _, ok := Lookup(name); !ok {
+			errs = append(errs, &config.ConfigError{File: "agent.toml", Field: "provider." + name, Err: fmt.Errorf("unknown provider %q (registered: %s)", name, strings.Join(Names(), ", "))})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Factory builds a provider from its environment and opts.
+type Factory func(opts Options) (llm.Provider, error)
+
+// Adapter describes one registered provider.
+type Adapter struct {
+	Name string   // model reference prefix
+	Env  []string // required credential environment variables
+	New  Factory
+	// Generation lists the llm.Generation parameters the adapter sends
+	// (llm.GenTemperature, ...). Setting any other one is a ConfigError.
+	Generation []string
+}
+
+var (
+	mu       sync.RWMutex
+	adapters = map[string]Adapter{}
+)
+
+// Register adds an adapter. It panics on an empty name, a nil factory, or a
+// duplicate name; registration happens from init, so these are programming
+// errors.
+func Register(a Adapter) {
+	if a.Name == "" || a.New == nil {
+		panic("provider: Register with empty name or nil factory")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := adapters[a.Name]; dup {
+		panic(fmt.Sprintf("provider: Register called twice for %q", a.Name))
+	}
+	adapters[a.Name] = a
+}
+
+// Lookup returns the adapter registered under name.
+func Lookup(name string) (Adapter, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	a, ok := adapters[name]
+	return a, ok
+}
+
+// Names returns the registered provider names, sorted.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	out := make([]string, 0, len(adapters))
+	for n := range adapters {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Check reports whether ref names a registered provider. The error is a
+// ConfigError listing the registered names.
+func Check(ref config.ModelRef) error {
+	if _, ok := Lookup(ref.Provider); !ok {
+		return &config.ConfigError{Field: "model", Err: fmt.Errorf("unknown provider %q (registered: %s)", ref.Provider, strings.Join(Names(), ", "))}
+	}
+	return nil
+}
+
+// CheckGeneration reports, joined, every parameter set in g that the
+// provider of ref does not support. Unknown providers are left to Check.
+func CheckGeneration(ref config.ModelRef, g llm.Generation) error {
+	a, ok := Lookup(ref.Provider)
+	if !ok {
+		return nil
+	}
+	var errs []error
+	for _, name := range g.Fields() {
+		if !slices.Contains(a.Generation, name) {
+			errs = append(errs, &config.ConfigError{Field: name, Err: fmt.Errorf("not supported by provider %s", ref.Provider)})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// New builds the provider for ref. Unknown providers and missing
+// credentials are ConfigErrors.
+func New(ref config.ModelRef, opts Options) (llm.Provider, error) {
+	if err := Check(ref); err != nil {
+		return nil, err
+	}
+	a, _ := Lookup(ref.Provider)
+	for _, env := range a.Env {
+		if os.Getenv(env) == "" {
+			return nil, &config.ConfigError{Field: env, Err: errors.New("not set")}
+		}
+	}
+	p, err := a.New(opts)
+	if err != nil {
+		return nil, &config.ConfigError{Field: "model", Err: fmt.Errorf("provider %s: %w", ref.Provider, err)}
+	}
+	return p, nil
+}
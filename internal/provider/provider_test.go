@@ -0,0 +1,91 @@
+package provider_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/chtushar/pingu/internal/config"
+	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/provider"
+)
+
+type stubProvider struct{}
+
+func (stubProvider) Stream(context.Context, llm.Request) (llm.Stream, error) {
+	return llm.NewSliceStream(nil), nil
+}
+
+func init() {
+	provider.Register(provider.Adapter{
+		Name: "stub",
+		Env:  []string{"STUB_API_KEY"},
+		New:  func(provider.Options) (llm.Provider, error) { return stubProvider{}, nil },
+
+		Generation: []string{llm.GenTemperature},
+	})
+}
+
+func TestNew(t *testing.T) {
+	t.Setenv("STUB_API_KEY", "k")
+	p, err := provider.New(config.ModelRef{Provider: "stub", Model: "m"}, provider.Options{})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if _, ok := p.(stubProvider); !ok {
+		t.Errorf("provider = %T", p)
+	}
+}
+
+func TestNew_UnknownProviderListsNames(t *testing.T) {
+	_, err := provider.New(config.ModelRef{Provider: "nope", Model: "m"}, provider.Options{})
+	var cfgErr *config.ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("expected ConfigError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `"nope"`) || !strings.Contains(err.Error(), "stub") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestNew_MissingCredential(t *testing.T) {
+	t.Setenv("STUB_API_KEY", "")
+	_, err := provider.New(config.ModelRef{Provider: "stub", Model: "m"}, provider.Options{})
+	var cfgErr *config.ConfigError
+	if !errors.As(err, &cfgErr) || cfgErr.Field != "STUB_API_KEY" {
+		t.Fatalf("expected ConfigError for STUB_API_KEY, got %v", err)
+	}
+}
+
+func TestCheckGeneration(t *testing.T) {
+	ref := config.ModelRef{Provider: "stub", Model: "m"}
+	temp, seed := 0.3, int64(1)
+	if err := provider.CheckGeneration(ref, llm.Generation{Temperature: &temp, ResponseFormat: llm.ResponseText}); err != nil {
+		t.Errorf("supported parameters: %v", err)
+	}
+	err := provider.CheckGeneration(ref, llm.Generation{Seed: &seed, ResponseFormat: llm.ResponseJSON})
+	var cfgErr *config.ConfigError
+	if !errors.As(err, &cfgErr) || cfgErr.Field != "seed" || !strings.Contains(err.Error(), "response_format: not supported by provider stub") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestNames(t *testing.T) {
+	if !slices.Contains(provider.Names(), "stub") {
+		t.Errorf("names = %v", provider.Names())
+	}
+}
+
+func TestRegisterDuplicatePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	provider.Register(provider.Adapter{
+		Name: "stub",
+		New:  func(provider.Options) (llm.Provider, error) { return stubProvider{}, nil },
+	})
+}
@@ -0,0 +1,172 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chtushar/pingu/internal/llm"
+)
+
+// RetryPolicy controls how WithRetry retries transient provider errors.
+type RetryPolicy struct {
+	MaxAttempts int           // attempts per model call, the first included
+	BaseDelay   time.Duration // backoff before the first retry; doubles after
+	MaxDelay    time.Duration // cap on one backoff; Retry-After may exceed it
+}
+
+// DefaultRetryPolicy is used for zero RetryPolicy fields.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// WithRetry wraps p so that a model call failing with a Retryable error is
+// tried again, up to policy.MaxAttempts times. Waits back off exponentially
+// with jitter unless the error carries a RetryAfter, which is honored. A
+// retry happens only before the stream has delivered an event: Stream reads
+// the first event itself, and failures after it are returned as they are.
+// No retry is attempted when the wait would outlast the context deadline.
+// Each retry is reported through llm.Warn.
+func WithRetry(p llm.Provider, policy RetryPolicy) llm.Provider {
+	d := DefaultRetryPolicy
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = d.MaxAttempts
+	}
+	if policy.BaseDelay == 0 {
+		policy.BaseDelay = d.BaseDelay
+	}
+	if policy.MaxDelay == 0 {
+		policy.MaxDelay = d.MaxDelay
+	}
+	return &retrying{p: p, policy: policy}
+}
+
+type retrying struct {
+	p      llm.Provider
+	policy RetryPolicy
+}
+
+func (r *retrying) Stream(ctx context.Context, req llm.Request) (llm.Stream, error) {
+	for attempt := 1; ; attempt++ {
+		s, err := r.p.Stream(ctx, req)
+		if err == nil {
+			ps := &peekedStream{Stream: s, peeked: true}
+			ps.ev, ps.err = s.Next(ctx)
+			if ps.err == nil || errors.Is(ps.err, io.EOF) {
+				return ps, nil
+			}
+			wait, ok := r.backoff(ctx, attempt, ps.err)
+			if !ok {
+				return ps, nil
+			}
+			s.Close()
+			if !r.sleep(ctx, wait, attempt, ps.err) {
+				return nil, ps.err
+			}
+			continue
+		}
+		wait, ok := r.backoff(ctx, attempt, err)
+		if !ok || !r.sleep(ctx, wait, attempt, err) {
+			return nil, err
+		}
+	}
+}
+
+// backoff returns how long to wait before retrying after the given attempt
+// failed with err, and whether to retry at all.
+func (r *retrying) backoff(ctx context.Context, attempt int, err error) (time.Duration, bool) {
+	if attempt >= r.policy.MaxAttempts || ctx.Err() != nil || !Retryable(err) {
+		return 0, false
+	}
+	var wait time.Duration
+	var pe *llm.ProviderError
+	if errors.As(err, &pe) && pe.RetryAfter > 0 {
+		wait = pe.RetryAfter
+	} else {
+		d := r.policy.BaseDelay << (attempt - 1)
+		if d <= 0 || d > r.policy.MaxDelay {
+			d = r.policy.MaxDelay
+		}
+		wait = d/2 + rand.N(d/2+1) // jitter within [d/2, d]
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+		return 0, false
+	}
+	return wait, true
+}
+
+// sleep reports the retry and waits; it returns false if ctx ends first.
+func (r *retrying) sleep(ctx context.Context, wait time.Duration, attempt int, err error) bool {
+	llm.Warn(ctx, fmt.Sprintf("model call failed, retrying in %s (attempt %d/%d): %v", wait.Round(time.Millisecond), attempt+1, r.policy.MaxAttempts, err))
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// peekedStream replays the event Stream read ahead to decide on a retry.
+type peekedStream struct {
+	llm.Stream
+	peeked bool
+	ev     llm.Event
+	err    error
+}
+
+func (s *peekedStream) Next(ctx context.Context) (llm.Event, error) {
+	if s.peeked {
+		s.peeked = false
+		return s.ev, s.err
+	}
+	return s.Stream.Next(ctx)
+}
+
+// Retryable reports whether err is a transient provider failure: rate
+// limiting, overload, a server error, or a transport failure. Context
+// cancellation and deadlines are not retryable.
+func Retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pe *llm.ProviderError
+	if !errors.As(err, &pe) {
+		return false
+	}
+	switch pe.Code {
+	case "http_408", "http_429", "http_500", "http_502", "http_503", "http_504", "http_529",
+		"overloaded_error", "request_failed", "stream_failed":
+		return true
+	}
+	return false
+}
+
+// RetryAfter parses a Retry-After header value, either delay-seconds or an
+// HTTP date, relative to now. It returns zero for a missing or unusable
+// value.
+func RetryAfter(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
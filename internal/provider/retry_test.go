@@ -0,0 +1,179 @@
+package provider_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/provider"
+)
+
+// flakyProvider fails the first len(errs) calls with errs, in order, then
+// streams events. A failure with failNext set comes from the first Next
+// instead of Stream.
+type flakyProvider struct {
+	errs     []error
+	failNext bool
+	events   []llm.Event
+	calls    int
+}
+
+func (f *flakyProvider) Stream(context.Context, llm.Request) (llm.Stream, error) {
+	f.calls++
+	if f.calls > len(f.errs) {
+		return llm.NewSliceStream(f.events), nil
+	}
+	err := f.errs[f.calls-1]
+	if f.failNext {
+		return &failingStream{err: err}, nil
+	}
+	return nil, err
+}
+
+// failingStream yields events, then fails with err.
+type failingStream struct {
+	events []llm.Event
+	err    error
+}
+
+func (s *failingStream) Next(context.Context) (llm.Event, error) {
+	if len(s.events) > 0 {
+		ev := s.events[0]
+		s.events = s.events[1:]
+		return ev, nil
+	}
+	return llm.Event{}, s.err
+}
+
+func (s *failingStream) Close() error { return nil }
+
+func overloaded() error {
+	return llm.NewProviderError("test", "http_503", errors.New("overloaded"))
+}
+
+var fastRetry = provider.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func drain(t *testing.T, s llm.Stream) []llm.Event {
+	t.Helper()
+	var out []llm.Event
+	for {
+		ev, err := s.Next(context.Background())
+		if errors.Is(err, io.EOF) {
+			return out
+		}
+		if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+		out = append(out, ev)
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	text := []llm.Event{{Type: llm.EventTextDelta, Text: "hi"}}
+	for _, failNext := range []bool{false, true} {
+		f := &flakyProvider{errs: []error{overloaded(), overloaded()}, failNext: failNext, events: text}
+		var warnings []string
+		ctx := llm.WithWarnings(context.Background(), func(msg string) { warnings = append(warnings, msg) })
+		s, err := provider.WithRetry(f, fastRetry).Stream(ctx, llm.Request{})
+		if err != nil {
+			t.Fatalf("failNext=%v: stream: %v", failNext, err)
+		}
+		if got := drain(t, s); len(got) != 1 || got[0].Text != "hi" {
+			t.Errorf("failNext=%v: events = %+v", failNext, got)
+		}
+		if f.calls != 3 {
+			t.Errorf("failNext=%v: calls = %d, want 3", failNext, f.calls)
+		}
+		if len(warnings) != 2 || !strings.Contains(warnings[1], "attempt 3/3") || !strings.Contains(warnings[1], "http_503") {
+			t.Errorf("failNext=%v: warnings = %q", failNext, warnings)
+		}
+	}
+}
+
+func TestWithRetry_GivesUp(t *testing.T) {
+	f := &flakyProvider{errs: []error{overloaded(), overloaded(), overloaded()}}
+	_, err := provider.WithRetry(f, fastRetry).Stream(context.Background(), llm.Request{})
+	var perr *llm.ProviderError
+	if !errors.As(err, &perr) || perr.Code != "http_503" {
+		t.Fatalf("err = %v", err)
+	}
+	if f.calls != 3 {
+		t.Errorf("calls = %d, want 3", f.calls)
+	}
+
+	f = &flakyProvider{errs: []error{llm.NewProviderError("test", "http_400", errors.New("bad request"))}}
+	if _, err := provider.WithRetry(f, fastRetry).Stream(context.Background(), llm.Request{}); err == nil || f.calls != 1 {
+		t.Errorf("non-retryable: err = %v, calls = %d", err, f.calls)
+	}
+}
+
+func TestWithRetry_RetryAfter(t *testing.T) {
+	limited := llm.NewProviderError("test", "http_429", errors.New("slow down"))
+	limited.RetryAfter = time.Millisecond
+	f := &flakyProvider{errs: []error{limited}}
+	// The backoff alone would outlast the deadline; Retry-After does not.
+	policy := provider.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := provider.WithRetry(f, policy).Stream(ctx, llm.Request{}); err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+
+	f = &flakyProvider{errs: []error{overloaded()}}
+	start := time.Now()
+	if _, err := provider.WithRetry(f, policy).Stream(ctx, llm.Request{}); err == nil {
+		t.Fatal("expected the error when the wait outlasts the deadline")
+	}
+	if f.calls != 1 || time.Since(start) > time.Second {
+		t.Errorf("calls = %d after %v", f.calls, time.Since(start))
+	}
+}
+
+func TestWithRetry_NotAfterFirstEvent(t *testing.T) {
+	calls := 0
+	counted := providerFunc(func() (llm.Stream, error) {
+		calls++
+		return &failingStream{events: []llm.Event{{Type: llm.EventTextDelta, Text: "par"}}, err: overloaded()}, nil
+	})
+	s, err := provider.WithRetry(counted, fastRetry).Stream(context.Background(), llm.Request{})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if ev, err := s.Next(context.Background()); err != nil || ev.Text != "par" {
+		t.Fatalf("first event = %+v, %v", ev, err)
+	}
+	if _, err := s.Next(context.Background()); err == nil {
+		t.Fatal("expected the stream error")
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
+
+type providerFunc func() (llm.Stream, error)
+
+func (f providerFunc) Stream(context.Context, llm.Request) (llm.Stream, error) { return f() }
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"0", 0},
+		{"-1", 0},
+		{"soon", 0},
+		{"Sat, 17 Oct 2026 12:00:30 GMT", 30 * time.Second},
+		{"Sat, 17 Oct 2026 11:00:00 GMT", 0},
+	} {
+		if got := provider.RetryAfter(tc.in, now); got != tc.want {
+			t.Errorf("RetryAfter(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package repl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/chtushar/pingu/internal/config"
+	"github.com/chtushar/pingu/internal/session"
+)
+
+// Default returns a registry with the built-in commands.
+func Default() *Registry {
+	r, err := NewRegistry(builtins()...)
+	if err != nil {
+		panic(err) // the built-ins are fixed
+	}
+	if err := r.Add(helpCommand(r)); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func builtins() []*Command {
+	return []*Command{
+		{Name: "exit", Summary: "end the session (Ctrl-D works too)", Run: exit},
+		{Name: "undo", Summary: "drop the last exchange", Run: undo},
+		{Name: "retry", Summary: "send the last input again, replacing its exchange", Run: retry},
+		{Name: "edit", Args: "[TEXT]", Summary: "replace the last exchange with one for TEXT, or for the last input edited in $EDITOR", Run: edit},
+		{Name: "compose", Summary: "write a new message in $EDITOR and send it", Run: compose},
+		{Name: "branch", Args: "NAME", Summary: "fork the session into a new session NAME and continue there", Run: branch},
+		{Name: "switch", Args: "NAME", Summary: "continue in the stored session with that ID or name", Run: switchSession, Complete: completeSessions},
+		{Name: "clear", Summary: "start a new, empty session; the current one stays stored", Run: clearSession},
+		{Name: "save", Args: "[FILE]", Summary: "write the transcript to FILE (.jsonl or markdown; default: the session label with .md)", Run: save},
+		{Name: "model", Args: "[MODEL]", Summary: "show the model, or switch to provider/model-id", Run: model, Complete: completeModels},
+		{Name: "tools", Summary: "list the agent's tools", Run: listTools},
+		{Name: "limits", Summary: "show the effective run limits", Run: limits},
+		{Name: "usage", Summary: "show the tokens and cost of this session so far", Run: usage},
+		{Name: "system", Summary: "show the system prompt", Run: system},
+	}
+}
+
+func helpCommand(r *Registry) *Command {
+	return &Command{
+		Name:    "help",
+		Args:    "[COMMAND]",
+		Summary: "list the commands, or describe one",
+		Run: func(_ context.Context, s *State, arg string) (string, error) {
+			cmds := r.List()
+			if arg != "" {
+				c, ok := r.Get(strings.TrimPrefix(arg, "/"))
+				if !ok {
+					return "", fmt.Errorf("unknown command %q", arg)
+				}
+				cmds = []*Command{c}
+			}
+			tw := tabwriter.NewWriter(s.Out, 0, 0, 2, ' ', 0)
+			for _, c := range cmds {
+				fmt.Fprintf(tw, "/%s\t%s\n", strings.TrimSpace(c.Name+" "+c.Args), c.Summary)
+			}
+			return "", tw.Flush()
+		},
+		Complete: func(_ context.Context, _ *State, arg string) []string {
+			return withPrefix(r.names, strings.TrimPrefix(arg, "/"))
+		},
+	}
+}
+
+func exit(context.Context, *State, string) (string, error) { return "", ErrExit }
+
+func undo(ctx context.Context, s *State, _ string) (string, error) {
+	input, err := s.Conv.Undo(ctx)
+	if err != nil {
+		return "", noExchange(err)
+	}
+	fmt.Fprintf(s.Out, "dropped: %s\n", session.Title(input))
+	return "", nil
+}
+
+// retry and edit rewind the conversation: the stored exchange is replaced
+// only once the new one succeeds.
+func retry(ctx context.Context, s *State, _ string) (string, error) {
+	input, err := s.Conv.Rewind(ctx)
+	if err != nil {
+		return "", noExchange(err)
+	}
+	return input, nil
+}
+
+// edit replaces the last exchange with TEXT, or without TEXT with the last
+// input as edited in the editor. Saving an empty text leaves the session
+// as it was.
+func edit(ctx context.Context, s *State, arg string) (string, error) {
+	if arg == "" {
+		last, err := s.Conv.LastInput(ctx)
+		if err != nil {
+			return "", noExchange(err)
+		}
+		if s.Editor == nil {
+			return "", fmt.Errorf("usage: /edit TEXT; the last input was:\n%s", last)
+		}
+		if arg, err = editText(s, last); arg == "" || err != nil {
+			return "", err
+		}
+	}
+	if _, err := s.Conv.Rewind(ctx); err != nil {
+		return "", noExchange(err)
+	}
+	return arg, nil
+}
+
+// compose writes a new message in the editor, starting from an empty text.
+func compose(_ context.Context, s *State, _ string) (string, error) {
+	if s.Editor == nil {
+		return "", errors.New("no editor: input is not a terminal")
+	}
+	return editText(s, "")
+}
+
+// editText opens text in s.Editor and returns the result, or "" with a
+// note when it was saved empty.
+func editText(s *State, text string) (string, error) {
+	text, err := s.Editor(text)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(text) == "" {
+		fmt.Fprintln(s.Out, "empty text; nothing sent")
+		return "", nil
+	}
+	return text, nil
+}
+
+// noExchange words session.ErrEmpty for the terminal.
+func noExchange(err error) error {
+	if errors.Is(err, session.ErrEmpty) {
+		return errors.New("no exchange yet")
+	}
+	return err
+}
+
+func branch(ctx context.Context, s *State, arg string) (string, error) {
+	if arg == "" {
+		return "", errors.New("usage: /branch NAME")
+	}
+	from := s.Conv.Label()
+	if err := s.Conv.Branch(ctx, arg); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(s.Out, "on branch %s, forked from %s\n", arg, from)
+	return "", nil
+}
+
+func switchSession(ctx context.Context, s *State, arg string) (string, error) {
+	if arg == "" {
+		return "", errors.New("usage: /switch NAME")
+	}
+	if err := s.Conv.Switch(ctx, arg); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(s.Out, "switched to %s (%d messages)\n", s.Conv.Label(), len(s.Conv.Messages()))
+	return "", nil
+}
+
+// completeSessions offers stored session names, and IDs of unnamed
+// sessions.
+func completeSessions(ctx context.Context, s *State, arg string) []string {
+	list, err := s.Conv.Store.List(ctx)
+	if err != nil {
+		return nil
+	}
+	var refs []string
+	for _, sum := range list {
+		if sum.Name != "" {
+			refs = append(refs, sum.Name)
+		} else {
+			refs = append(refs, sum.ID)
+		}
+	}
+	return withPrefix(refs, arg)
+}
+
+func clearSession(ctx context.Context, s *State, _ string) (string, error) {
+	from := s.Conv.Label()
+	if err := s.Conv.Clear(ctx); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(s.Out, "new session %s; %s is kept\n", s.Conv.Label(), from)
+	return "", nil
+}
+
+func save(ctx context.Context, s *State, arg string) (string, error) {
+	sess, err := s.Conv.Store.Get(ctx, s.Conv.Session.ID)
+	if err != nil {
+		return "", err
+	}
+	entries, err := s.Conv.Store.Transcript(ctx, sess.ID)
+	if err != nil {
+		return "", err
+	}
+	path := arg
+	if path == "" {
+		path = strings.ReplaceAll(s.Conv.Label(), string(filepath.Separator), "_") + ".md"
+	}
+	format := session.FormatMarkdown
+	if filepath.Ext(path) == ".jsonl" {
+		format = session.FormatJSONL
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := session.Export(f, sess, entries, format); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(s.Out, "saved %d messages to %s\n", len(entries), path)
+	return "", nil
+}
+
+func model(_ context.Context, s *State, arg string) (string, error) {
+	if arg == "" {
+		fmt.Fprintln(s.Out, s.Model)
+		return "", nil
+	}
+	ref, err := config.ParseModelRef(arg)
+	if err != nil {
+		return "", err
+	}
+	if s.SetModel == nil {
+		return "", errors.New("switching models is not supported here")
+	}
+	if err := s.SetModel(ref); err != nil {
+		return "", err
+	}
+	s.Model = ref
+	s.Conv.Model = ref.String()
+	fmt.Fprintf(s.Out, "model: %s\n", ref)
+	return "", nil
+}
+
+func completeModels(_ context.Context, s *State, arg string) []string {
+	return withPrefix(s.Models, arg)
+}
+
+func listTools(_ context.Context, s *State, _ string) (string, error) {
+	if s.Tools == nil || s.Tools.Empty() {
+		fmt.Fprintln(s.Out, "no tools")
+		return "", nil
+	}
+	tw := tabwriter.NewWriter(s.Out, 0, 0, 2, ' ', 0)
+	for _, t := range s.Tools.List() {
+		desc, _, _ := strings.Cut(t.Description(), "\n")
+		fmt.Fprintf(tw, "%s\t%s\n", t.Name(), desc)
+	}
+	return "", tw.Flush()
+}
+
+func limits(_ context.Context, s *State, _ string) (string, error) {
+	l := s.Runner.Limits.WithDefaults()
+	cost := "none"
+	if l.MaxCostUSD > 0 {
+		cost = fmt.Sprintf("$%.2f", l.MaxCostUSD)
+	}
+	tw := tabwriter.NewWriter(s.Out, 0, 0, 2, ' ', 0)
+	for _, row := range [][2]string{
+		{"max model turns", fmt.Sprint(l.MaxModelTurns)},
+		{"max tool calls", fmt.Sprint(l.MaxToolCalls)},
+		{"run timeout", l.RunTimeout.String()},
+		{"tool timeout", l.ToolTimeout.String()},
+		{"max tool output bytes", fmt.Sprint(l.MaxToolOutputBytes)},
+		{"max parallel tools", fmt.Sprint(l.MaxParallelTools)},
+		{"max model attempts", fmt.Sprint(l.MaxModelAttempts)},
+		{"max cost", cost},
+	} {
+		fmt.Fprintf(tw, "%s\t%s\n", row[0], row[1])
+	}
+	return "", tw.Flush()
+}
+
+func usage(_ context.Context, s *State, _ string) (string, error) {
+	line := fmt.Sprintf("%d exchanges: %d input + %d output tokens", s.Runs, s.Usage.InputTokens, s.Usage.OutputTokens)
+	if s.Runner.Pricing != nil {
+		line += fmt.Sprintf(", $%.4f", s.CostUSD)
+	}
+	fmt.Fprintln(s.Out, line)
+	return "", nil
+}
+
+func system(_ context.Context, s *State, _ string) (string, error) {
+	fmt.Fprintln(s.Out, strings.TrimRight(s.Instructions, "\n"))
+	return "", nil
+}
@@ -0,0 +1,53 @@
+package repl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// EditText opens initial in the user's editor — $VISUAL, else $EDITOR,
+// else vi — on the terminal, and returns the saved text with trailing
+// newlines removed. The editor setting may carry arguments, as in
+// "code --wait".
+func EditText(initial string) (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	args := strings.Fields(editor)
+
+	f, err := os.CreateTemp("", "pingu-*.md")
+	if err != nil {
+		return "", fmt.Errorf("editor: %w", err)
+	}
+	path := f.Name()
+	defer os.Remove(path)
+	_, err = f.WriteString(initial)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("editor: %w", err)
+	}
+
+	cmd := exec.Command(args[0], append(args[1:], path)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return "", fmt.Errorf("editor %s exited with status %d", args[0], exit.ExitCode())
+		}
+		return "", fmt.Errorf("editor: %w", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("editor: %w", err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
@@ -0,0 +1,87 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// HistoryFile is the input history file inside the state directory.
+const HistoryFile = "history"
+
+// DefaultHistorySize bounds the lines a History keeps.
+const DefaultHistorySize = 1000
+
+// History is the input history of interactive sessions, kept in a file so
+// it carries over to the next session. It implements term.History; entries
+// are single lines, so a multi-line input is recorded line by line.
+type History struct {
+	path  string
+	max   int
+	lines []string // oldest first
+}
+
+// LoadHistory reads the history file at path, keeping its last max lines.
+// A missing file is an empty history. A file that has grown past max lines
+// is rewritten with the lines kept.
+func LoadHistory(path string, max int) (*History, error) {
+	h := &History{path: path, max: max}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	sc := bufio.NewScanner(strings.NewReader(string(b)))
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		if line := sc.Text(); line != "" {
+			h.lines = append(h.lines, line)
+		}
+	}
+	if len(h.lines) > max {
+		h.lines = h.lines[len(h.lines)-max:]
+		data := strings.Join(h.lines, "\n") + "\n"
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			return nil, fmt.Errorf("write history: %w", err)
+		}
+	}
+	return h, nil
+}
+
+// Add records a line, in memory and in the file. Empty lines and repeats
+// of the latest line are skipped. A failed write is logged; the line stays
+// in memory.
+func (h *History) Add(line string) {
+	if strings.TrimSpace(line) == "" || strings.ContainsAny(line, "\r\n") {
+		return
+	}
+	if n := len(h.lines); n > 0 && h.lines[n-1] == line {
+		return
+	}
+	h.lines = append(h.lines, line)
+	if len(h.lines) > h.max {
+		h.lines = h.lines[len(h.lines)-h.max:]
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err == nil {
+		_, err = f.WriteString(line + "\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		slog.Debug("history write failed", "path", h.path, "error", err)
+	}
+}
+
+// Len returns the number of lines kept.
+func (h *History) Len() int { return len(h.lines) }
+
+// At returns a line; 0 is the most recent.
+func (h *History) At(idx int) string { return h.lines[len(h.lines)-1-idx] }
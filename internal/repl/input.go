@@ -0,0 +1,111 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Prompts shown by ReadInput.
+const (
+	Prompt         = "> "
+	ContinuePrompt = ". " // inside a multi-line entry
+)
+
+// blockQuote opens and closes a multi-line block.
+const blockQuote = `"""`
+
+// LineReader reads one line of input after showing prompt. It returns
+// io.EOF at the end of input.
+type LineReader interface {
+	ReadLine(prompt string) (string, error)
+}
+
+// ReadInput reads one input from lr, which may span lines: a line ending in
+// a backslash continues on the next line, and a line starting with """
+// opens a block that runs up to a line ending with """. The markers are
+// removed and the lines joined with newlines. Input cut short by the end of
+// input is returned as it is; io.EOF is returned only when nothing was read.
+func ReadInput(lr LineReader) (string, error) {
+	line, err := lr.ReadLine(Prompt)
+	if err != nil {
+		return "", err
+	}
+	if rest, ok := strings.CutPrefix(strings.TrimLeft(line, " \t"), blockQuote); ok {
+		return readBlock(lr, rest)
+	}
+	var lines []string
+	for {
+		text, more := strings.CutSuffix(line, `\`)
+		lines = append(lines, text)
+		if !more {
+			break
+		}
+		if line, err = lr.ReadLine(ContinuePrompt); err != nil {
+			return joinRead(lines, err)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// readBlock reads the rest of a """ block whose first line is first.
+func readBlock(lr LineReader, first string) (string, error) {
+	if text, ok := strings.CutSuffix(strings.TrimRight(first, " \t"), blockQuote); ok {
+		return text, nil
+	}
+	var lines []string
+	if first != "" {
+		lines = append(lines, first)
+	}
+	for {
+		line, err := lr.ReadLine(ContinuePrompt)
+		if err != nil {
+			return joinRead(lines, err)
+		}
+		if text, ok := strings.CutSuffix(strings.TrimRight(line, " \t"), blockQuote); ok {
+			if text != "" {
+				lines = append(lines, text)
+			}
+			return strings.Join(lines, "\n"), nil
+		}
+		lines = append(lines, line)
+	}
+}
+
+// joinRead ends a multi-line input that a read error cut short: at the end
+// of input the lines read so far are the input.
+func joinRead(lines []string, err error) (string, error) {
+	if errors.Is(err, io.EOF) {
+		if text := strings.Join(lines, "\n"); strings.TrimSpace(text) != "" {
+			return text, nil
+		}
+	}
+	return "", err
+}
+
+// scanner is the LineReader for input that is not a terminal.
+type scanner struct {
+	s   *bufio.Scanner
+	out io.Writer
+}
+
+// NewScanner returns a LineReader over plain input, such as a pipe. It
+// writes prompts to out and accepts lines of up to 1 MiB.
+func NewScanner(in io.Reader, out io.Writer) LineReader {
+	s := bufio.NewScanner(in)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return &scanner{s: s, out: out}
+}
+
+func (s *scanner) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(s.out, prompt)
+	if !s.s.Scan() {
+		if err := s.s.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return s.s.Text(), nil
+}
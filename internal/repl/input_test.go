@@ -0,0 +1,109 @@
+package repl_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chtushar/pingu/internal/repl"
+)
+
+func TestReadInput(t *testing.T) {
+	for _, tc := range []struct {
+		name, in string
+		want     []string
+	}{
+		{"lines", "one\ntwo\n", []string{"one", "two"}},
+		{"backslash", "one \\\ntwo\\\nthree\nfour\n", []string{"one \ntwo\nthree", "four"}},
+		{"block", "\"\"\"\n  indented\n\nlast\"\"\"\nnext\n", []string{"  indented\n\nlast", "next"}},
+		{"block with text", "\"\"\"first\nsecond\n\"\"\"\n", []string{"first\nsecond"}},
+		{"one-line block", "\"\"\"say \"hi\"\"\"\"\n", []string{`say "hi"`}},
+		{"cut short", "one\\\ntwo\\\n", []string{"one\ntwo"}},
+		{"open block", "\"\"\"\nfirst\n", []string{"first"}},
+		{"empty continuation", "\\\n", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out strings.Builder
+			lr := repl.NewScanner(strings.NewReader(tc.in), &out)
+			var got []string
+			for {
+				input, err := repl.ReadInput(lr)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, input)
+			}
+			if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+				t.Errorf("inputs = %q, want %q", got, tc.want)
+			}
+			if !strings.HasPrefix(out.String(), repl.Prompt) {
+				t.Errorf("prompts = %q", out.String())
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), repl.HistoryFile)
+	h, err := repl.LoadHistory(path, 3)
+	if err != nil || h.Len() != 0 {
+		t.Fatalf("new history: %d lines, %v", h.Len(), err)
+	}
+	for _, line := range []string{"one", "two", "two", " ", "three", "four"} {
+		h.Add(line)
+	}
+	if h.Len() != 3 || h.At(0) != "four" || h.At(2) != "two" {
+		t.Errorf("history = %d lines, newest %q, oldest %q", h.Len(), h.At(0), h.At(h.Len()-1))
+	}
+
+	h, err = repl.LoadHistory(path, 3)
+	if err != nil || h.Len() != 3 || h.At(0) != "four" {
+		t.Fatalf("reloaded: %d lines, %v", h.Len(), err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "two\nthree\nfour\n" {
+		t.Errorf("file = %q, want it trimmed to the kept lines", b)
+	}
+}
+
+func TestTabCompleter(t *testing.T) {
+	complete := repl.TabCompleter(func(line string) []string {
+		switch line {
+		case "/s":
+			return []string{"/save", "/switch", "/system"}
+		case "/sw":
+			return []string{"/switch"}
+		case "/help /mo":
+			return []string{"/help model"}
+		case "/model a":
+			return []string{"/model anthropic/x", "/model anthropic/y"}
+		}
+		return nil
+	})
+	for _, tc := range []struct {
+		line    string
+		pos     int
+		key     rune
+		want    string
+		wantPos int
+		ok      bool
+	}{
+		{"/s", 2, '\t', "", 0, false},
+		{"/sw", 3, '\t', "/switch ", 8, true},
+		{"/swX", 3, '\t', "/switch X", 8, true},
+		{"/help /mo", 9, '\t', "/help model ", 12, true},
+		{"/model a", 8, '\t', "/model anthropic/", 17, true},
+		{"/sw", 3, 'x', "", 0, false},
+		{"hello", 5, '\t', "", 0, false},
+	} {
+		got, pos, ok := complete(tc.line, tc.pos, tc.key)
+		if got != tc.want || pos != tc.wantPos || ok != tc.ok {
+			t.Errorf("%q at %d: %q, %d, %v; want %q, %d, %v", tc.line, tc.pos, got, pos, ok, tc.want, tc.wantPos, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Package repl implements the slash commands of the interactive session: a
+// registry of commands, dispatch of input lines, and tab completion.
+// Commands act on a State and write to its Out, so they run and test
+// without a terminal.
+package repl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/chtushar/pingu/internal/config"
+	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/runner"
+	"github.com/chtushar/pingu/internal/session"
+	"github.com/chtushar/pingu/internal/tools"
+)
+
+// ErrExit is returned by Dispatch when a command ends the session.
+var ErrExit = errors.New("exit")
+
+// State is what commands act on. The terminal loop owns it and reads Conv
+// and Model before every exchange, since commands may replace them.
+type State struct {
+	Out          io.Writer // command output
+	Conv         *session.Conversation
+	Runner       *runner.Runner
+	Tools        *tools.Registry // may be nil
+	Instructions string
+	Model        config.ModelRef
+	// SetModel switches the session to another model. With a nil SetModel,
+	// /model only shows the current one.
+	SetModel func(config.ModelRef) error
+	// Models lists model references offered by /model completion.
+	Models []string
+	// Editor opens text in the user's editor and returns the result, as
+	// EditText does. With a nil Editor, /edit needs its TEXT argument.
+	Editor func(initial string) (string, error)
+
+	Runs    int       // exchanges completed in this process
+	Usage   llm.Usage // their token usage
+	CostUSD float64   // their cost
+}
+
+// Record adds a completed exchange to the session totals.
+func (s *State) Record(result runner.RunResult) {
+	s.Runs++
+	s.Usage.InputTokens += result.Usage.InputTokens
+	s.Usage.OutputTokens += result.Usage.OutputTokens
+	s.CostUSD += result.CostUSD
+}
+
+// Command is one slash command.
+type Command struct {
+	Name    string // without the leading slash
+	Args    string // argument synopsis for /help, such as "NAME" or "[FILE]"
+	Summary string // one line for /help
+	// Run executes the command with its argument text, trimmed. It returns
+	// the input to send to the model next, or "" to send nothing.
+	Run func(ctx context.Context, s *State, arg string) (string, error)
+	// Complete returns the arguments that complete a partial one. It may be
+	// nil.
+	Complete func(ctx context.Context, s *State, arg string) []string
+}
+
+// Registry holds commands keyed by name with deterministic ordering.
+type Registry struct {
+	byName map[string]*Command
+	names  []string
+}
+
+// NewRegistry builds a registry from cmds; duplicate names are an error.
+func NewRegistry(cmds ...*Command) (*Registry, error) {
+	r := &Registry{byName: make(map[string]*Command, len(cmds))}
+	for _, c := range cmds {
+		if err := r.Add(c); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Add registers one command.
+func (r *Registry) Add(c *Command) error {
+	if c.Name == "" || strings.ContainsAny(c.Name, " /") {
+		return fmt.Errorf("invalid command name %q", c.Name)
+	}
+	if c.Run == nil {
+		return fmt.Errorf("command %q has no Run", c.Name)
+	}
+	if _, ok := r.byName[c.Name]; ok {
+		return fmt.Errorf("duplicate command name %q", c.Name)
+	}
+	r.byName[c.Name] = c
+	r.names = append(r.names, c.Name)
+	sort.Strings(r.names)
+	return nil
+}
+
+// Get looks a command up by name, without the slash.
+func (r *Registry) Get(name string) (*Command, bool) {
+	c, ok := r.byName[name]
+	return c, ok
+}
+
+// List returns the commands sorted by name.
+func (r *Registry) List() []*Command {
+	out := make([]*Command, 0, len(r.names))
+	for _, n := range r.names {
+		out = append(out, r.byName[n])
+	}
+	return out
+}
+
+// IsCommand reports whether an input line is a slash command rather than a
+// message: its first word is a slash and a name, such as /help, or a
+// mistyped one. A first word with another slash or a dot, such as
+// /etc/hosts, starts a message, and so does "//", which Message turns into
+// a literal slash.
+func IsCommand(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	name, ok := strings.CutPrefix(fields[0], "/")
+	return ok && name != "" && !strings.ContainsAny(name, "/.")
+}
+
+// Message returns the message to send for an input line that is not a
+// command, reducing a leading "//" to "/".
+func Message(line string) string {
+	if strings.HasPrefix(line, "//") {
+		return line[1:]
+	}
+	return line
+}
+
+// Dispatch runs the command line names, as "/name argument". It returns
+// the input to send next, if any, and ErrExit when the session should end.
+// Other errors are prefixed with the command.
+func (r *Registry) Dispatch(ctx context.Context, s *State, line string) (string, error) {
+	name, arg, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(line), "/"), " ")
+	c, ok := r.Get(name)
+	if !ok {
+		return "", fmt.Errorf("/%s: unknown command; /help lists them", name)
+	}
+	input, err := c.Run(ctx, s, strings.TrimSpace(arg))
+	if err != nil && !errors.Is(err, ErrExit) {
+		return "", fmt.Errorf("/%s: %w", name, err)
+	}
+	return input, err
+}
+
+// Complete returns the lines that complete line: command names while the
+// name is being typed, then the command's argument completions. Each
+// candidate is a whole line, sorted.
+func (r *Registry) Complete(ctx context.Context, s *State, line string) []string {
+	if !strings.HasPrefix(line, "/") {
+		return nil
+	}
+	name, arg, hasArg := strings.Cut(line[1:], " ")
+	var out []string
+	if !hasArg {
+		for _, n := range r.names {
+			if strings.HasPrefix(n, name) {
+				out = append(out, "/"+n)
+			}
+		}
+		return out
+	}
+	c, ok := r.Get(name)
+	if !ok || c.Complete == nil {
+		return nil
+	}
+	for _, a := range c.Complete(ctx, s, strings.TrimLeft(arg, " ")) {
+		out = append(out, "/"+name+" "+a)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// withPrefix returns the candidates that start with prefix.
+func withPrefix(candidates []string, prefix string) []string {
+	var out []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,316 @@
+package repl_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/chtushar/pingu/internal/config"
+	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/repl"
+	"github.com/chtushar/pingu/internal/runner"
+	"github.com/chtushar/pingu/internal/session"
+	"github.com/chtushar/pingu/internal/tools"
+)
+
+type replyProvider struct{}
+
+func (replyProvider) Stream(context.Context, llm.Request) (llm.Stream, error) {
+	return llm.NewSliceStream([]llm.Event{
+		{Type: llm.EventTextDelta, Text: "ok"},
+		{Type: llm.EventUsage, Usage: llm.Usage{InputTokens: 3, OutputTokens: 2}},
+	}), nil
+}
+
+type echoTool struct{}
+
+func (echoTool) Name() string                { return "echo" }
+func (echoTool) Description() string         { return "Echo the input.\nMore detail." }
+func (echoTool) Parameters() json.RawMessage { return json.RawMessage(`{"type":"object"}`) }
+func (echoTool) Run(_ context.Context, args json.RawMessage) (string, error) {
+	return string(args), nil
+}
+
+// newState returns a State over a fresh session named "main", and the
+// buffer its commands write to.
+func newState(t *testing.T) (*repl.State, *strings.Builder) {
+	t.Helper()
+	ctx := context.Background()
+	store, err := session.Open(ctx, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	conv, err := session.OpenConversation(ctx, store, "main", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, _ := tools.NewRegistry(echoTool{})
+	out := &strings.Builder{}
+	return &repl.State{
+		Out:          out,
+		Conv:         conv,
+		Runner:       &runner.Runner{Provider: replyProvider{}, Limits: config.Limits{MaxCostUSD: 0.5}},
+		Tools:        reg,
+		Instructions: "Be brief.\n",
+		Model:        config.ModelRef{Provider: "openai", Model: "gpt-4o-mini"},
+		Models:       []string{"openai/gpt-4o", "openai/gpt-4o-mini", "anthropic/claude-haiku-4-5"},
+	}, out
+}
+
+// exchange runs input in s's conversation and records it.
+func exchange(t *testing.T, s *repl.State, input string) {
+	t.Helper()
+	res, err := s.Conv.Exchange(context.Background(), s.Runner, runner.RunRequest{RunID: "run-" + input, Input: input}, func(runner.Event) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Record(res)
+}
+
+func TestNewRegistry(t *testing.T) {
+	run := func(context.Context, *repl.State, string) (string, error) { return "", nil }
+	for _, cmds := range [][]*repl.Command{
+		{{Name: "a", Run: run}, {Name: "a", Run: run}},
+		{{Name: "", Run: run}},
+		{{Name: "a b", Run: run}},
+		{{Name: "a"}},
+	} {
+		if _, err := repl.NewRegistry(cmds...); err == nil {
+			t.Errorf("%+v: expected an error", cmds)
+		}
+	}
+	r, err := repl.NewRegistry(&repl.Command{Name: "b", Run: run}, &repl.Command{Name: "a", Run: run})
+	if err != nil || r.List()[0].Name != "a" {
+		t.Fatalf("registry = %v, %v", r, err)
+	}
+}
+
+func TestIsCommand(t *testing.T) {
+	tests := []struct {
+		line    string
+		command bool
+		message string // sent when not a command
+	}{
+		{"/help", true, ""},
+		{"/model openai/gpt-4o", true, ""},
+		{"/hlep", true, ""}, // reported as unknown, not sent
+		{"/etc/hosts looks wrong", false, "/etc/hosts looks wrong"},
+		{"/tmp/a.txt is empty", false, "/tmp/a.txt is empty"},
+		{"/.bashrc", false, "/.bashrc"},
+		{"//help is a command?", false, "/help is a command?"},
+		{"/", false, "/"},
+		{"hello /help", false, "hello /help"},
+	}
+	for _, tt := range tests {
+		if got := repl.IsCommand(tt.line); got != tt.command {
+			t.Errorf("IsCommand(%q) = %v", tt.line, got)
+		}
+		if !tt.command {
+			if got := repl.Message(tt.line); got != tt.message {
+				t.Errorf("Message(%q) = %q, want %q", tt.line, got, tt.message)
+			}
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	r := repl.Default()
+	s, out := newState(t)
+	ctx := context.Background()
+
+	if _, err := r.Dispatch(ctx, s, "/exit"); !errors.Is(err, repl.ErrExit) {
+		t.Errorf("/exit: %v", err)
+	}
+	if _, err := r.Dispatch(ctx, s, "/nope"); err == nil || !strings.Contains(err.Error(), "/nope: unknown command") {
+		t.Errorf("/nope: %v", err)
+	}
+	if _, err := r.Dispatch(ctx, s, "/undo"); err == nil || err.Error() != "/undo: no exchange yet" {
+		t.Errorf("/undo: %v", err)
+	}
+
+	if _, err := r.Dispatch(ctx, s, "/help"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range r.List() {
+		if !strings.Contains(out.String(), "/"+c.Name) {
+			t.Errorf("/help misses /%s:\n%s", c.Name, out)
+		}
+	}
+	out.Reset()
+	r.Dispatch(ctx, s, "/help /model")
+	if got := out.String(); !strings.HasPrefix(got, "/model [MODEL]") || strings.Count(got, "\n") != 1 {
+		t.Errorf("/help /model = %q", got)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	r := repl.Default()
+	s, out := newState(t)
+	ctx := context.Background()
+	dispatch := func(line string) string {
+		t.Helper()
+		out.Reset()
+		input, err := r.Dispatch(ctx, s, line)
+		if err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		return input
+	}
+
+	dispatch("/tools")
+	if got := out.String(); !strings.Contains(got, "echo  Echo the input.") || strings.Contains(got, "More detail") {
+		t.Errorf("/tools = %q", got)
+	}
+	dispatch("/limits")
+	if got := out.String(); !strings.Contains(got, "max model turns") || !strings.Contains(got, "$0.50") {
+		t.Errorf("/limits = %q", got)
+	}
+	dispatch("/system")
+	if out.String() != "Be brief.\n" {
+		t.Errorf("/system = %q", out.String())
+	}
+
+	exchange(t, s, "one")
+	exchange(t, s, "two")
+	dispatch("/usage")
+	if got := out.String(); got != "2 exchanges: 6 input + 4 output tokens\n" {
+		t.Errorf("/usage = %q", got)
+	}
+	if input := dispatch("/retry"); input != "two" || len(s.Conv.Messages()) != 2 {
+		t.Errorf("/retry = %q with %d messages", input, len(s.Conv.Messages()))
+	}
+	exchange(t, s, "two")
+	if input := dispatch("/edit three"); input != "three" || len(s.Conv.Messages()) != 2 {
+		t.Errorf("/edit = %q with %d messages", input, len(s.Conv.Messages()))
+	}
+	exchange(t, s, "three")
+
+	path := filepath.Join(t.TempDir(), "main.jsonl")
+	dispatch("/save " + path)
+	if b, err := os.ReadFile(path); err != nil || strings.Count(string(b), "\n") != 4 || !strings.Contains(string(b), `"three"`) || strings.Contains(string(b), `"two"`) {
+		t.Errorf("saved %q, %v", b, err)
+	}
+	dispatch("/clear")
+	if s.Conv.Label() == "main" || len(s.Conv.Messages()) != 0 {
+		t.Errorf("/clear left %s with %d messages", s.Conv.Label(), len(s.Conv.Messages()))
+	}
+	dispatch("/switch main")
+	if s.Conv.Label() != "main" || len(s.Conv.Messages()) != 4 {
+		t.Errorf("/switch: %s with %d messages", s.Conv.Label(), len(s.Conv.Messages()))
+	}
+}
+
+func TestModelCommand(t *testing.T) {
+	r := repl.Default()
+	s, out := newState(t)
+	ctx := context.Background()
+
+	r.Dispatch(ctx, s, "/model")
+	if out.String() != "openai/gpt-4o-mini\n" {
+		t.Errorf("/model = %q", out.String())
+	}
+	if _, err := r.Dispatch(ctx, s, "/model openai/gpt-4o"); err == nil {
+		t.Error("expected an error without SetModel")
+	}
+
+	var set []config.ModelRef
+	s.SetModel = func(ref config.ModelRef) error {
+		if ref.Provider != "openai" {
+			return errors.New("no credentials")
+		}
+		set = append(set, ref)
+		return nil
+	}
+	if _, err := r.Dispatch(ctx, s, "/model anthropic/claude-haiku-4-5"); err == nil || s.Model.Model != "gpt-4o-mini" {
+		t.Errorf("failed switch: %v, model = %s", err, s.Model)
+	}
+	if _, err := r.Dispatch(ctx, s, "/model gpt-4o"); err == nil {
+		t.Error("expected an error for a reference without provider")
+	}
+	if _, err := r.Dispatch(ctx, s, "/model openai/gpt-4o"); err != nil {
+		t.Fatal(err)
+	}
+	if len(set) != 1 || s.Model.Model != "gpt-4o" || s.Conv.Model != "openai/gpt-4o" {
+		t.Errorf("set = %v, model = %s, conversation model = %s", set, s.Model, s.Conv.Model)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	r := repl.Default()
+	s, _ := newState(t)
+	ctx := context.Background()
+	s.Conv.Branch(ctx, "work")
+	s.Conv.Branch(ctx, "west")
+
+	for _, tc := range []struct {
+		line string
+		want []string
+	}{
+		{"hello", nil},
+		{"/he", []string{"/help"}},
+		{"/s", []string{"/save", "/switch", "/system"}},
+		{"/", nil}, // checked below: every command
+		{"/switch w", []string{"/switch west", "/switch work"}},
+		{"/model openai/gpt-4o", []string{"/model openai/gpt-4o", "/model openai/gpt-4o-mini"}},
+		{"/help /mo", []string{"/help model"}},
+		{"/tools x", nil},
+		{"/nope ", nil},
+	} {
+		got := r.Complete(ctx, s, tc.line)
+		if tc.line == "/" {
+			if len(got) != len(r.List()) {
+				t.Errorf("%q: %d candidates, want %d", tc.line, len(got), len(r.List()))
+			}
+			continue
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("Complete(%q) = %q, want %q", tc.line, got, tc.want)
+		}
+	}
+}
+
+func TestEditAndCompose(t *testing.T) {
+	r := repl.Default()
+	s, out := newState(t)
+	ctx := context.Background()
+
+	if _, err := r.Dispatch(ctx, s, "/compose"); err == nil {
+		t.Error("/compose without an editor: expected an error")
+	}
+	var opened []string
+	reply := "composed"
+	s.Editor = func(initial string) (string, error) {
+		opened = append(opened, initial)
+		return reply, nil
+	}
+	if _, err := r.Dispatch(ctx, s, "/edit"); err == nil || err.Error() != "/edit: no exchange yet" {
+		t.Errorf("/edit with no exchange: %v", err)
+	}
+	if input, err := r.Dispatch(ctx, s, "/compose"); err != nil || input != "composed" {
+		t.Errorf("/compose = %q, %v", input, err)
+	}
+
+	exchange(t, s, "one")
+	if input, err := r.Dispatch(ctx, s, "/compose"); err != nil || input != "composed" || len(s.Conv.Messages()) != 2 {
+		t.Errorf("/compose after an exchange = %q, %v with %d messages", input, err, len(s.Conv.Messages()))
+	}
+	reply = "one, edited"
+	if input, err := r.Dispatch(ctx, s, "/edit"); err != nil || input != "one, edited" || len(s.Conv.Messages()) != 0 {
+		t.Errorf("/edit = %q, %v with %d messages", input, err, len(s.Conv.Messages()))
+	}
+	exchange(t, s, "one, edited")
+	reply = "\n"
+	out.Reset()
+	if input, err := r.Dispatch(ctx, s, "/edit"); err != nil || input != "" || len(s.Conv.Messages()) != 2 || out.String() == "" {
+		t.Errorf("empty /edit = %q, %v with %d messages", input, err, len(s.Conv.Messages()))
+	}
+	if strings.Join(opened, "|") != "||one|one, edited" {
+		t.Errorf("editor opened %q", opened)
+	}
+}
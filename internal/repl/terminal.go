@@ -0,0 +1,108 @@
+package repl
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Terminal is a LineReader with line editing: the arrow keys move within
+// the line and through the history, Tab completes, and Ctrl-C clears the
+// line. The terminal is in raw mode only while a line is read, so output
+// and signals behave normally in between.
+type Terminal struct {
+	in  *os.File
+	out *os.File
+	t   *term.Terminal
+}
+
+// IsTerminal reports whether f is a terminal.
+func IsTerminal(f *os.File) bool { return term.IsTerminal(int(f.Fd())) }
+
+// NewTerminal returns a Terminal reading from in and echoing to out, both
+// terminals. history may be nil for an in-memory history of recent lines.
+// complete, which may be nil, returns the whole lines that complete the
+// text before the cursor; see TabCompleter.
+func NewTerminal(in, out *os.File, history *History, complete func(line string) []string) *Terminal {
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{ctrlCReader{in}, out}, Prompt)
+	if history != nil {
+		t.History = history
+	}
+	if complete != nil {
+		t.AutoCompleteCallback = TabCompleter(complete)
+	}
+	return &Terminal{in: in, out: out, t: t}
+}
+
+// ReadLine implements LineReader.
+func (t *Terminal) ReadLine(prompt string) (string, error) {
+	state, err := term.MakeRaw(int(t.in.Fd()))
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(int(t.in.Fd()), state)
+	if w, h, err := term.GetSize(int(t.out.Fd())); err == nil && w > 0 {
+		t.t.SetSize(w, h)
+	}
+	t.t.SetPrompt(prompt)
+	line, err := t.t.ReadLine()
+	if err == term.ErrPasteIndicator {
+		err = nil // a pasted line is a line like any other
+	}
+	return line, err
+}
+
+// ctrlCReader turns Ctrl-C into Ctrl-A Ctrl-K, which clears the line; the
+// terminal would otherwise end the input.
+type ctrlCReader struct{ r io.Reader }
+
+func (c ctrlCReader) Read(p []byte) (int, error) {
+	if len(p) < 2 {
+		return c.r.Read(p)
+	}
+	buf := p[:len(p)/2]
+	n, err := c.r.Read(buf)
+	if bytes.IndexByte(buf[:n], 3) < 0 {
+		return n, err
+	}
+	out := bytes.ReplaceAll(buf[:n], []byte{3}, []byte{1, 11})
+	return copy(p, out), err
+}
+
+// TabCompleter returns a term.Terminal AutoCompleteCallback that completes
+// on Tab. complete gets the text before the cursor and returns whole-line
+// candidates; the text is extended to their longest common prefix, plus a
+// space when there is only one.
+func TabCompleter(complete func(line string) []string) func(line string, pos int, key rune) (string, int, bool) {
+	return func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		head, tail := line[:pos], line[pos:]
+		candidates := complete(head)
+		if len(candidates) == 0 {
+			return "", 0, false
+		}
+		fill := candidates[0]
+		for _, c := range candidates[1:] {
+			for !strings.HasPrefix(c, fill) {
+				fill = fill[:len(fill)-1]
+			}
+		}
+		if len(candidates) == 1 {
+			fill += " " // a candidate may also rewrite the text, as /help /mo does
+		} else if !strings.HasPrefix(fill, head) {
+			return "", 0, false
+		}
+		if fill == head {
+			return "", 0, false
+		}
+		return fill + tail, len(fill), true
+	}
+}
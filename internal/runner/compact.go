@@ -0,0 +1,183 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/chtushar/pingu/internal/llm"
+)
+
+// ContextManager keeps a run's history within the model's context window.
+// Before the first model call Run estimates the size of the request; when it
+// does not fit, the oldest exchanges of RunRequest.History are dropped and,
+// with Summarize, replaced by a summary the model writes. An exchange is a
+// user message and everything up to the next one, so an assistant's tool
+// calls and their results are always kept or dropped together. Every
+// compaction emits a warning.
+type ContextManager struct {
+	Window    int  // context window of the model, in tokens
+	Reserve   int  // tokens kept free for the run's own messages and answers; zero means a quarter of Window
+	Summarize bool // summarize dropped exchanges with a model call; without it they are only dropped
+}
+
+// SummaryPrefix starts the user message that stands in for summarized
+// exchanges.
+const SummaryPrefix = "Summary of the earlier conversation:\n"
+
+const summarizeInstructions = `You compact conversations between a user and an assistant. Summarize the transcript you are given so the assistant can continue the conversation without it: keep the user's goals, decisions, facts learned from tool results, and open questions. Write at most 300 words of plain prose and nothing else.`
+
+// maxSummaryTokens is the room set aside for the summary message.
+const maxSummaryTokens = 1000
+
+// EstimateTokens approximates the token count of msgs at four bytes per
+// token, plus a small overhead per message and tool call. It is meant for
+// budgeting, not billing.
+func EstimateTokens(msgs []llm.Message) int {
+	n := 0
+	for _, m := range msgs {
+		n += 4 + estimateText(m.Content)
+		for _, c := range m.ToolCalls {
+			n += 4 + estimateText(c.Name) + estimateText(string(c.Arguments))
+		}
+	}
+	return n
+}
+
+func estimateText(s string) int { return (len(s) + 3) / 4 }
+
+// exchangeStarts returns the indexes at which the exchanges of history
+// start: every user message, and the first message whatever its role.
+func exchangeStarts(history []llm.Message) []int {
+	var starts []int
+	for i, m := range history {
+		if i == 0 || m.Role == llm.RoleUser {
+			starts = append(starts, i)
+		}
+	}
+	return starts
+}
+
+// compaction is what compact did to the history.
+type compaction struct {
+	history []llm.Message
+	called  bool      // whether a summary call was made
+	usage   llm.Usage // of the summary call
+	model   string    // model that served the summary call
+}
+
+// compact fits req.History into the context window, leaving room for the
+// instructions, the tool definitions, and the input.
+func (r *Runner) compact(ctx context.Context, req RunRequest, defs []llm.ToolDef, emit func(Event)) compaction {
+	c := compaction{history: req.History}
+	cm := r.Context
+	if cm == nil || cm.Window <= 0 || len(req.History) == 0 {
+		return c
+	}
+	reserve := cm.Reserve
+	if reserve <= 0 {
+		reserve = cm.Window / 4
+	}
+	budget := cm.Window - reserve - estimateText(req.Instructions) - EstimateTokens([]llm.Message{{Role: llm.RoleUser, Content: req.Input}})
+	for _, d := range defs {
+		budget -= 4 + estimateText(d.Name) + estimateText(d.Description) + estimateText(string(d.Parameters))
+	}
+	size := EstimateTokens(req.History)
+	if size <= budget {
+		return c
+	}
+
+	summarize := cm.Summarize && budget > maxSummaryTokens
+	target := budget
+	if summarize {
+		target -= maxSummaryTokens
+	}
+	cut := len(req.History)
+	for _, start := range exchangeStarts(req.History)[1:] {
+		if EstimateTokens(req.History[start:]) <= target {
+			cut = start
+			break
+		}
+	}
+	dropped, kept := req.History[:cut], req.History[cut:]
+	droppedTokens := EstimateTokens(dropped)
+
+	if summarize {
+		summary, usage, model, err := r.summarize(ctx, req.Model, dropped, cm.Window-reserve)
+		c.called, c.usage, c.model = true, usage, model
+		if err == nil {
+			c.history = append([]llm.Message{{Role: llm.RoleUser, Content: SummaryPrefix + summary}}, kept...)
+			emit(Event{Kind: EventWarning, Text: fmt.Sprintf("history compacted: summarized %d earlier messages (~%d tokens) to fit the %d-token context window", len(dropped), droppedTokens, cm.Window)})
+			return c
+		}
+		emit(Event{Kind: EventWarning, Text: fmt.Sprintf("history summary failed: %v", err)})
+	}
+	c.history = kept
+	emit(Event{Kind: EventWarning, Text: fmt.Sprintf("history compacted: dropped %d earlier messages (~%d tokens) to fit the %d-token context window", len(dropped), droppedTokens, cm.Window)})
+	return c
+}
+
+// summarize asks the model to summarize msgs. The transcript is cut to its
+// most recent limit tokens. It returns the summary with the call's usage
+// and served model.
+func (r *Runner) summarize(ctx context.Context, model string, msgs []llm.Message, limit int) (string, llm.Usage, string, error) {
+	var usage llm.Usage
+	transcript := transcriptOf(msgs)
+	if n := limit * 4; len(transcript) > n {
+		transcript = strings.ToValidUTF8(transcript[len(transcript)-n:], "")
+	}
+	g := r.Generation
+	g.ResponseFormat, g.Schema = llm.ResponseText, nil
+	stream, err := r.Provider.Stream(ctx, llm.Request{
+		Model:      model,
+		System:     summarizeInstructions,
+		Messages:   []llm.Message{{Role: llm.RoleUser, Content: transcript}},
+		Generation: g,
+	})
+	if err != nil {
+		return "", usage, model, err
+	}
+	defer stream.Close()
+	served := model
+	if s, ok := stream.(llm.ServedModel); ok {
+		served = s.Model()
+	}
+	var text strings.Builder
+	for {
+		ev, err := stream.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", usage, served, err
+		}
+		switch ev.Type {
+		case llm.EventTextDelta:
+			text.WriteString(ev.Text)
+		case llm.EventUsage:
+			usage.InputTokens += ev.Usage.InputTokens
+			usage.OutputTokens += ev.Usage.OutputTokens
+		}
+	}
+	summary := strings.TrimSpace(text.String())
+	if summary == "" {
+		return "", usage, served, errors.New("empty summary")
+	}
+	return summary, usage, served, nil
+}
+
+// transcriptOf renders msgs as plain text for the summarizer.
+func transcriptOf(msgs []llm.Message) string {
+	var b strings.Builder
+	for _, m := range msgs {
+		if m.Content != "" {
+			fmt.Fprintf(&b, "%s: %s\n\n", m.Role, m.Content)
+		}
+		for _, c := range m.ToolCalls {
+			fmt.Fprintf(&b, "%s called %s with %s\n\n", m.Role, c.Name, c.Arguments)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,185 @@
+package runner_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/runner"
+)
+
+// longHistory holds three exchanges of about 1000 tokens per big message;
+// the first two include a tool call and its result.
+func longHistory() []llm.Message {
+	big := strings.Repeat("x", 4000)
+	var out []llm.Message
+	for i, withTool := range []bool{true, true, false} {
+		id := string(rune('a' + i))
+		out = append(out, llm.Message{Role: llm.RoleUser, Content: big})
+		if withTool {
+			out = append(out,
+				llm.Message{Role: llm.RoleAssistant, ToolCalls: []llm.ToolCall{{ID: id, Name: "echo", Arguments: []byte(`{}`)}}},
+				llm.Message{Role: llm.RoleTool, ToolCallID: id, Content: big},
+			)
+		}
+		out = append(out, llm.Message{Role: llm.RoleAssistant, Content: "ok " + id})
+	}
+	return out
+}
+
+// checkPairs fails unless msgs start with a user message and every tool
+// result follows the assistant message that called it.
+func checkPairs(t *testing.T, msgs []llm.Message) {
+	t.Helper()
+	if len(msgs) > 0 && msgs[0].Role != llm.RoleUser {
+		t.Errorf("history starts with %s", msgs[0].Role)
+	}
+	called := map[string]bool{}
+	for _, m := range msgs {
+		for _, c := range m.ToolCalls {
+			called[c.ID] = true
+		}
+		if m.Role == llm.RoleTool && !called[m.ToolCallID] {
+			t.Errorf("tool result %s without its call", m.ToolCallID)
+		}
+	}
+}
+
+func warningsOf(events []runner.Event) []string {
+	var out []string
+	for _, e := range events {
+		if e.Kind == runner.EventWarning {
+			out = append(out, e.Text)
+		}
+	}
+	return out
+}
+
+func TestRun_ContextFits(t *testing.T) {
+	p := &fakeProvider{next: func(int, llm.Request) ([]llm.Event, error) { return textEvents("hi"), nil }}
+	history := longHistory()
+	r := &runner.Runner{Provider: p, Limits: testLimits(), Context: &runner.ContextManager{Window: 100_000, Summarize: true}}
+	var events []runner.Event
+	res, err := r.Run(context.Background(), runner.RunRequest{Input: "x", History: history}, collect(&events))
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if p.requestCount() != 1 || len(res.History) != len(history) || len(warningsOf(events)) != 0 {
+		t.Errorf("requests = %d, history = %d, warnings = %q", p.requestCount(), len(res.History), warningsOf(events))
+	}
+}
+
+func TestRun_ContextDrop(t *testing.T) {
+	p := &fakeProvider{next: func(int, llm.Request) ([]llm.Event, error) { return textEvents("hi"), nil }}
+	history := longHistory()
+	// Room for the last two exchanges, which start at the second user
+	// message, but not for all three.
+	keep := history[4:]
+	window := 100 + runner.EstimateTokens(keep) + runner.EstimateTokens([]llm.Message{{Role: llm.RoleUser, Content: "x"}})
+	r := &runner.Runner{Provider: p, Limits: testLimits(), Context: &runner.ContextManager{Window: window, Reserve: 100}}
+	var events []runner.Event
+	res, err := r.Run(context.Background(), runner.RunRequest{Input: "x", History: history}, collect(&events))
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !slices.EqualFunc(res.History, keep, func(a, b llm.Message) bool { return a.Content == b.Content && a.ToolCallID == b.ToolCallID }) {
+		t.Fatalf("history = %+v", res.History)
+	}
+	req := p.request(0)
+	if len(req.Messages) != len(keep)+1 {
+		t.Errorf("sent %d messages, want %d", len(req.Messages), len(keep)+1)
+	}
+	checkPairs(t, req.Messages)
+	if w := warningsOf(events); len(w) != 1 || !strings.Contains(w[0], "dropped 4 earlier messages") {
+		t.Errorf("warnings = %q", w)
+	}
+}
+
+func TestRun_ContextSummarize(t *testing.T) {
+	usage := llm.Event{Type: llm.EventUsage, Usage: llm.Usage{InputTokens: 50, OutputTokens: 5}}
+	p := &fakeProvider{next: func(call int, req llm.Request) ([]llm.Event, error) {
+		if call == 1 {
+			return append(textEvents("the user sent a lot of x"), usage), nil
+		}
+		return append(textEvents("hi"), usage), nil
+	}}
+	history := longHistory()
+	// Room for the summary and the last exchange only.
+	keep := history[8:]
+	window := 100 + 1000 + runner.EstimateTokens(keep) + runner.EstimateTokens([]llm.Message{{Role: llm.RoleUser, Content: "x"}})
+	r := &runner.Runner{Provider: p, Limits: testLimits(), Context: &runner.ContextManager{Window: window, Reserve: 100, Summarize: true}}
+	var events []runner.Event
+	res, err := r.Run(context.Background(), runner.RunRequest{Input: "x", History: history}, collect(&events))
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	summary := p.request(0)
+	if len(summary.Tools) != 0 || len(summary.Messages) != 1 || !strings.Contains(summary.Messages[0].Content, "called echo with {}") {
+		t.Errorf("summary request = %+v", summary.Messages)
+	}
+	want := runner.SummaryPrefix + "the user sent a lot of x"
+	if len(res.History) != 1+len(keep) || res.History[0].Content != want {
+		t.Fatalf("history = %+v", res.History)
+	}
+	sent := p.request(1).Messages
+	if sent[0].Content != want || sent[len(sent)-1].Content != "x" {
+		t.Errorf("sent = %+v", sent)
+	}
+	checkPairs(t, sent)
+	if res.Turns != 1 || res.Usage.InputTokens != 100 {
+		t.Errorf("turns = %d, usage = %+v", res.Turns, res.Usage)
+	}
+	if w := warningsOf(events); len(w) != 1 || !strings.Contains(w[0], "summarized 8 earlier messages") {
+		t.Errorf("warnings = %q", w)
+	}
+}
+
+func TestRun_ContextSummaryOverBudget(t *testing.T) {
+	p := &fakeProvider{next: func(call int, req llm.Request) ([]llm.Event, error) {
+		usage := llm.Event{Type: llm.EventUsage, Usage: llm.Usage{InputTokens: 1_000_000}}
+		return append(textEvents("summary"), usage), nil
+	}}
+	history := longHistory()
+	window := 100 + 1000 + runner.EstimateTokens(history[8:]) + runner.EstimateTokens([]llm.Message{{Role: llm.RoleUser, Content: "x"}})
+	limits := testLimits()
+	limits.MaxCostUSD = 0.5
+	pricing := func(string) (llm.Price, bool) { return llm.Price{InputPerMTok: 1}, true }
+	r := &runner.Runner{Provider: p, Limits: limits, Pricing: pricing, Context: &runner.ContextManager{Window: window, Reserve: 100, Summarize: true}}
+	res, err := r.Run(context.Background(), runner.RunRequest{Input: "x", History: history}, func(runner.Event) {})
+	if !errors.Is(err, runner.ErrLimitExhausted) || !strings.Contains(err.Error(), "max cost") {
+		t.Fatalf("expected a cost limit error, got %v", err)
+	}
+	if p.requestCount() != 1 || res.Turns != 0 || res.CostUSD != 1 {
+		t.Errorf("requests = %d, turns = %d, cost = %v; want only the summary call", p.requestCount(), res.Turns, res.CostUSD)
+	}
+}
+
+func TestRun_ContextSummaryFails(t *testing.T) {
+	p := &fakeProvider{next: func(call int, req llm.Request) ([]llm.Event, error) {
+		if call == 1 {
+			return nil, errors.New("no summary today")
+		}
+		return textEvents("hi"), nil
+	}}
+	history := longHistory()
+	keep := history[8:]
+	window := 100 + 1000 + runner.EstimateTokens(keep) + runner.EstimateTokens([]llm.Message{{Role: llm.RoleUser, Content: "x"}})
+	r := &runner.Runner{Provider: p, Limits: testLimits(), Context: &runner.ContextManager{Window: window, Reserve: 100, Summarize: true}}
+	var events []runner.Event
+	res, err := r.Run(context.Background(), runner.RunRequest{Input: "x", History: history}, collect(&events))
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	// The exchanges are dropped instead.
+	if len(res.History) != len(keep) {
+		t.Errorf("history = %+v", res.History)
+	}
+	w := warningsOf(events)
+	if len(w) != 2 || !strings.Contains(w[0], "no summary today") || !strings.Contains(w[1], "dropped 8 earlier messages") {
+		t.Errorf("warnings = %q", w)
+	}
+}
@@ -60,6 +60,19 @@ func toolCallEvents(id, name, args string) []llm.Event {
 	}
 }
 
+// multiToolCallEvents emits one complete tool call per {id, name, args}.
+func multiToolCallEvents(calls ...[3]string) []llm.Event {
+	var out []llm.Event
+	for i, c := range calls {
+		out = append(out,
+			llm.Event{Type: llm.EventToolCallStart, ToolIndex: i, ToolCallID: c[0], ToolName: c[1]},
+			llm.Event{Type: llm.EventToolCallDelta, ToolIndex: i, ArgumentsDelta: c[2]},
+			llm.Event{Type: llm.EventToolCallEnd, ToolIndex: i},
+		)
+	}
+	return out
+}
+
 // hangStream blocks until the context is done, then returns the ctx error.
 type hangStream struct{}
 
@@ -76,8 +89,10 @@ func (hangingProvider) Stream(ctx context.Context, _ llm.Request) (llm.Stream, e
 	return hangStream{}, nil
 }
 
-// fakeTool is a minimal Tool implementation.
+// fakeTool is a minimal Tool implementation. It is safe for concurrent
+// calls.
 type fakeTool struct {
+	mu      sync.Mutex
 	name    string
 	fn      func(ctx context.Context, args json.RawMessage) (string, error)
 	calls   int
@@ -91,13 +106,20 @@ func (t *fakeTool) Parameters() json.RawMessage {
 }
 
 func (t *fakeTool) Run(ctx context.Context, args json.RawMessage) (string, error) {
-	t.calls++
 	var m map[string]any
 	_ = json.Unmarshal(args, &m)
+	t.mu.Lock()
+	t.calls++
 	t.lastArg = fmt.Sprint(m["value"])
+	t.mu.Unlock()
 	return t.fn(ctx, args)
 }
 
+// serialTool is a fakeTool that declares itself not parallel-safe.
+type serialTool struct{ *fakeTool }
+
+func (serialTool) ParallelSafe() bool { return false }
+
 // erroringStream yields prefix events, then fails.
 type erroringStream struct {
 	events []llm.Event
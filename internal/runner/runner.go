@@ -14,6 +14,7 @@ import (
 	"strings"
 
 	"github.com/chtushar/pingu/internal/config"
+	"github.com/chtushar/pingu/internal/jsonschema"
 	"github.com/chtushar/pingu/internal/llm"
 	"github.com/chtushar/pingu/internal/tools"
 )
@@ -40,6 +41,7 @@ type Event struct {
 	Result     string    // tool output on EventToolFinished
 	Turns      int       // EventRunFinished
 	Usage      llm.Usage // EventRunFinished
+	CostUSD    float64   // EventRunFinished; see Runner.Pricing
 	Err        error     // terminal error on EventRunFinished
 }
 
@@ -55,19 +57,46 @@ type RunRequest struct {
 
 // RunResult reports what the run appended to the conversation.
 type RunResult struct {
+	// History is the history the run sent, after Runner.Context compacted
+	// it; it is RunRequest.History when nothing was compacted. Callers that
+	// keep a conversation continue it from History and Messages.
+	History  []llm.Message
 	Messages []llm.Message // messages produced during this run
-	Usage    llm.Usage
+	Usage    llm.Usage     // every model call, a compaction summary included
 	Turns    int
+	// Models holds the model that served each turn that got a response:
+	// the llm.ServedModel of its stream, or RunRequest.Model. TurnCosts
+	// holds the cost of each of those turns, in US dollars, and CostUSD
+	// their sum plus the cost of a compaction summary.
+	Models    []string
+	TurnCosts []float64
+	CostUSD   float64
 }
 
 // ErrLimitExhausted reports that a configured run limit was reached.
 var ErrLimitExhausted = errors.New("run limit exhausted")
 
+// ErrOutputSchema reports that the final answer still did not match the
+// output schema after every re-prompt.
+var ErrOutputSchema = errors.New("output does not match the schema")
+
 // Runner owns loop termination and ordering. It is safe for sequential use;
 // concurrent runs require separate Runner values or external serialization.
 type Runner struct {
-	Provider llm.Provider
-	Limits   config.Limits
+	Provider   llm.Provider
+	Limits     config.Limits
+	Generation llm.Generation // sent with every model call
+	// SchemaRetries bounds how often the model is asked to correct a final
+	// answer that does not match Generation.Schema. Re-prompts also count
+	// against MaxModelTurns.
+	SchemaRetries int
+	// Pricing returns the price of a model as recorded in RunResult.Models.
+	// Turns of a model it does not know cost nothing and raise a warning;
+	// with a nil Pricing costs are not computed and MaxCostUSD is inert.
+	Pricing func(model string) (llm.Price, bool)
+	// Context keeps the history within the model's context window; with a
+	// nil Context the history is sent as it is.
+	Context *ContextManager
 }
 
 type assembly struct {
@@ -79,8 +108,15 @@ type assembly struct {
 }
 
 // Run executes the loop. emit is called synchronously and in order for every
-// event; it must not block on the run. The returned error is nil only when
-// the run completed normally. Cancellation propagates from ctx.
+// event, always from the goroutine that called Run; it must not block on the
+// run. The returned error is nil only when the run completed normally.
+// Cancellation propagates from ctx.
+//
+// Tool events of one turn keep these guarantees at any MaxParallelTools:
+// tool_started events come in call order, each call's tool_finished comes
+// after its tool_started, and every tool_finished of a turn comes before the
+// next model call. With more than one tool running, tool_finished events come
+// in completion order; tool messages are appended in call order regardless.
 func (r *Runner) Run(ctx context.Context, req RunRequest, emit func(Event)) (RunResult, error) {
 	limits := r.Limits.WithDefaults()
 	if err := limits.Validate(); err != nil {
@@ -100,14 +136,10 @@ func (r *Runner) Run(ctx context.Context, req RunRequest, emit func(Event)) (Run
 			}
 		}
 		result.Usage = usage
-		emit(Event{Kind: EventRunFinished, Turns: result.Turns, Usage: usage, Err: err})
+		emit(Event{Kind: EventRunFinished, Turns: result.Turns, Usage: usage, CostUSD: result.CostUSD, Err: err})
 		return result, err
 	}
 
-	messages := make([]llm.Message, 0, len(req.History)+8)
-	messages = append(messages, req.History...)
-	messages = append(messages, llm.Message{Role: llm.RoleUser, Content: req.Input})
-
 	var defs []llm.ToolDef
 	if req.Tools != nil && !req.Tools.Empty() {
 		for _, t := range req.Tools.List() {
@@ -121,19 +153,68 @@ func (r *Runner) Run(ctx context.Context, req RunRequest, emit func(Event)) (Run
 
 	var toolCallsUsed int
 	var syntheticID int
+	var schemaRetries int
+	unpriced := map[string]bool{}
+	price := func(model string, u llm.Usage) float64 {
+		if r.Pricing == nil {
+			return 0
+		}
+		p, ok := r.Pricing(model)
+		if !ok {
+			if !unpriced[model] {
+				unpriced[model] = true
+				emit(Event{Kind: EventWarning, Text: fmt.Sprintf("no price for model %s; its cost is not counted", model)})
+			}
+			return 0
+		}
+		return p.Cost(u)
+	}
+	// overBudget fails the run once its cost exceeds MaxCostUSD. It is
+	// checked before each model turn and tool batch, never on a final
+	// answer already paid for.
+	overBudget := func() error {
+		if limits.MaxCostUSD <= 0 || result.CostUSD <= limits.MaxCostUSD {
+			return nil
+		}
+		emit(Event{Kind: EventWarning, Text: "cost budget exhausted"})
+		return fmt.Errorf("%w: max cost ($%.2f)", ErrLimitExhausted, limits.MaxCostUSD)
+	}
+	streamCtx := llm.WithWarnings(ctx, func(msg string) {
+		emit(Event{Kind: EventWarning, Text: msg})
+	})
+
+	compacted := r.compact(streamCtx, req, defs, emit)
+	result.History = compacted.history
+	if compacted.called {
+		usage = compacted.usage
+		result.CostUSD = price(compacted.model, compacted.usage)
+	}
+	messages := make([]llm.Message, 0, len(result.History)+8)
+	messages = append(messages, result.History...)
+	messages = append(messages, llm.Message{Role: llm.RoleUser, Content: req.Input})
 
 	for turn := 1; turn <= limits.MaxModelTurns; turn++ {
+		if err := overBudget(); err != nil {
+			return finish(err)
+		}
 		result.Turns = turn
-		stream, err := r.Provider.Stream(ctx, llm.Request{
-			Model:    req.Model,
-			System:   req.Instructions,
-			Messages: messages,
-			Tools:    defs,
+		stream, err := r.Provider.Stream(streamCtx, llm.Request{
+			Model:      req.Model,
+			System:     req.Instructions,
+			Messages:   messages,
+			Tools:      defs,
+			Generation: r.Generation,
 		})
 		if err != nil {
 			return finish(fmt.Errorf("model call failed: %w", err))
 		}
+		served := req.Model
+		if s, ok := stream.(llm.ServedModel); ok {
+			served = s.Model()
+		}
+		result.Models = append(result.Models, served)
 
+		var turnUsage llm.Usage
 		var content strings.Builder
 		calls := map[int]*assembly{}
 		var order []int
@@ -145,6 +226,8 @@ func (r *Runner) Run(ctx context.Context, req RunRequest, emit func(Event)) (Run
 			}
 			if err != nil {
 				stream.Close()
+				usage.InputTokens += turnUsage.InputTokens
+				usage.OutputTokens += turnUsage.OutputTokens
 				return finish(fmt.Errorf("model stream failed: %w", err))
 			}
 			switch ev.Type {
@@ -173,13 +256,18 @@ func (r *Runner) Run(ctx context.Context, req RunRequest, emit func(Event)) (Run
 			case llm.EventToolCallEnd:
 				// Assembly is finalized when the message is assembled below.
 			case llm.EventUsage:
-				usage.InputTokens += ev.Usage.InputTokens
-				usage.OutputTokens += ev.Usage.OutputTokens
+				turnUsage.InputTokens += ev.Usage.InputTokens
+				turnUsage.OutputTokens += ev.Usage.OutputTokens
 			}
 		}
 		if err := stream.Close(); err != nil {
 			slog.Debug("stream close failed", "error", err)
 		}
+		usage.InputTokens += turnUsage.InputTokens
+		usage.OutputTokens += turnUsage.OutputTokens
+		cost := price(served, turnUsage)
+		result.TurnCosts = append(result.TurnCosts, cost)
+		result.CostUSD += cost
 
 		assistant := llm.Message{Role: llm.RoleAssistant, Content: content.String()}
 		for _, idx := range order {
@@ -198,33 +286,108 @@ func (r *Runner) Run(ctx context.Context, req RunRequest, emit func(Event)) (Run
 		result.Messages = append(result.Messages, assistant)
 
 		if len(assistant.ToolCalls) == 0 {
-			return finish(nil)
-		}
-
-		for _, call := range assistant.ToolCalls {
-			toolCallsUsed++
-			if toolCallsUsed > limits.MaxToolCalls {
-				emit(Event{Kind: EventWarning, Text: "tool call budget exhausted"})
-				return finish(fmt.Errorf("%w: max tool calls (%d)", ErrLimitExhausted, limits.MaxToolCalls))
+			err := r.checkOutput(assistant.Content)
+			if err == nil {
+				return finish(nil)
 			}
-			emit(Event{Kind: EventToolStarted, ToolCallID: call.ID, ToolName: call.Name})
-
-			out := r.executeTool(ctx, req.Tools, call, limits)
-			if int64(len(out)) > limits.MaxToolOutputBytes {
-				truncated := out[:limits.MaxToolOutputBytes]
-				emit(Event{Kind: EventWarning, Text: fmt.Sprintf("tool %q output truncated to %d bytes", call.Name, limits.MaxToolOutputBytes)})
-				out = truncated
+			if schemaRetries >= r.SchemaRetries {
+				return finish(fmt.Errorf("%w: %v", ErrOutputSchema, err))
 			}
-			emit(Event{Kind: EventToolFinished, ToolCallID: call.ID, ToolName: call.Name, Result: out})
+			schemaRetries++
+			emit(Event{Kind: EventWarning, Text: fmt.Sprintf("output does not match the schema, asking again (%d/%d): %v", schemaRetries, r.SchemaRetries, err)})
+			fix := llm.Message{Role: llm.RoleUser, Content: "Your answer does not match the required JSON Schema:\n" + err.Error() + "\n\nReply with only the corrected JSON, no other text."}
+			messages = append(messages, fix)
+			result.Messages = append(result.Messages, fix)
+			continue
+		}
 
-			messages = append(messages, llm.Message{Role: llm.RoleTool, ToolCallID: call.ID, Content: out})
-			result.Messages = append(result.Messages, llm.Message{Role: llm.RoleTool, ToolCallID: call.ID, Content: out})
+		if err := overBudget(); err != nil {
+			return finish(err)
+		}
+		// Only the calls the budget still allows run; the rest fail the run
+		// after the allowed ones finish, as if they had been tried in order.
+		batch := assistant.ToolCalls
+		if left := limits.MaxToolCalls - toolCallsUsed; len(batch) > left {
+			batch = batch[:left]
+		}
+		toolCallsUsed += len(batch)
+		for i, out := range r.executeTools(ctx, req.Tools, batch, limits, emit) {
+			msg := llm.Message{Role: llm.RoleTool, ToolCallID: batch[i].ID, Content: out}
+			messages = append(messages, msg)
+			result.Messages = append(result.Messages, msg)
+		}
+		if len(batch) < len(assistant.ToolCalls) {
+			emit(Event{Kind: EventWarning, Text: "tool call budget exhausted"})
+			return finish(fmt.Errorf("%w: max tool calls (%d)", ErrLimitExhausted, limits.MaxToolCalls))
 		}
 	}
 	emit(Event{Kind: EventWarning, Text: "model turn budget exhausted"})
 	return finish(fmt.Errorf("%w: max model turns (%d)", ErrLimitExhausted, limits.MaxModelTurns))
 }
 
+// checkOutput validates a final answer against the output schema, if the
+// run has one.
+func (r *Runner) checkOutput(content string) error {
+	g := r.Generation
+	if g.ResponseFormat != llm.ResponseJSONSchema {
+		return nil
+	}
+	return jsonschema.Validate(g.Schema, json.RawMessage(strings.TrimSpace(content)))
+}
+
+// executeTools runs calls with at most limits.MaxParallelTools in flight and
+// returns their outputs in call order. Calls start in order; a tool that is
+// not parallel-safe waits for the running calls to finish and then runs
+// alone. Events are emitted from the calling goroutine only.
+func (r *Runner) executeTools(ctx context.Context, reg *tools.Registry, calls []llm.ToolCall, limits config.Limits, emit func(Event)) []string {
+	type done struct {
+		i   int
+		out string
+	}
+	outs := make([]string, len(calls))
+	results := make(chan done)
+	running, exclusive := 0, false
+	collect := func() {
+		d := <-results
+		running--
+		exclusive = false
+		call, out := calls[d.i], d.out
+		if int64(len(out)) > limits.MaxToolOutputBytes {
+			out = out[:limits.MaxToolOutputBytes]
+			emit(Event{Kind: EventWarning, Text: fmt.Sprintf("tool %q output truncated to %d bytes", call.Name, limits.MaxToolOutputBytes)})
+		}
+		emit(Event{Kind: EventToolFinished, ToolCallID: call.ID, ToolName: call.Name, Result: out})
+		outs[d.i] = out
+	}
+
+	for i, call := range calls {
+		safe := parallelSafe(reg, call.Name)
+		for running > 0 && (running >= limits.MaxParallelTools || exclusive || !safe) {
+			collect()
+		}
+		emit(Event{Kind: EventToolStarted, ToolCallID: call.ID, ToolName: call.Name})
+		running++
+		exclusive = !safe
+		go func() {
+			results <- done{i, r.executeTool(ctx, reg, call, limits)}
+		}()
+	}
+	for running > 0 {
+		collect()
+	}
+	return outs
+}
+
+// parallelSafe reports whether the named tool may run next to others.
+// Unknown tools only produce an error result, so they are safe.
+func parallelSafe(reg *tools.Registry, name string) bool {
+	if reg == nil {
+		return true
+	}
+	t, ok := reg.Get(name)
+	return !ok || tools.IsParallelSafe(t)
+}
+
 // executeTool runs one tool call and always returns a string result suitable
 // for the conversation: tool errors become "error: ..." so the model can
 // recover, matching the tool error convention.
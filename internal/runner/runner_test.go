@@ -5,7 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"math"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -64,6 +67,9 @@ func TestRun_TextOnly(t *testing.T) {
 	if req.System != "be brief" || len(req.Messages) != 1 || req.Messages[0].Content != "hi" {
 		t.Errorf("unexpected request: %+v", req)
 	}
+	if len(res.Models) != 1 || res.Models[0] != "openai/test" {
+		t.Errorf("models = %v", res.Models)
+	}
 	if len(res.Messages) != 1 || res.Messages[0].Content != "hello there" {
 		t.Errorf("unexpected result messages: %+v", res.Messages)
 	}
@@ -410,3 +416,266 @@ func TestRun_HistoryPassedThrough(t *testing.T) {
 		t.Errorf("messages = %+v", req.Messages)
 	}
 }
+
+func TestRun_OutputSchemaRetries(t *testing.T) {
+	answers := []string{"Sure! The answer is 4.", `{"answer":"4"}`, `{"answer":4}`}
+	p := &fakeProvider{next: func(call int, _ llm.Request) ([]llm.Event, error) {
+		return textEvents(answers[(call-1)%len(answers)]), nil
+	}}
+	gen := llm.Generation{
+		ResponseFormat: llm.ResponseJSONSchema,
+		Schema:         json.RawMessage(`{"type":"object","properties":{"answer":{"type":"integer"}},"required":["answer"]}`),
+	}
+	r := &runner.Runner{Provider: p, Limits: testLimits(), Generation: gen, SchemaRetries: 2}
+	var events []runner.Event
+	res, err := r.Run(context.Background(), runner.RunRequest{Input: "2+2?"}, collect(&events))
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if res.Turns != 3 {
+		t.Errorf("turns = %d, want 3", res.Turns)
+	}
+	if last := res.Messages[len(res.Messages)-1]; last.Content != `{"answer":4}` {
+		t.Errorf("final answer = %q", last.Content)
+	}
+	// The second request carries the validation error back to the model.
+	fix := p.request(1).Messages[2]
+	if fix.Role != llm.RoleUser || !strings.Contains(fix.Content, "not valid JSON") {
+		t.Errorf("re-prompt = %+v", fix)
+	}
+	if p.request(0).Generation.ResponseFormat != llm.ResponseJSONSchema {
+		t.Error("generation not sent with the request")
+	}
+	warnings := 0
+	for _, ev := range events {
+		if ev.Kind == runner.EventWarning {
+			warnings++
+		}
+	}
+	if warnings != 2 {
+		t.Errorf("warnings = %d, want 2", warnings)
+	}
+
+	r.SchemaRetries = 1
+	_, err = r.Run(context.Background(), runner.RunRequest{Input: "2+2?"}, func(runner.Event) {})
+	if !errors.Is(err, runner.ErrOutputSchema) || !strings.Contains(err.Error(), "answer: got string, want integer") {
+		t.Errorf("expected ErrOutputSchema, got %v", err)
+	}
+}
+
+func TestRun_ParallelTools(t *testing.T) {
+	var active, overlap atomic.Int32
+	var both sync.WaitGroup
+	both.Add(2)
+	// gate returns only once both of its calls are running at the same time.
+	gate := &fakeTool{name: "gate", fn: func(ctx context.Context, _ json.RawMessage) (string, error) {
+		active.Add(1)
+		defer active.Add(-1)
+		both.Done()
+		done := make(chan struct{})
+		go func() { both.Wait(); close(done) }()
+		select {
+		case <-done:
+			return "gate ok", nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}}
+	serial := serialTool{&fakeTool{name: "serial", fn: func(context.Context, json.RawMessage) (string, error) {
+		if n := active.Add(1); n != 1 {
+			overlap.Store(n)
+		}
+		defer active.Add(-1)
+		return "serial ok", nil
+	}}}
+	reg, err := tools.NewRegistry(gate, serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{next: func(call int, _ llm.Request) ([]llm.Event, error) {
+		if call == 1 {
+			return multiToolCallEvents(
+				[3]string{"c1", "gate", `{}`},
+				[3]string{"c2", "gate", `{}`},
+				[3]string{"c3", "serial", `{}`},
+				[3]string{"c4", "missing", `{}`},
+			), nil
+		}
+		return textEvents("done"), nil
+	}}
+	limits := testLimits()
+	limits.MaxParallelTools = 4
+	r := &runner.Runner{Provider: p, Limits: limits}
+	var events []runner.Event
+	res, err := r.Run(context.Background(), runner.RunRequest{Input: "x", Tools: reg}, collect(&events))
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if n := overlap.Load(); n != 0 {
+		t.Errorf("serial tool ran with %d tools active", n)
+	}
+
+	var ids, contents []string
+	for _, m := range res.Messages[1:5] {
+		ids = append(ids, m.ToolCallID)
+		contents = append(contents, m.Content)
+	}
+	if got := strings.Join(ids, ","); got != "c1,c2,c3,c4" {
+		t.Errorf("tool message order = %s", got)
+	}
+	if contents[0] != "gate ok" || contents[1] != "gate ok" || contents[2] != "serial ok" || !strings.Contains(contents[3], "unknown tool") {
+		t.Errorf("tool results = %q", contents)
+	}
+
+	var started []string
+	finished := map[string]int{}
+	for i, e := range events {
+		switch e.Kind {
+		case runner.EventToolStarted:
+			started = append(started, e.ToolCallID)
+		case runner.EventToolFinished:
+			finished[e.ToolCallID] = i
+		}
+	}
+	if got := strings.Join(started, ","); got != "c1,c2,c3,c4" {
+		t.Errorf("tool_started order = %s", got)
+	}
+	// The serial call starts only after both gate calls have finished.
+	for i, e := range events {
+		if e.Kind == runner.EventToolStarted && e.ToolCallID == "c3" && (finished["c1"] > i || finished["c2"] > i) {
+			t.Errorf("serial call started before the gate calls finished: %v", kinds(events))
+		}
+	}
+}
+
+func TestRun_ParallelToolCallBudget(t *testing.T) {
+	echo := &fakeTool{name: "echo", fn: func(context.Context, json.RawMessage) (string, error) {
+		return "ok", nil
+	}}
+	reg, _ := tools.NewRegistry(echo)
+	p := &fakeProvider{next: func(int, llm.Request) ([]llm.Event, error) {
+		return multiToolCallEvents(
+			[3]string{"c1", "echo", `{}`},
+			[3]string{"c2", "echo", `{}`},
+			[3]string{"c3", "echo", `{}`},
+		), nil
+	}}
+	limits := testLimits()
+	limits.MaxToolCalls = 2
+	limits.MaxParallelTools = 3
+	r := &runner.Runner{Provider: p, Limits: limits}
+	var events []runner.Event
+	res, err := r.Run(context.Background(), runner.RunRequest{Input: "x", Tools: reg}, collect(&events))
+	if !errors.Is(err, runner.ErrLimitExhausted) {
+		t.Fatalf("expected ErrLimitExhausted, got %v", err)
+	}
+	if echo.calls != 2 {
+		t.Errorf("tool calls = %d, want 2", echo.calls)
+	}
+	if len(res.Messages) != 3 || res.Messages[2].ToolCallID != "c2" {
+		t.Errorf("result messages = %+v", res.Messages)
+	}
+	want := "run_started,tool_started,tool_started,tool_finished,tool_finished,warning,error,run_finished"
+	if got := strings.Join(kinds(events), ","); got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+}
+
+// warningProvider reports a warning through the request context, as
+// provider wrappers do, then answers.
+type warningProvider struct{}
+
+func (warningProvider) Stream(ctx context.Context, _ llm.Request) (llm.Stream, error) {
+	llm.Warn(ctx, "model call failed, retrying")
+	return llm.NewSliceStream(textEvents("ok")), nil
+}
+
+func TestRun_ProviderWarnings(t *testing.T) {
+	r := &runner.Runner{Provider: warningProvider{}, Limits: testLimits()}
+	var events []runner.Event
+	if _, err := r.Run(context.Background(), runner.RunRequest{Input: "x"}, collect(&events)); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got := strings.Join(kinds(events), ","); got != "run_started,warning,text_delta,run_finished" {
+		t.Errorf("events = %s", got)
+	}
+	if events[1].Text != "model call failed, retrying" {
+		t.Errorf("warning = %q", events[1].Text)
+	}
+}
+
+func TestRun_Cost(t *testing.T) {
+	echo := &fakeTool{name: "echo", fn: func(context.Context, json.RawMessage) (string, error) {
+		return "ok", nil
+	}}
+	reg, _ := tools.NewRegistry(echo)
+	usage := llm.Event{Type: llm.EventUsage, Usage: llm.Usage{InputTokens: 1000, OutputTokens: 100}}
+	// Every run makes a tool call turn, then answers.
+	newProvider := func() *fakeProvider {
+		return &fakeProvider{next: func(call int, _ llm.Request) ([]llm.Event, error) {
+			if call == 1 {
+				return append(toolCallEvents("c1", "echo", `{}`), usage), nil
+			}
+			return append(textEvents("done"), usage), nil
+		}}
+	}
+	pricing := func(model string) (llm.Price, bool) {
+		if model != "openai/test" {
+			return llm.Price{}, false
+		}
+		return llm.Price{InputPerMTok: 2, OutputPerMTok: 10}, true
+	}
+	r := &runner.Runner{Provider: newProvider(), Limits: testLimits(), Pricing: pricing}
+	var events []runner.Event
+	res, err := r.Run(context.Background(), runner.RunRequest{Model: "openai/test", Input: "x", Tools: reg}, collect(&events))
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	// Each turn: 1000 * $2/M + 100 * $10/M = $0.003.
+	if len(res.TurnCosts) != 2 || math.Abs(res.TurnCosts[0]-0.003) > 1e-12 || math.Abs(res.CostUSD-0.006) > 1e-12 {
+		t.Errorf("turn costs = %v, total = %v", res.TurnCosts, res.CostUSD)
+	}
+	if last := events[len(events)-1]; last.CostUSD != res.CostUSD {
+		t.Errorf("run_finished cost = %v", last.CostUSD)
+	}
+
+	// The first turn already exceeds the budget.
+	limits := testLimits()
+	limits.MaxCostUSD = 0.002
+	r = &runner.Runner{Provider: newProvider(), Limits: limits, Pricing: pricing}
+	res, err = r.Run(context.Background(), runner.RunRequest{Model: "openai/test", Input: "x", Tools: reg}, func(runner.Event) {})
+	if !errors.Is(err, runner.ErrLimitExhausted) || !strings.Contains(err.Error(), "max cost") {
+		t.Fatalf("expected a cost limit error, got %v", err)
+	}
+	if res.Turns != 1 || len(res.Messages) != 1 {
+		t.Errorf("turns = %d, messages = %d; want the tools not run", res.Turns, len(res.Messages))
+	}
+
+	// A final answer over the budget is kept: the run stops on cost only
+	// before more turns or tools.
+	answer := &fakeProvider{next: func(int, llm.Request) ([]llm.Event, error) {
+		return append(textEvents("done"), usage), nil
+	}}
+	r = &runner.Runner{Provider: answer, Limits: limits, Pricing: pricing}
+	res, err = r.Run(context.Background(), runner.RunRequest{Model: "openai/test", Input: "x"}, func(runner.Event) {})
+	if err != nil || len(res.Messages) != 1 || res.Messages[0].Content != "done" {
+		t.Errorf("final answer over budget: messages = %+v, err = %v", res.Messages, err)
+	}
+
+	// An unpriced model costs nothing and is warned about once.
+	events = nil
+	r = &runner.Runner{Provider: newProvider(), Limits: testLimits(), Pricing: pricing}
+	res, err = r.Run(context.Background(), runner.RunRequest{Model: "other/model", Input: "x", Tools: reg}, collect(&events))
+	if err != nil || res.Turns != 2 || res.CostUSD != 0 {
+		t.Fatalf("turns = %d, cost = %v, err = %v", res.Turns, res.CostUSD, err)
+	}
+	warnings := 0
+	for _, e := range events {
+		if e.Kind == runner.EventWarning && strings.Contains(e.Text, "no price for model other/model") {
+			warnings++
+		}
+	}
+	if warnings != 1 {
+		t.Errorf("price warnings = %d, want 1", warnings)
+	}
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/runner"
+)
+
+// Chat Completions facade. Clients send an OpenAI Chat Completions request;
+// the last message must be from the user and becomes the run input, and the
+// messages before it become the run history. Client system messages are
+// appended to the agent instructions. Tools run server-side, so responses
+// carry only assistant text. Other request fields (temperature, tools, ...)
+// are ignored: the agent's configuration decides.
+
+type chatRequest struct {
+	Model         string            `json:"model"`
+	Messages      []chatMessage     `json:"messages"`
+	Stream        bool              `json:"stream"`
+	StreamOptions *chatStreamOption `json:"stream_options"`
+}
+
+type chatStreamOption struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type chatMessage struct {
+	Role       string          `json:"role"`
+	Content    json.RawMessage `json:"content"`
+	ToolCalls  []chatToolCall  `json:"tool_calls"`
+	ToolCallID string          `json:"tool_call_id"`
+}
+
+type chatToolCall struct {
+	ID       string `json:"id"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// text returns the message content, which may be a string, null, or an
+// array of content parts of which only text parts are supported.
+func (m chatMessage) text() (string, error) {
+	if len(m.Content) == 0 || string(m.Content) == "null" {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(m.Content, &s); err == nil {
+		return s, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return "", errors.New("content must be a string or an array of content parts")
+	}
+	var b strings.Builder
+	for _, p := range parts {
+		if p.Type != "text" {
+			return "", fmt.Errorf("unsupported content part type %q", p.Type)
+		}
+		b.WriteString(p.Text)
+	}
+	return b.String(), nil
+}
+
+// conversation splits the request into system text, history, and input.
+func (r *chatRequest) conversation() (system string, history []llm.Message, input string, err error) {
+	if len(r.Messages) == 0 {
+		return "", nil, "", errors.New("messages is required")
+	}
+	last := r.Messages[len(r.Messages)-1]
+	if last.Role != "user" {
+		return "", nil, "", errors.New("the last message must have role user")
+	}
+	var systems []string
+	for i, m := range r.Messages {
+		text, err := m.text()
+		if err != nil {
+			return "", nil, "", fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		if i == len(r.Messages)-1 {
+			input = text
+			break
+		}
+		switch m.Role {
+		case "system", "developer":
+			systems = append(systems, text)
+		case "user":
+			history = append(history, llm.Message{Role: llm.RoleUser, Content: text})
+		case "assistant":
+			msg := llm.Message{Role: llm.RoleAssistant, Content: text}
+			for _, c := range m.ToolCalls {
+				msg.ToolCalls = append(msg.ToolCalls, llm.ToolCall{
+					ID:        c.ID,
+					Name:      c.Function.Name,
+					Arguments: json.RawMessage(c.Function.Arguments),
+				})
+			}
+			history = append(history, msg)
+		case "tool":
+			history = append(history, llm.Message{Role: llm.RoleTool, ToolCallID: m.ToolCallID, Content: text})
+		default:
+			return "", nil, "", fmt.Errorf("messages[%d]: unsupported role %q", i, m.Role)
+		}
+	}
+	if input == "" {
+		return "", nil, "", errors.New("the last user message is empty")
+	}
+	return strings.Join(systems, "\n\n"), history, input, nil
+}
+
+type chatUsage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+func wireChatUsage(u llm.Usage) *chatUsage {
+	return &chatUsage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.InputTokens + u.OutputTokens,
+	}
+}
+
+type chatChunk struct {
+	ID      string            `json:"id"`
+	Object  string            `json:"object"`
+	Created int64             `json:"created"`
+	Model   string            `json:"model"`
+	Choices []chatChunkChoice `json:"choices"`
+	Usage   *chatUsage        `json:"usage,omitempty"`
+}
+
+type chatChunkChoice struct {
+	Index        int            `json:"index"`
+	Delta        chatChunkDelta `json:"delta"`
+	FinishReason *string        `json:"finish_reason"`
+}
+
+type chatChunkDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type chatCompletion struct {
+	ID      string       `json:"id"`
+	Object  string       `json:"object"`
+	Created int64        `json:"created"`
+	Model   string       `json:"model"`
+	Choices []chatChoice `json:"choices"`
+	Usage   *chatUsage   `json:"usage"`
+}
+
+type chatChoice struct {
+	Index        int             `json:"index"`
+	Message      chatReplyObject `json:"message"`
+	FinishReason string          `json:"finish_reason"`
+}
+
+type chatReplyObject struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatError struct {
+	Error chatErrorDetail `json:"error"`
+}
+
+type chatErrorDetail struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+func writeChatError(w http.ResponseWriter, code int, typ, msg string) {
+	writeJSON(w, code, chatError{Error: chatErrorDetail{Message: msg, Type: typ}})
+}
+
+// finishReason maps a run outcome to a Chat Completions finish reason. A run
+// that hit a limit still returns its text, like a completion cut off at
+// max_tokens.
+func finishReason(err error) (string, bool) {
+	switch {
+	case err == nil:
+		return "stop", true
+	case errors.Is(err, runner.ErrLimitExhausted):
+		return "length", true
+	default:
+		return "", false
+	}
+}
+
+func (s *Server) handleChatCompletions(w http.ResponseWriter, req *http.Request) {
+	var body chatRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxRequestBytes)).Decode(&body); err != nil {
+		writeChatError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	system, history, input, err := body.conversation()
+	if err != nil {
+		writeChatError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	id, err := newRunID()
+	if err != nil {
+		writeChatError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	switch err := s.acquire(); {
+	case errors.Is(err, errTooManyRuns):
+		writeChatError(w, http.StatusTooManyRequests, "rate_limit_error", err.Error())
+		return
+	case err != nil:
+		writeChatError(w, http.StatusServiceUnavailable, "server_error", err.Error())
+		return
+	}
+	defer s.release()
+
+	// The run ends with the request or when the server shuts down.
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	stop := context.AfterFunc(s.ctx, cancel)
+	defer stop()
+
+	instructions := s.cfg.Instructions
+	if system != "" {
+		instructions += "\n\n" + system
+	}
+	runReq := runner.RunRequest{
+		RunID:        id,
+		Instructions: instructions,
+		Model:        s.cfg.Model,
+		Input:        input,
+		History:      history,
+		Tools:        s.cfg.Tools,
+	}
+	slog.Info("chat completion started", "run_id", id, "stream", body.Stream)
+	var result runner.RunResult
+	if body.Stream {
+		includeUsage := body.StreamOptions != nil && body.StreamOptions.IncludeUsage
+		result, err = s.streamChat(ctx, w, runReq, includeUsage)
+	} else {
+		result, err = s.completeChat(ctx, w, runReq)
+	}
+	if err != nil {
+		slog.Info("chat completion failed", "run_id", id, "error", err)
+	} else {
+		slog.Info("chat completion finished", "run_id", id, "turns", result.Turns)
+	}
+}
+
+// completeChat answers with the run's final assistant message; text of
+// earlier turns that called tools is left out.
+func (s *Server) completeChat(ctx context.Context, w http.ResponseWriter, runReq runner.RunRequest) (runner.RunResult, error) {
+	result, err := s.cfg.NewRunner().Run(ctx, runReq, func(runner.Event) {})
+	reason, ok := finishReason(err)
+	if !ok {
+		writeChatError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return result, err
+	}
+	writeJSON(w, http.StatusOK, chatCompletion{
+		ID:      runReq.RunID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   s.cfg.Model,
+		Choices: []chatChoice{{
+			Message:      chatReplyObject{Role: "assistant", Content: finalText(result.Messages)},
+			FinishReason: reason,
+		}},
+		Usage: wireChatUsage(result.Usage),
+	})
+	return result, err
+}
+
+// streamChat streams assistant text as chat.completion.chunk events, in the
+// format internal/provider/openai decodes: a role chunk, content chunks, a
+// chunk with the finish reason, an optional usage chunk with no choices,
+// and [DONE]. A run failure ends the stream with an error object instead.
+func (s *Server) streamChat(ctx context.Context, w http.ResponseWriter, runReq runner.RunRequest, includeUsage bool) (runner.RunResult, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeChatError(w, http.StatusInternalServerError, "server_error", "streaming unsupported")
+		return runner.RunResult{}, errors.New("streaming unsupported")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	created := time.Now().Unix()
+	send := func(choices []chatChunkChoice, u *chatUsage) {
+		b, err := json.Marshal(chatChunk{
+			ID:      runReq.RunID,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   s.cfg.Model,
+			Choices: choices,
+			Usage:   u,
+		})
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "data: %s\n\n", b)
+		flusher.Flush()
+	}
+
+	send([]chatChunkChoice{{Delta: chatChunkDelta{Role: "assistant"}}}, nil)
+	result, err := s.cfg.NewRunner().Run(ctx, runReq, func(ev runner.Event) {
+		if ev.Kind == runner.EventTextDelta && ev.Text != "" {
+			send([]chatChunkChoice{{Delta: chatChunkDelta{Content: ev.Text}}}, nil)
+		}
+	})
+	reason, ok := finishReason(err)
+	if !ok {
+		b, _ := json.Marshal(chatError{Error: chatErrorDetail{Message: err.Error(), Type: "server_error"}})
+		fmt.Fprintf(w, "data: %s\n\n", b)
+		flusher.Flush()
+		return result, err
+	}
+	send([]chatChunkChoice{{FinishReason: &reason}}, nil)
+	if includeUsage {
+		send([]chatChunkChoice{}, wireChatUsage(result.Usage))
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+	return result, err
+}
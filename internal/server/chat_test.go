@@ -0,0 +1,192 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/provider/openai"
+	"github.com/chtushar/pingu/internal/server"
+	"github.com/chtushar/pingu/internal/tools"
+)
+
+// toolThenTextProvider asks for the echo tool on the first turn and answers
+// with text on the second. It records every request.
+type toolThenTextProvider struct {
+	mu       sync.Mutex
+	requests []llm.Request
+}
+
+func (p *toolThenTextProvider) Stream(_ context.Context, req llm.Request) (llm.Stream, error) {
+	p.mu.Lock()
+	p.requests = append(p.requests, req)
+	call := len(p.requests)
+	p.mu.Unlock()
+	if call == 1 {
+		return llm.NewSliceStream([]llm.Event{
+			{Type: llm.EventTextDelta, Text: "Checking. "},
+			{Type: llm.EventToolCallStart, ToolCallID: "c1", ToolName: "echo"},
+			{Type: llm.EventToolCallDelta, ArgumentsDelta: `{"value":"pong"}`},
+			{Type: llm.EventToolCallEnd},
+			{Type: llm.EventUsage, Usage: llm.Usage{InputTokens: 10, OutputTokens: 3}},
+		}), nil
+	}
+	return llm.NewSliceStream([]llm.Event{
+		{Type: llm.EventTextDelta, Text: "It said pong."},
+		{Type: llm.EventUsage, Usage: llm.Usage{InputTokens: 20, OutputTokens: 4}},
+	}), nil
+}
+
+type echoTool struct{}
+
+func (echoTool) Name() string                { return "echo" }
+func (echoTool) Description() string         { return "echoes value" }
+func (echoTool) Parameters() json.RawMessage { return json.RawMessage(`{"type":"object"}`) }
+func (echoTool) Run(_ context.Context, args json.RawMessage) (string, error) {
+	var in struct{ Value string }
+	err := json.Unmarshal(args, &in)
+	return in.Value, err
+}
+
+func newChatServer(t *testing.T) (*toolThenTextProvider, string) {
+	t.Helper()
+	reg, err := tools.NewRegistry(echoTool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &toolThenTextProvider{}
+	_, ts := newServer(t, server.Config{Instructions: "be brief", NewRunner: runnerFor(p), Model: "agent-model", Tools: reg})
+	return p, ts.URL
+}
+
+// The streaming format is checked by decoding it with the OpenAI adapter.
+func TestChatCompletionsStreamRoundTrip(t *testing.T) {
+	upstream, base := newChatServer(t)
+	client, err := openai.New(openai.Options{APIKey: "unused", BaseURL: base + "/v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := client.Stream(context.Background(), llm.Request{
+		Model:  "anything",
+		System: "answer in English",
+		Messages: []llm.Message{
+			{Role: llm.RoleUser, Content: "earlier question"},
+			{Role: llm.RoleAssistant, Content: "earlier answer"},
+			{Role: llm.RoleUser, Content: "ping"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var text strings.Builder
+	var usage llm.Usage
+	for {
+		ev, err := s.Next(context.Background())
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch ev.Type {
+		case llm.EventTextDelta:
+			text.WriteString(ev.Text)
+		case llm.EventUsage:
+			usage = ev.Usage
+		case llm.EventToolCallStart:
+			t.Errorf("tool call leaked to the client: %+v", ev)
+		}
+	}
+	if text.String() != "Checking. It said pong." {
+		t.Errorf("text = %q", text.String())
+	}
+	if usage.InputTokens != 30 || usage.OutputTokens != 7 {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	first := upstream.requests[0]
+	if first.System != "be brief\n\nanswer in English" || first.Model != "agent-model" {
+		t.Errorf("system = %q, model = %q", first.System, first.Model)
+	}
+	if len(first.Messages) != 3 || first.Messages[0].Content != "earlier question" || first.Messages[2].Content != "ping" {
+		t.Errorf("messages = %+v", first.Messages)
+	}
+	if last := upstream.requests[1].Messages; last[len(last)-1].Content != "pong" {
+		t.Errorf("tool result = %+v", last[len(last)-1])
+	}
+}
+
+func TestChatCompletionsNonStreaming(t *testing.T) {
+	_, base := newChatServer(t)
+	resp, err := http.Post(base+"/v1/chat/completions", "application/json", strings.NewReader(
+		`{"model":"x","temperature":0.2,"messages":[{"role":"user","content":[{"type":"text","text":"ping"}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Object  string
+		Model   string
+		Choices []struct {
+			Message      struct{ Role, Content string }
+			FinishReason string `json:"finish_reason"`
+		}
+		Usage struct {
+			PromptTokens     int64 `json:"prompt_tokens"`
+			CompletionTokens int64 `json:"completion_tokens"`
+			TotalTokens      int64 `json:"total_tokens"`
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Object != "chat.completion" || body.Model != "agent-model" {
+		t.Fatalf("status = %d, body = %+v", resp.StatusCode, body)
+	}
+	// Only the final answer: "Checking. " came with the tool call.
+	if len(body.Choices) != 1 || body.Choices[0].Message.Content != "It said pong." || body.Choices[0].FinishReason != "stop" {
+		t.Errorf("choices = %+v", body.Choices)
+	}
+	if body.Usage.PromptTokens != 30 || body.Usage.CompletionTokens != 7 || body.Usage.TotalTokens != 37 {
+		t.Errorf("usage = %+v", body.Usage)
+	}
+}
+
+func TestChatCompletionsInvalidRequests(t *testing.T) {
+	_, base := newChatServer(t)
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"malformed", `{`},
+		{"no messages", `{"messages":[]}`},
+		{"last not user", `{"messages":[{"role":"user","content":"a"},{"role":"assistant","content":"b"}]}`},
+		{"empty input", `{"messages":[{"role":"user","content":""}]}`},
+		{"image part", `{"messages":[{"role":"user","content":[{"type":"image_url","image_url":{"url":"x"}}]}]}`},
+		{"unknown role", `{"messages":[{"role":"narrator","content":"a"},{"role":"user","content":"b"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(base+"/v1/chat/completions", "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var body struct {
+				Error struct{ Message, Type string }
+			}
+			json.NewDecoder(resp.Body).Decode(&body)
+			if resp.StatusCode != http.StatusBadRequest || body.Error.Type != "invalid_request_error" || body.Error.Message == "" {
+				t.Errorf("status = %d, body = %+v", resp.StatusCode, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,497 @@
+// Package server exposes agent runs over HTTP. A run's runner events are
+// streamed to the client as Server-Sent Events; the final result stays
+// available for later retrieval until it ages out of a bounded history.
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/runner"
+	"github.com/chtushar/pingu/internal/tools"
+)
+
+// Defaults for Config fields left zero.
+const (
+	DefaultMaxConcurrentRuns = 4
+	DefaultRetainedRuns      = 100
+	maxRequestBytes          = 1 << 20
+)
+
+// Config describes the agent a Server runs.
+type Config struct {
+	Instructions string
+	Model        string          // provider-side model id
+	Tools        *tools.Registry // may be nil
+	// NewRunner returns a fresh runner.Runner for each run: the provider,
+	// limits, generation parameters, pricing, and context management.
+	// Runners are for sequential use, so concurrent runs never share one.
+	NewRunner func() *runner.Runner
+
+	// MaxConcurrentRuns bounds runs in flight; further requests get 429.
+	MaxConcurrentRuns int
+	// RetainedRuns bounds how many finished runs remain queryable.
+	RetainedRuns int
+	// Token, if set, must be sent as "Authorization: Bearer <Token>" with
+	// every request; others get 401.
+	Token string
+}
+
+// Server serves runs over HTTP. Every run gets its own runner.Runner, so
+// requests may run concurrently up to Config.MaxConcurrentRuns.
+type Server struct {
+	cfg Config
+	// ctx is the parent of every run's context; Close cancels it.
+	ctx       context.Context
+	cancelAll context.CancelFunc
+
+	mu       sync.Mutex
+	runs     map[string]*run
+	finished []string // IDs of finished runs, oldest first
+	active   int
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// New returns a Server for cfg.
+func New(cfg Config) *Server {
+	if cfg.MaxConcurrentRuns <= 0 {
+		cfg.MaxConcurrentRuns = DefaultMaxConcurrentRuns
+	}
+	if cfg.RetainedRuns <= 0 {
+		cfg.RetainedRuns = DefaultRetainedRuns
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{cfg: cfg, ctx: ctx, cancelAll: cancel, runs: map[string]*run{}}
+}
+
+// Handler returns the HTTP routes:
+//
+//	POST   /v1/runs       start a run and stream its events as SSE
+//	GET    /v1/runs/{id}  the run's status and, once finished, its result
+//	DELETE /v1/runs/{id}  cancel the run
+//	POST   /v1/chat/completions  OpenAI-compatible Chat Completions
+//
+// With Config.Token set, every route requires the bearer token.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/runs", s.handleCreateRun)
+	mux.HandleFunc("GET /v1/runs/{id}", s.handleGetRun)
+	mux.HandleFunc("DELETE /v1/runs/{id}", s.handleCancelRun)
+	mux.HandleFunc("POST /v1/chat/completions", s.handleChatCompletions)
+	if s.cfg.Token == "" {
+		return mux
+	}
+	return s.requireToken(mux)
+}
+
+// requireToken rejects requests without the configured bearer token. Chat
+// Completions clients send their API key that way, so they get an
+// OpenAI-style error.
+func (s *Server) requireToken(next http.Handler) http.Handler {
+	want := []byte("Bearer " + s.cfg.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="pingu"`)
+		const msg = "missing or invalid bearer token"
+		if strings.HasPrefix(r.URL.Path, "/v1/chat/") {
+			writeChatError(w, http.StatusUnauthorized, "invalid_request_error", msg)
+			return
+		}
+		writeError(w, http.StatusUnauthorized, msg)
+	})
+}
+
+// Close cancels every run in flight, waits for them to finish, and rejects
+// new runs. Call it before shutting down the HTTP server so that streaming
+// responses end.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.cancelAll()
+	s.wg.Wait()
+}
+
+var (
+	errTooManyRuns  = errors.New("too many concurrent runs")
+	errShuttingDown = errors.New("server is shutting down")
+)
+
+// acquire reserves a slot for a run in flight. Every successful acquire
+// must be paired with release.
+func (s *Server) acquire() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errShuttingDown
+	}
+	if s.active >= s.cfg.MaxConcurrentRuns {
+		return fmt.Errorf("%w (max %d)", errTooManyRuns, s.cfg.MaxConcurrentRuns)
+	}
+	s.active++
+	s.wg.Add(1)
+	return nil
+}
+
+func (s *Server) release() {
+	s.mu.Lock()
+	s.active--
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+// RunStatus values reported by GET /v1/runs/{id}.
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// run is one run's event log and outcome. emit appends under mu and wakes
+// every stream waiting on changed.
+type run struct {
+	id      string
+	cancel  context.CancelFunc
+	created time.Time
+
+	mu       sync.Mutex
+	events   []runner.Event
+	changed  chan struct{} // closed and replaced whenever events or done change
+	done     bool
+	result   runner.RunResult
+	err      error
+	finished time.Time
+}
+
+func (r *run) emit(ev runner.Event) {
+	r.mu.Lock()
+	r.events = append(r.events, ev)
+	close(r.changed)
+	r.changed = make(chan struct{})
+	r.mu.Unlock()
+}
+
+func (r *run) finish(result runner.RunResult, err error) {
+	r.mu.Lock()
+	r.done, r.result, r.err, r.finished = true, result, err, time.Now()
+	close(r.changed)
+	r.changed = make(chan struct{})
+	r.mu.Unlock()
+}
+
+// since returns the events from index next on, a channel that is closed on
+// the next change, and whether the run has finished.
+func (r *run) since(next int) ([]runner.Event, <-chan struct{}, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := append([]runner.Event(nil), r.events[min(next, len(r.events)):]...)
+	return events, r.changed, r.done
+}
+
+func (r *run) status() runStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := runStatus{ID: r.id, Status: StatusRunning, CreatedAt: r.created}
+	if !r.done {
+		return st
+	}
+	finished := r.finished
+	st.FinishedAt = &finished
+	st.Turns = r.result.Turns
+	st.Models = r.result.Models
+	st.CostUSD = r.result.CostUSD
+	st.Usage = wireUsage(r.result.Usage)
+	st.Messages = wireMessages(r.result.Messages)
+	st.Output = finalText(r.result.Messages)
+	switch {
+	case r.err == nil:
+		st.Status = StatusSucceeded
+	case errors.Is(r.err, context.Canceled):
+		st.Status = StatusCancelled
+		st.Error = r.err.Error()
+	default:
+		st.Status = StatusFailed
+		st.Error = r.err.Error()
+	}
+	return st
+}
+
+type createRunRequest struct {
+	Input string `json:"input"`
+}
+
+func (s *Server) handleCreateRun(w http.ResponseWriter, req *http.Request) {
+	var body createRunRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxRequestBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if body.Input == "" {
+		writeError(w, http.StatusBadRequest, "input is required")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	r, err := s.start(body.Input)
+	switch {
+	case errors.Is(err, errTooManyRuns):
+		writeError(w, http.StatusTooManyRequests, err.Error())
+		return
+	case errors.Is(err, errShuttingDown):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Location", "/v1/runs/"+r.id)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// The run is detached from the request: a client that disconnects can
+	// still fetch the result or cancel the run by ID.
+	next := 0
+	for {
+		events, changed, done := r.since(next)
+		for _, ev := range events {
+			if err := writeEvent(w, r.id, next, ev); err != nil {
+				return
+			}
+			next++
+		}
+		flusher.Flush()
+		if done {
+			return
+		}
+		select {
+		case <-changed:
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// start registers a new run and starts it in the background.
+func (s *Server) start(input string) (*run, error) {
+	id, err := newRunID()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	r := &run{id: id, cancel: cancel, created: time.Now(), changed: make(chan struct{})}
+	s.mu.Lock()
+	s.runs[id] = r
+	s.mu.Unlock()
+
+	slog.Info("run started", "run_id", id)
+	go func() {
+		defer s.release()
+		defer cancel()
+		result, err := s.cfg.NewRunner().Run(ctx, runner.RunRequest{
+			RunID:        id,
+			Instructions: s.cfg.Instructions,
+			Model:        s.cfg.Model,
+			Input:        input,
+			Tools:        s.cfg.Tools,
+		}, r.emit)
+		// Retire before finishing so that a client that saw the stream end
+		// also sees the retention bound applied.
+		s.retire(id)
+		r.finish(result, err)
+		if err != nil {
+			slog.Info("run failed", "run_id", id, "error", err)
+		} else {
+			slog.Info("run finished", "run_id", id, "turns", result.Turns)
+		}
+	}()
+	return r, nil
+}
+
+// retire drops the oldest finished runs beyond the retention bound.
+func (s *Server) retire(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finished = append(s.finished, id)
+	for len(s.finished) > s.cfg.RetainedRuns {
+		delete(s.runs, s.finished[0])
+		s.finished = s.finished[1:]
+	}
+}
+
+func (s *Server) lookup(id string) *run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.runs[id]
+}
+
+func (s *Server) handleGetRun(w http.ResponseWriter, req *http.Request) {
+	r := s.lookup(req.PathValue("id"))
+	if r == nil {
+		writeError(w, http.StatusNotFound, "run not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, r.status())
+}
+
+func (s *Server) handleCancelRun(w http.ResponseWriter, req *http.Request) {
+	r := s.lookup(req.PathValue("id"))
+	if r == nil {
+		writeError(w, http.StatusNotFound, "run not found")
+		return
+	}
+	r.cancel()
+	slog.Info("run cancel requested", "run_id", r.id)
+	writeJSON(w, http.StatusAccepted, r.status())
+}
+
+// runStatus is the JSON body of GET and DELETE /v1/runs/{id}.
+type runStatus struct {
+	ID         string        `json:"id"`
+	Status     string        `json:"status"`
+	Output     string        `json:"output,omitempty"`
+	Messages   []wireMessage `json:"messages,omitempty"`
+	Turns      int           `json:"turns,omitempty"`
+	Models     []string      `json:"models,omitempty"` // model that served each turn
+	CostUSD    float64       `json:"cost_usd,omitempty"`
+	Usage      usage         `json:"usage"`
+	Error      string        `json:"error,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+}
+
+type usage struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+}
+
+func wireUsage(u llm.Usage) usage {
+	return usage{InputTokens: u.InputTokens, OutputTokens: u.OutputTokens}
+}
+
+type wireMessage struct {
+	Role       string         `json:"role"`
+	Content    string         `json:"content,omitempty"`
+	ToolCalls  []wireToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string         `json:"tool_call_id,omitempty"`
+}
+
+type wireToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+func wireMessages(msgs []llm.Message) []wireMessage {
+	out := make([]wireMessage, 0, len(msgs))
+	for _, m := range msgs {
+		wm := wireMessage{Role: string(m.Role), Content: m.Content, ToolCallID: m.ToolCallID}
+		for _, c := range m.ToolCalls {
+			wm.ToolCalls = append(wm.ToolCalls, wireToolCall{ID: c.ID, Name: c.Name, Arguments: string(c.Arguments)})
+		}
+		out = append(out, wm)
+	}
+	return out
+}
+
+// finalText is the content of the last assistant message that is an
+// answer rather than a turn of tool calls, so text written before a tool
+// call ("Let me look that up") is not mistaken for the answer.
+func finalText(msgs []llm.Message) string {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role == llm.RoleAssistant && len(msgs[i].ToolCalls) == 0 {
+			return msgs[i].Content
+		}
+	}
+	return ""
+}
+
+// eventData is the JSON payload of one SSE event; the event name is the
+// runner event kind.
+type eventData struct {
+	RunID      string  `json:"run_id"`
+	Text       string  `json:"text,omitempty"`
+	ToolCallID string  `json:"tool_call_id,omitempty"`
+	ToolName   string  `json:"tool_name,omitempty"`
+	Result     string  `json:"result,omitempty"`
+	Turns      int     `json:"turns,omitempty"`
+	Usage      *usage  `json:"usage,omitempty"`
+	CostUSD    float64 `json:"cost_usd,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+func writeEvent(w http.ResponseWriter, runID string, seq int, ev runner.Event) error {
+	data := eventData{
+		RunID:      runID,
+		Text:       ev.Text,
+		ToolCallID: ev.ToolCallID,
+		ToolName:   ev.ToolName,
+		Result:     ev.Result,
+	}
+	if ev.Kind == runner.EventRunFinished {
+		u := wireUsage(ev.Usage)
+		data.Turns, data.Usage, data.CostUSD = ev.Turns, &u, ev.CostUSD
+		if ev.Err != nil {
+			data.Error = ev.Err.Error()
+		}
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", strconv.Itoa(seq), ev.Kind, b)
+	return err
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, errorBody{Error: msg})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Debug("write response failed", "error", err)
+	}
+}
+
+func newRunID() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("generate run id: %w", err)
+	}
+	return "run-" + hex.EncodeToString(b[:]), nil
+}
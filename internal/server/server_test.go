@@ -0,0 +1,314 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/runner"
+	"github.com/chtushar/pingu/internal/server"
+)
+
+// textProvider answers every request with a fixed text reply.
+type textProvider struct{ reply string }
+
+func (p textProvider) Stream(context.Context, llm.Request) (llm.Stream, error) {
+	return llm.NewSliceStream([]llm.Event{
+		{Type: llm.EventTextDelta, Text: p.reply},
+		{Type: llm.EventUsage, Usage: llm.Usage{InputTokens: 5, OutputTokens: 2}},
+	}), nil
+}
+
+// hangingProvider streams nothing until the context is cancelled.
+type hangingProvider struct{ started chan struct{} }
+
+func (p hangingProvider) Stream(ctx context.Context, _ llm.Request) (llm.Stream, error) {
+	p.started <- struct{}{}
+	return hangStream{}, nil
+}
+
+type hangStream struct{}
+
+func (hangStream) Next(ctx context.Context) (llm.Event, error) {
+	<-ctx.Done()
+	return llm.Event{}, ctx.Err()
+}
+
+func (hangStream) Close() error { return nil }
+
+type sseEvent struct {
+	Name string
+	Data map[string]any
+}
+
+// readEvents parses an SSE response body until it ends.
+func readEvents(t *testing.T, r io.Reader) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var cur sseEvent
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			cur.Name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &cur.Data); err != nil {
+				t.Fatalf("data %q: %v", line, err)
+			}
+		case line == "":
+			if cur.Name != "" {
+				events = append(events, cur)
+			}
+			cur = sseEvent{}
+		}
+	}
+	return events
+}
+
+// runnerFor returns a runner factory over p with default limits.
+func runnerFor(p llm.Provider) func() *runner.Runner {
+	return func() *runner.Runner { return &runner.Runner{Provider: p} }
+}
+
+func newServer(t *testing.T, cfg server.Config) (*server.Server, *httptest.Server) {
+	t.Helper()
+	s := server.New(cfg)
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		s.Close()
+		ts.Close()
+	})
+	return s, ts
+}
+
+func postRun(t *testing.T, base, body string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(base+"/v1/runs", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func getStatus(t *testing.T, method, url string) (int, map[string]any) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body map[string]any
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+func TestCreateRunStreamsEvents(t *testing.T) {
+	_, ts := newServer(t, server.Config{NewRunner: runnerFor(textProvider{reply: "hello"}), Model: "m"})
+
+	resp := postRun(t, ts.URL, `{"input":"hi"}`)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, content-type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	location := resp.Header.Get("Location")
+	events := readEvents(t, resp.Body)
+
+	var names []string
+	for _, ev := range events {
+		names = append(names, ev.Name)
+	}
+	if got := strings.Join(names, ","); got != "run_started,text_delta,run_finished" {
+		t.Fatalf("events = %s", got)
+	}
+	runID := events[0].Data["run_id"].(string)
+	if location != "/v1/runs/"+runID || events[0].Data["text"] != runID {
+		t.Errorf("location = %q, run_started = %v", location, events[0].Data)
+	}
+	if events[1].Data["text"] != "hello" {
+		t.Errorf("text_delta = %v", events[1].Data)
+	}
+	if u, _ := events[2].Data["usage"].(map[string]any); u["input_tokens"] != 5.0 {
+		t.Errorf("run_finished = %v", events[2].Data)
+	}
+
+	code, status := getStatus(t, http.MethodGet, ts.URL+location)
+	if code != http.StatusOK || status["status"] != server.StatusSucceeded || status["output"] != "hello" {
+		t.Errorf("GET = %d %v", code, status)
+	}
+	if msgs, _ := status["messages"].([]any); len(msgs) != 1 {
+		t.Errorf("messages = %v", status["messages"])
+	}
+}
+
+func TestCancelRun(t *testing.T) {
+	started := make(chan struct{}, 1)
+	_, ts := newServer(t, server.Config{NewRunner: runnerFor(hangingProvider{started: started}), Model: "m"})
+
+	resp := postRun(t, ts.URL, `{"input":"wait"}`)
+	defer resp.Body.Close()
+	location := resp.Header.Get("Location")
+	<-started
+
+	code, status := getStatus(t, http.MethodGet, ts.URL+location)
+	if code != http.StatusOK || status["status"] != server.StatusRunning {
+		t.Fatalf("GET while running = %d %v", code, status)
+	}
+	if code, _ := getStatus(t, http.MethodDelete, ts.URL+location); code != http.StatusAccepted {
+		t.Fatalf("DELETE = %d", code)
+	}
+
+	events := readEvents(t, resp.Body)
+	last := events[len(events)-1]
+	if last.Name != "run_finished" || !strings.Contains(last.Data["error"].(string), "canceled") {
+		t.Errorf("last event = %+v", last)
+	}
+	_, status = getStatus(t, http.MethodGet, ts.URL+location)
+	if status["status"] != server.StatusCancelled {
+		t.Errorf("status after cancel = %v", status)
+	}
+}
+
+func TestConcurrentRunLimit(t *testing.T) {
+	started := make(chan struct{}, 1)
+	s, ts := newServer(t, server.Config{
+		NewRunner:         runnerFor(hangingProvider{started: started}),
+		Model:             "m",
+		MaxConcurrentRuns: 1,
+	})
+	first := postRun(t, ts.URL, `{"input":"one"}`)
+	defer first.Body.Close()
+	<-started
+
+	second := postRun(t, ts.URL, `{"input":"two"}`)
+	second.Body.Close()
+	if second.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("second run status = %d, want 429", second.StatusCode)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not cancel the running run")
+	}
+	after := postRun(t, ts.URL, `{"input":"three"}`)
+	after.Body.Close()
+	if after.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("run after Close status = %d, want 503", after.StatusCode)
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	_, ts := newServer(t, server.Config{NewRunner: runnerFor(textProvider{}), Model: "m"})
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"empty input", http.MethodPost, "/v1/runs", `{"input":""}`, http.StatusBadRequest},
+		{"malformed body", http.MethodPost, "/v1/runs", `{`, http.StatusBadRequest},
+		{"unknown field", http.MethodPost, "/v1/runs", `{"input":"x","stream":true}`, http.StatusBadRequest},
+		{"unknown run", http.MethodGet, "/v1/runs/run-missing", "", http.StatusNotFound},
+		{"cancel unknown run", http.MethodDelete, "/v1/runs/run-missing", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var body struct{ Error string }
+			json.NewDecoder(resp.Body).Decode(&body)
+			if resp.StatusCode != tt.want || body.Error == "" {
+				t.Errorf("status = %d, error = %q; want %d", resp.StatusCode, body.Error, tt.want)
+			}
+		})
+	}
+}
+
+func TestToken(t *testing.T) {
+	_, ts := newServer(t, server.Config{NewRunner: runnerFor(textProvider{}), Model: "m", Token: "s3cret"})
+	tests := []struct {
+		name  string
+		path  string
+		auth  string
+		want  int
+		field string // error field of the 401 body
+	}{
+		{"no token", "/v1/runs/run-missing", "", http.StatusUnauthorized, "error"},
+		{"wrong token", "/v1/runs/run-missing", "Bearer nope", http.StatusUnauthorized, "error"},
+		{"not bearer", "/v1/runs/run-missing", "s3cret", http.StatusUnauthorized, "error"},
+		{"chat without token", "/v1/chat/completions", "", http.StatusUnauthorized, "error.message"},
+		{"valid token", "/v1/runs/run-missing", "Bearer s3cret", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := http.MethodGet
+			if strings.HasPrefix(tt.path, "/v1/chat/") {
+				method = http.MethodPost
+			}
+			req, _ := http.NewRequest(method, ts.URL+tt.path, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if tt.want != http.StatusUnauthorized {
+				return
+			}
+			if resp.Header.Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate header")
+			}
+			var body map[string]any
+			json.NewDecoder(resp.Body).Decode(&body)
+			msg := body["error"]
+			if tt.field == "error.message" {
+				detail, _ := msg.(map[string]any)
+				msg = detail["message"]
+			}
+			if s, _ := msg.(string); s == "" {
+				t.Errorf("body = %v, want %s", body, tt.field)
+			}
+		})
+	}
+}
+
+func TestRetainedRuns(t *testing.T) {
+	_, ts := newServer(t, server.Config{NewRunner: runnerFor(textProvider{reply: "ok"}), Model: "m", RetainedRuns: 1})
+	var locations []string
+	for range 2 {
+		resp := postRun(t, ts.URL, `{"input":"hi"}`)
+		readEvents(t, resp.Body)
+		resp.Body.Close()
+		locations = append(locations, resp.Header.Get("Location"))
+	}
+	if code, _ := getStatus(t, http.MethodGet, ts.URL+locations[0]); code != http.StatusNotFound {
+		t.Errorf("oldest run = %d, want 404", code)
+	}
+	if code, _ := getStatus(t, http.MethodGet, ts.URL+locations[1]); code != http.StatusOK {
+		t.Errorf("newest run = %d, want 200", code)
+	}
+}
@@ -0,0 +1,222 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/runner"
+)
+
+// Conversation is the message history of one stored session. Exchange runs
+// input against that history and saves what the run produced. A
+// Conversation is not safe for concurrent use.
+type Conversation struct {
+	Store   *Store
+	Session *Session
+	Model   string // model reference recorded with each run
+	msgs    []llm.Message
+
+	// A pending Rewind: the run the next exchange replaces, and the history
+	// to return to if it fails.
+	replace string
+	restore []llm.Message
+}
+
+// OpenConversation resumes the session named by ref (an ID or name),
+// creating it when missing. With fresh, or without ref, it starts a new
+// session instead.
+func OpenConversation(ctx context.Context, store *Store, ref string, fresh bool) (*Conversation, error) {
+	c := &Conversation{Store: store}
+	if ref != "" && !fresh {
+		sess, err := store.Get(ctx, ref)
+		switch {
+		case err == nil:
+			msgs, err := store.Messages(ctx, sess.ID)
+			if err != nil {
+				return nil, err
+			}
+			c.Session, c.msgs = sess, msgs
+			return c, nil
+		case !errors.Is(err, ErrNotFound):
+			return nil, err
+		}
+	}
+	sess, err := store.Create(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	c.Session = sess
+	return c, nil
+}
+
+// Messages returns the conversation history as it is sent to the model.
+func (c *Conversation) Messages() []llm.Message { return c.msgs }
+
+// Label names the session for humans: its name when it has one.
+func (c *Conversation) Label() string {
+	if c.Session.Name != "" {
+		return c.Session.Name
+	}
+	return c.Session.ID
+}
+
+// Exchange runs req with the conversation as history. On success the input
+// and everything the run produced are saved to the session before they join
+// the in-memory history, which then starts from the run's possibly
+// compacted RunResult.History. After a Rewind, the saved exchange replaces
+// the rewound one; if the run or the save fails, the rewound exchange is
+// back in the history.
+func (c *Conversation) Exchange(ctx context.Context, r *runner.Runner, req runner.RunRequest, emit func(runner.Event)) (runner.RunResult, error) {
+	replace, restore := c.replace, c.restore
+	c.replace, c.restore = "", nil
+	result, err := c.exchange(ctx, r, req, emit, replace)
+	if err != nil && replace != "" {
+		c.msgs = restore
+	}
+	return result, err
+}
+
+func (c *Conversation) exchange(ctx context.Context, r *runner.Runner, req runner.RunRequest, emit func(runner.Event), replace string) (runner.RunResult, error) {
+	req.History = c.msgs
+	started := time.Now()
+	result, err := r.Run(ctx, req, emit)
+	if err != nil {
+		return result, err
+	}
+	msgs := make([]llm.Message, 0, len(result.Messages)+1)
+	msgs = append(msgs, llm.Message{Role: llm.RoleUser, Content: req.Input})
+	msgs = append(msgs, result.Messages...)
+	run := Run{
+		ID:         req.RunID,
+		Model:      c.Model,
+		Usage:      result.Usage,
+		Turns:      result.Turns,
+		StartedAt:  started,
+		FinishedAt: time.Now(),
+	}
+	// The run context may already be cancelled by the time the run is
+	// saved; persistence must not be.
+	saveCtx := context.WithoutCancel(ctx)
+	if replace != "" {
+		err = c.Store.ReplaceRun(saveCtx, c.Session.ID, replace, run, msgs)
+	} else {
+		err = c.Store.AppendRun(saveCtx, c.Session.ID, run, msgs)
+	}
+	if err != nil {
+		return result, fmt.Errorf("save session: %w", err)
+	}
+	// The store keeps every message; the in-memory history goes on from the
+	// one the run sent, so a compaction is not redone on the next exchange.
+	c.msgs = append(slices.Clip(result.History), msgs...)
+	return result, nil
+}
+
+// Undo removes the last exchange from the session and the history and
+// returns its input. It fails with ErrEmpty when there is nothing to undo.
+// The history is reloaded from the store, so a compaction is redone on the
+// next exchange.
+func (c *Conversation) Undo(ctx context.Context) (string, error) {
+	c.cancelRewind()
+	dropped, err := c.Store.DropLastRun(ctx, c.Session.ID)
+	if err != nil {
+		return "", err
+	}
+	msgs, err := c.Store.Messages(ctx, c.Session.ID)
+	if err != nil {
+		return "", err
+	}
+	c.msgs = msgs
+	return inputOf(dropped), nil
+}
+
+// Rewind takes the last exchange out of the history and returns its input,
+// for the next Exchange to run without it. The session keeps the exchange
+// until that Exchange succeeds and replaces it; if it fails or is
+// cancelled, the exchange is back in the history. Rewind fails with
+// ErrEmpty when there is no exchange.
+func (c *Conversation) Rewind(ctx context.Context) (string, error) {
+	c.cancelRewind()
+	runID, last, err := c.Store.lastRun(ctx, c.Session.ID)
+	if err != nil {
+		return "", err
+	}
+	msgs, err := c.Store.Messages(ctx, c.Session.ID)
+	if err != nil {
+		return "", err
+	}
+	c.replace, c.restore = runID, c.msgs
+	c.msgs = msgs[:len(msgs)-len(last)]
+	return inputOf(last), nil
+}
+
+// cancelRewind undoes a Rewind no Exchange followed.
+func (c *Conversation) cancelRewind() {
+	if c.replace != "" {
+		c.msgs, c.replace, c.restore = c.restore, "", nil
+	}
+}
+
+// LastInput returns the input of the last exchange. It fails with ErrEmpty
+// when there is none.
+func (c *Conversation) LastInput(ctx context.Context) (string, error) {
+	msgs, err := c.Store.LastRun(ctx, c.Session.ID)
+	if err != nil {
+		return "", err
+	}
+	return inputOf(msgs), nil
+}
+
+// inputOf returns the input that started an exchange: its first user
+// message.
+func inputOf(exchange []llm.Message) string {
+	for _, m := range exchange {
+		if m.Role == llm.RoleUser {
+			return m.Content
+		}
+	}
+	return ""
+}
+
+// Branch forks the session into a new session named name and continues the
+// conversation there; the original session keeps its history.
+func (c *Conversation) Branch(ctx context.Context, name string) error {
+	c.cancelRewind()
+	sess, err := c.Store.Fork(ctx, c.Session.ID, name)
+	if err != nil {
+		return err
+	}
+	c.Session = sess
+	return nil
+}
+
+// Switch continues the conversation in the stored session named by ref, an
+// ID or name, with its history.
+func (c *Conversation) Switch(ctx context.Context, ref string) error {
+	c.cancelRewind()
+	sess, err := c.Store.Get(ctx, ref)
+	if err != nil {
+		return err
+	}
+	msgs, err := c.Store.Messages(ctx, sess.ID)
+	if err != nil {
+		return err
+	}
+	c.Session, c.msgs = sess, msgs
+	return nil
+}
+
+// Clear continues the conversation in a new, unnamed session with an empty
+// history; the current session stays stored.
+func (c *Conversation) Clear(ctx context.Context) error {
+	c.cancelRewind()
+	sess, err := c.Store.Create(ctx, "")
+	if err != nil {
+		return err
+	}
+	c.Session, c.msgs = sess, nil
+	return nil
+}
@@ -0,0 +1,170 @@
+package session_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/runner"
+	"github.com/chtushar/pingu/internal/session"
+)
+
+// replyProvider answers every request with reply, or fails with err.
+type replyProvider struct {
+	reply string
+	err   error
+	last  llm.Request
+}
+
+func (p *replyProvider) Stream(_ context.Context, req llm.Request) (llm.Stream, error) {
+	p.last = req
+	if p.err != nil {
+		return nil, p.err
+	}
+	return llm.NewSliceStream([]llm.Event{{Type: llm.EventTextDelta, Text: p.reply}}), nil
+}
+
+func TestConversationExchangeAndResume(t *testing.T) {
+	ctx := context.Background()
+	store := openStore(t)
+	p := &replyProvider{reply: "noted"}
+	r := &runner.Runner{Provider: p}
+
+	conv, err := session.OpenConversation(ctx, store, "work", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conv.Model = "openai/test"
+	if _, err := conv.Exchange(ctx, r, runner.RunRequest{RunID: "run-1", Input: "remember 7"}, func(runner.Event) {}); err != nil {
+		t.Fatal(err)
+	}
+	if len(conv.Messages()) != 2 || conv.Label() != "work" {
+		t.Fatalf("messages = %+v, label = %q", conv.Messages(), conv.Label())
+	}
+
+	resumed, err := session.OpenConversation(ctx, store, "work", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Session.ID != conv.Session.ID || len(resumed.Messages()) != 2 {
+		t.Fatalf("resumed %s with %d messages", resumed.Session.ID, len(resumed.Messages()))
+	}
+	resumed.Exchange(ctx, r, runner.RunRequest{RunID: "run-2", Input: "what was it?"}, func(runner.Event) {})
+	if len(p.last.Messages) != 3 || p.last.Messages[0].Content != "remember 7" {
+		t.Errorf("history sent = %+v", p.last.Messages)
+	}
+
+	fresh, err := session.OpenConversation(ctx, store, "work", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh.Session.ID == conv.Session.ID || len(fresh.Messages()) != 0 {
+		t.Errorf("fresh session = %s with %d messages", fresh.Session.ID, len(fresh.Messages()))
+	}
+}
+
+func TestConversationFailedRunIsNotSaved(t *testing.T) {
+	ctx := context.Background()
+	store := openStore(t)
+	conv, err := session.OpenConversation(ctx, store, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &runner.Runner{Provider: &replyProvider{err: errors.New("boom")}}
+	if _, err := conv.Exchange(ctx, r, runner.RunRequest{RunID: "run-1", Input: "hi"}, func(runner.Event) {}); err == nil {
+		t.Fatal("expected run error")
+	}
+	msgs, _ := store.Messages(ctx, conv.Session.ID)
+	if len(msgs) != 0 || len(conv.Messages()) != 0 {
+		t.Errorf("failed run saved %d messages", len(msgs))
+	}
+}
+
+func TestConversationUndoBranchSwitch(t *testing.T) {
+	ctx := context.Background()
+	store := openStore(t)
+	r := &runner.Runner{Provider: &replyProvider{reply: "ok"}}
+	conv, err := session.OpenConversation(ctx, store, "main", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, input := range []string{"one", "two"} {
+		conv.Exchange(ctx, r, runner.RunRequest{RunID: fmt.Sprintf("run-%d", i), Input: input}, func(runner.Event) {})
+	}
+
+	if err := conv.Branch(ctx, "alt"); err != nil || conv.Label() != "alt" {
+		t.Fatalf("branch: %v, label = %q", err, conv.Label())
+	}
+	if last, err := conv.LastInput(ctx); err != nil || last != "two" {
+		t.Errorf("last input = %q, %v", last, err)
+	}
+	if input, err := conv.Undo(ctx); err != nil || input != "two" || len(conv.Messages()) != 2 {
+		t.Fatalf("undo = %q, %v, %d messages", input, err, len(conv.Messages()))
+	}
+
+	// The original session keeps both exchanges.
+	if err := conv.Switch(ctx, "main"); err != nil || len(conv.Messages()) != 4 {
+		t.Fatalf("switch: %v, %d messages", err, len(conv.Messages()))
+	}
+	if err := conv.Switch(ctx, "nope"); !errors.Is(err, session.ErrNotFound) || conv.Label() != "main" {
+		t.Errorf("switch to a missing session: %v, label = %q", err, conv.Label())
+	}
+}
+
+func TestConversationRewind(t *testing.T) {
+	ctx := context.Background()
+	store := openStore(t)
+	p := &replyProvider{reply: "ok"}
+	r := &runner.Runner{Provider: p}
+	conv, err := session.OpenConversation(ctx, store, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conv.Rewind(ctx); !errors.Is(err, session.ErrEmpty) {
+		t.Errorf("rewind with no exchange: %v", err)
+	}
+	for i, input := range []string{"one", "two"} {
+		conv.Exchange(ctx, r, runner.RunRequest{RunID: fmt.Sprintf("run-%d", i), Input: input}, func(runner.Event) {})
+	}
+	contents := func(msgs []llm.Message) string {
+		var out []string
+		for _, m := range msgs {
+			out = append(out, m.Content)
+		}
+		return fmt.Sprint(out)
+	}
+
+	// A failed replacement leaves the session and the history as they were.
+	input, err := conv.Rewind(ctx)
+	if err != nil || input != "two" || len(conv.Messages()) != 2 {
+		t.Fatalf("rewind = %q, %v, %d messages", input, err, len(conv.Messages()))
+	}
+	p.err = errors.New("boom")
+	if _, err := conv.Exchange(ctx, r, runner.RunRequest{RunID: "run-2", Input: "two"}, func(runner.Event) {}); err == nil {
+		t.Fatal("expected run error")
+	}
+	stored, _ := store.Messages(ctx, conv.Session.ID)
+	if got := contents(stored); got != "[one ok two ok]" || contents(conv.Messages()) != got {
+		t.Errorf("after a failed replacement: stored %s, history %s", got, contents(conv.Messages()))
+	}
+
+	// A successful one replaces the exchange, and sent only the history
+	// before it.
+	p.err, p.reply = nil, "better"
+	conv.Rewind(ctx)
+	if _, err := conv.Exchange(ctx, r, runner.RunRequest{RunID: "run-3", Input: "two, again"}, func(runner.Event) {}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(p.last.Messages); n != 3 || p.last.Messages[n-1].Content != "two, again" {
+		t.Errorf("request messages = %+v", p.last.Messages)
+	}
+	stored, _ = store.Messages(ctx, conv.Session.ID)
+	if got := contents(stored); got != "[one ok two, again better]" || contents(conv.Messages()) != got {
+		t.Errorf("after the replacement: stored %s, history %s", got, contents(conv.Messages()))
+	}
+	if list, _ := store.List(ctx); len(list) != 1 || list[0].Turns != 2 {
+		t.Errorf("sessions = %+v", list)
+	}
+}
@@ -0,0 +1,144 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/chtushar/pingu/internal/llm"
+)
+
+// Export formats accepted by Export.
+const (
+	FormatJSONL    = "jsonl"
+	FormatMarkdown = "markdown"
+)
+
+// Export writes a session transcript in format (FormatJSONL or
+// FormatMarkdown).
+func Export(w io.Writer, sess *Session, entries []Entry, format string) error {
+	switch format {
+	case FormatJSONL:
+		return writeJSONL(w, entries)
+	case FormatMarkdown:
+		return writeMarkdown(w, sess, entries)
+	default:
+		return fmt.Errorf("unknown export format %q (want %s or %s)", format, FormatJSONL, FormatMarkdown)
+	}
+}
+
+// jsonlEntry is one exported line. Tool call arguments are embedded as JSON
+// when valid and as a string otherwise.
+type jsonlEntry struct {
+	Seq        int64       `json:"seq"`
+	RunID      string      `json:"run_id"`
+	CreatedAt  time.Time   `json:"created_at"`
+	Role       string      `json:"role"`
+	Content    string      `json:"content,omitempty"`
+	ToolCalls  []jsonlCall `json:"tool_calls,omitempty"`
+	ToolCallID string      `json:"tool_call_id,omitempty"`
+}
+
+type jsonlCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments any    `json:"arguments,omitempty"`
+}
+
+func writeJSONL(w io.Writer, entries []Entry) error {
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		line := jsonlEntry{
+			Seq:        e.Seq,
+			RunID:      e.RunID,
+			CreatedAt:  e.CreatedAt.UTC(),
+			Role:       string(e.Message.Role),
+			Content:    e.Message.Content,
+			ToolCallID: e.Message.ToolCallID,
+		}
+		for _, c := range e.Message.ToolCalls {
+			call := jsonlCall{ID: c.ID, Name: c.Name}
+			if json.Valid(c.Arguments) {
+				call.Arguments = json.RawMessage(c.Arguments)
+			} else if len(c.Arguments) > 0 {
+				call.Arguments = string(c.Arguments)
+			}
+			line.ToolCalls = append(line.ToolCalls, call)
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeMarkdown(w io.Writer, sess *Session, entries []Entry) error {
+	var b strings.Builder
+	title := sess.Title
+	if title == "" {
+		title = "Session " + sess.ID
+	}
+	fmt.Fprintf(&b, "# %s\n\n", title)
+	fmt.Fprintf(&b, "- ID: `%s`\n", sess.ID)
+	if sess.Name != "" {
+		fmt.Fprintf(&b, "- Name: %s\n", sess.Name)
+	}
+	fmt.Fprintf(&b, "- Created: %s\n", sess.CreatedAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Updated: %s\n", sess.UpdatedAt.UTC().Format(time.RFC3339))
+	for _, e := range entries {
+		m := e.Message
+		switch m.Role {
+		case llm.RoleUser:
+			fmt.Fprintf(&b, "\n## User\n\n%s\n", m.Content)
+		case llm.RoleAssistant:
+			b.WriteString("\n## Assistant\n")
+			if m.Content != "" {
+				fmt.Fprintf(&b, "\n%s\n", m.Content)
+			}
+			for _, c := range m.ToolCalls {
+				fmt.Fprintf(&b, "\n**Tool call** `%s` (`%s`)\n\n```json\n%s\n```\n", c.Name, c.ID, string(c.Arguments))
+			}
+		case llm.RoleTool:
+			fmt.Fprintf(&b, "\n**Tool result** (`%s`)\n\n```\n%s\n```\n", m.ToolCallID, m.Content)
+		default:
+			fmt.Fprintf(&b, "\n## %s\n\n%s\n", m.Role, m.Content)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteTranscript renders entries for reading in a terminal: one block per
+// message, with tool calls and results shown inline.
+func WriteTranscript(w io.Writer, entries []Entry) error {
+	var b strings.Builder
+	for i, e := range entries {
+		m := e.Message
+		if i > 0 && m.Role == llm.RoleUser {
+			b.WriteString("\n")
+		}
+		switch m.Role {
+		case llm.RoleUser:
+			fmt.Fprintf(&b, "> %s\n", indent(m.Content, "  "))
+		case llm.RoleAssistant:
+			for _, c := range m.ToolCalls {
+				fmt.Fprintf(&b, "→ %s %s\n", c.Name, string(c.Arguments))
+			}
+			if m.Content != "" {
+				fmt.Fprintf(&b, "%s\n", m.Content)
+			}
+		case llm.RoleTool:
+			fmt.Fprintf(&b, "✓ %s\n", indent(m.Content, "  "))
+		default:
+			fmt.Fprintf(&b, "[%s] %s\n", m.Role, m.Content)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func indent(s, prefix string) string {
+	return strings.ReplaceAll(strings.TrimRight(s, "\n"), "\n", "\n"+prefix)
+}
@@ -0,0 +1,601 @@
+// Package session persists conversations in SQLite under the agent's state
+// directory: <agent>/.pingu/ by default, or PINGU_STATE_DIR. The driver is
+// pure Go, so the binary stays CGO-free.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chtushar/pingu/internal/llm"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" database/sql driver
+)
+
+const (
+	// StateDirName is the runtime state directory inside an agent root.
+	StateDirName = ".pingu"
+	// DBFile is the session database file inside the state directory.
+	DBFile = "sessions.db"
+	// maxTitleRunes bounds a session title derived from the first input.
+	maxTitleRunes = 60
+)
+
+// ErrNotFound reports that no session matches an ID or name.
+var ErrNotFound = errors.New("session not found")
+
+// ErrNameTaken reports that another session already has a name.
+var ErrNameTaken = errors.New("session name already in use")
+
+// ErrEmpty reports that a session has no exchange to remove.
+var ErrEmpty = errors.New("session has no exchanges")
+
+// StateDir returns PINGU_STATE_DIR when set, otherwise root/.pingu.
+func StateDir(root string) string {
+	if v := os.Getenv("PINGU_STATE_DIR"); v != "" {
+		return v
+	}
+	return filepath.Join(root, StateDirName)
+}
+
+// Session is one stored conversation.
+type Session struct {
+	ID        string
+	Name      string // optional; unique among sessions
+	Title     string // first user input, shortened
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Run records one runner.Run that contributed messages to a session.
+type Run struct {
+	ID         string
+	Model      string
+	Usage      llm.Usage
+	Turns      int
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Store is a session database. It is safe for concurrent use.
+type Store struct {
+	db *sql.DB
+}
+
+// schema is applied when the database's user_version is below
+// schemaVersion. Timestamps are Unix milliseconds.
+const (
+	schemaVersion = 1
+	schema        = `
+CREATE TABLE IF NOT EXISTS sessions (
+	id         TEXT PRIMARY KEY,
+	name       TEXT UNIQUE,
+	title      TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS runs (
+	id            TEXT PRIMARY KEY,
+	session_id    TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+	model         TEXT NOT NULL,
+	input_tokens  INTEGER NOT NULL,
+	output_tokens INTEGER NOT NULL,
+	turns         INTEGER NOT NULL,
+	started_at    INTEGER NOT NULL,
+	finished_at   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS runs_session ON runs(session_id);
+CREATE TABLE IF NOT EXISTS messages (
+	session_id   TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+	seq          INTEGER NOT NULL,
+	run_id       TEXT NOT NULL,
+	role         TEXT NOT NULL,
+	content      TEXT NOT NULL,
+	tool_calls   TEXT,
+	tool_call_id TEXT NOT NULL DEFAULT '',
+	created_at   INTEGER NOT NULL,
+	PRIMARY KEY (session_id, seq)
+);
+`
+)
+
+// Open opens (creating if needed) the session database in dir.
+func Open(ctx context.Context, dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create state dir: %w", err)
+	}
+	dsn := "file:" + filepath.Join(dir, DBFile) +
+		"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open session db: %w", err)
+	}
+	s := &Store{db: db}
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) migrate(ctx context.Context) error {
+	var version int
+	if err := s.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	if version > schemaVersion {
+		return fmt.Errorf("session db schema version %d is newer than supported %d", version, schemaVersion)
+	}
+	if version == schemaVersion {
+		return nil
+	}
+	if _, err := s.db.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("migrate session db: %w", err)
+	}
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", schemaVersion)); err != nil {
+		return fmt.Errorf("migrate session db: %w", err)
+	}
+	return nil
+}
+
+// Close releases the database.
+func (s *Store) Close() error { return s.db.Close() }
+
+// Create starts an empty session. A non-empty name is attached to the new
+// session; if another session holds that name, it keeps its history but
+// loses the name.
+func (s *Store) Create(ctx context.Context, name string) (*Session, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	sess := &Session{ID: id, Name: name, CreatedAt: now, UpdatedAt: now}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+	defer tx.Rollback()
+	if name != "" {
+		if _, err := tx.ExecContext(ctx, "UPDATE sessions SET name = NULL WHERE name = ?", name); err != nil {
+			return nil, fmt.Errorf("create session: %w", err)
+		}
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO sessions (id, name, created_at, updated_at) VALUES (?, ?, ?, ?)",
+		id, nullString(name), now.UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+	return sess, nil
+}
+
+// Get finds a session by ID or name.
+func (s *Store) Get(ctx context.Context, idOrName string) (*Session, error) {
+	row := s.db.QueryRowContext(ctx,
+		"SELECT id, name, title, created_at, updated_at FROM sessions WHERE id = ? OR name = ? ORDER BY id = ? DESC LIMIT 1",
+		idOrName, idOrName, idOrName)
+	sess, err := scanSession(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, idOrName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get session: %w", err)
+	}
+	return sess, nil
+}
+
+// Entry is one stored message with its bookkeeping.
+type Entry struct {
+	Seq       int64
+	RunID     string
+	CreatedAt time.Time
+	Message   llm.Message
+}
+
+// Transcript returns a session's entries in conversation order.
+func (s *Store) Transcript(ctx context.Context, sessionID string) ([]Entry, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT seq, run_id, created_at, role, content, tool_calls, tool_call_id FROM messages WHERE session_id = ? ORDER BY seq",
+		sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("load messages: %w", err)
+	}
+	defer rows.Close()
+	var out []Entry
+	for rows.Next() {
+		var (
+			e       Entry
+			created int64
+			role    string
+			calls   sql.NullString
+		)
+		if err := rows.Scan(&e.Seq, &e.RunID, &created, &role, &e.Message.Content, &calls, &e.Message.ToolCallID); err != nil {
+			return nil, fmt.Errorf("load messages: %w", err)
+		}
+		e.CreatedAt = time.UnixMilli(created)
+		e.Message.Role = llm.Role(role)
+		if calls.Valid {
+			if e.Message.ToolCalls, err = decodeToolCalls(calls.String); err != nil {
+				return nil, fmt.Errorf("load messages: %w", err)
+			}
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("load messages: %w", err)
+	}
+	return out, nil
+}
+
+// Messages returns a session's messages in conversation order.
+func (s *Store) Messages(ctx context.Context, sessionID string) ([]llm.Message, error) {
+	entries, err := s.Transcript(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]llm.Message, len(entries))
+	for i, e := range entries {
+		out[i] = e.Message
+	}
+	return out, nil
+}
+
+// Summary is a session with aggregate run statistics.
+type Summary struct {
+	Session
+	Turns int // completed exchanges (runs)
+	Usage llm.Usage
+}
+
+// List returns every session, most recently active first.
+func (s *Store) List(ctx context.Context) ([]Summary, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT s.id, s.name, s.title, s.created_at, s.updated_at,
+		       COUNT(r.id), COALESCE(SUM(r.input_tokens), 0), COALESCE(SUM(r.output_tokens), 0)
+		FROM sessions s LEFT JOIN runs r ON r.session_id = s.id
+		GROUP BY s.id
+		ORDER BY s.updated_at DESC, s.id`)
+	if err != nil {
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+	defer rows.Close()
+	var out []Summary
+	for rows.Next() {
+		var (
+			sum              Summary
+			name             sql.NullString
+			created, updated int64
+		)
+		if err := rows.Scan(&sum.ID, &name, &sum.Title, &created, &updated,
+			&sum.Turns, &sum.Usage.InputTokens, &sum.Usage.OutputTokens); err != nil {
+			return nil, fmt.Errorf("list sessions: %w", err)
+		}
+		sum.Name = name.String
+		sum.CreatedAt = time.UnixMilli(created)
+		sum.UpdatedAt = time.UnixMilli(updated)
+		out = append(out, sum)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+	return out, nil
+}
+
+// Delete removes a session with its runs and messages.
+func (s *Store) Delete(ctx context.Context, sessionID string) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE id = ?", sessionID)
+	if err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, sessionID)
+	}
+	return nil
+}
+
+// Prune deletes sessions with no activity since cutoff and reports how many
+// were removed.
+func (s *Store) Prune(ctx context.Context, cutoff time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE updated_at < ?", cutoff.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("prune sessions: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("prune sessions: %w", err)
+	}
+	return int(n), nil
+}
+
+// AppendRun atomically records run and appends msgs to the session. The
+// session title is set from the first user message if it has none yet.
+func (s *Store) AppendRun(ctx context.Context, sessionID string, run Run, msgs []llm.Message) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("append run: %w", err)
+	}
+	defer tx.Rollback()
+	if err := appendRun(ctx, tx, sessionID, run, msgs); err != nil {
+		return fmt.Errorf("append run: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("append run: %w", err)
+	}
+	return nil
+}
+
+// ReplaceRun atomically removes the run oldRunID with its messages and
+// records run and msgs in its place, at the end of the session.
+func (s *Store) ReplaceRun(ctx context.Context, sessionID, oldRunID string, run Run, msgs []llm.Message) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("replace run: %w", err)
+	}
+	defer tx.Rollback()
+	if err := dropRun(ctx, tx, sessionID, oldRunID); err != nil {
+		return fmt.Errorf("replace run: %w", err)
+	}
+	if err := appendRun(ctx, tx, sessionID, run, msgs); err != nil {
+		return fmt.Errorf("replace run: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("replace run: %w", err)
+	}
+	return nil
+}
+
+func appendRun(ctx context.Context, tx *sql.Tx, sessionID string, run Run, msgs []llm.Message) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO runs (id, session_id, model, input_tokens, output_tokens, turns, started_at, finished_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.ID, sessionID, run.Model, run.Usage.InputTokens, run.Usage.OutputTokens, run.Turns,
+		run.StartedAt.UnixMilli(), run.FinishedAt.UnixMilli())
+	if err != nil {
+		return err
+	}
+
+	var next int64
+	if err := tx.QueryRowContext(ctx,
+		"SELECT COALESCE(MAX(seq), 0) + 1 FROM messages WHERE session_id = ?", sessionID).Scan(&next); err != nil {
+		return err
+	}
+	now := run.FinishedAt.UnixMilli()
+	var title string
+	for i, m := range msgs {
+		calls, err := encodeToolCalls(m.ToolCalls)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO messages (session_id, seq, run_id, role, content, tool_calls, tool_call_id, created_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			sessionID, next+int64(i), run.ID, string(m.Role), m.Content, calls, m.ToolCallID, now)
+		if err != nil {
+			return err
+		}
+		if title == "" && m.Role == llm.RoleUser {
+			title = Title(m.Content)
+		}
+	}
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE sessions SET updated_at = ?, title = CASE WHEN title = '' THEN ? ELSE title END WHERE id = ?",
+		now, title, sessionID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, sessionID)
+	}
+	return nil
+}
+
+// LastRun returns the messages the most recent run of a session added. It
+// fails with ErrEmpty when the session has no messages.
+func (s *Store) LastRun(ctx context.Context, sessionID string) ([]llm.Message, error) {
+	_, msgs, err := s.lastRun(ctx, sessionID)
+	return msgs, err
+}
+
+func (s *Store) lastRun(ctx context.Context, sessionID string) (string, []llm.Message, error) {
+	entries, err := s.Transcript(ctx, sessionID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(entries) == 0 {
+		return "", nil, fmt.Errorf("%w: %s", ErrEmpty, sessionID)
+	}
+	runID := entries[len(entries)-1].RunID
+	var msgs []llm.Message
+	for _, e := range entries {
+		if e.RunID == runID {
+			msgs = append(msgs, e.Message)
+		}
+	}
+	return runID, msgs, nil
+}
+
+// DropLastRun removes the most recent run of a session with the messages
+// it added, and returns those messages. It fails with ErrEmpty when the
+// session has no messages.
+func (s *Store) DropLastRun(ctx context.Context, sessionID string) ([]llm.Message, error) {
+	runID, msgs, err := s.lastRun(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("drop run: %w", err)
+	}
+	defer tx.Rollback()
+	if err := dropRun(ctx, tx, sessionID, runID); err != nil {
+		return nil, fmt.Errorf("drop run: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE sessions SET updated_at = ? WHERE id = ?", time.Now().UnixMilli(), sessionID); err != nil {
+		return nil, fmt.Errorf("drop run: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("drop run: %w", err)
+	}
+	return msgs, nil
+}
+
+// dropRun deletes a run of a session and the messages it added.
+func dropRun(ctx context.Context, tx *sql.Tx, sessionID, runID string) error {
+	for _, q := range []string{
+		"DELETE FROM messages WHERE session_id = ? AND run_id = ?",
+		"DELETE FROM runs WHERE session_id = ? AND id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, q, sessionID, runID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fork creates a session named name holding a copy of another session's
+// runs and messages. Copied runs get the ID "<run>@<new session>", since
+// run IDs are unique across sessions. Unlike Create, Fork does not take a
+// name over: it fails with ErrNameTaken when the name is in use.
+func (s *Store) Fork(ctx context.Context, sessionID, name string) (*Session, error) {
+	src, err := s.Get(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	sess := &Session{ID: id, Name: name, Title: src.Title, CreatedAt: now, UpdatedAt: now}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fork session: %w", err)
+	}
+	defer tx.Rollback()
+	var taken int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM sessions WHERE name = ?", name).Scan(&taken); err != nil {
+		return nil, fmt.Errorf("fork session: %w", err)
+	}
+	if taken > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNameTaken, name)
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO sessions (id, name, title, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		id, nullString(name), src.Title, now.UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("fork session: %w", err)
+	}
+	for _, q := range []string{
+		`INSERT INTO runs (id, session_id, model, input_tokens, output_tokens, turns, started_at, finished_at)
+		 SELECT id || '@' || ?1, ?1, model, input_tokens, output_tokens, turns, started_at, finished_at
+		 FROM runs WHERE session_id = ?2`,
+		`INSERT INTO messages (session_id, seq, run_id, role, content, tool_calls, tool_call_id, created_at)
+		 SELECT ?1, seq, run_id || '@' || ?1, role, content, tool_calls, tool_call_id, created_at
+		 FROM messages WHERE session_id = ?2`,
+	} {
+		if _, err := tx.ExecContext(ctx, q, id, src.ID); err != nil {
+			return nil, fmt.Errorf("fork session: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("fork session: %w", err)
+	}
+	return sess, nil
+}
+
+// Title shortens a user input to a one-line session title.
+func Title(input string) string {
+	t := strings.Join(strings.Fields(input), " ")
+	if utf8.RuneCountInString(t) <= maxTitleRunes {
+		return t
+	}
+	r := []rune(t)
+	return string(r[:maxTitleRunes-1]) + "…"
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSession(row scanner) (*Session, error) {
+	var (
+		sess             Session
+		name             sql.NullString
+		created, updated int64
+	)
+	if err := row.Scan(&sess.ID, &name, &sess.Title, &created, &updated); err != nil {
+		return nil, err
+	}
+	sess.Name = name.String
+	sess.CreatedAt = time.UnixMilli(created)
+	sess.UpdatedAt = time.UnixMilli(updated)
+	return &sess, nil
+}
+
+// wireToolCall is the stored JSON form of llm.ToolCall. Arguments are kept
+// as a string so malformed model output round-trips unchanged.
+type wireToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+func encodeToolCalls(calls []llm.ToolCall) (sql.NullString, error) {
+	if len(calls) == 0 {
+		return sql.NullString{}, nil
+	}
+	w := make([]wireToolCall, len(calls))
+	for i, c := range calls {
+		w[i] = wireToolCall{ID: c.ID, Name: c.Name, Arguments: string(c.Arguments)}
+	}
+	b, err := json.Marshal(w)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+func decodeToolCalls(s string) ([]llm.ToolCall, error) {
+	var w []wireToolCall
+	if err := json.Unmarshal([]byte(s), &w); err != nil {
+		return nil, fmt.Errorf("decode tool calls: %w", err)
+	}
+	out := make([]llm.ToolCall, len(w))
+	for i, c := range w {
+		out[i] = llm.ToolCall{ID: c.ID, Name: c.Name}
+		if c.Arguments != "" {
+			out[i].Arguments = json.RawMessage(c.Arguments)
+		}
+	}
+	return out, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func newID() (string, error) {
+	var b [6]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("session id: %w", err)
+	}
+	return "s-" + hex.EncodeToString(b[:]), nil
+}
@@ -0,0 +1,314 @@
+package session_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/session"
+)
+
+func openStore(t *testing.T) *session.Store {
+	t.Helper()
+	s, err := session.Open(context.Background(), t.TempDir())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func testRun(id string) session.Run {
+	now := time.Now()
+	return session.Run{
+		ID:         id,
+		Model:      "openai/test",
+		Usage:      llm.Usage{InputTokens: 10, OutputTokens: 4},
+		Turns:      2,
+		StartedAt:  now.Add(-time.Second),
+		FinishedAt: now,
+	}
+}
+
+func TestAppendRunRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+	sess, err := s.Create(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := []llm.Message{
+		{Role: llm.RoleUser, Content: "what time is it\nin Paris?"},
+		{Role: llm.RoleAssistant, ToolCalls: []llm.ToolCall{
+			{ID: "c1", Name: "clock", Arguments: json.RawMessage(`{"tz":"Europe/Paris"}`)},
+			{ID: "c2", Name: "broken", Arguments: json.RawMessage(`{not json`)},
+		}},
+		{Role: llm.RoleTool, ToolCallID: "c1", Content: "12:00"},
+		{Role: llm.RoleTool, ToolCallID: "c2", Content: "error: invalid JSON arguments"},
+		{Role: llm.RoleAssistant, Content: "It is noon."},
+	}
+	if err := s.AppendRun(ctx, sess.ID, testRun("run-1"), msgs); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if err := s.AppendRun(ctx, sess.ID, testRun("run-2"), []llm.Message{
+		{Role: llm.RoleUser, Content: "thanks"},
+		{Role: llm.RoleAssistant, Content: "any time"},
+	}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+
+	got, err := s.Messages(ctx, sess.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 7 || got[5].Content != "thanks" {
+		t.Fatalf("messages = %+v", got)
+	}
+	calls := got[1].ToolCalls
+	if len(calls) != 2 || calls[0].Name != "clock" || string(calls[0].Arguments) != `{"tz":"Europe/Paris"}` {
+		t.Errorf("tool calls = %+v", calls)
+	}
+	if string(calls[1].Arguments) != `{not json` {
+		t.Errorf("malformed arguments must round-trip, got %q", calls[1].Arguments)
+	}
+	if got[2].ToolCallID != "c1" || got[2].Role != llm.RoleTool {
+		t.Errorf("tool result = %+v", got[2])
+	}
+
+	reloaded, err := s.Get(ctx, sess.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Title != "what time is it in Paris?" {
+		t.Errorf("title = %q", reloaded.Title)
+	}
+}
+
+func TestGetByName(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+	sess, err := s.Create(ctx, "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(ctx, "work")
+	if err != nil || got.ID != sess.ID {
+		t.Fatalf("get = %+v, %v", got, err)
+	}
+	if _, err := s.Get(ctx, "missing"); !errors.Is(err, session.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestCreateTakesOverName(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+	old, _ := s.Create(ctx, "work")
+	s.AppendRun(ctx, old.ID, testRun("run-1"), []llm.Message{{Role: llm.RoleUser, Content: "hi"}})
+
+	fresh, err := s.Create(ctx, "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(ctx, "work")
+	if err != nil || got.ID != fresh.ID {
+		t.Fatalf("name should move to the fresh session, got %+v, %v", got, err)
+	}
+	// The old session keeps its history under its ID.
+	msgs, err := s.Messages(ctx, old.ID)
+	if err != nil || len(msgs) != 1 {
+		t.Errorf("old messages = %+v, %v", msgs, err)
+	}
+}
+
+func TestDropLastRunAndFork(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+	sess, _ := s.Create(ctx, "main")
+	s.AppendRun(ctx, sess.ID, testRun("run-1"), []llm.Message{{Role: llm.RoleUser, Content: "one"}, {Role: llm.RoleAssistant, Content: "1"}})
+	s.AppendRun(ctx, sess.ID, testRun("run-2"), []llm.Message{{Role: llm.RoleUser, Content: "two"}, {Role: llm.RoleAssistant, Content: "2"}})
+
+	fork, err := s.Fork(ctx, "main", "alt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork.Title != "one" {
+		t.Errorf("fork title = %q", fork.Title)
+	}
+	if _, err := s.Fork(ctx, sess.ID, "alt"); !errors.Is(err, session.ErrNameTaken) {
+		t.Errorf("fork onto a taken name: %v", err)
+	}
+
+	dropped, err := s.DropLastRun(ctx, sess.ID)
+	if err != nil || len(dropped) != 2 || dropped[0].Content != "two" {
+		t.Fatalf("dropped = %+v, %v", dropped, err)
+	}
+	if last, err := s.LastRun(ctx, sess.ID); err != nil || last[0].Content != "one" {
+		t.Errorf("last run = %+v, %v", last, err)
+	}
+	// The fork keeps both exchanges, with its own runs.
+	entries, err := s.Transcript(ctx, fork.ID)
+	if err != nil || len(entries) != 4 || entries[3].RunID != "run-2@"+fork.ID {
+		t.Fatalf("fork transcript = %+v, %v", entries, err)
+	}
+	list, _ := s.List(ctx)
+	for _, sum := range list {
+		if want := map[string]int{sess.ID: 1, fork.ID: 2}[sum.ID]; sum.Turns != want {
+			t.Errorf("%s: turns = %d, want %d", sum.ID, sum.Turns, want)
+		}
+	}
+
+	s.DropLastRun(ctx, sess.ID)
+	if _, err := s.DropLastRun(ctx, sess.ID); !errors.Is(err, session.ErrEmpty) {
+		t.Errorf("drop from an empty session: %v", err)
+	}
+}
+
+func TestAppendRunUnknownSession(t *testing.T) {
+	s := openStore(t)
+	err := s.AppendRun(context.Background(), "s-missing", testRun("run-1"), nil)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestReopenPersists(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := session.Open(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, _ := s.Create(ctx, "keep")
+	s.AppendRun(ctx, sess.ID, testRun("run-1"), []llm.Message{{Role: llm.RoleUser, Content: "remember me"}})
+	s.Close()
+
+	s, err = session.Open(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	msgs, err := s.Messages(ctx, sess.ID)
+	if err != nil || len(msgs) != 1 || msgs[0].Content != "remember me" {
+		t.Fatalf("messages = %+v, %v", msgs, err)
+	}
+}
+
+func TestStateDir(t *testing.T) {
+	t.Setenv("PINGU_STATE_DIR", "")
+	if got := session.StateDir("/agents/a"); got != filepath.Join("/agents/a", ".pingu") {
+		t.Errorf("StateDir = %q", got)
+	}
+	t.Setenv("PINGU_STATE_DIR", "/var/lib/pingu")
+	if got := session.StateDir("/agents/a"); got != "/var/lib/pingu" {
+		t.Errorf("StateDir = %q", got)
+	}
+}
+
+func TestTitle(t *testing.T) {
+	long := strings.Repeat("word ", 30)
+	got := session.Title(long)
+	if len([]rune(got)) != 60 || !strings.HasSuffix(got, "…") {
+		t.Errorf("title = %q", got)
+	}
+}
+
+func TestListDeletePrune(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+	a, _ := s.Create(ctx, "a")
+	b, _ := s.Create(ctx, "")
+	time.Sleep(5 * time.Millisecond) // timestamps have millisecond resolution
+	s.AppendRun(ctx, a.ID, testRun("run-1"), []llm.Message{{Role: llm.RoleUser, Content: "one"}})
+	s.AppendRun(ctx, a.ID, testRun("run-2"), []llm.Message{{Role: llm.RoleUser, Content: "two"}})
+
+	list, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].ID != a.ID {
+		t.Fatalf("list = %+v", list)
+	}
+	if list[0].Turns != 2 || list[0].Usage.InputTokens != 20 || list[0].Usage.OutputTokens != 8 {
+		t.Errorf("summary = %+v", list[0])
+	}
+	if list[1].Turns != 0 || list[1].Title != "" {
+		t.Errorf("empty summary = %+v", list[1])
+	}
+
+	if err := s.Delete(ctx, b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, b.ID); !errors.Is(err, session.ErrNotFound) {
+		t.Errorf("second delete: %v", err)
+	}
+
+	n, err := s.Prune(ctx, time.Now().Add(-time.Hour))
+	if err != nil || n != 0 {
+		t.Fatalf("prune recent = %d, %v", n, err)
+	}
+	n, err = s.Prune(ctx, time.Now().Add(time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("prune all = %d, %v", n, err)
+	}
+	if msgs, _ := s.Messages(ctx, a.ID); len(msgs) != 0 {
+		t.Errorf("messages survived prune: %+v", msgs)
+	}
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+	sess, _ := s.Create(ctx, "demo")
+	s.AppendRun(ctx, sess.ID, testRun("run-1"), []llm.Message{
+		{Role: llm.RoleUser, Content: "echo hi"},
+		{Role: llm.RoleAssistant, ToolCalls: []llm.ToolCall{{ID: "c1", Name: "echo", Arguments: json.RawMessage(`{"v":"hi"}`)}}},
+		{Role: llm.RoleTool, ToolCallID: "c1", Content: "hi"},
+		{Role: llm.RoleAssistant, Content: "It said hi."},
+	})
+	sess, _ = s.Get(ctx, sess.ID)
+	entries, err := s.Transcript(ctx, sess.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var jsonl strings.Builder
+	if err := session.Export(&jsonl, sess, entries, session.FormatJSONL); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(jsonl.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("jsonl lines = %d", len(lines))
+	}
+	var second struct {
+		RunID     string `json:"run_id"`
+		ToolCalls []struct {
+			Arguments map[string]string `json:"arguments"`
+		} `json:"tool_calls"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatal(err)
+	}
+	if second.RunID != "run-1" || second.ToolCalls[0].Arguments["v"] != "hi" {
+		t.Errorf("line = %s", lines[1])
+	}
+
+	var md strings.Builder
+	if err := session.Export(&md, sess, entries, session.FormatMarkdown); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# echo hi", "## User", "**Tool call** `echo`", "**Tool result**", "It said hi."} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("markdown missing %q:\n%s", want, md.String())
+		}
+	}
+
+	if err := session.Export(&md, sess, entries, "xml"); err == nil {
+		t.Error("expected unknown format error")
+	}
+}
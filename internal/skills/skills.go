@@ -0,0 +1,268 @@
+// Package skills loads markdown playbooks from an agent's skills/ directory.
+// Each skill is advertised to the model in a short index appended to the
+// system prompt; the full text is pulled into context on demand with the
+// load_skill tool.
+package skills
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Dir holds skill files, relative to the agent root.
+const Dir = "skills"
+
+// MaxSkillBytes bounds one skill file, front-matter included.
+const MaxSkillBytes = 256 * 1024
+
+// ToolName is the name of the tool that loads a skill's playbook.
+const ToolName = "load_skill"
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Skill is one playbook. The file starts with front-matter:
+//
+//	---
+//	name: release
+//	description: Cut a release and publish the changelog.
+//	triggers: [release, tag a version]
+//	---
+//
+// name defaults to the file name without .md; description is required.
+type Skill struct {
+	Name        string
+	Description string
+	Triggers    []string // phrases that suggest the skill applies
+	File        string   // path relative to the agent root
+	Body        string   // the playbook after the front-matter
+}
+
+// Error reports a problem with one skill file.
+type Error struct {
+	File string // path relative to the agent root
+	Err  error
+}
+
+func (e *Error) Error() string { return e.File + ": " + e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Load reads every *.md file directly in dir, sorted by skill name. A
+// missing directory means no skills. Problems with individual files are
+// joined *Error values, so callers see all of them; the valid skills are
+// still returned.
+func Load(dir string) ([]Skill, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var (
+		skills []Skill
+		errs   []error
+		seen   = map[string]string{}
+	)
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".md" {
+			continue
+		}
+		rel := filepath.ToSlash(filepath.Join(Dir, e.Name()))
+		s, err := loadFile(filepath.Join(dir, e.Name()), strings.TrimSuffix(e.Name(), ".md"))
+		if err != nil {
+			errs = append(errs, &Error{File: rel, Err: err})
+			continue
+		}
+		if prev, ok := seen[s.Name]; ok {
+			errs = append(errs, &Error{File: rel, Err: fmt.Errorf("skill name %q is also used by %s", s.Name, prev)})
+			continue
+		}
+		seen[s.Name] = rel
+		s.File = rel
+		skills = append(skills, s)
+	}
+	sort.Slice(skills, func(i, j int) bool { return skills[i].Name < skills[j].Name })
+	return skills, errors.Join(errs...)
+}
+
+func loadFile(path, stem string) (Skill, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Skill{}, err
+	}
+	if info.Size() > MaxSkillBytes {
+		return Skill{}, fmt.Errorf("size %d exceeds limit %d", info.Size(), MaxSkillBytes)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Skill{}, err
+	}
+	s, err := Parse(data)
+	if err != nil {
+		return Skill{}, err
+	}
+	if s.Name == "" {
+		s.Name = stem
+	}
+	if !validName.MatchString(s.Name) {
+		return Skill{}, fmt.Errorf("invalid name %q: use lowercase letters, digits, - and _", s.Name)
+	}
+	return s, nil
+}
+
+// Parse splits a skill file into front-matter and body. Front-matter is a
+// small YAML subset: "key: value" lines, with triggers given as a flow list
+// ([a, b]) or as "- item" lines. Unknown keys are rejected.
+func Parse(data []byte) (Skill, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	rest, ok := strings.CutPrefix(text, "---\n")
+	if !ok {
+		return Skill{}, errors.New("missing front-matter: the file must start with a --- line")
+	}
+	front, body, ok := strings.Cut(rest, "\n---\n")
+	if !ok {
+		front, ok = strings.CutSuffix(rest, "\n---")
+		if !ok {
+			return Skill{}, errors.New("front-matter is not closed with a --- line")
+		}
+	}
+
+	var s Skill
+	listKey := ""
+	sc := bufio.NewScanner(strings.NewReader(front))
+	for n := 2; sc.Scan(); n++ {
+		line := strings.TrimRight(sc.Text(), " \t")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if item, ok := strings.CutPrefix(trimmed, "- "); ok && listKey != "" {
+			s.Triggers = append(s.Triggers, unquote(item))
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return Skill{}, fmt.Errorf("line %d: want key: value", n)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		listKey = ""
+		switch key {
+		case "name":
+			s.Name = unquote(value)
+		case "description":
+			s.Description = unquote(value)
+		case "triggers":
+			switch {
+			case value == "":
+				listKey = key
+			case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+				for _, item := range strings.Split(value[1:len(value)-1], ",") {
+					if item = unquote(strings.TrimSpace(item)); item != "" {
+						s.Triggers = append(s.Triggers, item)
+					}
+				}
+			default:
+				s.Triggers = append(s.Triggers, unquote(value))
+			}
+		default:
+			return Skill{}, fmt.Errorf("line %d: unknown field %q", n, key)
+		}
+	}
+	if s.Description == "" {
+		return Skill{}, errors.New("front-matter needs a description")
+	}
+	s.Body = strings.TrimSpace(body)
+	if s.Body == "" {
+		return Skill{}, errors.New("playbook is empty")
+	}
+	return s, nil
+}
+
+// unquote strips matching single or double quotes.
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		if u, err := strconv.Unquote(s); err == nil {
+			return u
+		}
+	}
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	return s
+}
+
+// Index renders the system-prompt section that advertises skills. It is
+// empty when there are none.
+func Index(skills []Skill) string {
+	if len(skills) == 0 {
+		return ""
+	}
+	var b bytes.Buffer
+	b.WriteString("## Skills\n\n")
+	fmt.Fprintf(&b, "These playbooks are available. When a task matches one, call %s with its name and follow the playbook it returns.\n\n", ToolName)
+	for _, s := range skills {
+		fmt.Fprintf(&b, "- %s: %s", s.Name, s.Description)
+		if len(s.Triggers) > 0 {
+			fmt.Fprintf(&b, " (use for: %s)", strings.Join(s.Triggers, "; "))
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// Tool is the load_skill tool: it returns one skill's playbook.
+type Tool struct {
+	skills []Skill
+}
+
+// NewTool returns the load_skill tool for skills.
+func NewTool(skills []Skill) *Tool { return &Tool{skills: skills} }
+
+// Name implements tools.Tool.
+func (t *Tool) Name() string { return ToolName }
+
+// Description implements tools.Tool.
+func (t *Tool) Description() string {
+	return "Load the full playbook of a skill listed in the system prompt."
+}
+
+// Parameters implements tools.Tool.
+func (t *Tool) Parameters() json.RawMessage {
+	names := make([]string, len(t.skills))
+	for i, s := range t.skills {
+		names[i] = s.Name
+	}
+	enum, _ := json.Marshal(names)
+	return json.RawMessage(`{"type":"object","properties":{"name":{"type":"string","enum":` + string(enum) + `}},"required":["name"],"additionalProperties":false}`)
+}
+
+// Run implements tools.Tool.
+func (t *Tool) Run(_ context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		Name string `json:"name"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(args))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&in); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	names := make([]string, 0, len(t.skills))
+	for _, s := range t.skills {
+		if s.Name == in.Name {
+			return s.Body, nil
+		}
+		names = append(names, s.Name)
+	}
+	return "", fmt.Errorf("unknown skill %q; available: %s", in.Name, strings.Join(names, ", "))
+}
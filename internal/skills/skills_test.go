@@ -0,0 +1,125 @@
+package skills_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chtushar/pingu/internal/skills"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want skills.Skill
+	}{
+		{
+			name: "flow list",
+			in:   "---\nname: release\ndescription: \"Cut a release: tag and publish.\"\ntriggers: [release, 'tag a version']\n---\n# Release\n\nSteps.\n",
+			want: skills.Skill{Name: "release", Description: "Cut a release: tag and publish.", Triggers: []string{"release", "tag a version"}, Body: "# Release\n\nSteps."},
+		},
+		{
+			name: "block list and comments",
+			in:   "---\n# comment\ndescription: Triage a bug report\ntriggers:\n  - bug\n  - crash report\n---\nDo it.",
+			want: skills.Skill{Description: "Triage a bug report", Triggers: []string{"bug", "crash report"}, Body: "Do it."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := skills.Parse([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != tt.want.Name || got.Description != tt.want.Description || got.Body != tt.want.Body ||
+				strings.Join(got.Triggers, "|") != strings.Join(tt.want.Triggers, "|") {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	for _, in := range []string{
+		"# no front-matter\n",
+		"---\ndescription: unclosed\n",
+		"---\nname: x\n---\nbody\n",                    // no description
+		"---\ndescription: d\nauthor: me\n---\nbody\n", // unknown field
+		"---\ndescription: d\n---\n\n",                 // empty body
+	} {
+		if _, err := skills.Parse([]byte(in)); err == nil {
+			t.Errorf("Parse(%q): expected error", in)
+		}
+	}
+}
+
+func writeSkill(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadIndexAndTool(t *testing.T) {
+	if got, err := skills.Load(filepath.Join(t.TempDir(), "missing")); err != nil || got != nil {
+		t.Fatalf("missing dir = %v, %v", got, err)
+	}
+
+	dir := t.TempDir()
+	writeSkill(t, dir, "zeta.md", "---\ndescription: Last one\n---\nZ body\n")
+	writeSkill(t, dir, "a.md", "---\nname: alpha\ndescription: First one\ntriggers: [start]\n---\nA body\n")
+	writeSkill(t, dir, "notes.txt", "ignored")
+	list, err := skills.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != "alpha" || list[1].Name != "zeta" || list[1].File != "skills/zeta.md" {
+		t.Fatalf("skills = %+v", list)
+	}
+
+	index := skills.Index(list)
+	for _, want := range []string{"## Skills", "load_skill", "- alpha: First one (use for: start)\n- zeta: Last one"} {
+		if !strings.Contains(index, want) {
+			t.Errorf("index missing %q:\n%s", want, index)
+		}
+	}
+	if skills.Index(nil) != "" {
+		t.Error("index for no skills should be empty")
+	}
+
+	tool := skills.NewTool(list)
+	if !json.Valid(tool.Parameters()) {
+		t.Errorf("invalid parameters %s", tool.Parameters())
+	}
+	if got, err := tool.Run(context.Background(), json.RawMessage(`{"name":"zeta"}`)); err != nil || got != "Z body" {
+		t.Errorf("load zeta = %q, %v", got, err)
+	}
+	if _, err := tool.Run(context.Background(), json.RawMessage(`{"name":"nope"}`)); err == nil || !strings.Contains(err.Error(), "alpha, zeta") {
+		t.Errorf("unknown skill error = %v", err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for name, files := range map[string]map[string]string{
+		"duplicate name": {
+			"a.md": "---\nname: same\ndescription: d\n---\nbody\n",
+			"b.md": "---\nname: same\ndescription: d\n---\nbody\n",
+		},
+		"invalid name": {"Bad Name.md": "---\ndescription: d\n---\nbody\n"},
+		"bad file":     {"x.md": "no front-matter\n"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			for n, c := range files {
+				writeSkill(t, dir, n, c)
+			}
+			_, err := skills.Load(dir)
+			var skillErr *skills.Error
+			if !errors.As(err, &skillErr) || !strings.HasPrefix(skillErr.File, "skills/") {
+				t.Errorf("expected *skills.Error naming the file, got %v", err)
+			}
+		})
+	}
+}
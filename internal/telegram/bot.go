@@ -0,0 +1,435 @@
+// Package telegram is the Telegram channel: a long-polling bot that keeps
+// one stored conversation per chat and renders each run's event stream by
+// editing its reply as text arrives.
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chtushar/pingu/internal/runner"
+	"github.com/chtushar/pingu/internal/session"
+	"github.com/chtushar/pingu/internal/tools"
+)
+
+const (
+	// DefaultEditInterval is the minimum time between edits of one reply.
+	// Telegram throttles bots that edit faster than about once a second.
+	DefaultEditInterval = time.Second
+	// SessionPrefix prefixes the chat ID in stored session names.
+	SessionPrefix = "telegram:"
+
+	pollTimeout     = 30 * time.Second
+	maxPollBackoff  = 30 * time.Second
+	maxMessageRunes = 4096
+	chatQueueSize   = 8
+	finalTimeout    = 10 * time.Second
+)
+
+// Config describes the bot and the agent it runs.
+type Config struct {
+	Client       *Client
+	AllowedUsers []int64 // required; messages from anyone else are ignored
+	Store        *session.Store
+
+	Instructions string
+	Model        string // provider-side model id
+	ModelRef     string // model reference recorded with each run
+	Tools        *tools.Registry
+	// NewRunner returns a fresh runner.Runner for each chat: the provider,
+	// limits, generation parameters, pricing, and context management.
+	NewRunner func() *runner.Runner
+
+	EditInterval time.Duration // zero means DefaultEditInterval
+}
+
+// Bot long-polls for messages and answers each chat from its own goroutine
+// with its own runner.Runner, so chats never wait on each other. Messages
+// within a chat are handled in order.
+type Bot struct {
+	cfg     Config
+	allowed map[int64]bool
+
+	mu    sync.Mutex
+	chats map[int64]chan string
+	wg    sync.WaitGroup
+}
+
+// New returns a bot for cfg. An empty allowlist is an error: a bot open to
+// everyone would let any Telegram user drive the agent's tools.
+func New(cfg Config) (*Bot, error) {
+	if len(cfg.AllowedUsers) == 0 {
+		return nil, errors.New("an allowlist of Telegram user IDs is required")
+	}
+	if cfg.EditInterval <= 0 {
+		cfg.EditInterval = DefaultEditInterval
+	}
+	b := &Bot{cfg: cfg, allowed: map[int64]bool{}, chats: map[int64]chan string{}}
+	for _, id := range cfg.AllowedUsers {
+		b.allowed[id] = true
+	}
+	return b, nil
+}
+
+// Run polls until ctx is done, then waits for the chats to wind down. It
+// returns early only when the API rejects the bot token.
+func (b *Bot) Run(ctx context.Context) error {
+	defer b.wg.Wait()
+	var offset int64
+	backoff := time.Second
+	for {
+		updates, err := b.cfg.Client.GetUpdates(ctx, offset, pollTimeout)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && (apiErr.Code == 401 || apiErr.Code == 404) {
+				return fmt.Errorf("telegram rejected the bot token: %w", err)
+			}
+			wait := backoff
+			if apiErr != nil && apiErr.RetryAfter > wait {
+				wait = apiErr.RetryAfter
+			}
+			slog.Warn("telegram poll failed", "error", err, "retry_in", wait)
+			if !sleep(ctx, wait) {
+				return nil
+			}
+			backoff = min(2*backoff, maxPollBackoff)
+			continue
+		}
+		backoff = time.Second
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			b.dispatch(ctx, u)
+		}
+	}
+}
+
+// dispatch queues a message for its chat, starting the chat's goroutine on
+// first use.
+func (b *Bot) dispatch(ctx context.Context, u Update) {
+	m := u.Message
+	if m == nil || m.Text == "" {
+		return
+	}
+	if m.From == nil || !b.allowed[m.From.ID] {
+		var userID int64
+		if m.From != nil {
+			userID = m.From.ID
+		}
+		slog.Info("ignoring message from user not in allowlist", "user_id", userID, "chat_id", m.Chat.ID)
+		return
+	}
+	chatID := m.Chat.ID
+	b.mu.Lock()
+	queue, ok := b.chats[chatID]
+	if !ok {
+		queue = make(chan string, chatQueueSize)
+		b.chats[chatID] = queue
+		b.wg.Add(1)
+		go b.serveChat(ctx, chatID, queue)
+	}
+	b.mu.Unlock()
+	select {
+	case queue <- m.Text:
+	default:
+		b.notify(ctx, chatID, "Still working on your earlier messages; try again in a moment.")
+	}
+}
+
+// serveChat handles one chat's messages in order.
+func (b *Bot) serveChat(ctx context.Context, chatID int64, queue <-chan string) {
+	defer b.wg.Done()
+	r := b.cfg.NewRunner()
+	name := SessionPrefix + strconv.FormatInt(chatID, 10)
+	var conv *session.Conversation
+	for {
+		var text string
+		select {
+		case <-ctx.Done():
+			return
+		case text = <-queue:
+		}
+		switch command(text) {
+		case "/start":
+			b.notify(ctx, chatID, "Send a message to talk to the agent. /new starts a new conversation.")
+			continue
+		case "/new":
+			c, err := b.openConversation(ctx, name, true)
+			if err != nil {
+				slog.Error("telegram: open session failed", "chat_id", chatID, "error", err)
+				b.notify(ctx, chatID, "error: could not start a new conversation")
+				continue
+			}
+			conv = c
+			b.notify(ctx, chatID, "Started a new conversation.")
+			continue
+		}
+		if conv == nil {
+			c, err := b.openConversation(ctx, name, false)
+			if err != nil {
+				slog.Error("telegram: open session failed", "chat_id", chatID, "error", err)
+				b.notify(ctx, chatID, "error: could not load the conversation")
+				continue
+			}
+			conv = c
+		}
+		b.answer(ctx, r, conv, chatID, text)
+	}
+}
+
+func (b *Bot) openConversation(ctx context.Context, name string, fresh bool) (*session.Conversation, error) {
+	c, err := session.OpenConversation(ctx, b.cfg.Store, name, fresh)
+	if err != nil {
+		return nil, err
+	}
+	c.Model = b.cfg.ModelRef
+	return c, nil
+}
+
+// command returns the bot command text starts with ("/new" for
+// "/new@pingu_bot"), or "".
+func command(text string) string {
+	if !strings.HasPrefix(text, "/") {
+		return ""
+	}
+	cmd, _, _ := strings.Cut(strings.Fields(text)[0], "@")
+	return cmd
+}
+
+// answer runs text through the conversation while a renderer edits the
+// reply at most once per EditInterval.
+func (b *Bot) answer(ctx context.Context, r *runner.Runner, conv *session.Conversation, chatID int64, text string) {
+	out := &reply{client: b.cfg.Client, chatID: chatID}
+	if err := out.update(ctx, placeholder); err != nil {
+		slog.Warn("telegram: send reply failed", "chat_id", chatID, "error", err)
+	}
+
+	var (
+		mu     sync.Mutex
+		v      = &view{}
+		notify = make(chan struct{}, 1)
+		stop   = make(chan struct{})
+		done   = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-notify:
+			}
+			mu.Lock()
+			s := v.render()
+			mu.Unlock()
+			wait := b.cfg.EditInterval
+			if err := out.update(ctx, s); err != nil {
+				var apiErr *APIError
+				if errors.As(err, &apiErr) && apiErr.RetryAfter > wait {
+					wait = apiErr.RetryAfter
+				}
+				slog.Debug("telegram: edit reply failed", "chat_id", chatID, "error", err)
+			}
+			if !sleepUntil(stop, wait) {
+				return
+			}
+		}
+	}()
+
+	runID := fmt.Sprintf("tg-%d-%d", chatID, time.Now().UnixNano())
+	_, err := conv.Exchange(ctx, r, runner.RunRequest{
+		RunID:        runID,
+		Instructions: b.cfg.Instructions,
+		Model:        b.cfg.Model,
+		Input:        text,
+		Tools:        b.cfg.Tools,
+	}, func(ev runner.Event) {
+		mu.Lock()
+		changed := v.apply(ev)
+		mu.Unlock()
+		if changed {
+			select {
+			case notify <- struct{}{}:
+			default:
+			}
+		}
+	})
+	close(stop)
+	<-done
+	if err != nil {
+		slog.Info("telegram run failed", "chat_id", chatID, "run_id", runID, "error", err)
+	}
+
+	// The final edit goes out even when the bot is shutting down.
+	finalCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), finalTimeout)
+	defer cancel()
+	final := v.final(err)
+	for attempt := 0; attempt < 3; attempt++ {
+		err := out.update(finalCtx, final)
+		var apiErr *APIError
+		if err == nil || !errors.As(err, &apiErr) || apiErr.RetryAfter == 0 {
+			if err != nil {
+				slog.Warn("telegram: final edit failed", "chat_id", chatID, "error", err)
+			}
+			return
+		}
+		if !sleep(finalCtx, apiErr.RetryAfter) {
+			return
+		}
+	}
+}
+
+// notify sends a standalone message, logging failures.
+func (b *Bot) notify(ctx context.Context, chatID int64, text string) {
+	if _, err := b.cfg.Client.SendMessage(ctx, chatID, text); err != nil {
+		slog.Warn("telegram: send message failed", "chat_id", chatID, "error", err)
+	}
+}
+
+const placeholder = "…"
+
+// view accumulates one run's events into the text shown while it runs.
+type view struct {
+	text     strings.Builder
+	activity []string       // one line per tool call
+	calls    map[string]int // tool call ID → activity line
+}
+
+// apply records ev and reports whether the rendering changed.
+func (v *view) apply(ev runner.Event) bool {
+	switch ev.Kind {
+	case runner.EventTextDelta:
+		v.text.WriteString(ev.Text)
+		return ev.Text != ""
+	case runner.EventToolStarted:
+		if v.calls == nil {
+			v.calls = map[string]int{}
+		}
+		v.calls[ev.ToolCallID] = len(v.activity)
+		v.activity = append(v.activity, "→ "+ev.ToolName)
+		return true
+	case runner.EventToolFinished:
+		if i, ok := v.calls[ev.ToolCallID]; ok {
+			v.activity[i] = "✓ " + ev.ToolName
+			return true
+		}
+	}
+	return false
+}
+
+// render is the in-progress reply: the text so far followed by tool
+// activity.
+func (v *view) render() string {
+	s := strings.TrimSpace(v.text.String())
+	if len(v.activity) > 0 {
+		if s != "" {
+			s += "\n\n"
+		}
+		s += strings.Join(v.activity, "\n")
+	}
+	if s == "" {
+		return placeholder
+	}
+	return s
+}
+
+// final is the finished reply: the text alone, plus the error if the run
+// failed.
+func (v *view) final(err error) string {
+	s := strings.TrimSpace(v.text.String())
+	var note string
+	switch {
+	case err == nil:
+	case errors.Is(err, context.Canceled):
+		note = "(stopped)"
+	default:
+		note = "error: " + err.Error()
+	}
+	if note != "" {
+		if s != "" {
+			s += "\n\n"
+		}
+		s += note
+	}
+	if s == "" {
+		return "(no reply)"
+	}
+	return s
+}
+
+// reply is the bot's answer to one message. Text longer than Telegram's
+// message limit continues in further messages.
+type reply struct {
+	client *Client
+	chatID int64
+	ids    []int64  // sent message IDs, in order
+	sent   []string // current text of each sent message
+}
+
+// update makes the reply show text, editing only messages whose text
+// changed and sending new ones as needed.
+func (r *reply) update(ctx context.Context, text string) error {
+	for i, page := range paginate(text, maxMessageRunes) {
+		if i < len(r.ids) {
+			if r.sent[i] == page {
+				continue
+			}
+			if err := r.client.EditMessageText(ctx, r.chatID, r.ids[i], page); err != nil {
+				return err
+			}
+			r.sent[i] = page
+			continue
+		}
+		id, err := r.client.SendMessage(ctx, r.chatID, page)
+		if err != nil {
+			return err
+		}
+		r.ids = append(r.ids, id)
+		r.sent = append(r.sent, page)
+	}
+	return nil
+}
+
+// paginate splits s into pieces of at most n runes.
+func paginate(s string, n int) []string {
+	runes := []rune(s)
+	var pages []string
+	for len(runes) > n {
+		pages = append(pages, string(runes[:n]))
+		runes = runes[n:]
+	}
+	return append(pages, string(runes))
+}
+
+// sleep waits for d or until ctx is done; it reports whether d elapsed.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// sleepUntil waits for d or until stop is closed; it reports whether d
+// elapsed.
+func sleepUntil(stop <-chan struct{}, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-stop:
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,334 @@
+package telegram_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chtushar/pingu/internal/llm"
+	"github.com/chtushar/pingu/internal/runner"
+	"github.com/chtushar/pingu/internal/session"
+	"github.com/chtushar/pingu/internal/telegram"
+	"github.com/chtushar/pingu/internal/tools"
+)
+
+const token = "123:test-token"
+
+// fakeAPI is a minimal Bot API: getUpdates hands out queued updates once,
+// and sent or edited messages are recorded per message ID.
+type fakeAPI struct {
+	t       *testing.T
+	mu      sync.Mutex
+	updates []map[string]any
+	nextID  int64
+	texts   map[int64]string // message ID → current text
+	changed chan struct{}
+	// rateLimitEdits makes the first edit open a one-second window in which
+	// every edit fails with retry_after, as Telegram does.
+	rateLimitEdits bool
+	blockedUntil   time.Time
+}
+
+// runnerFor returns a runner factory over p with default limits.
+func runnerFor(p llm.Provider) func() *runner.Runner {
+	return func() *runner.Runner { return &runner.Runner{Provider: p} }
+}
+
+func newFakeAPI(t *testing.T) (*fakeAPI, *httptest.Server) {
+	f := &fakeAPI{t: t, texts: map[int64]string{}, changed: make(chan struct{}, 100)}
+	srv := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeAPI) push(userID, chatID int64, text string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updates = append(f.updates, map[string]any{
+		"update_id": len(f.updates) + 1,
+		"message": map[string]any{
+			"message_id": 1000 + len(f.updates),
+			"from":       map[string]any{"id": userID},
+			"chat":       map[string]any{"id": chatID},
+			"text":       text,
+		},
+	})
+}
+
+func (f *fakeAPI) serve(w http.ResponseWriter, r *http.Request) {
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+token+"/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]any{"ok": false, "error_code": 404, "description": "Not Found"})
+		return
+	}
+	var params map[string]any
+	json.NewDecoder(r.Body).Decode(&params)
+	reply := func(result any) {
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch method {
+	case "getUpdates":
+		offset := int(params["offset"].(float64))
+		var out []map[string]any
+		for _, u := range f.updates {
+			if u["update_id"].(int) >= offset {
+				out = append(out, u)
+			}
+		}
+		if len(out) == 0 {
+			// Stand in for the long poll without holding up the test.
+			f.mu.Unlock()
+			select {
+			case <-r.Context().Done():
+			case <-time.After(20 * time.Millisecond):
+			}
+			f.mu.Lock()
+		}
+		reply(out)
+	case "sendMessage":
+		f.nextID++
+		f.texts[f.nextID] = params["text"].(string)
+		reply(map[string]any{"message_id": f.nextID, "chat": map[string]any{"id": params["chat_id"]}})
+	case "editMessageText":
+		if f.rateLimitEdits {
+			f.rateLimitEdits = false
+			f.blockedUntil = time.Now().Add(time.Second)
+		}
+		if time.Now().Before(f.blockedUntil) {
+			json.NewEncoder(w).Encode(map[string]any{
+				"ok": false, "error_code": 429, "description": "Too Many Requests",
+				"parameters": map[string]any{"retry_after": 1},
+			})
+			return
+		}
+		f.texts[int64(params["message_id"].(float64))] = params["text"].(string)
+		reply(true)
+	default:
+		f.t.Errorf("unexpected method %q", method)
+	}
+	select {
+	case f.changed <- struct{}{}:
+	default:
+	}
+}
+
+// waitFor polls until some message satisfies ok.
+func (f *fakeAPI) waitFor(t *testing.T, ok func(texts map[int64]string) bool) map[int64]string {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		f.mu.Lock()
+		snapshot := map[int64]string{}
+		for k, v := range f.texts {
+			snapshot[k] = v
+		}
+		f.mu.Unlock()
+		if ok(snapshot) {
+			return snapshot
+		}
+		select {
+		case <-f.changed:
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("timed out; messages = %v", snapshot)
+		}
+	}
+}
+
+func hasText(want string) func(map[int64]string) bool {
+	return func(texts map[int64]string) bool {
+		for _, s := range texts {
+			if s == want {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// scriptedProvider replays one response per call, recording requests.
+type scriptedProvider struct {
+	mu        sync.Mutex
+	requests  []llm.Request
+	responses [][]llm.Event
+}
+
+func (p *scriptedProvider) Stream(_ context.Context, req llm.Request) (llm.Stream, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests = append(p.requests, req)
+	events := p.responses[0]
+	if len(p.responses) > 1 {
+		p.responses = p.responses[1:]
+	}
+	return llm.NewSliceStream(events), nil
+}
+
+func (p *scriptedProvider) calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.requests)
+}
+
+type echoTool struct{}
+
+func (echoTool) Name() string                { return "echo" }
+func (echoTool) Description() string         { return "echoes value" }
+func (echoTool) Parameters() json.RawMessage { return json.RawMessage(`{"type":"object"}`) }
+func (echoTool) Run(_ context.Context, args json.RawMessage) (string, error) {
+	return string(args), nil
+}
+
+func startBot(t *testing.T, srvURL string, p llm.Provider, store *session.Store) {
+	t.Helper()
+	reg, err := tools.NewRegistry(echoTool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot, err := telegram.New(telegram.Config{
+		Client:       telegram.NewClient(srvURL, token, nil),
+		AllowedUsers: []int64{7},
+		Store:        store,
+		NewRunner:    runnerFor(p),
+		Model:        "m",
+		ModelRef:     "openai/m",
+		Tools:        reg,
+		EditInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- bot.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	})
+}
+
+func openStore(t *testing.T) *session.Store {
+	t.Helper()
+	store, err := session.Open(context.Background(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestBotAnswersAndPersists(t *testing.T) {
+	api, srv := newFakeAPI(t)
+	p := &scriptedProvider{responses: [][]llm.Event{
+		{
+			{Type: llm.EventToolCallStart, ToolCallID: "c1", ToolName: "echo"},
+			{Type: llm.EventToolCallDelta, ArgumentsDelta: `{}`},
+			{Type: llm.EventToolCallEnd},
+		},
+		{{Type: llm.EventTextDelta, Text: "Hello "}, {Type: llm.EventTextDelta, Text: "there."}},
+	}}
+	store := openStore(t)
+	startBot(t, srv.URL, p, store)
+
+	api.push(7, 42, "hi")
+	api.waitFor(t, hasText("Hello there."))
+
+	sess, err := store.Get(context.Background(), telegram.SessionPrefix+"42")
+	if err != nil {
+		t.Fatalf("session not stored: %v", err)
+	}
+	msgs, _ := store.Messages(context.Background(), sess.ID)
+	if len(msgs) != 4 || msgs[0].Content != "hi" {
+		t.Errorf("stored messages = %+v", msgs)
+	}
+
+	// A second message continues the same conversation.
+	api.push(7, 42, "again")
+	api.waitFor(t, func(texts map[int64]string) bool { return p.calls() == 3 && len(texts) == 2 })
+	p.mu.Lock()
+	history := p.requests[2].Messages
+	p.mu.Unlock()
+	if len(history) != 5 || history[0].Content != "hi" || history[4].Content != "again" {
+		t.Errorf("second run history = %+v", history)
+	}
+}
+
+func TestBotIgnoresUsersOutsideAllowlist(t *testing.T) {
+	api, srv := newFakeAPI(t)
+	p := &scriptedProvider{responses: [][]llm.Event{{{Type: llm.EventTextDelta, Text: "ok"}}}}
+	startBot(t, srv.URL, p, openStore(t))
+
+	api.push(99, 99, "let me in")
+	api.push(7, 1, "hi")
+	texts := api.waitFor(t, hasText("ok"))
+	if p.calls() != 1 || len(texts) != 1 {
+		t.Errorf("calls = %d, messages = %v", p.calls(), texts)
+	}
+}
+
+func TestBotNewCommandStartsFreshSession(t *testing.T) {
+	api, srv := newFakeAPI(t)
+	p := &scriptedProvider{responses: [][]llm.Event{{{Type: llm.EventTextDelta, Text: "ok"}}}}
+	startBot(t, srv.URL, p, openStore(t))
+
+	api.push(7, 5, "first")
+	api.waitFor(t, hasText("ok"))
+	api.push(7, 5, "/new@pingu_bot")
+	api.waitFor(t, hasText("Started a new conversation."))
+	api.push(7, 5, "second")
+	api.waitFor(t, func(map[int64]string) bool { return p.calls() == 2 })
+	p.mu.Lock()
+	history := p.requests[1].Messages
+	p.mu.Unlock()
+	if len(history) != 1 || history[0].Content != "second" {
+		t.Errorf("history after /new = %+v", history)
+	}
+}
+
+func TestBotHonorsRetryAfter(t *testing.T) {
+	api, srv := newFakeAPI(t)
+	api.rateLimitEdits = true
+	p := &scriptedProvider{responses: [][]llm.Event{{{Type: llm.EventTextDelta, Text: "eventually"}}}}
+	startBot(t, srv.URL, p, openStore(t))
+
+	start := time.Now()
+	api.push(7, 3, "hi")
+	api.waitFor(t, hasText("eventually"))
+	if time.Since(start) < time.Second {
+		t.Errorf("reply landed after %s, before retry_after elapsed", time.Since(start))
+	}
+}
+
+func TestNewRequiresAllowlist(t *testing.T) {
+	if _, err := telegram.New(telegram.Config{}); err == nil {
+		t.Fatal("expected error for empty allowlist")
+	}
+}
+
+func TestRunFailsOnRejectedToken(t *testing.T) {
+	_, srv := newFakeAPI(t)
+	bot, err := telegram.New(telegram.Config{
+		Client:       telegram.NewClient(srv.URL, "wrong", nil),
+		AllowedUsers: []int64{1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = bot.Run(ctx)
+	if err == nil || !strings.Contains(err.Error(), "rejected the bot token") || strings.Contains(err.Error(), "wrong") {
+		t.Errorf("Run = %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the Telegram Bot API endpoint.
+const DefaultBaseURL = "https://api.telegram.org"
+
+// Client calls the Bot API methods the bot needs. It is safe for
+// concurrent use.
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// NewClient returns a client for the bot with token. An empty baseURL means
+// DefaultBaseURL; httpClient may be nil.
+func NewClient(baseURL, token string, httpClient *http.Client) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	return &Client{baseURL: strings.TrimRight(baseURL, "/"), token: token, http: httpClient}
+}
+
+// APIError is a Bot API call that returned ok=false.
+type APIError struct {
+	Method      string
+	Code        int
+	Description string
+	RetryAfter  time.Duration // set when the API asks the bot to slow down
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("telegram %s: %d %s", e.Method, e.Code, e.Description)
+}
+
+// Update is one incoming update; only messages are used.
+type Update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *Message `json:"message"`
+}
+
+// Message is an incoming or sent message.
+type Message struct {
+	MessageID int64  `json:"message_id"`
+	From      *User  `json:"from"`
+	Chat      Chat   `json:"chat"`
+	Text      string `json:"text"`
+}
+
+// User is a Telegram user.
+type User struct {
+	ID int64 `json:"id"`
+}
+
+// Chat is a Telegram chat.
+type Chat struct {
+	ID int64 `json:"id"`
+}
+
+type apiResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Parameters  *struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// call posts params as JSON to method and decodes the result into out.
+func (c *Client) call(ctx context.Context, method string, params, out any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/bot"+c.token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		// The URL embeds the token; never let it reach an error message.
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("telegram %s: request failed", method)
+	}
+	defer resp.Body.Close()
+	var r apiResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&r); err != nil {
+		return &APIError{Method: method, Code: resp.StatusCode, Description: "malformed response"}
+	}
+	if !r.OK {
+		apiErr := &APIError{Method: method, Code: r.ErrorCode, Description: r.Description}
+		if r.Parameters != nil && r.Parameters.RetryAfter > 0 {
+			apiErr.RetryAfter = time.Duration(r.Parameters.RetryAfter) * time.Second
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(r.Result, out)
+}
+
+// GetUpdates long-polls for updates with IDs of at least offset, waiting up
+// to timeout for one to arrive.
+func (c *Client) GetUpdates(ctx context.Context, offset int64, timeout time.Duration) ([]Update, error) {
+	var updates []Update
+	err := c.call(ctx, "getUpdates", map[string]any{
+		"offset":          offset,
+		"timeout":         int(timeout / time.Second),
+		"allowed_updates": []string{"message"},
+	}, &updates)
+	return updates, err
+}
+
+// SendMessage sends text to chatID and returns the new message's ID.
+func (c *Client) SendMessage(ctx context.Context, chatID int64, text string) (int64, error) {
+	var m Message
+	err := c.call(ctx, "sendMessage", map[string]any{"chat_id": chatID, "text": text}, &m)
+	return m.MessageID, err
+}
+
+// EditMessageText replaces the text of a message the bot sent.
+func (c *Client) EditMessageText(ctx context.Context, chatID, messageID int64, text string) error {
+	return c.call(ctx, "editMessageText", map[string]any{
+		"chat_id":    chatID,
+		"message_id": messageID,
+		"text":       text,
+	}, nil)
+}
@@ -0,0 +1,234 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// ManifestFlag is the single argument an executable tool receives when
+	// pingu asks for its manifest.
+	ManifestFlag = "--manifest"
+	// ManifestTimeout bounds one manifest invocation during discovery.
+	ManifestTimeout = 10 * time.Second
+	// maxManifestBytes bounds a manifest printed on stdout.
+	maxManifestBytes = 256 * 1024
+	// maxStderrBytes bounds stderr kept for error messages.
+	maxStderrBytes = 4 * 1024
+	// killGrace is how long pipes may stay open after the process group
+	// was killed before Wait gives up on them.
+	killGrace = 2 * time.Second
+)
+
+// validName matches tool names every supported provider accepts.
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Manifest describes an executable tool. The executable prints it as JSON on
+// stdout when invoked with ManifestFlag.
+type Manifest struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	// ParallelSafe, when false, keeps the runner from running the tool at
+	// the same time as other calls. Unset means true.
+	ParallelSafe *bool `json:"parallel_safe,omitempty"`
+}
+
+// Validate checks the fields a provider needs to advertise the tool.
+func (m Manifest) Validate() error {
+	if !validName.MatchString(m.Name) {
+		return fmt.Errorf("invalid name %q: want 1-64 letters, digits, '_' or '-'", m.Name)
+	}
+	if strings.TrimSpace(m.Description) == "" {
+		return errors.New("description is empty")
+	}
+	if len(m.Parameters) > 0 {
+		var schema map[string]any
+		if err := json.Unmarshal(m.Parameters, &schema); err != nil {
+			return fmt.Errorf("parameters: not a JSON object: %w", err)
+		}
+	}
+	return nil
+}
+
+// Executable is a Tool backed by a subprocess. Each call starts the
+// executable with no arguments, writes the JSON arguments to its stdin, and
+// returns its stdout. A non-zero exit is a tool error carrying stderr.
+//
+// The process runs in its own process group; when the call context is done
+// the whole group is killed so grandchildren cannot outlive the run.
+type Executable struct {
+	path      string
+	manifest  Manifest
+	maxOutput int64 // 0 means unbounded
+}
+
+// LoadExecutable asks the executable at path for its manifest.
+func LoadExecutable(ctx context.Context, path string) (*Executable, error) {
+	ctx, cancel := context.WithTimeout(ctx, ManifestTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := command(ctx, path, ManifestFlag)
+	cmd.Stdout = &limitedBuffer{buf: &stdout, max: maxManifestBytes}
+	cmd.Stderr = &limitedBuffer{buf: &stderr, max: maxStderrBytes}
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("manifest: %w", ctxErr)
+		}
+		return nil, fmt.Errorf("manifest: %w%s", err, stderrSuffix(&stderr))
+	}
+
+	var m Manifest
+	dec := json.NewDecoder(&stdout)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("manifest: decode: %w", err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("manifest: %w", err)
+	}
+	return &Executable{path: path, manifest: m}, nil
+}
+
+// Discover loads every executable tool in dir. Hidden files, directories,
+// and files without an execute bit are skipped. A missing dir yields no
+// tools. Errors for individual files are joined so callers see all of them.
+func Discover(ctx context.Context, dir string) ([]*Executable, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var (
+		out  []*Executable
+		errs []error
+		seen = map[string]string{}
+	)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		info, err := os.Stat(path) // follow symlinks to the target
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", e.Name(), err))
+			continue
+		}
+		if !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+		t, err := LoadExecutable(ctx, path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", e.Name(), err))
+			continue
+		}
+		if prev, ok := seen[t.Name()]; ok {
+			errs = append(errs, fmt.Errorf("%s: duplicate tool name %q (also declared by %s)", e.Name(), t.Name(), prev))
+			continue
+		}
+		seen[t.Name()] = e.Name()
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out, errors.Join(errs...)
+}
+
+// Path returns the executable's path.
+func (e *Executable) Path() string { return e.path }
+
+// Name returns the manifest name.
+func (e *Executable) Name() string { return e.manifest.Name }
+
+// Description returns the manifest description.
+func (e *Executable) Description() string { return e.manifest.Description }
+
+// Parameters returns the manifest JSON Schema, or nil.
+func (e *Executable) Parameters() json.RawMessage { return e.manifest.Parameters }
+
+// ParallelSafe implements ParallelSafe from the manifest.
+func (e *Executable) ParallelSafe() bool {
+	return e.manifest.ParallelSafe == nil || *e.manifest.ParallelSafe
+}
+
+// WithOutputLimit returns a copy of e that captures at most n bytes of
+// stdout per call. One extra byte is kept so the runner can tell that the
+// output overflowed and warn about truncation.
+func (e *Executable) WithOutputLimit(n int64) *Executable {
+	c := *e
+	c.maxOutput = n
+	return &c
+}
+
+// Run executes the tool with args on stdin.
+func (e *Executable) Run(ctx context.Context, args json.RawMessage) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := command(ctx, e.path)
+	cmd.Stdin = bytes.NewReader(args)
+	if e.maxOutput > 0 {
+		cmd.Stdout = &limitedBuffer{buf: &stdout, max: e.maxOutput + 1}
+	} else {
+		cmd.Stdout = &stdout
+	}
+	cmd.Stderr = &limitedBuffer{buf: &stderr, max: maxStderrBytes}
+
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return "", fmt.Errorf("exit status %d%s", exit.ExitCode(), stderrSuffix(&stderr))
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// command builds a subprocess in its own process group that is killed as a
+// group when ctx is done.
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+	cmd.WaitDelay = killGrace
+	return cmd
+}
+
+func stderrSuffix(stderr *bytes.Buffer) string {
+	msg := strings.TrimSpace(stderr.String())
+	if msg == "" {
+		return ""
+	}
+	return ": " + msg
+}
+
+// limitedBuffer keeps the first max bytes written and silently discards the
+// rest, so a chatty process never blocks on a full pipe.
+type limitedBuffer struct {
+	buf *bytes.Buffer
+	max int64
+}
+
+func (w *limitedBuffer) Write(p []byte) (int, error) {
+	if room := w.max - int64(w.buf.Len()); room > 0 {
+		if int64(len(p)) > room {
+			w.buf.Write(p[:room])
+		} else {
+			w.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,165 @@
+//go:build unix
+
+package tools_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/chtushar/pingu/internal/tools"
+)
+
+// writeTool writes an executable shell script that prints manifest on
+// --manifest and otherwise runs body.
+func writeTool(t *testing.T, dir, file, manifest, body string) string {
+	t.Helper()
+	script := "#!/bin/sh\n" +
+		"if [ \"$1\" = \"--manifest\" ]; then\n" +
+		"cat <<'EOF'\n" + manifest + "\nEOF\n" +
+		"exit 0\nfi\n" + body + "\n"
+	path := filepath.Join(dir, file)
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const upperManifest = `{"name":"upper","description":"uppercases text","parameters":{"type":"object","properties":{"text":{"type":"string"}}}}`
+
+func TestDiscover(t *testing.T) {
+	dir := t.TempDir()
+	writeTool(t, dir, "upper.sh", upperManifest, `tr a-z A-Z`)
+	writeTool(t, dir, "echo", `{"name":"echo","description":"echoes stdin","parallel_safe":false}`, `cat`)
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a tool"), 0o644)
+	os.WriteFile(filepath.Join(dir, ".hidden"), []byte("#!/bin/sh\n"), 0o755)
+
+	found, err := tools.Discover(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+	if len(found) != 2 || found[0].Name() != "echo" || found[1].Name() != "upper" {
+		t.Fatalf("found = %v", found)
+	}
+	if tools.IsParallelSafe(found[0]) || !tools.IsParallelSafe(found[1]) {
+		t.Errorf("parallel_safe not read from the manifests")
+	}
+	if !json.Valid(found[1].Parameters()) {
+		t.Errorf("parameters = %s", found[1].Parameters())
+	}
+
+	out, err := found[1].Run(context.Background(), json.RawMessage(`{"text":"hi"}`))
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if out != `{"TEXT":"HI"}` {
+		t.Errorf("out = %q", out)
+	}
+}
+
+func TestDiscoverMissingDir(t *testing.T) {
+	found, err := tools.Discover(context.Background(), filepath.Join(t.TempDir(), "tools"))
+	if err != nil || len(found) != 0 {
+		t.Fatalf("found = %v, err = %v", found, err)
+	}
+}
+
+func TestDiscoverReportsEveryBadManifest(t *testing.T) {
+	dir := t.TempDir()
+	writeTool(t, dir, "bad-name", `{"name":"has space","description":"x"}`, `cat`)
+	writeTool(t, dir, "bad-json", `{not json`, `cat`)
+	writeTool(t, dir, "good", `{"name":"good","description":"fine"}`, `cat`)
+
+	found, err := tools.Discover(context.Background(), dir)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, want := range []string{"bad-name", "bad-json"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error should mention %s: %v", want, err)
+		}
+	}
+	if len(found) != 1 || found[0].Name() != "good" {
+		t.Errorf("found = %v", found)
+	}
+}
+
+func TestDiscoverDuplicateName(t *testing.T) {
+	dir := t.TempDir()
+	writeTool(t, dir, "a", `{"name":"same","description":"x"}`, `cat`)
+	writeTool(t, dir, "b", `{"name":"same","description":"y"}`, `cat`)
+	if _, err := tools.Discover(context.Background(), dir); err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Fatalf("expected duplicate error, got %v", err)
+	}
+}
+
+func TestExecutableFailure(t *testing.T) {
+	dir := t.TempDir()
+	writeTool(t, dir, "fail", `{"name":"fail","description":"fails"}`, `echo "bad input" >&2; exit 3`)
+	tool, err := tools.LoadExecutable(context.Background(), filepath.Join(dir, "fail"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tool.Run(context.Background(), json.RawMessage(`{}`))
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "bad input") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestExecutableOutputLimit(t *testing.T) {
+	dir := t.TempDir()
+	writeTool(t, dir, "noisy", `{"name":"noisy","description":"noisy"}`, `head -c 100000 /dev/zero | tr '\0' a`)
+	tool, err := tools.LoadExecutable(context.Background(), filepath.Join(dir, "noisy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tool.WithOutputLimit(10).Run(context.Background(), json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One byte past the limit marks the output as overflowing.
+	if len(out) != 11 {
+		t.Errorf("len(out) = %d, want 11", len(out))
+	}
+}
+
+func TestExecutableCancelKillsProcessGroup(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "child.pid")
+	writeTool(t, dir, "slow", `{"name":"slow","description":"sleeps"}`,
+		`sleep 30 & echo $! > `+pidFile+`; wait`)
+	tool, err := tools.LoadExecutable(context.Background(), filepath.Join(dir, "slow"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = tool.Run(ctx, json.RawMessage(`{}`))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("run took %s after cancellation", elapsed)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("read pid: %v", err)
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	deadline := time.Now().Add(2 * time.Second)
+	for syscall.Kill(pid, 0) == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("grandchild %d survived cancellation", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,496 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// File tool names.
+const (
+	ReadFileName    = "read_file"
+	WriteFileName   = "write_file"
+	ListDirName     = "list_dir"
+	SearchFilesName = "search_files"
+	ApplyPatchName  = "apply_patch"
+)
+
+const (
+	maxSearchMatches  = 200
+	maxSearchFileSize = 1 << 20
+	maxSearchLineLen  = 300
+	binarySniffBytes  = 8 * 1024
+)
+
+// Workspace confines paths to a root directory. Paths are resolved through
+// symlinks before they are checked, so neither ".." nor a symlink can reach
+// outside the root.
+type Workspace struct {
+	root string // absolute and symlink-free
+}
+
+// NewWorkspace returns a workspace rooted at dir, which must exist.
+func NewWorkspace(dir string) (*Workspace, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	root, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("workspace %s is not a directory", dir)
+	}
+	return &Workspace{root: root}, nil
+}
+
+// Root returns the workspace root.
+func (w *Workspace) Root() string { return w.root }
+
+// Resolve maps p, relative to the root or absolute, to a symlink-free
+// absolute path inside the workspace. The path need not exist; symlinks in
+// its existing prefix are resolved, and a dangling symlink is rejected
+// because writing through it could create a file anywhere.
+func (w *Workspace) Resolve(p string) (string, error) {
+	if p == "" {
+		p = "."
+	}
+	var abs string
+	if filepath.IsAbs(p) {
+		abs = filepath.Clean(p)
+	} else {
+		abs = filepath.Join(w.root, p)
+	}
+	if !w.contains(abs) {
+		return "", fmt.Errorf("path %q is outside the workspace", p)
+	}
+	resolved, err := resolveExisting(abs)
+	if err != nil {
+		return "", fmt.Errorf("path %q: %w", p, err)
+	}
+	if !w.contains(resolved) {
+		return "", fmt.Errorf("path %q resolves outside the workspace", p)
+	}
+	return resolved, nil
+}
+
+func (w *Workspace) contains(p string) bool {
+	rel, err := filepath.Rel(w.root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// rel renders an absolute path inside the workspace for the model.
+func (w *Workspace) rel(p string) string {
+	rel, err := filepath.Rel(w.root, p)
+	if err != nil {
+		return p
+	}
+	return filepath.ToSlash(rel)
+}
+
+// resolveExisting evaluates symlinks in the longest existing prefix of path
+// and appends the remainder unchanged.
+func resolveExisting(path string) (string, error) {
+	cur, rest := path, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(cur)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		if _, lerr := os.Lstat(cur); lerr == nil {
+			return "", errors.New("dangling symlink")
+		}
+		parent := filepath.Dir(cur)
+		if parent == cur {
+			return path, nil
+		}
+		rest = filepath.Join(filepath.Base(cur), rest)
+		cur = parent
+	}
+}
+
+// FilesConfig configures the file tools.
+type FilesConfig struct {
+	ReadOnly  bool  // omit write_file and apply_patch
+	MaxOutput int64 // bytes read_file captures; 0 means unbounded
+}
+
+// FileTools returns the file tools for ws: read_file, list_dir, and
+// search_files, plus write_file and apply_patch unless cfg.ReadOnly.
+func FileTools(ws *Workspace, cfg FilesConfig) []Tool {
+	ts := []Tool{
+		&readFile{ws: ws, maxOutput: cfg.MaxOutput},
+		&listDir{ws: ws},
+		&searchFiles{ws: ws},
+	}
+	if !cfg.ReadOnly {
+		ts = append(ts, &writeFile{ws: ws}, &applyPatch{ws: ws})
+	}
+	return ts
+}
+
+// decodeArgs decodes tool arguments strictly so misspelled fields surface
+// as errors the model can correct.
+func decodeArgs(args json.RawMessage, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(args))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+type readFile struct {
+	ws        *Workspace
+	maxOutput int64
+}
+
+func (t *readFile) Name() string { return ReadFileName }
+func (t *readFile) Description() string {
+	return "Read a text file in the workspace. Optionally restrict to a 1-based, inclusive line range."
+}
+func (t *readFile) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"path":{"type":"string","description":"file path relative to the workspace root"},"start_line":{"type":"integer","minimum":1},"end_line":{"type":"integer","minimum":1}},"required":["path"],"additionalProperties":false}`)
+}
+
+func (t *readFile) Run(_ context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		Path      string `json:"path"`
+		StartLine int    `json:"start_line"`
+		EndLine   int    `json:"end_line"`
+	}
+	if err := decodeArgs(args, &in); err != nil {
+		return "", err
+	}
+	path, err := t.ws.Resolve(in.Path)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", in.Path, unwrapPathError(err))
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		return "", fmt.Errorf("read %s: is a directory", in.Path)
+	}
+
+	if in.StartLine == 0 && in.EndLine == 0 {
+		var r io.Reader = f
+		if t.maxOutput > 0 {
+			// Keep one extra byte so the runner reports the truncation.
+			r = io.LimitReader(f, t.maxOutput+1)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return "", fmt.Errorf("read %s: %w", in.Path, err)
+		}
+		if isBinary(data) {
+			return "", fmt.Errorf("read %s: binary file", in.Path)
+		}
+		return string(data), nil
+	}
+
+	start, end := max(in.StartLine, 1), in.EndLine
+	if end != 0 && end < start {
+		return "", fmt.Errorf("read %s: end_line %d is before start_line %d", in.Path, end, start)
+	}
+	br := bufio.NewReader(f)
+	if head, _ := br.Peek(binarySniffBytes); isBinary(head) {
+		return "", fmt.Errorf("read %s: binary file", in.Path)
+	}
+	out, n, err := readLines(br, start, end, t.maxOutput)
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", in.Path, err)
+	}
+	if start > n {
+		return "", fmt.Errorf("read %s: line range %d-%d is outside the file's %d lines", in.Path, in.StartLine, in.EndLine, n)
+	}
+	return out, nil
+}
+
+// readLines returns lines start through end of r, 1-based and inclusive,
+// with end 0 meaning the last line, and how many lines it read. Only lines
+// in the range are kept, at most limit+1 bytes of them if limit > 0 so the
+// runner reports the truncation, and reading stops after line end.
+func readLines(r *bufio.Reader, start, end int, limit int64) (string, int, error) {
+	var b strings.Builder
+	line := 1        // the line being read
+	midLine := false // part of the current line was read
+	for end == 0 || line <= end {
+		chunk, err := r.ReadSlice('\n')
+		if line >= start {
+			b.Write(chunk)
+			if limit > 0 && int64(b.Len()) > limit {
+				return b.String()[:limit+1], line, nil
+			}
+		}
+		switch {
+		case err == nil:
+			line++
+			midLine = false
+		case errors.Is(err, bufio.ErrBufferFull):
+			midLine = true
+		case errors.Is(err, io.EOF):
+			if midLine || len(chunk) > 0 {
+				line++
+			}
+			return b.String(), line - 1, nil
+		default:
+			return "", 0, err
+		}
+	}
+	return b.String(), line - 1, nil
+}
+
+type writeFile struct{ ws *Workspace }
+
+func (t *writeFile) Name() string       { return WriteFileName }
+func (t *writeFile) ParallelSafe() bool { return false }
+func (t *writeFile) Description() string {
+	return "Create or overwrite a file in the workspace with the given content. Parent directories are created as needed."
+}
+func (t *writeFile) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"path":{"type":"string","description":"file path relative to the workspace root"},"content":{"type":"string"}},"required":["path","content"],"additionalProperties":false}`)
+}
+
+func (t *writeFile) Run(_ context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		Path    string  `json:"path"`
+		Content *string `json:"content"`
+	}
+	if err := decodeArgs(args, &in); err != nil {
+		return "", err
+	}
+	if in.Content == nil {
+		return "", errors.New("content is required")
+	}
+	path, err := t.ws.Resolve(in.Path)
+	if err != nil {
+		return "", err
+	}
+	if path == t.ws.root {
+		return "", fmt.Errorf("write %s: is a directory", in.Path)
+	}
+	if err := writeWorkspaceFile(path, []byte(*in.Content)); err != nil {
+		return "", fmt.Errorf("write %s: %w", in.Path, err)
+	}
+	return fmt.Sprintf("wrote %d bytes to %s", len(*in.Content), t.ws.rel(path)), nil
+}
+
+// writeWorkspaceFile writes data to a resolved path, creating parent
+// directories and keeping the mode of an existing file.
+func writeWorkspaceFile(path string, data []byte) error {
+	mode := fs.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		if info.IsDir() {
+			return errors.New("is a directory")
+		}
+		mode = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return unwrapPathError(err)
+	}
+	return unwrapPathError(os.WriteFile(path, data, mode))
+}
+
+type listDir struct{ ws *Workspace }
+
+func (t *listDir) Name() string { return ListDirName }
+func (t *listDir) Description() string {
+	return "List a workspace directory, one entry per line; directories end with a slash."
+}
+func (t *listDir) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"path":{"type":"string","description":"directory relative to the workspace root; default the root"}},"additionalProperties":false}`)
+}
+
+func (t *listDir) Run(_ context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		Path string `json:"path"`
+	}
+	if err := decodeArgs(args, &in); err != nil {
+		return "", err
+	}
+	path, err := t.ws.Resolve(in.Path)
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", fmt.Errorf("list %s: %w", orDot(in.Path), unwrapPathError(err))
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		b.WriteString(e.Name())
+		if e.IsDir() {
+			b.WriteString("/")
+		}
+		b.WriteString("\n")
+	}
+	if b.Len() == 0 {
+		return "(empty directory)", nil
+	}
+	return b.String(), nil
+}
+
+type searchFiles struct{ ws *Workspace }
+
+func (t *searchFiles) Name() string { return SearchFilesName }
+func (t *searchFiles) Description() string {
+	return fmt.Sprintf("Search text files under a workspace directory for a regular expression (RE2 syntax). "+
+		"Returns up to %d matches as path:line: text. .git directories and binary files are skipped.", maxSearchMatches)
+}
+func (t *searchFiles) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"pattern":{"type":"string","description":"regular expression"},"path":{"type":"string","description":"directory or file to search; default the workspace root"},"include":{"type":"string","description":"only search files whose name matches this glob, e.g. *.go"}},"required":["pattern"],"additionalProperties":false}`)
+}
+
+func (t *searchFiles) Run(ctx context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		Pattern string `json:"pattern"`
+		Path    string `json:"path"`
+		Include string `json:"include"`
+	}
+	if err := decodeArgs(args, &in); err != nil {
+		return "", err
+	}
+	re, err := regexp.Compile(in.Pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %w", err)
+	}
+	if in.Include != "" {
+		if _, err := filepath.Match(in.Include, ""); err != nil {
+			return "", fmt.Errorf("invalid include glob: %w", err)
+		}
+	}
+	start, err := t.ws.Resolve(in.Path)
+	if err != nil {
+		return "", err
+	}
+
+	var matches []string
+	more := false
+	err = filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // unreadable entries are skipped
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" && path != start {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if in.Include != "" {
+			if ok, _ := filepath.Match(in.Include, d.Name()); !ok {
+				return nil
+			}
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			// WalkDir does not follow symlinks; a linked file is searched
+			// only when its target is inside the workspace.
+			target, err := filepath.EvalSymlinks(path)
+			if err != nil || !t.ws.contains(target) {
+				return nil
+			}
+			if info, err := os.Stat(target); err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+		found, err := searchFile(path, re, maxSearchMatches-len(matches))
+		if err != nil {
+			return nil
+		}
+		for _, m := range found {
+			matches = append(matches, t.ws.rel(path)+":"+m)
+		}
+		if len(matches) >= maxSearchMatches {
+			more = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "no matches", nil
+	}
+	out := strings.Join(matches, "\n") + "\n"
+	if more {
+		out += fmt.Sprintf("(stopped after %d matches; narrow the pattern or path)\n", maxSearchMatches)
+	}
+	return out, nil
+}
+
+// searchFile returns up to limit "line: text" matches in a text file.
+func searchFile(path string, re *regexp.Regexp, limit int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || info.Size() > maxSearchFileSize {
+		return nil, errors.New("skipped")
+	}
+	data, err := io.ReadAll(f)
+	if err != nil || isBinary(data) {
+		return nil, errors.New("skipped")
+	}
+	var out []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), maxSearchFileSize)
+	for n := 1; sc.Scan() && len(out) < limit; n++ {
+		line := sc.Text()
+		if !re.MatchString(line) {
+			continue
+		}
+		if len(line) > maxSearchLineLen {
+			line = trimTail(line, int64(len(line)-maxSearchLineLen)) + "…"
+		}
+		out = append(out, fmt.Sprintf("%d: %s", n, line))
+	}
+	return out, nil
+}
+
+// isBinary reports whether data looks binary: a NUL byte near the start.
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), binarySniffBytes)], 0) >= 0
+}
+
+// unwrapPathError drops the absolute path from *fs.PathError so errors name
+// paths as the model wrote them.
+func unwrapPathError(err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return pe.Err
+	}
+	return err
+}
+
+func orDot(p string) string {
+	if p == "" {
+		return "."
+	}
+	return p
+}
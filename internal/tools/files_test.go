@@ -0,0 +1,229 @@
+package tools_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chtushar/pingu/internal/tools"
+)
+
+// fileTools returns the file tools over a fresh workspace, keyed by name.
+func fileTools(t *testing.T, cfg tools.FilesConfig) (string, map[string]tools.Tool) {
+	t.Helper()
+	dir := t.TempDir()
+	ws, err := tools.NewWorkspace(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]tools.Tool{}
+	for _, tool := range tools.FileTools(ws, cfg) {
+		byName[tool.Name()] = tool
+	}
+	return ws.Root(), byName
+}
+
+func call(t *testing.T, tool tools.Tool, args map[string]any) (string, error) {
+	t.Helper()
+	b, _ := json.Marshal(args)
+	return tool.Run(context.Background(), b)
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileToolsReadWriteList(t *testing.T) {
+	root, ts := fileTools(t, tools.FilesConfig{})
+	writeFiles(t, root, map[string]string{"a.txt": "one\ntwo\nthree\n", "sub/b.txt": "b"})
+
+	tests := []struct {
+		tool string
+		args map[string]any
+		want string
+	}{
+		{"read_file", map[string]any{"path": "a.txt"}, "one\ntwo\nthree\n"},
+		{"read_file", map[string]any{"path": "a.txt", "start_line": 2, "end_line": 2}, "two\n"},
+		{"read_file", map[string]any{"path": "a.txt", "start_line": 3}, "three\n"},
+		{"list_dir", map[string]any{}, "a.txt\nsub/\n"},
+		{"list_dir", map[string]any{"path": "sub"}, "b.txt\n"},
+		{"write_file", map[string]any{"path": "new/dir/c.txt", "content": "hi"}, "wrote 2 bytes to new/dir/c.txt"},
+		{"read_file", map[string]any{"path": "new/dir/c.txt"}, "hi"},
+	}
+	for _, tt := range tests {
+		got, err := call(t, ts[tt.tool], tt.args)
+		if err != nil || got != tt.want {
+			t.Errorf("%s %v = %q, %v; want %q", tt.tool, tt.args, got, err, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		tool string
+		args map[string]any
+	}{
+		{"read_file", map[string]any{"path": "missing.txt"}},
+		{"read_file", map[string]any{"path": "sub"}},
+		{"read_file", map[string]any{"path": "a.txt", "start_line": 9}},
+		{"read_file", map[string]any{"path": "a.txt", "lines": 2}},
+		{"write_file", map[string]any{"path": "sub", "content": "x"}},
+		{"write_file", map[string]any{"path": "a.txt"}},
+	} {
+		if _, err := call(t, ts[tt.tool], tt.args); err == nil {
+			t.Errorf("%s %v: expected error", tt.tool, tt.args)
+		}
+	}
+}
+
+func TestFileToolsReadTruncatesAndRejectsBinary(t *testing.T) {
+	root, ts := fileTools(t, tools.FilesConfig{MaxOutput: 4})
+	writeFiles(t, root, map[string]string{"big.txt": "0123456789", "bin": "a\x00b"})
+
+	if got, _ := call(t, ts["read_file"], map[string]any{"path": "big.txt"}); got != "01234" {
+		t.Errorf("read = %q, want max+1 bytes for the runner to truncate", got)
+	}
+	if _, err := call(t, ts["read_file"], map[string]any{"path": "bin"}); err == nil || !strings.Contains(err.Error(), "binary") {
+		t.Errorf("binary read error = %v", err)
+	}
+}
+
+func TestFileToolsReadLineRange(t *testing.T) {
+	root, ts := fileTools(t, tools.FilesConfig{MaxOutput: 16})
+	long := strings.Repeat("x", 10000) // longer than the line reader's buffer
+	writeFiles(t, root, map[string]string{
+		"long.txt": long + "\nshort\nlast",
+		"bin":      "a\x00b\nc\n",
+	})
+
+	tests := []struct {
+		start, end int
+		want       string
+	}{
+		{2, 2, "short\n"},
+		{2, 0, "short\nlast"},
+		{3, 3, "last"},
+		{1, 1, long[:17]}, // max+1 bytes for the runner to truncate
+	}
+	for _, tt := range tests {
+		got, err := call(t, ts["read_file"], map[string]any{"path": "long.txt", "start_line": tt.start, "end_line": tt.end})
+		if err != nil || got != tt.want {
+			t.Errorf("lines %d-%d = %q, %v; want %q", tt.start, tt.end, got, err, tt.want)
+		}
+	}
+	for _, args := range []map[string]any{
+		{"path": "long.txt", "start_line": 4},
+		{"path": "long.txt", "start_line": 3, "end_line": 2},
+	} {
+		if _, err := call(t, ts["read_file"], args); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+	if _, err := call(t, ts["read_file"], map[string]any{"path": "bin", "start_line": 2}); err == nil || !strings.Contains(err.Error(), "binary") {
+		t.Errorf("binary range read error = %v", err)
+	}
+}
+
+func TestFileToolsConfinement(t *testing.T) {
+	root, ts := fileTools(t, tools.FilesConfig{})
+	outside := t.TempDir()
+	writeFiles(t, outside, map[string]string{"secret.txt": "s3cret"})
+	writeFiles(t, root, map[string]string{"ok.txt": "ok"})
+
+	links := map[string]string{
+		"escape":      outside,
+		"escape.txt":  filepath.Join(outside, "secret.txt"),
+		"dangling":    filepath.Join(outside, "nope.txt"),
+		"inside-link": filepath.Join(root, "ok.txt"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skipf("symlinks unavailable: %v", err)
+		}
+	}
+
+	for _, tt := range []struct {
+		tool string
+		args map[string]any
+	}{
+		{"read_file", map[string]any{"path": "../" + filepath.Base(outside) + "/secret.txt"}},
+		{"read_file", map[string]any{"path": filepath.Join(outside, "secret.txt")}},
+		{"read_file", map[string]any{"path": "escape/secret.txt"}},
+		{"read_file", map[string]any{"path": "escape.txt"}},
+		{"list_dir", map[string]any{"path": "escape"}},
+		{"list_dir", map[string]any{"path": ".."}},
+		{"write_file", map[string]any{"path": "escape/new.txt", "content": "x"}},
+		{"write_file", map[string]any{"path": "dangling", "content": "x"}},
+		{"search_files", map[string]any{"pattern": "s3cret", "path": "escape"}},
+		{"apply_patch", map[string]any{"patch": "--- /dev/null\n+++ b/escape/new.txt\n@@ -0,0 +1 @@\n+x\n"}},
+	} {
+		if out, err := call(t, ts[tt.tool], tt.args); err == nil {
+			t.Errorf("%s %v = %q, want an error", tt.tool, tt.args, out)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new.txt")); err == nil {
+		t.Error("a write escaped the workspace")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "nope.txt")); err == nil {
+		t.Error("a write followed a dangling symlink out of the workspace")
+	}
+
+	// Links that stay inside the workspace work, and search skips the rest.
+	if got, err := call(t, ts["read_file"], map[string]any{"path": "inside-link"}); err != nil || got != "ok" {
+		t.Errorf("read inside-link = %q, %v", got, err)
+	}
+	if got, _ := call(t, ts["search_files"], map[string]any{"pattern": "s3cret|ok"}); got != "inside-link:1: ok\nok.txt:1: ok\n" {
+		t.Errorf("search = %q", got)
+	}
+}
+
+func TestSearchFiles(t *testing.T) {
+	root, ts := fileTools(t, tools.FilesConfig{})
+	writeFiles(t, root, map[string]string{
+		"main.go":        "package main\n\nfunc main() {}\n",
+		"lib/util.go":    "package lib\n\nfunc Helper() {}\n",
+		"lib/notes.md":   "func in prose\n",
+		".git/config":    "func hidden\n",
+		"data.bin":       "func\x00",
+		"lib/deep/x.txt": "nothing here\n",
+	})
+
+	tests := []struct {
+		args map[string]any
+		want string
+	}{
+		{map[string]any{"pattern": `^func \w+\(`}, "lib/util.go:3: func Helper() {}\nmain.go:3: func main() {}\n"},
+		{map[string]any{"pattern": "func", "include": "*.md"}, "lib/notes.md:1: func in prose\n"},
+		{map[string]any{"pattern": "func", "path": "lib", "include": "*.go"}, "lib/util.go:3: func Helper() {}\n"},
+		{map[string]any{"pattern": "zzz"}, "no matches"},
+	}
+	for _, tt := range tests {
+		got, err := call(t, ts["search_files"], tt.args)
+		if err != nil || got != tt.want {
+			t.Errorf("search %v = %q, %v; want %q", tt.args, got, err, tt.want)
+		}
+	}
+	if _, err := call(t, ts["search_files"], map[string]any{"pattern": "("}); err == nil {
+		t.Error("expected invalid pattern error")
+	}
+}
+
+func TestFileToolsReadOnly(t *testing.T) {
+	_, ts := fileTools(t, tools.FilesConfig{ReadOnly: true})
+	for _, name := range []string{"write_file", "apply_patch"} {
+		if _, ok := ts[name]; ok {
+			t.Errorf("%s offered in read-only mode", name)
+		}
+	}
+	if len(ts) != 3 {
+		t.Errorf("tools = %v", ts)
+	}
+}
@@ -0,0 +1,324 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// patchContextSearch is how far, in lines, a hunk may drift from the line
+// numbers in its header and still apply.
+const patchContextSearch = 200
+
+type applyPatch struct{ ws *Workspace }
+
+func (t *applyPatch) Name() string       { return ApplyPatchName }
+func (t *applyPatch) ParallelSafe() bool { return false }
+func (t *applyPatch) Description() string {
+	return "Apply a unified diff (as produced by diff -u or git diff) to files in the workspace. " +
+		"Use /dev/null as the old or new path to create or delete a file. " +
+		"Every hunk is checked before any file is changed."
+}
+func (t *applyPatch) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"patch":{"type":"string","description":"unified diff"}},"required":["patch"],"additionalProperties":false}`)
+}
+
+func (t *applyPatch) Run(_ context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		Patch string `json:"patch"`
+	}
+	if err := decodeArgs(args, &in); err != nil {
+		return "", err
+	}
+	files, err := parsePatch(in.Patch)
+	if err != nil {
+		return "", err
+	}
+
+	// Compute every result first so a hunk that does not apply leaves the
+	// workspace untouched.
+	type change struct {
+		remove string // resolved path to delete, if any
+		write  string // resolved path to write, if any
+		data   []byte
+		report string
+	}
+	var changes []change
+	for _, fp := range files {
+		var c change
+		var old string
+		if fp.oldPath != "" {
+			path, err := t.ws.Resolve(fp.oldPath)
+			if err != nil {
+				return "", err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return "", fmt.Errorf("patch %s: %w", fp.oldPath, unwrapPathError(err))
+			}
+			old = string(data)
+			if fp.newPath != fp.oldPath {
+				c.remove = path
+			}
+		}
+		if fp.newPath != "" {
+			path, err := t.ws.Resolve(fp.newPath)
+			if err != nil {
+				return "", err
+			}
+			if fp.oldPath == "" || fp.newPath != fp.oldPath {
+				if _, err := os.Lstat(path); err == nil {
+					return "", fmt.Errorf("patch %s: file already exists", fp.newPath)
+				}
+			}
+			updated, err := applyHunks(old, fp.hunks)
+			if err != nil {
+				return "", fmt.Errorf("patch %s: %w", fp.newPath, err)
+			}
+			c.write, c.data = path, []byte(updated)
+		}
+		switch {
+		case fp.oldPath == "":
+			c.report = "created " + fp.newPath
+		case fp.newPath == "":
+			c.report = "deleted " + fp.oldPath
+		case fp.oldPath != fp.newPath:
+			c.report = fmt.Sprintf("renamed %s to %s (%s)", fp.oldPath, fp.newPath, hunkCount(len(fp.hunks)))
+		default:
+			c.report = fmt.Sprintf("patched %s (%s)", fp.newPath, hunkCount(len(fp.hunks)))
+		}
+		changes = append(changes, c)
+	}
+
+	var b strings.Builder
+	for _, c := range changes {
+		if c.write != "" {
+			if err := writeWorkspaceFile(c.write, c.data); err != nil {
+				return "", fmt.Errorf("write %s: %w", t.ws.rel(c.write), err)
+			}
+		}
+		if c.remove != "" {
+			if err := os.Remove(c.remove); err != nil {
+				return "", fmt.Errorf("remove %s: %w", t.ws.rel(c.remove), unwrapPathError(err))
+			}
+		}
+		b.WriteString(c.report + "\n")
+	}
+	return b.String(), nil
+}
+
+func hunkCount(n int) string {
+	if n == 1 {
+		return "1 hunk"
+	}
+	return fmt.Sprintf("%d hunks", n)
+}
+
+// filePatch is one file's section of a unified diff. An empty oldPath
+// creates the file; an empty newPath deletes it.
+type filePatch struct {
+	oldPath, newPath string
+	hunks            []hunk
+}
+
+type hunk struct {
+	oldStart int
+	old, new []string // lines without their newline
+	// oldNoEOL and newNoEOL record "\ No newline at end of file" markers.
+	oldNoEOL, newNoEOL bool
+}
+
+// parsePatch parses a unified diff. Lines outside file sections, such as
+// git's "diff --git" and "index" headers, are ignored.
+func parsePatch(patch string) ([]filePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(patch, "\r\n", "\n"), "\n")
+	var files []filePatch
+	for i := 0; i < len(lines); {
+		if !strings.HasPrefix(lines[i], "--- ") {
+			i++
+			continue
+		}
+		if i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "+++ ") {
+			return nil, fmt.Errorf("line %d: \"---\" header without \"+++\" header", i+1)
+		}
+		fp := filePatch{
+			oldPath: patchPath(lines[i][4:], "a/"),
+			newPath: patchPath(lines[i+1][4:], "b/"),
+		}
+		if fp.oldPath == "" && fp.newPath == "" {
+			return nil, fmt.Errorf("line %d: both paths are /dev/null", i+1)
+		}
+		i += 2
+		for i < len(lines) && strings.HasPrefix(lines[i], "@@ ") {
+			h, next, err := parseHunk(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			fp.hunks = append(fp.hunks, h)
+			i = next
+		}
+		if len(fp.hunks) == 0 && fp.newPath != "" {
+			return nil, fmt.Errorf("%s: no hunks", fp.newPath)
+		}
+		files = append(files, fp)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no file sections found; expected a unified diff with --- and +++ headers")
+	}
+	return files, nil
+}
+
+// patchPath strips a trailing timestamp and a git-style a/ or b/ prefix.
+// /dev/null becomes "".
+func patchPath(s, prefix string) string {
+	if tab := strings.IndexByte(s, '\t'); tab >= 0 {
+		s = s[:tab]
+	}
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return ""
+	}
+	return strings.TrimPrefix(s, prefix)
+}
+
+// parseHunk parses the hunk whose header is lines[i] and returns the index
+// of the line after it.
+func parseHunk(lines []string, i int) (hunk, int, error) {
+	var h hunk
+	header := lines[i]
+	oldStart, oldCount, newCount, ok := parseHunkHeader(header)
+	if !ok {
+		return h, 0, fmt.Errorf("line %d: invalid hunk header %q", i+1, header)
+	}
+	h.oldStart = oldStart
+	i++
+	var last byte
+	for len(h.old) < oldCount || len(h.new) < newCount || (i < len(lines) && strings.HasPrefix(lines[i], `\`)) {
+		if i >= len(lines) {
+			return h, 0, fmt.Errorf("hunk %q is truncated", header)
+		}
+		line := lines[i]
+		op, text := byte(' '), ""
+		if line != "" {
+			op, text = line[0], line[1:]
+		}
+		switch op {
+		case ' ':
+			h.old = append(h.old, text)
+			h.new = append(h.new, text)
+		case '-':
+			h.old = append(h.old, text)
+		case '+':
+			h.new = append(h.new, text)
+		case '\\':
+			h.oldNoEOL = h.oldNoEOL || last == ' ' || last == '-'
+			h.newNoEOL = h.newNoEOL || last == ' ' || last == '+'
+		default:
+			return h, 0, fmt.Errorf("line %d: unexpected %q in hunk", i+1, line)
+		}
+		last = op
+		i++
+	}
+	if len(h.old) != oldCount || len(h.new) != newCount {
+		return h, 0, fmt.Errorf("hunk %q: line counts do not match its body", header)
+	}
+	return h, i, nil
+}
+
+// parseHunkHeader parses "@@ -l[,s] +l[,s] @@ ...".
+func parseHunkHeader(s string) (oldStart, oldCount, newCount int, ok bool) {
+	fields := strings.Fields(s)
+	if len(fields) < 4 || fields[3] != "@@" || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return 0, 0, 0, false
+	}
+	oldStart, oldCount, ok1 := parseRange(fields[1][1:])
+	_, newCount, ok2 := parseRange(fields[2][1:])
+	return oldStart, oldCount, newCount, ok1 && ok2
+}
+
+func parseRange(s string) (start, count int, ok bool) {
+	startStr, countStr, hasCount := strings.Cut(s, ",")
+	start, err := strconv.Atoi(startStr)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	count = 1
+	if hasCount {
+		if count, err = strconv.Atoi(countStr); err != nil || count < 0 {
+			return 0, 0, false
+		}
+	}
+	return start, count, true
+}
+
+// applyHunks applies hunks in order to content. A hunk applies where its
+// old lines match, searching outward from the line its header names so
+// patches against a slightly different revision still apply.
+func applyHunks(content string, hunks []hunk) (string, error) {
+	lines := strings.Split(content, "\n")
+	eol := true
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		eol = false
+	}
+
+	delta := 0
+	for n, h := range hunks {
+		want := h.oldStart - 1 + delta
+		if len(h.old) == 0 {
+			want = h.oldStart + delta // pure insertion after line oldStart
+		}
+		at, ok := findLines(lines, h.old, want)
+		if !ok {
+			return "", fmt.Errorf("hunk %d (line %d) does not match the file", n+1, h.oldStart)
+		}
+		end := at + len(h.old)
+		if end == len(lines) {
+			if h.newNoEOL {
+				eol = false
+			} else if h.oldNoEOL || len(lines) == 0 {
+				eol = true
+			}
+		}
+		lines = append(lines[:at], append(append([]string{}, h.new...), lines[end:]...)...)
+		delta += len(h.new) - len(h.old)
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	out := strings.Join(lines, "\n")
+	if eol {
+		out += "\n"
+	}
+	return out, nil
+}
+
+// findLines returns the index nearest to want at which old occurs in lines.
+func findLines(lines, old []string, want int) (int, bool) {
+	want = min(max(want, 0), len(lines))
+	for d := 0; d <= patchContextSearch; d++ {
+		for _, at := range []int{want - d, want + d} {
+			if d == 0 && at != want {
+				continue
+			}
+			if at >= 0 && at+len(old) <= len(lines) && equalLines(lines[at:at+len(old)], old) {
+				return at, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func equalLines(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package tools_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chtushar/pingu/internal/tools"
+)
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		before map[string]string
+		patch  string
+		after  map[string]string // "" means the file must not exist
+		report string
+	}{
+		{
+			name:   "modify",
+			before: map[string]string{"a.txt": "one\ntwo\nthree\nfour\n"},
+			patch: "diff --git a/a.txt b/a.txt\nindex 1..2 100644\n--- a/a.txt\n+++ b/a.txt\n" +
+				"@@ -1,3 +1,3 @@\n one\n-two\n+TWO\n three\n@@ -4 +4,2 @@\n four\n+five\n",
+			after:  map[string]string{"a.txt": "one\nTWO\nthree\nfour\nfive\n"},
+			report: "patched a.txt (2 hunks)\n",
+		},
+		{
+			name:   "offset hunk",
+			before: map[string]string{"a.txt": "new first line\none\ntwo\nthree\n"},
+			patch:  "--- a.txt\t2024-01-01\n+++ a.txt\t2024-01-02\n@@ -1,2 +1,2 @@\n one\n-two\n+2\n",
+			after:  map[string]string{"a.txt": "new first line\none\n2\nthree\n"},
+			report: "patched a.txt (1 hunk)\n",
+		},
+		{
+			name:   "create and delete",
+			before: map[string]string{"old.txt": "bye\n"},
+			patch: "--- /dev/null\n+++ b/dir/new.txt\n@@ -0,0 +1,2 @@\n+hello\n+world\n" +
+				"--- a/old.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-bye\n",
+			after:  map[string]string{"dir/new.txt": "hello\nworld\n", "old.txt": ""},
+			report: "created dir/new.txt\ndeleted old.txt\n",
+		},
+		{
+			name:   "no newline at end of file",
+			before: map[string]string{"a.txt": "x\ny"},
+			patch:  "--- a/a.txt\n+++ b/a.txt\n@@ -1,2 +1,2 @@\n x\n-y\n\\ No newline at end of file\n+z\n",
+			after:  map[string]string{"a.txt": "x\nz\n"},
+			report: "patched a.txt (1 hunk)\n",
+		},
+		{
+			name:   "rename",
+			before: map[string]string{"a.txt": "a\n"},
+			patch:  "--- a/a.txt\n+++ b/b.txt\n@@ -1 +1 @@\n-a\n+b\n",
+			after:  map[string]string{"a.txt": "", "b.txt": "b\n"},
+			report: "renamed a.txt to b.txt (1 hunk)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, ts := fileTools(t, tools.FilesConfig{})
+			writeFiles(t, root, tt.before)
+			got, err := call(t, ts["apply_patch"], map[string]any{"patch": tt.patch})
+			if err != nil || got != tt.report {
+				t.Fatalf("apply = %q, %v; want %q", got, err, tt.report)
+			}
+			for name, want := range tt.after {
+				data, err := os.ReadFile(filepath.Join(root, name))
+				if want == "" {
+					if err == nil {
+						t.Errorf("%s still exists", name)
+					}
+					continue
+				}
+				if string(data) != want {
+					t.Errorf("%s = %q, want %q", name, data, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyPatchIsAllOrNothing(t *testing.T) {
+	root, ts := fileTools(t, tools.FilesConfig{})
+	writeFiles(t, root, map[string]string{"a.txt": "a\n", "b.txt": "b\n", "exists.txt": "e\n"})
+
+	for _, patch := range []string{
+		// The second file's hunk does not match.
+		"--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-a\n+A\n--- a/b.txt\n+++ b/b.txt\n@@ -1 +1 @@\n-nope\n+B\n",
+		// Creating a file that exists.
+		"--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-a\n+A\n--- /dev/null\n+++ b/exists.txt\n@@ -0,0 +1 @@\n+x\n",
+		// Malformed input.
+		"--- a/a.txt\n+++ b/a.txt\n@@ -1,2 +1 @@\n-a\n",
+		"just some text",
+	} {
+		if _, err := call(t, ts["apply_patch"], map[string]any{"patch": patch}); err == nil {
+			t.Errorf("expected error for %q", patch)
+		}
+	}
+	for name, want := range map[string]string{"a.txt": "a\n", "b.txt": "b\n", "exists.txt": "e\n"} {
+		if data, _ := os.ReadFile(filepath.Join(root, name)); string(data) != want {
+			t.Errorf("%s changed to %q", name, data)
+		}
+	}
+
+	_, err := call(t, ts["apply_patch"], map[string]any{"patch": "--- a/b.txt\n+++ b/b.txt\n@@ -1 +1 @@\n-nope\n+B\n"})
+	if err == nil || !strings.Contains(err.Error(), "hunk 1") {
+		t.Errorf("error = %v, want it to name the hunk", err)
+	}
+}
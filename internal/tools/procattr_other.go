@@ -0,0 +1,9 @@
+//go:build !unix
+
+package tools
+
+import "os/exec"
+
+// setProcessGroup is a no-op where process groups are unavailable; context
+// cancellation kills the direct child only.
+func setProcessGroup(cmd *exec.Cmd) {}
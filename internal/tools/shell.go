@@ -0,0 +1,188 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode/utf8"
+)
+
+// ShellName is the name of the built-in shell tool.
+const ShellName = "shell"
+
+// BaseShellEnv lists the variables every shell command inherits from pingu's
+// environment; ShellConfig.Env adds to it.
+var BaseShellEnv = []string{"PATH", "HOME", "LANG", "TMPDIR"}
+
+// ShellConfig configures the shell tool.
+type ShellConfig struct {
+	Dir       string   // working directory for every command
+	Env       []string // extra variable names passed through from pingu's environment
+	MaxOutput int64    // bound on the JSON result; 0 means unbounded
+}
+
+// Shell runs commands with sh -c. Commands get no stdin, only allowlisted
+// environment variables, and their own process group, which is killed when
+// the call's context is done (the runner applies Limits.ToolTimeout).
+type Shell struct {
+	cfg ShellConfig
+}
+
+// NewShell returns the shell tool for cfg.
+func NewShell(cfg ShellConfig) *Shell { return &Shell{cfg: cfg} }
+
+// Name implements Tool.
+func (s *Shell) Name() string { return ShellName }
+
+// ParallelSafe implements ParallelSafe: commands may change anything, so
+// each runs alone.
+func (s *Shell) ParallelSafe() bool { return false }
+
+// Description implements Tool.
+func (s *Shell) Description() string {
+	return "Run a shell command with sh -c in the agent's working directory. " +
+		"Returns JSON with exit_code, stdout, stderr, and truncated. " +
+		"A non-zero exit_code is a result, not a failure."
+}
+
+// Parameters implements Tool.
+func (s *Shell) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"command":{"type":"string","description":"shell command line"}},"required":["command"],"additionalProperties":false}`)
+}
+
+// ShellResult is the JSON result of one command.
+type ShellResult struct {
+	ExitCode  int    `json:"exit_code"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	Truncated bool   `json:"truncated"`
+}
+
+// Run implements Tool.
+func (s *Shell) Run(ctx context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		Command string `json:"command"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(args))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&in); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	if strings.TrimSpace(in.Command) == "" {
+		return "", errors.New("command is required")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := command(ctx, "sh", "-c", in.Command)
+	cmd.Dir = s.cfg.Dir
+	cmd.Env = s.environ()
+	capture := s.cfg.MaxOutput + 1
+	if s.cfg.MaxOutput <= 0 {
+		capture = maxUnboundedShellOutput
+	}
+	cmd.Stdout = &limitedBuffer{buf: &stdout, max: capture}
+	cmd.Stderr = &limitedBuffer{buf: &stderr, max: capture}
+
+	res := ShellResult{}
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		var exit *exec.ExitError
+		if !errors.As(err, &exit) {
+			return "", err
+		}
+		res.ExitCode = exit.ExitCode()
+	}
+	res.Stdout, res.Stderr = stdout.String(), stderr.String()
+	res.Truncated = int64(stdout.Len()) >= capture || int64(stderr.Len()) >= capture
+	return s.encode(res)
+}
+
+// maxUnboundedShellOutput caps each stream when no output bound is set.
+const maxUnboundedShellOutput = 16 << 20
+
+// encode marshals res. When the JSON would exceed MaxOutput, stdout and
+// stderr share what the envelope leaves, each keeping its head, so the
+// runner never cuts the result mid-document.
+func (s *Shell) encode(res ShellResult) (string, error) {
+	b, err := json.Marshal(res)
+	if err != nil || s.cfg.MaxOutput <= 0 || int64(len(b)) <= s.cfg.MaxOutput {
+		return string(b), err
+	}
+	res.Truncated = true
+	stdout, stderr := res.Stdout, res.Stderr
+	res.Stdout, res.Stderr = "", ""
+	envelope, err := json.Marshal(res)
+	if err != nil {
+		return "", err
+	}
+	avail := s.cfg.MaxOutput - int64(len(envelope))
+	if avail <= 0 {
+		return string(envelope), nil
+	}
+	outBudget, errBudget := avail/2, avail-avail/2
+	if n := jsonLen(stdout); n < outBudget {
+		errBudget += outBudget - n
+		outBudget = n
+	} else if n := jsonLen(stderr); n < errBudget {
+		outBudget += errBudget - n
+		errBudget = n
+	}
+	res.Stdout, res.Stderr = fitJSON(stdout, outBudget), fitJSON(stderr, errBudget)
+	b, err = json.Marshal(res)
+	return string(b), err
+}
+
+// jsonLen is the encoded length of s inside a JSON string, quotes excluded.
+func jsonLen(s string) int64 {
+	b, _ := json.Marshal(s)
+	return int64(len(b)) - 2
+}
+
+// fitJSON trims s from the end until its JSON encoding fits in n bytes.
+func fitJSON(s string, n int64) string {
+	for {
+		over := jsonLen(s) - n
+		if over <= 0 {
+			return s
+		}
+		s = trimTail(s, over)
+	}
+}
+
+// trimTail drops at least n bytes from the end of s without splitting a
+// UTF-8 sequence.
+func trimTail(s string, n int64) string {
+	keep := int64(len(s)) - n
+	if keep <= 0 {
+		return ""
+	}
+	for keep > 0 && !utf8.RuneStart(s[keep]) {
+		keep--
+	}
+	return s[:keep]
+}
+
+// environ returns the allowlisted variables that are set.
+func (s *Shell) environ() []string {
+	var env []string
+	seen := map[string]bool{}
+	for _, names := range [][]string{BaseShellEnv, s.cfg.Env} {
+		for _, name := range names {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if v, ok := os.LookupEnv(name); ok {
+				env = append(env, name+"="+v)
+			}
+		}
+	}
+	return env
+}
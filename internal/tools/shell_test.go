@@ -0,0 +1,105 @@
+//go:build unix
+
+package tools_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chtushar/pingu/internal/tools"
+)
+
+func runShell(t *testing.T, sh *tools.Shell, command string) tools.ShellResult {
+	t.Helper()
+	args, _ := json.Marshal(map[string]string{"command": command})
+	out, err := sh.Run(context.Background(), args)
+	if err != nil {
+		t.Fatalf("run %q: %v", command, err)
+	}
+	var res tools.ShellResult
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatalf("result %q is not JSON: %v", out, err)
+	}
+	return res
+}
+
+func TestShellResult(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "marker"), nil, 0o644)
+	sh := tools.NewShell(tools.ShellConfig{Dir: dir})
+
+	tests := []struct {
+		command string
+		want    tools.ShellResult
+	}{
+		{"echo out; echo err >&2", tools.ShellResult{Stdout: "out\n", Stderr: "err\n"}},
+		{"exit 3", tools.ShellResult{ExitCode: 3}},
+		{"ls", tools.ShellResult{Stdout: "marker\n"}},
+		{"cat", tools.ShellResult{}}, // no stdin
+	}
+	for _, tt := range tests {
+		if got := runShell(t, sh, tt.command); got != tt.want {
+			t.Errorf("%q = %+v, want %+v", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestShellEnvAllowlist(t *testing.T) {
+	t.Setenv("PINGU_TEST_ALLOWED", "yes")
+	t.Setenv("PINGU_TEST_SECRET", "hunter2")
+	sh := tools.NewShell(tools.ShellConfig{Dir: t.TempDir(), Env: []string{"PINGU_TEST_ALLOWED"}})
+
+	res := runShell(t, sh, `echo "$PINGU_TEST_ALLOWED:$PINGU_TEST_SECRET"; command -v sh >/dev/null && echo path-ok`)
+	if res.Stdout != "yes:\npath-ok\n" {
+		t.Errorf("stdout = %q", res.Stdout)
+	}
+}
+
+func TestShellOutputBound(t *testing.T) {
+	const max = 200
+	sh := tools.NewShell(tools.ShellConfig{Dir: t.TempDir(), MaxOutput: max})
+	args, _ := json.Marshal(map[string]string{"command": `yes "€ and \"quotes\"" | head -c 5000; yes err | head -c 5000 >&2`})
+	out, err := sh.Run(context.Background(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) > max {
+		t.Errorf("result is %d bytes, bound %d", len(out), max)
+	}
+	var res tools.ShellResult
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatalf("truncated result is not JSON: %v\n%s", err, out)
+	}
+	if !res.Truncated || res.Stdout == "" || res.Stderr == "" || strings.ContainsRune(res.Stdout, '�') {
+		t.Errorf("result = %+v", res)
+	}
+}
+
+func TestShellTimeoutKillsCommand(t *testing.T) {
+	sh := tools.NewShell(tools.ShellConfig{Dir: t.TempDir()})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := sh.Run(ctx, json.RawMessage(`{"command":"sleep 30 & sleep 30"}`))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run returned after %s", elapsed)
+	}
+}
+
+func TestShellInvalidArguments(t *testing.T) {
+	sh := tools.NewShell(tools.ShellConfig{Dir: t.TempDir()})
+	for _, args := range []string{`{}`, `{"command":"  "}`, `{"command":"ls","cwd":"/"}`, `[]`} {
+		if _, err := sh.Run(context.Background(), json.RawMessage(args)); err == nil {
+			t.Errorf("args %s: expected error", args)
+		}
+	}
+}
@@ -1,6 +1,5 @@
-// Package tools defines the tool abstraction and an in-memory registry.
-// Phase 2 adds discovery of executable tools from an agent directory; the
-// interface and registry are the stable seam.
+// Package tools defines the tool abstraction, an in-memory registry, and
+// executable tool plugins discovered from an agent directory's tools/.
 package tools
 
 import (
@@ -20,6 +19,22 @@ type Tool interface {
 	Run(ctx context.Context, args json.RawMessage) (string, error)
 }
 
+// ParallelSafe is implemented by tools that may not be safe to run at the
+// same time as other calls, for example because they change shared state.
+// When ParallelSafe returns false the runner runs the call alone. Tools
+// without the method are assumed safe.
+type ParallelSafe interface {
+	ParallelSafe() bool
+}
+
+// IsParallelSafe reports whether t may run concurrently with other calls.
+func IsParallelSafe(t Tool) bool {
+	if p, ok := t.(ParallelSafe); ok {
+		return p.ParallelSafe()
+	}
+	return true
+}
+
 // Registry holds tools keyed by name with deterministic ordering.
 type Registry struct {
 	byName map[string]Tool
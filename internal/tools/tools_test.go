@@ -39,6 +39,22 @@ func TestRegistryDuplicateName(t *testing.T) {
 	}
 }
 
+type serialStub struct{ stubTool }
+
+func (*serialStub) ParallelSafe() bool { return false }
+
+func TestIsParallelSafe(t *testing.T) {
+	if !tools.IsParallelSafe(&stubTool{name: "plain"}) {
+		t.Error("a tool without ParallelSafe should be parallel-safe")
+	}
+	if tools.IsParallelSafe(&serialStub{stubTool{name: "serial"}}) {
+		t.Error("ParallelSafe() false should be respected")
+	}
+	if tools.IsParallelSafe(tools.NewShell(tools.ShellConfig{})) {
+		t.Error("shell should not be parallel-safe")
+	}
+}
+
 func TestRegistrySorted(t *testing.T) {
 	r, _ := tools.NewRegistry()
 	for _, n := range []string{"zeta", "alpha", "mid"} {
@@ -0,0 +1,582 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// WebFetchName is the name of the built-in web fetch tool.
+const WebFetchName = "web_fetch"
+
+// Web fetch defaults, used when WebFetchConfig leaves a field zero.
+const (
+	DefaultWebFetchTimeout  = 30 * time.Second
+	DefaultWebFetchMaxBytes = 2 << 20
+)
+
+const maxWebFetchRedirects = 5
+
+// WebFetchConfig configures the web_fetch tool.
+type WebFetchConfig struct {
+	// AllowDomains, when non-empty, limits fetches to these hosts and their
+	// subdomains. DenyDomains always wins.
+	AllowDomains []string
+	DenyDomains  []string
+	Timeout      time.Duration // per fetch, redirects included
+	MaxBytes     int64         // response body bytes read
+	MaxOutput    int64         // bound on the result text; 0 means unbounded
+	// AllowPrivate lets fetches reach loopback, private, link-local, and
+	// other non-public addresses. Without it such addresses are refused when
+	// connecting, so a public name resolving to one, whether in the first
+	// URL, after a redirect, or by DNS rebinding, is refused as well.
+	AllowPrivate bool
+	// Client is used for requests; nil means a default client. Its
+	// transport is cloned to add the address check, and proxies are not
+	// used unless AllowPrivate is set, since the check cannot see past a
+	// proxy. A transport other than *http.Transport is used as it is.
+	Client *http.Client
+}
+
+// WebFetch GETs a URL and returns its content as text, converting HTML to
+// markdown-like text.
+type WebFetch struct {
+	cfg    WebFetchConfig
+	client *http.Client
+}
+
+// NewWebFetch returns the web_fetch tool for cfg.
+func NewWebFetch(cfg WebFetchConfig) *WebFetch {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultWebFetchTimeout
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultWebFetchMaxBytes
+	}
+	client := &http.Client{}
+	if cfg.Client != nil {
+		c := *cfg.Client
+		client = &c
+	}
+	if !cfg.AllowPrivate {
+		client.Transport = publicOnly(client.Transport)
+	}
+	w := &WebFetch{cfg: cfg, client: client}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxWebFetchRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxWebFetchRedirects)
+		}
+		return w.check(req.URL)
+	}
+	return w
+}
+
+// Name implements Tool.
+func (w *WebFetch) Name() string { return WebFetchName }
+
+// Description implements Tool.
+func (w *WebFetch) Description() string {
+	return "Fetch an http or https URL with GET and return its content as text. " +
+		"HTML pages are converted to markdown-like text with headings and links kept " +
+		"and scripts, styles, and navigation removed."
+}
+
+// Parameters implements Tool.
+func (w *WebFetch) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"url":{"type":"string","description":"absolute http or https URL"}},"required":["url"],"additionalProperties":false}`)
+}
+
+// Run implements Tool.
+func (w *WebFetch) Run(ctx context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		URL string `json:"url"`
+	}
+	if err := decodeArgs(args, &in); err != nil {
+		return "", err
+	}
+	u, err := url.Parse(strings.TrimSpace(in.URL))
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %w", err)
+	}
+	if err := w.check(u); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "pingu-web-fetch/1")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,text/plain;q=0.9,*/*;q=0.5")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return "", fmt.Errorf("fetch %s: timed out after %s", u.Redacted(), w.cfg.Timeout)
+		}
+		return "", fmt.Errorf("fetch %s: %w", u.Redacted(), unwrapURLError(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("fetch %s: %s", u.Redacted(), resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, w.cfg.MaxBytes+1))
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return "", fmt.Errorf("fetch %s: timed out after %s", u.Redacted(), w.cfg.Timeout)
+		}
+		return "", fmt.Errorf("fetch %s: %w", u.Redacted(), err)
+	}
+	partial := int64(len(body)) > w.cfg.MaxBytes
+	if partial {
+		body = body[:w.cfg.MaxBytes]
+	}
+
+	final := resp.Request.URL
+	var text, title string
+	switch kind := contentKind(resp.Header.Get("Content-Type"), body); kind {
+	case "html":
+		title, text = htmlToText(body, final)
+	case "text":
+		text = strings.ToValidUTF8(string(body), "�")
+	default:
+		return "", fmt.Errorf("fetch %s: unsupported content type %q", u.Redacted(), kind)
+	}
+
+	var b strings.Builder
+	if title != "" {
+		b.WriteString("Title: " + title + "\n")
+	}
+	b.WriteString("URL: " + final.Redacted() + "\n")
+	if partial {
+		fmt.Fprintf(&b, "(response cut at %d bytes)\n", w.cfg.MaxBytes)
+	}
+	b.WriteString("\n" + text)
+	return w.fit(b.String()), nil
+}
+
+// check applies the scheme and domain policy to u.
+func (w *WebFetch) check(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url %q: only http and https are supported", u.Redacted())
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return fmt.Errorf("url %q has no host", u.Redacted())
+	}
+	if matchDomain(host, w.cfg.DenyDomains) {
+		return fmt.Errorf("host %s is denied by tools.web_fetch.deny_domains", host)
+	}
+	if len(w.cfg.AllowDomains) > 0 && !matchDomain(host, w.cfg.AllowDomains) {
+		return fmt.Errorf("host %s is not in tools.web_fetch.allow_domains", host)
+	}
+	return nil
+}
+
+// publicOnly returns a clone of rt, or of http.DefaultTransport for nil,
+// whose connections fail for non-public addresses. The check runs on the
+// address actually dialed, after DNS resolution.
+func publicOnly(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	base, ok := rt.(*http.Transport)
+	if !ok {
+		return rt
+	}
+	t := base.Clone()
+	t.Proxy = nil
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: checkDialAddr}
+	t.DialContext = dialer.DialContext
+	return t
+}
+
+// nonPublic lists address ranges beyond those the net/netip predicates
+// cover that web_fetch refuses by default.
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT, some cloud metadata
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),   // reserved, broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64, may map to any IPv4 address
+}
+
+// checkDialAddr refuses to connect to a non-public address. It is a
+// net.Dialer Control function: address is the resolved "ip:port".
+func checkDialAddr(_, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("address %s: %w", address, err)
+	}
+	ip := ap.Addr().Unmap()
+	public := ip.IsGlobalUnicast() && !ip.IsPrivate()
+	for _, p := range nonPublic {
+		if p.Contains(ip) {
+			public = false
+		}
+	}
+	if !public {
+		return fmt.Errorf("address %s is not public; set tools.web_fetch.allow_private to fetch it", ip)
+	}
+	return nil
+}
+
+// matchDomain reports whether host is one of domains or a subdomain of one.
+// A leading "*." in a domain is accepted and means the same.
+func matchDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		d = strings.TrimPrefix(strings.ToLower(d), "*.")
+		if host == d || (net.ParseIP(host) == nil && strings.HasSuffix(host, "."+d)) {
+			return true
+		}
+	}
+	return false
+}
+
+// fit keeps s within MaxOutput, marking the cut, so the runner never
+// truncates mid-rune.
+func (w *WebFetch) fit(s string) string {
+	const marker = "\n\n[truncated]"
+	if w.cfg.MaxOutput <= 0 || int64(len(s)) <= w.cfg.MaxOutput {
+		return s
+	}
+	if w.cfg.MaxOutput <= int64(len(marker)) {
+		return trimTail(s, int64(len(s))-w.cfg.MaxOutput)
+	}
+	return trimTail(s, int64(len(s))-w.cfg.MaxOutput+int64(len(marker))) + marker
+}
+
+// unwrapURLError drops the *url.Error wrapper, which repeats the URL.
+func unwrapURLError(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return ue.Err
+	}
+	return err
+}
+
+// contentKind classifies a response as "html", "text", or, for anything
+// else, its media type.
+func contentKind(contentType string, body []byte) string {
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	switch {
+	case mt == "text/html" || mt == "application/xhtml+xml":
+		return "html"
+	case strings.HasPrefix(mt, "text/"),
+		mt == "application/json", mt == "application/xml", mt == "application/javascript",
+		strings.HasSuffix(mt, "+json"), strings.HasSuffix(mt, "+xml"):
+		return "text"
+	}
+	return mt
+}
+
+// skippedElements are dropped with their content when converting HTML.
+var skippedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+	atom.Nav: true, atom.Footer: true, atom.Aside: true, atom.Form: true,
+	atom.Svg: true, atom.Iframe: true, atom.Head: true, atom.Button: true,
+	atom.Select: true, atom.Dialog: true,
+}
+
+// blockElements start and end on their own paragraph.
+var blockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true,
+	atom.Main: true, atom.Header: true, atom.Blockquote: true, atom.Table: true,
+	atom.Ul: true, atom.Ol: true, atom.Dl: true, atom.Figure: true,
+	atom.Figcaption: true, atom.Details: true, atom.Summary: true, atom.Address: true,
+}
+
+var headingLevels = map[atom.Atom]int{
+	atom.H1: 1, atom.H2: 2, atom.H3: 3, atom.H4: 4, atom.H5: 5, atom.H6: 6,
+}
+
+// htmlToText converts an HTML document to markdown-like text and returns
+// its title. Links are resolved against base.
+func htmlToText(data []byte, base *url.URL) (title, text string) {
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return "", strings.ToValidUTF8(string(data), "�")
+	}
+	c := &htmlConverter{base: base}
+	if t := findElement(doc, atom.Title); t != nil {
+		title = strings.Join(strings.Fields(textContent(t)), " ")
+	}
+	c.walk(doc)
+	return title, cleanText(c.buf.String())
+}
+
+type htmlConverter struct {
+	buf  bytes.Buffer
+	base *url.URL
+	pre  int   // depth of <pre> elements
+	list []int // per open list: 0 for <ul>, else the next <ol> number
+}
+
+func (c *htmlConverter) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		c.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		c.children(n)
+		return
+	}
+	if skippedElements[n.DataAtom] || hiddenElement(n) {
+		return
+	}
+
+	switch a := n.DataAtom; {
+	case headingLevels[a] > 0:
+		c.block()
+		c.buf.WriteString(strings.Repeat("#", headingLevels[a]) + " ")
+		c.children(n)
+		c.block()
+	case a == atom.Br:
+		c.buf.WriteString("\n")
+	case a == atom.Hr:
+		c.block()
+		c.buf.WriteString("---")
+		c.block()
+	case a == atom.Pre:
+		c.block()
+		c.buf.WriteString("```\n")
+		c.pre++
+		c.children(n)
+		c.pre--
+		c.newline()
+		c.buf.WriteString("```")
+		c.block()
+	case a == atom.Code && c.pre == 0:
+		c.buf.WriteString("`")
+		c.children(n)
+		c.buf.WriteString("`")
+	case a == atom.Strong || a == atom.B:
+		c.wrap(n, "**")
+	case a == atom.Em || a == atom.I:
+		c.wrap(n, "*")
+	case a == atom.A:
+		c.link(n)
+	case a == atom.Img:
+		if alt := strings.TrimSpace(attr(n, "alt")); alt != "" {
+			c.text("[image: " + alt + "]")
+		}
+	case a == atom.Ul || a == atom.Ol:
+		start := 0
+		if a == atom.Ol {
+			start = 1
+		}
+		c.block()
+		c.list = append(c.list, start)
+		c.children(n)
+		c.list = c.list[:len(c.list)-1]
+		c.block()
+	case a == atom.Li:
+		c.newline()
+		depth := max(len(c.list), 1)
+		c.buf.WriteString(strings.Repeat("  ", depth-1))
+		if depth <= len(c.list) && c.list[depth-1] > 0 {
+			fmt.Fprintf(&c.buf, "%d. ", c.list[depth-1])
+			c.list[depth-1]++
+		} else {
+			c.buf.WriteString("- ")
+		}
+		c.children(n)
+		c.newline()
+	case a == atom.Tr:
+		c.newline()
+		c.children(n)
+		c.newline()
+	case a == atom.Td || a == atom.Th:
+		if last := c.lastByte(); last != '\n' && last != 0 {
+			c.buf.WriteString(" | ")
+		}
+		c.children(n)
+	case a == atom.Dt:
+		c.newline()
+		c.children(n)
+	case a == atom.Dd:
+		c.newline()
+		c.buf.WriteString(": ")
+		c.children(n)
+		c.newline()
+	case blockElements[a]:
+		c.block()
+		c.children(n)
+		c.block()
+	default:
+		c.children(n)
+	}
+}
+
+func (c *htmlConverter) children(n *html.Node) {
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		c.walk(ch)
+	}
+}
+
+// text writes a text node, collapsing whitespace outside <pre>.
+func (c *htmlConverter) text(s string) {
+	if c.pre > 0 {
+		c.buf.WriteString(s)
+		return
+	}
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		if s != "" {
+			c.space()
+		}
+		return
+	}
+	if s[0] == ' ' || s[0] == '\n' || s[0] == '\t' || s[0] == '\r' {
+		c.space()
+	}
+	c.buf.WriteString(strings.Join(words, " "))
+	if last := s[len(s)-1]; last == ' ' || last == '\n' || last == '\t' || last == '\r' {
+		c.space()
+	}
+}
+
+// space writes a single space unless the output already ends in whitespace.
+func (c *htmlConverter) space() {
+	if last := c.lastByte(); last != ' ' && last != '\n' && last != 0 {
+		c.buf.WriteByte(' ')
+	}
+}
+
+func (c *htmlConverter) newline() {
+	if last := c.lastByte(); last != '\n' && last != 0 {
+		c.buf.WriteByte('\n')
+	}
+}
+
+// block ends the current paragraph; cleanText collapses repeats.
+func (c *htmlConverter) block() {
+	if c.buf.Len() > 0 {
+		c.buf.WriteString("\n\n")
+	}
+}
+
+func (c *htmlConverter) lastByte() byte {
+	if c.buf.Len() == 0 {
+		return 0
+	}
+	return c.buf.Bytes()[c.buf.Len()-1]
+}
+
+// wrap renders n's children between marks, dropping the marks when the
+// children render nothing.
+func (c *htmlConverter) wrap(n *html.Node, mark string) {
+	start := c.buf.Len()
+	c.buf.WriteString(mark)
+	c.children(n)
+	if strings.TrimSpace(c.buf.String()[start+len(mark):]) == "" {
+		c.buf.Truncate(start)
+		return
+	}
+	c.buf.WriteString(mark)
+}
+
+// link renders <a> as [text](url) with the URL resolved against the page.
+// Fragment-only and javascript: links keep only their text.
+func (c *htmlConverter) link(n *html.Node) {
+	href := strings.TrimSpace(attr(n, "href"))
+	var target string
+	if href != "" && !strings.HasPrefix(href, "#") && !strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		if u, err := c.base.Parse(href); err == nil {
+			target = u.String()
+		}
+	}
+	if target == "" {
+		c.children(n)
+		return
+	}
+	start := c.buf.Len()
+	c.buf.WriteString("[")
+	c.children(n)
+	label := strings.Join(strings.Fields(c.buf.String()[start+1:]), " ")
+	if label == "" {
+		c.buf.Truncate(start)
+		return
+	}
+	c.buf.Truncate(start)
+	c.buf.WriteString("[" + label + "](" + target + ")")
+}
+
+// hiddenElement reports elements marked hidden or aria-hidden.
+func hiddenElement(n *html.Node) bool {
+	for _, a := range n.Attr {
+		if a.Key == "hidden" || (a.Key == "aria-hidden" && a.Val == "true") {
+			return true
+		}
+	}
+	return false
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		if found := findElement(ch, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		b.WriteString(textContent(ch))
+	}
+	return b.String()
+}
+
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+// cleanText trims trailing spaces and collapses runs of blank lines.
+func cleanText(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " \t")
+	}
+	s = blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(s) + "\n"
+}
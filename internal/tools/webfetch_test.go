@@ -0,0 +1,161 @@
+package tools_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chtushar/pingu/internal/tools"
+)
+
+const testPage = `<!doctype html>
+<html><head><title>  Test
+ Page </title><style>body { color: red }</style><script>alert("x")</script></head>
+<body>
+<nav><a href="/home">Home</a> | <a href="/about">About</a></nav>
+<main>
+<h1>Welcome</h1>
+<p>Read the <a href="/docs/intro">intro   guide</a> or <a href="#top">jump</a>.
+It is <strong>short</strong>.</p>
+<ul><li>one</li><li>two <em>items</em></li></ul>
+<ol><li>first</li><li>second</li></ol>
+<pre>line 1
+  indented</pre>
+<div hidden>secret</div>
+<script>document.write("no")</script>
+</main>
+<footer>Copyright</footer>
+</body></html>`
+
+const testPageText = `Title: Test Page
+URL: %s/page
+
+# Welcome
+
+Read the [intro guide](%s/docs/intro) or jump. It is **short**.
+
+- one
+- two *items*
+
+1. first
+2. second
+
+` + "```\nline 1\n  indented\n```\n"
+
+func fetchServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(testPage))
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("abcdefghij", 100)))
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func fetch(t *testing.T, wf *tools.WebFetch, url string) (string, error) {
+	t.Helper()
+	args, _ := json.Marshal(map[string]string{"url": url})
+	return wf.Run(context.Background(), args)
+}
+
+func TestWebFetchHTML(t *testing.T) {
+	srv := fetchServer(t)
+	wf := tools.NewWebFetch(tools.WebFetchConfig{AllowDomains: []string{"127.0.0.1"}, AllowPrivate: true})
+
+	got, err := fetch(t, wf, srv.URL+"/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.ReplaceAll(testPageText, "%s", srv.URL)
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWebFetchLimits(t *testing.T) {
+	srv := fetchServer(t)
+
+	wf := tools.NewWebFetch(tools.WebFetchConfig{MaxBytes: 25, AllowPrivate: true})
+	got, err := fetch(t, wf, srv.URL+"/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "(response cut at 25 bytes)") || !strings.HasSuffix(got, "\n\nabcdefghijabcdefghijabcde") {
+		t.Errorf("cut response = %q", got)
+	}
+
+	wf = tools.NewWebFetch(tools.WebFetchConfig{MaxOutput: 100, AllowPrivate: true})
+	got, err = fetch(t, wf, srv.URL+"/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) > 100 || !strings.HasSuffix(got, "[truncated]") {
+		t.Errorf("output (%d bytes) = %q", len(got), got)
+	}
+
+	wf = tools.NewWebFetch(tools.WebFetchConfig{Timeout: 50 * time.Millisecond, AllowPrivate: true})
+	start := time.Now()
+	if _, err := fetch(t, wf, srv.URL+"/slow"); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("slow fetch error = %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("timeout not honored: took %s", time.Since(start))
+	}
+}
+
+func TestWebFetchErrors(t *testing.T) {
+	srv := fetchServer(t)
+	localhost := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	tests := []struct {
+		name string
+		cfg  tools.WebFetchConfig
+		url  string
+		want string
+	}{
+		{"not allowed", tools.WebFetchConfig{AllowDomains: []string{"example.com"}}, srv.URL + "/page", "not in tools.web_fetch.allow_domains"},
+		{"denied", tools.WebFetchConfig{AllowDomains: []string{"127.0.0.1"}, DenyDomains: []string{"127.0.0.1"}, AllowPrivate: true}, srv.URL + "/page", "denied"},
+		{"denied subdomain", tools.WebFetchConfig{DenyDomains: []string{"example.com"}}, "http://docs.example.com/", "denied"},
+		{"redirect to disallowed host", tools.WebFetchConfig{AllowDomains: []string{"127.0.0.1"}, AllowPrivate: true}, srv.URL + "/redirect?to=" + localhost + "/page", "not in tools.web_fetch.allow_domains"},
+		{"scheme", tools.WebFetchConfig{}, "file:///etc/passwd", "only http and https"},
+		{"status", tools.WebFetchConfig{AllowPrivate: true}, srv.URL + "/missing", "404"},
+		{"content type", tools.WebFetchConfig{AllowPrivate: true}, srv.URL + "/image", "unsupported content type"},
+		{"loopback address", tools.WebFetchConfig{}, srv.URL + "/page", "not public"},
+		{"name resolving to loopback", tools.WebFetchConfig{}, localhost + "/page", "not public"},
+		{"link-local address", tools.WebFetchConfig{Timeout: time.Second}, "http://169.254.169.254/latest/meta-data/", "not public"},
+		{"private address", tools.WebFetchConfig{Timeout: time.Second}, "http://10.0.0.1/", "not public"},
+		{"unspecified address", tools.WebFetchConfig{}, "http://0.0.0.0:1/", "not public"},
+		{"mapped loopback", tools.WebFetchConfig{}, "http://[::ffff:127.0.0.1]:1/", "not public"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := fetch(t, tools.NewWebFetch(tt.cfg), tt.url)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}